@@ -30,6 +30,8 @@ type (
 		Scope            string                       `json:"scope"`
 		Title            string                       `json:"title,omitempty"`
 		Entrypoint       bool                         `json:"entrypoint,omitempty"`
+		Private          bool                         `json:"private,omitempty"`
+		Memoize          bool                         `json:"memoize,omitempty"`
 		Description      string                       `json:"description,omitempty"`
 		Organizations    []string                     `json:"organizations,omitempty"`
 		RelatedResources []*RelatedResourceAnnotation `json:"related_resources,omitempty"`
@@ -162,6 +164,20 @@ func (a *Annotations) Compare(other *Annotations) int {
 		return -1
 	}
 
+	if a.Private != other.Private {
+		if a.Private {
+			return 1
+		}
+		return -1
+	}
+
+	if a.Memoize != other.Memoize {
+		if a.Memoize {
+			return 1
+		}
+		return -1
+	}
+
 	if cmp := util.Compare(a.Custom, other.Custom); cmp != 0 {
 		return cmp
 	}
@@ -209,6 +225,14 @@ func (a *Annotations) MarshalJSON() ([]byte, error) {
 		data["entrypoint"] = a.Entrypoint
 	}
 
+	if a.Private {
+		data["private"] = a.Private
+	}
+
+	if a.Memoize {
+		data["memoize"] = a.Memoize
+	}
+
 	if len(a.Organizations) > 0 {
 		data["organizations"] = a.Organizations
 	}
@@ -437,6 +461,14 @@ func (a *Annotations) toObject() (*Object, *Error) {
 		obj.Insert(StringTerm("entrypoint"), BooleanTerm(true))
 	}
 
+	if a.Private {
+		obj.Insert(StringTerm("private"), BooleanTerm(true))
+	}
+
+	if a.Memoize {
+		obj.Insert(StringTerm("memoize"), BooleanTerm(true))
+	}
+
 	if len(a.Description) > 0 {
 		obj.Insert(StringTerm("description"), StringTerm(a.Description))
 	}
@@ -543,6 +575,14 @@ func attachAnnotationsNodes(mod *Module) Errors {
 		if err := validateAnnotationEntrypointAttachment(a); err != nil {
 			errs = append(errs, err)
 		}
+
+		if err := validateAnnotationPrivate(a); err != nil {
+			errs = append(errs, err)
+		}
+
+		if err := validateAnnotationMemoizeAttachment(a); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
 	return errs
@@ -574,6 +614,20 @@ func validateAnnotationEntrypointAttachment(a *Annotations) *Error {
 	return nil
 }
 
+func validateAnnotationMemoizeAttachment(a *Annotations) *Error {
+	if a.Memoize && !(a.Scope == annotationScopeRule || a.Scope == annotationScopeDocument) {
+		return NewError(ParseErr, a.Loc(), "annotation memoize applied to non-rule or document scope '%v'", a.Scope)
+	}
+	return nil
+}
+
+func validateAnnotationPrivate(a *Annotations) *Error {
+	if a.Private && a.Entrypoint {
+		return NewError(ParseErr, a.Loc(), "annotation private cannot be combined with entrypoint")
+	}
+	return nil
+}
+
 // Copy returns a deep copy of a.
 func (a *AuthorAnnotation) Copy() *AuthorAnnotation {
 	cpy := *a
@@ -848,6 +902,31 @@ func (as *AnnotationSet) Chain(rule *Rule) AnnotationsRefSet {
 	return refs
 }
 
+// PrivateTo returns the package path prefix that rule is visible to. If the
+// rule has not been marked private (via the "private" annotation on the rule,
+// its document, package, or enclosing subpackages), nil is returned. Private
+// rules may only be referenced by modules whose package path is prefixed by
+// the returned ref.
+func (as *AnnotationSet) PrivateTo(rule *Rule) Ref {
+	if as == nil || rule.Module == nil {
+		return nil
+	}
+	// The chain is ordered from the closest annotation outwards so the first
+	// private annotation yields the narrowest prefix.
+	for _, ar := range as.Chain(rule) {
+		if ar.Annotations == nil || !ar.Annotations.Private {
+			continue
+		}
+		switch ar.Annotations.Scope {
+		case annotationScopePackage, annotationScopeSubpackages:
+			return ar.Path
+		default:
+			return rule.Module.Package.Path
+		}
+	}
+	return nil
+}
+
 func (ars FlatAnnotationsRefSet) Insert(ar *AnnotationsRef) FlatAnnotationsRefSet {
 	result := make(FlatAnnotationsRefSet, 0, len(ars)+1)
 
@@ -190,9 +190,14 @@ var DefaultBuiltins = [...]*Builtin{
 	JWTVerifyHS384,
 	JWTVerifyHS512,
 	JWTDecodeVerify,
+	JWTVerifyWithJWKS,
 	JWTEncodeSignRaw,
 	JWTEncodeSign,
 
+	// OAuth 2.0 and OpenID Connect
+	OIDCDiscovery,
+	OAuth2Introspect,
+
 	// Time
 	NowNanos,
 	ParseNanos,
@@ -204,6 +209,12 @@ var DefaultBuiltins = [...]*Builtin{
 	Weekday,
 	AddDate,
 	Diff,
+	TimeCronMatch,
+	TimeCronNext,
+	TimeCronPrev,
+	TimeConvertZone,
+	TimeIntervalOverlap,
+	TimeInWindow,
 
 	// Crypto
 	CryptoX509ParseCertificates,
@@ -311,10 +322,13 @@ var IgnoreDuringPartialEval = []*Builtin{
 	RandIntn,
 	UUIDRFC4122,
 	JWTDecodeVerify,
+	JWTVerifyWithJWKS,
 	JWTEncodeSignRaw,
 	JWTEncodeSign,
 	NowNanos,
 	HTTPSend,
+	OIDCDiscovery,
+	OAuth2Introspect,
 	OPARuntime,
 	NetLookupIPAddr,
 }
@@ -2139,6 +2153,62 @@ Supports the following algorithms: HS256, HS384, HS512, RS256, RS384, RS512, ES2
 	Nondeterministic: true,
 }
 
+// Marked non-deterministic because it relies on time and network access internally.
+var JWTVerifyWithJWKS = &Builtin{
+	Name: "io.jwt.verify_with_jwks",
+	Description: `Verifies a JWT signature with keys fetched from a JWKS URL and decodes the claims if it is valid.
+The JWKS is fetched from ` + "`jwks_url`" + ` or from the ` + "`jwks_uri`" + ` in the OpenID Connect discovery document of ` + "`issuer`" + `.
+Responses are cached like ` + "`http.send`" + ` responses with caching enabled, honoring their ` + "`Cache-Control`" + ` header.`,
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("jwt", types.S).Description("JWT token whose signature is to be verified and whose claims are to be checked"),
+			types.Named("options", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("JWKS location and claim verification constraints"),
+		),
+		types.Named("output", types.NewArray([]types.Type{
+			types.B,
+			types.NewObject(nil, types.NewDynamicProperty(types.A, types.A)),
+			types.NewObject(nil, types.NewDynamicProperty(types.A, types.A)),
+		}, nil)).Description("`[valid, header, payload]`:  if the input token is verified and meets the requirements of `options` then `valid` is `true`; `header` and `payload` are objects containing the JOSE header and the JWT claim set; otherwise, `valid` is `false`, `header` and `payload` are `{}`"),
+	),
+	Categories:       tokensCat,
+	Nondeterministic: true,
+}
+
+var oauthCat = category("oauth")
+
+// Marked non-deterministic because it relies on network access internally.
+var OIDCDiscovery = &Builtin{
+	Name: "oidc.discovery",
+	Description: `Returns the OpenID Connect discovery document of an issuer.
+The document is fetched from ` + "`<issuer>/.well-known/openid-configuration`" + ` and cached like ` + "`http.send`" + ` responses with caching enabled.
+The ` + "`issuer`" + ` in the document must match the requested issuer.`,
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("issuer", types.S).Description("issuer URL"),
+		),
+		types.Named("output", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("OpenID Connect provider metadata"),
+	),
+	Categories:       oauthCat,
+	Nondeterministic: true,
+}
+
+// Marked non-deterministic because it relies on network access internally.
+var OAuth2Introspect = &Builtin{
+	Name: "oauth2.introspect",
+	Description: `Introspects an OAuth 2.0 token as described in RFC 7662 and returns its normalized claims.
+The introspection endpoint is ` + "`introspection_endpoint`" + ` or the one found in the OpenID Connect discovery document of ` + "`issuer`" + `.
+Responses are cached like ` + "`http.send`" + ` responses with caching enabled.`,
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("token", types.S).Description("token to introspect"),
+			types.Named("options", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("introspection endpoint and client authentication options"),
+		),
+		types.Named("output", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("claims of the token; `{\"active\": false}` if the token is not active"),
+	),
+	Categories:       oauthCat,
+	Nondeterministic: true,
+}
+
 var tokenSign = category("tokensign")
 
 // Marked non-deterministic because it relies on RNG internally.
@@ -2312,6 +2382,100 @@ var Diff = &Builtin{
 	),
 }
 
+var TimeCronMatch = &Builtin{
+	Name: "time.cron_match",
+	Description: `Returns whether the cron expression fires at the minute of the nanoseconds since epoch.
+
+The cron expression has five fields (minute, hour, day of month, month and day of week) supporting
+lists, ranges, steps and month and day names, or is one of ` + "`@yearly`, `@monthly`, `@weekly`, `@daily` and `@hourly`" + `.
+If both day fields are restricted, either of them has to match.`,
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("cron", types.S).Description("cron expression"),
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string the expression is evaluated in"),
+		),
+		types.Named("result", types.B).Description("`true` if the cron expression fires at `x`"),
+	),
+}
+
+var TimeCronNext = &Builtin{
+	Name:        "time.cron_next",
+	Description: "Returns the first time after the nanoseconds since epoch the cron expression fires at. See `time.cron_match` for the cron expression syntax.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("cron", types.S).Description("cron expression"),
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string the expression is evaluated in"),
+		),
+		types.Named("ns", types.N).Description("nanoseconds since the epoch of the next fire time"),
+	),
+}
+
+var TimeCronPrev = &Builtin{
+	Name:        "time.cron_prev",
+	Description: "Returns the last time before the nanoseconds since epoch the cron expression fired at. See `time.cron_match` for the cron expression syntax.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("cron", types.S).Description("cron expression"),
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string the expression is evaluated in"),
+		),
+		types.Named("ns", types.N).Description("nanoseconds since the epoch of the previous fire time"),
+	),
+}
+
+var TimeConvertZone = &Builtin{
+	Name:        "time.convert_zone",
+	Description: "Converts a date-time without offset from one IANA time zone to another.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("datetime", types.S).Description("date-time in the `YYYY-MM-DDTHH:MM:SS` format, optionally with fractional seconds"),
+			types.Named("from", types.S).Description("time zone of `datetime`"),
+			types.Named("to", types.S).Description("time zone to convert to"),
+		),
+		types.Named("output", types.S).Description("the date-time in the `to` time zone, in the format of `datetime`"),
+	),
+}
+
+var TimeIntervalOverlap = &Builtin{
+	Name:        "time.interval_overlap",
+	Description: "Returns the overlap of two intervals, e.g., of nanoseconds since epoch. Intervals include their start but not their end.",
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("a", types.NewArray([]types.Type{types.N, types.N}, nil)).Description("`[start, end]` of the first interval"),
+			types.Named("b", types.NewArray([]types.Type{types.N, types.N}, nil)).Description("`[start, end]` of the second interval"),
+		),
+		types.Named("output", types.NewArray(nil, types.N)).Description("`[start, end]` of the overlap, or an empty array if the intervals do not overlap"),
+	),
+}
+
+var TimeInWindow = &Builtin{
+	Name: "time.in_window",
+	Description: `Returns whether the nanoseconds since epoch fall into a recurring time window.
+
+The window is an object with the keys ` + "`start` and `end` (times of day as `HH:MM` or `HH:MM:SS`), and optionally `days` " +
+		"(weekday names, e.g., `Monday` or `mon`), `tz` (IANA time zone, defaults to the time zone of `x`) and `exclude` " +
+		"(`YYYY-MM-DD` dates, e.g., holidays)" + `. Windows whose end is not after their start end on the next day
+and belong to the day they start on.`,
+	Decl: types.NewFunction(
+		types.Args(
+			types.Named("x", types.NewAny(
+				types.N,
+				types.NewArray([]types.Type{types.N, types.S}, nil),
+			)).Description("a number representing the nanoseconds since the epoch (UTC); or a two-element array of the nanoseconds, and a timezone string"),
+			types.Named("window", types.NewObject(nil, types.NewDynamicProperty(types.S, types.A))).Description("recurring time window"),
+		),
+		types.Named("result", types.B).Description("`true` if `x` falls into the window"),
+	),
+}
+
 /**
  * Crypto.
  */
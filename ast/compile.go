@@ -117,6 +117,10 @@ type Compiler struct {
 	// with the key being the generated name and value being the original.
 	RewrittenVars map[Var]Var
 
+	// SourceMap maps variables generated by rewrites to the terms they stand
+	// in for in the original source.
+	SourceMap SourceMap
+
 	// Capabliities required by the modules that were compiled.
 	Required *Capabilities
 
@@ -142,6 +146,9 @@ type Compiler struct {
 	schemaSet               *SchemaSet                    // user-supplied schemas for input and data documents
 	inputType               types.Type                    // global input type retrieved from schema set
 	annotationSet           *AnnotationSet                // hierarchical set of annotations
+	memoized                map[*Rule]struct{}            // rules annotated with "memoize: true"
+	constantEvaluator       ConstantEvaluator             // evaluates pure rules at compile-time if set
+	folded                  map[string]*Term              // values of documents folded at compile-time
 	strict                  bool                          // enforce strict compilation checks
 	keepModules             bool                          // whether to keep the unprocessed, parse modules (below)
 	parsedModules           map[string]*Module            // parsed, but otherwise unprocessed modules, kept track of when keepModules is true
@@ -294,15 +301,11 @@ type stage struct {
 func NewCompiler() *Compiler {
 
 	c := &Compiler{
-		Modules:       map[string]*Module{},
-		RewrittenVars: map[Var]Var{},
-		Required:      &Capabilities{},
-		ruleIndices: util.NewHashMap(func(a, b util.T) bool {
-			r1, r2 := a.(Ref), b.(Ref)
-			return r1.Equal(r2)
-		}, func(x util.T) int {
-			return x.(Ref).Hash()
-		}),
+		Modules:               map[string]*Module{},
+		RewrittenVars:         map[Var]Var{},
+		SourceMap:             SourceMap{},
+		Required:              &Capabilities{},
+		ruleIndices:           newRuleIndices(),
 		maxErrs:               CompileErrorLimitDefault,
 		after:                 map[string][]CompilerStageDefinition{},
 		unsafeBuiltinsMap:     map[string]struct{}{},
@@ -337,6 +340,8 @@ func NewCompiler() *Compiler {
 		{"SetAnnotationSet", "compile_stage_set_annotationset", c.setAnnotationSet},
 		{"RewriteRegoMetadataCalls", "compile_stage_rewrite_rego_metadata_calls", c.rewriteRegoMetadataCalls},
 		{"SetGraph", "compile_stage_set_graph", c.setGraph},
+		{"CheckVisibility", "compile_stage_check_visibility", c.checkVisibility},
+		{"CheckMemoizedRules", "compile_stage_check_memoized_rules", c.checkMemoizedRules},
 		{"RewriteComprehensionTerms", "compile_stage_rewrite_comprehension_terms", c.rewriteComprehensionTerms},
 		{"RewriteRefsInHead", "compile_stage_rewrite_refs_in_head", c.rewriteRefsInHead},
 		{"RewriteWithValues", "compile_stage_rewrite_with_values", c.rewriteWithModifiers},
@@ -352,6 +357,7 @@ func NewCompiler() *Compiler {
 		{"CheckDeprecatedBuiltins", "compile_state_check_deprecated_builtins", c.checkDeprecatedBuiltins},
 		{"BuildRuleIndices", "compile_stage_rebuild_indices", c.buildRuleIndices},
 		{"BuildComprehensionIndices", "compile_stage_rebuild_comprehension_indices", c.buildComprehensionIndices},
+		{"FoldConstants", "compile_stage_fold_constants", c.foldConstants}, // depends on BuildRuleIndices
 		{"BuildRequiredCapabilities", "compile_stage_build_required_capabilities", c.buildRequiredCapabilities},
 	}
 
@@ -1519,7 +1525,7 @@ func (c *Compiler) checkTypes() {
 		WithInputType(c.inputType).
 		WithBuiltins(c.builtins).
 		WithRequiredCapabilities(c.Required).
-		WithVarRewriter(rewriteVarsInRef(c.RewrittenVars)).
+		WithVarRewriter(rewriteGeneratedVarsInRef(c.SourceMap, c.RewrittenVars)).
 		WithAllowUndefinedFunctionCalls(c.allowUndefinedFuncCalls)
 	var as *AnnotationSet
 	if c.useTypeCheckAnnotations {
@@ -1690,6 +1696,11 @@ func (c *Compiler) GetAnnotationSet() *AnnotationSet {
 	return c.annotationSet
 }
 
+// GetSchemaSet returns the user-supplied schemas set with WithSchemas.
+func (c *Compiler) GetSchemaSet() *SchemaSet {
+	return c.schemaSet
+}
+
 func (c *Compiler) checkDuplicateImports() {
 	modules := make([]*Module, 0, len(c.Modules))
 
@@ -1807,7 +1818,7 @@ func (c *Compiler) removeImports() {
 }
 
 func (c *Compiler) initLocalVarGen() {
-	c.localvargen = newLocalVarGeneratorForModuleSet(c.sorted, c.Modules)
+	c.localvargen = newLocalVarGeneratorForModuleSet(c.sorted, c.Modules).WithSourceMap(c.SourceMap)
 }
 
 func (c *Compiler) rewriteComprehensionTerms() {
@@ -2023,7 +2034,7 @@ func rewritePrintCalls(gen *localVarGenerator, getArity func(Ref) int, globals V
 		arr := NewArray()
 
 		for j := range args {
-			x := NewTerm(gen.Generate()).SetLocation(args[j].Loc())
+			x := gen.GenerateFor(args[j])
 			capture := Equality.Expr(x, args[j]).SetLocation(args[j].Loc())
 			arr = arr.Append(SetComprehensionTerm(x, NewBody(capture)).SetLocation(args[j].Loc()))
 		}
@@ -2683,11 +2694,181 @@ func (c *Compiler) setGraph() {
 	c.Graph = NewGraph(c.Modules, list)
 }
 
+// PrivateTo returns the package path prefix that rule is visible to or nil if
+// the rule is public. See AnnotationSet.PrivateTo for details.
+func (c *Compiler) PrivateTo(rule *Rule) Ref {
+	if c.annotationSet == nil {
+		return nil
+	}
+	return c.annotationSet.PrivateTo(rule)
+}
+
+// checkVisibility reports an error for every reference to a private rule from
+// a module outside of the package prefix the rule is visible to.
+func (c *Compiler) checkVisibility() {
+	if c.annotationSet == nil || !c.hasPrivateAnnotations() {
+		return
+	}
+
+	cache := map[*Rule]Ref{}
+	privateTo := func(rule *Rule) Ref {
+		prefix, ok := cache[rule]
+		if !ok {
+			prefix = c.PrivateTo(rule)
+			cache[rule] = prefix
+		}
+		return prefix
+	}
+
+	for _, name := range c.sorted {
+		mod := c.Modules[name]
+		for _, r := range mod.Rules {
+			for _, err := range c.visibilityErrors(mod.Package.Path, r, privateTo) {
+				c.err(err)
+			}
+		}
+	}
+}
+
+// visibilityErrors returns an error for every reference in x that selects a
+// private rule not visible from path. References to enclosing documents (e.g.,
+// the package containing a private rule) do not select the rule and are
+// allowed.
+func (c *Compiler) visibilityErrors(path Ref, x interface{}, privateTo func(*Rule) Ref) Errors {
+	var errs Errors
+	WalkTerms(x, func(t *Term) bool {
+		ref, ok := t.Value.(Ref)
+		if !ok || !ref.HasPrefix(DefaultRootRef) {
+			return false
+		}
+		for _, rule := range c.GetRulesDynamicWithOpts(ref, RulesOptions{IncludeHiddenModules: true}) {
+			if len(ref) < len(rule.Ref().GroundPrefix()) {
+				continue
+			}
+			if prefix := privateTo(rule); prefix != nil && !path.HasPrefix(prefix) {
+				errs = append(errs, NewError(CompileErr, t.Location, "%v refers to private rule %v which is only visible within %v",
+					ref, rule.Ref(), prefix))
+				break
+			}
+		}
+		return false
+	})
+	return errs
+}
+
+// Memoized returns true if rule has been annotated with "memoize: true" (at
+// rule or document scope). The values of memoized rules may be cached across
+// queries.
+func (c *Compiler) Memoized(rule *Rule) bool {
+	_, ok := c.memoized[rule]
+	return ok
+}
+
+// checkMemoizedRules records rules annotated with "memoize: true" and reports
+// an error for memoized rules that are not pure, i.e., rules that (directly
+// or transitively) refer to input or call non-deterministic built-in
+// functions. Only functions and complete rules may be memoized.
+func (c *Compiler) checkMemoizedRules() {
+	c.memoized = map[*Rule]struct{}{}
+	if c.annotationSet == nil {
+		return
+	}
+
+	for _, name := range c.sorted {
+		for _, rule := range c.Modules[name].Rules {
+			if !c.memoizeAnnotated(rule) {
+				continue
+			}
+			if err := c.checkMemoizedRule(rule); err != nil {
+				c.err(err)
+				continue
+			}
+			c.memoized[rule] = struct{}{}
+		}
+	}
+}
+
+func (c *Compiler) memoizeAnnotated(rule *Rule) bool {
+	for _, a := range c.annotationSet.GetRuleScope(rule) {
+		if a.Memoize {
+			return true
+		}
+	}
+	a := c.annotationSet.GetDocumentScope(rule.Ref().GroundPrefix())
+	return a != nil && a.Memoize
+}
+
+func (c *Compiler) checkMemoizedRule(rule *Rule) *Error {
+	if len(rule.Head.Args) == 0 && (rule.Head.RuleKind() != SingleValue || !rule.Head.Ref().IsGround()) {
+		return NewError(CompileErr, rule.Loc(), "memoized rule %v must be a function or complete rule", rule.Ref())
+	}
+
+	var err *Error
+	visited := map[*Rule]struct{}{}
+
+	var visit func(r *Rule)
+	visit = func(r *Rule) {
+		if _, ok := visited[r]; ok || err != nil {
+			return
+		}
+		visited[r] = struct{}{}
+
+		WalkTerms(r, func(x *Term) bool {
+			if err != nil {
+				return true
+			}
+			switch v := x.Value.(type) {
+			case Ref:
+				if v.HasPrefix(InputRootRef) {
+					err = NewError(CompileErr, x.Location, "memoized rule %v must not depend on input", rule.Ref())
+				}
+			case Call:
+				err = c.checkMemoizedCall(rule, v[0].String(), x.Location)
+			}
+			return err != nil
+		})
+
+		WalkExprs(r, func(expr *Expr) bool {
+			if err == nil && expr.IsCall() {
+				err = c.checkMemoizedCall(rule, expr.Operator().String(), expr.Location)
+			}
+			return err != nil
+		})
+
+		for dep := range c.Graph.Dependencies(r) {
+			visit(dep.(*Rule))
+		}
+	}
+
+	visit(rule)
+
+	return err
+}
+
+func (c *Compiler) checkMemoizedCall(rule *Rule, name string, loc *Location) *Error {
+	if bi, ok := c.builtins[name]; ok && bi.Nondeterministic {
+		return NewError(CompileErr, loc, "memoized rule %v must not call non-deterministic built-in function %v", rule.Ref(), name)
+	}
+	return nil
+}
+
+func (c *Compiler) hasPrivateAnnotations() bool {
+	for _, mod := range c.Modules {
+		for _, a := range mod.Annotations {
+			if a.Private {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type queryCompiler struct {
 	compiler              *Compiler
 	qctx                  *QueryContext
 	typeEnv               *TypeEnv
 	rewritten             map[Var]Var
+	sourceMap             SourceMap
 	after                 map[string][]QueryCompilerStageDefinition
 	unsafeBuiltins        map[string]struct{}
 	comprehensionIndices  map[*Term]*ComprehensionIndex
@@ -2698,6 +2879,7 @@ func newQueryCompiler(compiler *Compiler) QueryCompiler {
 	qc := &queryCompiler{
 		compiler:             compiler,
 		qctx:                 nil,
+		sourceMap:            SourceMap{},
 		after:                map[string][]QueryCompilerStageDefinition{},
 		comprehensionIndices: map[*Term]*ComprehensionIndex{},
 	}
@@ -2733,6 +2915,12 @@ func (qc *queryCompiler) RewrittenVars() map[Var]Var {
 	return qc.rewritten
 }
 
+// SourceMap maps vars generated while compiling the query to the terms they
+// stand in for in the parsed query.
+func (qc *queryCompiler) SourceMap() SourceMap {
+	return qc.sourceMap
+}
+
 func (qc *queryCompiler) ComprehensionIndex(term *Term) *ComprehensionIndex {
 	if result, ok := qc.comprehensionIndices[term]; ok {
 		return result
@@ -2774,6 +2962,7 @@ func (qc *queryCompiler) Compile(query Body) (Body, error) {
 	stages := []queryStage{
 		{"CheckKeywordOverrides", "query_compile_stage_check_keyword_overrides", qc.checkKeywordOverrides},
 		{"ResolveRefs", "query_compile_stage_resolve_refs", qc.resolveRefs},
+		{"CheckVisibility", "query_compile_stage_check_visibility", qc.checkVisibility},
 		{"RewriteLocalVars", "query_compile_stage_rewrite_local_vars", qc.rewriteLocalVars},
 		{"CheckVoidCalls", "query_compile_stage_check_void_calls", qc.checkVoidCalls},
 		{"RewritePrintCalls", "query_compile_stage_rewrite_print_calls", qc.rewritePrintCalls},
@@ -2862,7 +3051,7 @@ func (qc *queryCompiler) resolveRefs(qctx *QueryContext, body Body) (Body, error
 }
 
 func (qc *queryCompiler) rewriteComprehensionTerms(_ *QueryContext, body Body) (Body, error) {
-	gen := newLocalVarGenerator("q", body)
+	gen := newLocalVarGenerator("q", body).WithSourceMap(qc.sourceMap)
 	f := newEqualityFactory(gen)
 	node, err := rewriteComprehensionTerms(f, body)
 	if err != nil {
@@ -2872,18 +3061,18 @@ func (qc *queryCompiler) rewriteComprehensionTerms(_ *QueryContext, body Body) (
 }
 
 func (qc *queryCompiler) rewriteDynamicTerms(_ *QueryContext, body Body) (Body, error) {
-	gen := newLocalVarGenerator("q", body)
+	gen := newLocalVarGenerator("q", body).WithSourceMap(qc.sourceMap)
 	f := newEqualityFactory(gen)
 	return rewriteDynamics(f, body), nil
 }
 
 func (qc *queryCompiler) rewriteExprTerms(_ *QueryContext, body Body) (Body, error) {
-	gen := newLocalVarGenerator("q", body)
+	gen := newLocalVarGenerator("q", body).WithSourceMap(qc.sourceMap)
 	return rewriteExprTermsInBody(gen, body), nil
 }
 
 func (qc *queryCompiler) rewriteLocalVars(_ *QueryContext, body Body) (Body, error) {
-	gen := newLocalVarGenerator("q", body)
+	gen := newLocalVarGenerator("q", body).WithSourceMap(qc.sourceMap)
 	stack := newLocalDeclaredVars()
 	body, _, err := rewriteLocalVars(gen, stack, nil, body, qc.compiler.strict)
 	if len(err) != 0 {
@@ -2904,7 +3093,7 @@ func (qc *queryCompiler) rewritePrintCalls(_ *QueryContext, body Body) (Body, er
 		_, cpy := erasePrintCallsInBody(body)
 		return cpy, nil
 	}
-	gen := newLocalVarGenerator("q", body)
+	gen := newLocalVarGenerator("q", body).WithSourceMap(qc.sourceMap)
 	if _, errs := rewritePrintCalls(gen, qc.compiler.GetArity, ReservedVars, body); len(errs) > 0 {
 		return nil, errs
 	}
@@ -2939,7 +3128,7 @@ func (qc *queryCompiler) checkTypes(_ *QueryContext, body Body) (Body, error) {
 	checker := newTypeChecker().
 		WithSchemaSet(qc.compiler.schemaSet).
 		WithInputType(qc.compiler.inputType).
-		WithVarRewriter(rewriteVarsInRef(qc.rewritten, qc.compiler.RewrittenVars))
+		WithVarRewriter(rewriteGeneratedVarsInRef(qc.sourceMap, qc.rewritten, qc.compiler.RewrittenVars))
 	qc.typeEnv, errs = checker.CheckBody(qc.compiler.TypeEnv, body)
 	if len(errs) > 0 {
 		return nil, errs
@@ -2956,6 +3145,23 @@ func (qc *queryCompiler) checkUnsafeBuiltins(_ *QueryContext, body Body) (Body,
 	return body, nil
 }
 
+// checkVisibility reports an error for every reference in the query to a
+// private rule that is not visible from the query's package (if any).
+func (qc *queryCompiler) checkVisibility(qctx *QueryContext, body Body) (Body, error) {
+	c := qc.compiler
+	if c.annotationSet == nil || !c.hasPrivateAnnotations() {
+		return body, nil
+	}
+	var path Ref
+	if qctx != nil && qctx.Package != nil {
+		path = qctx.Package.Path
+	}
+	if errs := c.visibilityErrors(path, body, c.PrivateTo); len(errs) > 0 {
+		return nil, errs
+	}
+	return body, nil
+}
+
 func (qc *queryCompiler) unsafeBuiltinsMap() map[string]struct{} {
 	if qc.unsafeBuiltins != nil {
 		return qc.unsafeBuiltins
@@ -2974,7 +3180,7 @@ func (qc *queryCompiler) checkDeprecatedBuiltins(_ *QueryContext, body Body) (Bo
 }
 
 func (qc *queryCompiler) rewriteWithModifiers(_ *QueryContext, body Body) (Body, error) {
-	f := newEqualityFactory(newLocalVarGenerator("q", body))
+	f := newEqualityFactory(newLocalVarGenerator("q", body).WithSourceMap(qc.sourceMap))
 	body, err := rewriteWithModifiersInBody(qc.compiler, qc.unsafeBuiltinsMap(), f, body)
 	if err != nil {
 		return nil, Errors{err}
@@ -4070,7 +4276,7 @@ func newEqualityFactory(gen *localVarGenerator) *equalityFactory {
 }
 
 func (f *equalityFactory) Generate(other *Term) *Expr {
-	term := NewTerm(f.gen.Generate()).SetLocation(other.Location)
+	term := f.gen.GenerateFor(other)
 	expr := Equality.Expr(term, other)
 	expr.Generated = true
 	expr.Location = other.Location
@@ -4081,6 +4287,7 @@ type localVarGenerator struct {
 	exclude VarSet
 	suffix  string
 	next    int
+	sources SourceMap
 }
 
 func newLocalVarGeneratorForModuleSet(sorted []string, modules map[string]*Module) *localVarGenerator {
@@ -4099,6 +4306,20 @@ func newLocalVarGenerator(suffix string, node interface{}) *localVarGenerator {
 	return &localVarGenerator{exclude: exclude, suffix: suffix, next: 0}
 }
 
+// WithSourceMap sets the source map that generated vars are recorded in.
+func (l *localVarGenerator) WithSourceMap(sources SourceMap) *localVarGenerator {
+	l.sources = sources
+	return l
+}
+
+// GenerateFor returns a term holding a new var that stands in for x. The term
+// is located at x and the var is recorded in the generator's source map.
+func (l *localVarGenerator) GenerateFor(x *Term) *Term {
+	v := l.Generate()
+	l.sources.add(v, x)
+	return NewTerm(v).SetLocation(x.Location)
+}
+
 func (l *localVarGenerator) Generate() Var {
 	for {
 		result := Var("__local" + l.suffix + strconv.Itoa(l.next) + "__")
@@ -4724,7 +4945,7 @@ func expandExpr(gen *localVarGenerator, expr *Expr) (result []*Expr) {
 		if _, ok := terms.Domain.Value.(Call); ok {
 			extras, terms.Domain = expandExprTerm(gen, terms.Domain)
 		} else {
-			term := NewTerm(gen.Generate()).SetLocation(terms.Domain.Location)
+			term := gen.GenerateFor(terms.Domain)
 			eq := Equality.Expr(term, terms.Domain).SetLocation(terms.Domain.Location)
 			eq.Generated = true
 			eq.With = expr.With
@@ -4747,7 +4968,7 @@ func expandExprTerm(gen *localVarGenerator, term *Term) (support []*Expr, output
 			extras, v[i] = expandExprTerm(gen, v[i])
 			support = append(support, extras...)
 		}
-		output = NewTerm(gen.Generate()).SetLocation(term.Location)
+		output = gen.GenerateFor(term)
 		expr := v.MakeExpr(output).SetLocation(term.Location)
 		expr.Generated = true
 		support = append(support, expr)
@@ -5170,7 +5391,7 @@ func rewriteEveryStatement(g *localVarGenerator, stack *localDeclaredVars, expr
 			every.Key.Value = gv
 		}
 	} else { // if the key doesn't exist, add dummy local
-		every.Key = NewTerm(g.Generate())
+		every.Key = NewTerm(g.Generate()).SetLocation(every.Value.Location)
 	}
 
 	// value is always present
@@ -5205,7 +5426,7 @@ func rewriteSomeDeclStatement(g *localVarGenerator, stack *localDeclaredVars, ex
 				val = v[2]
 				container = v[3]
 			case 3: // member
-				key = NewTerm(g.Generate())
+				key = NewTerm(g.Generate()).SetLocation(v[1].Location)
 				val = v[1]
 				container = v[2]
 			}
@@ -5684,6 +5905,16 @@ func checkUnsafeBuiltins(unsafeBuiltinsMap map[string]struct{}, node interface{}
 	return errs
 }
 
+// rewriteGeneratedVarsInRef returns a varRewriter that first replaces vars
+// generated by the compiler with the terms they stand in for and then applies
+// the rewritten vars.
+func rewriteGeneratedVarsInRef(sources SourceMap, vars ...map[Var]Var) varRewriter {
+	rw := rewriteVarsInRef(vars...)
+	return func(node Ref) Ref {
+		return rw(sources.OriginalRef(node))
+	}
+}
+
 func rewriteVarsInRef(vars ...map[Var]Var) varRewriter {
 	return func(node Ref) Ref {
 		i, _ := TransformVars(node, func(v Var) (Value, error) {
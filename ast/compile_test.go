@@ -10679,3 +10679,330 @@ deny {
 		t.Fatal(c.Errors)
 	}
 }
+
+func TestCompilerCheckVisibility(t *testing.T) {
+	lib := `# METADATA
+# scope: subpackages
+# private: true
+package lib
+
+helper := 1
+
+public_helper := helper`
+
+	tests := []struct {
+		note    string
+		modules map[string]string
+		exp     []string
+	}{
+		{
+			note: "private rule referenced within package",
+			modules: map[string]string{
+				"mod1.rego": `package a
+
+# METADATA
+# private: true
+helper := 1
+
+p := helper`,
+			},
+		},
+		{
+			note: "private rule referenced from other package",
+			modules: map[string]string{
+				"mod1.rego": `package a
+
+# METADATA
+# private: true
+helper := 1
+
+pub := 2`,
+				"mod2.rego": `package b
+
+p := data.a.helper
+q := data.a.pub`,
+			},
+			exp: []string{"mod2.rego:3: rego_compile_error: data.a.helper refers to private rule data.a.helper which is only visible within data.a"},
+		},
+		{
+			note: "private rule referenced via package prefix",
+			modules: map[string]string{
+				"mod1.rego": `package a
+
+# METADATA
+# scope: document
+# private: true
+helper := 1`,
+				"mod2.rego": `package b
+
+p := data.a`,
+			},
+		},
+		{
+			note: "private rule selected via dynamic ref",
+			modules: map[string]string{
+				"mod1.rego": `package a
+
+# METADATA
+# private: true
+helper := 1`,
+				"mod2.rego": `package b
+
+p := data.a[_]`,
+			},
+			exp: []string{"mod2.rego:3: rego_compile_error: data.a[_] refers to private rule data.a.helper which is only visible within data.a"},
+		},
+		{
+			note: "private subpackages referenced from within prefix",
+			modules: map[string]string{
+				"lib.rego": lib,
+				"mod1.rego": `package lib.strings
+
+p := data.lib.helper`,
+			},
+		},
+		{
+			note: "private subpackages referenced from outside prefix",
+			modules: map[string]string{
+				"lib.rego": lib,
+				"mod1.rego": `package lib.strings
+
+q := 1`,
+				"mod2.rego": `package app
+
+p := data.lib.public_helper
+q := data.lib.strings.q`,
+			},
+			exp: []string{
+				"mod2.rego:3: rego_compile_error: data.lib.public_helper refers to private rule data.lib.public_helper which is only visible within data.lib",
+				"mod2.rego:4: rego_compile_error: data.lib.strings.q refers to private rule data.lib.strings.q which is only visible within data.lib",
+			},
+		},
+		{
+			note: "private package",
+			modules: map[string]string{
+				"mod1.rego": `# METADATA
+# private: true
+package a
+
+p := 1`,
+				"mod2.rego": `package b
+
+p := data.a.p`,
+			},
+			exp: []string{"mod2.rego:3: rego_compile_error: data.a.p refers to private rule data.a.p which is only visible within data.a"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			modules := make(map[string]*Module, len(tc.modules))
+			for name, src := range tc.modules {
+				mod, err := ParseModuleWithOpts(name, src, ParserOptions{ProcessAnnotation: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				modules[name] = mod
+			}
+
+			c := NewCompiler()
+			c.Compile(modules)
+
+			var act []string
+			for _, err := range c.Errors {
+				act = append(act, err.Error())
+			}
+			if !reflect.DeepEqual(tc.exp, act) {
+				t.Fatalf("expected errors:\n%v\n\ngot:\n%v", strings.Join(tc.exp, "\n"), strings.Join(act, "\n"))
+			}
+		})
+	}
+}
+
+func TestQueryCompilerCheckVisibility(t *testing.T) {
+	mod := MustParseModuleWithOpts(`package a
+
+# METADATA
+# private: true
+helper := 1
+
+pub := helper`, ParserOptions{ProcessAnnotation: true})
+
+	c := NewCompiler()
+	c.Compile(map[string]*Module{"mod.rego": mod})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	tests := []struct {
+		note  string
+		pkg   string
+		query string
+		exp   string
+	}{
+		{
+			note:  "private rule",
+			query: "data.a.helper",
+			exp:   "1 error occurred: 1:1: rego_compile_error: data.a.helper refers to private rule data.a.helper which is only visible within data.a",
+		},
+		{
+			note:  "private rule from other package",
+			pkg:   "b",
+			query: "x = data.a.helper",
+			exp:   "1 error occurred: 1:5: rego_compile_error: data.a.helper refers to private rule data.a.helper which is only visible within data.a",
+		},
+		{
+			note:  "private rule from same package",
+			pkg:   "a",
+			query: "helper",
+		},
+		{
+			note:  "public rule",
+			query: "data.a.pub",
+		},
+		{
+			note:  "enclosing package",
+			query: "data.a",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			qc := c.QueryCompiler()
+			if tc.pkg != "" {
+				qc = qc.WithContext(NewQueryContext().WithPackage(MustParsePackage("package " + tc.pkg)))
+			}
+			_, err := qc.Compile(MustParseBody(tc.query))
+			if tc.exp == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tc.exp {
+				t.Fatalf("expected error %q but got: %v", tc.exp, err)
+			}
+		})
+	}
+}
+
+func TestCompilerPrivateTo(t *testing.T) {
+	c := NewCompiler()
+	c.Compile(map[string]*Module{
+		"test.rego": MustParseModuleWithOpts(`package test
+
+# METADATA
+# private: true
+p := 1
+
+q := 2`, ParserOptions{ProcessAnnotation: true}),
+	})
+	assertNotFailed(t, c)
+
+	rules := c.GetRules(MustParseRef("data.test"))
+	for _, rule := range rules {
+		act := c.PrivateTo(rule)
+		switch rule.Head.Name {
+		case "p":
+			if !act.Equal(MustParseRef("data.test")) {
+				t.Fatalf("expected data.test but got %v", act)
+			}
+		default:
+			if act != nil {
+				t.Fatalf("expected nil but got %v", act)
+			}
+		}
+	}
+}
+
+func TestParsePrivateAndEntrypointAnnotation(t *testing.T) {
+	_, err := ParseModuleWithOpts("test.rego", `package test
+
+# METADATA
+# entrypoint: true
+# private: true
+p := 1`, ParserOptions{ProcessAnnotation: true})
+	if err == nil || !strings.Contains(err.Error(), "annotation private cannot be combined with entrypoint") {
+		t.Fatalf("expected error but got: %v", err)
+	}
+}
+
+func TestCompilerCheckMemoizedRules(t *testing.T) {
+	tests := []struct {
+		note   string
+		module string
+		exp    string
+	}{
+		{
+			note: "function",
+			module: `# METADATA
+# memoize: true
+f(x) := y { y := data.a[x] }`,
+		},
+		{
+			note: "document scope",
+			module: `# METADATA
+# scope: document
+# memoize: true
+p := 1
+
+p := 1`,
+		},
+		{
+			note: "partial set",
+			module: `# METADATA
+# memoize: true
+p[x] { x := data.a[_] }`,
+			exp: "test.rego:4: rego_compile_error: memoized rule data.test.p must be a function or complete rule",
+		},
+		{
+			note: "input",
+			module: `# METADATA
+# memoize: true
+p := input.x`,
+			exp: "test.rego:4: rego_compile_error: memoized rule data.test.p must not depend on input",
+		},
+		{
+			note: "transitive input",
+			module: `# METADATA
+# memoize: true
+p := q
+
+q := x { x := input.x }`,
+			exp: "test.rego:6: rego_compile_error: memoized rule data.test.p must not depend on input",
+		},
+		{
+			note: "non-deterministic builtin",
+			module: `# METADATA
+# memoize: true
+p := x { x := time.now_ns() }`,
+			exp: "test.rego:4: rego_compile_error: memoized rule data.test.p must not call non-deterministic built-in function time.now_ns",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			mod, err := ParseModuleWithOpts("test.rego", "package test\n"+tc.module, ParserOptions{ProcessAnnotation: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c := NewCompiler()
+			c.Compile(map[string]*Module{"test.rego": mod})
+
+			if tc.exp == "" {
+				assertNotFailed(t, c)
+				for _, rule := range c.Modules["test.rego"].Rules {
+					if !c.Memoized(rule) {
+						t.Fatalf("expected %v to be memoized", rule.Head.Name)
+					}
+				}
+				return
+			}
+
+			if len(c.Errors) != 1 || c.Errors[0].Error() != tc.exp {
+				t.Fatalf("expected error %q but got: %v", tc.exp, c.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"sort"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+// ConstantEvaluator evaluates the document identified by ref using the
+// compiler c. If the document is undefined, false is returned. The evaluator
+// is only invoked for documents that do not depend on input, base documents,
+// or non-deterministic built-in functions.
+type ConstantEvaluator func(c *Compiler, ref Ref) (Value, bool, error)
+
+// impureBuiltins contains built-in functions that are deterministic but must
+// not be evaluated at compile-time because of their side effects.
+var impureBuiltins = map[string]struct{}{
+	Print.Name:         {},
+	InternalPrint.Name: {},
+	Trace.Name:         {},
+}
+
+// WithConstantFolding enables the constant folding stage. Complete rules that
+// only depend on literals, deterministic built-in functions, and other such
+// rules are evaluated with ev at compile-time. The bodies of folded rules are
+// replaced with their value and references to them are replaced with the
+// value itself.
+func (c *Compiler) WithConstantFolding(ev ConstantEvaluator) *Compiler {
+	c.constantEvaluator = ev
+	return c
+}
+
+// FoldedConstants returns the values of the documents folded by the constant
+// folding stage keyed by the document path (e.g., "data.x.y").
+func (c *Compiler) FoldedConstants() map[string]*Term {
+	return c.folded
+}
+
+// ApplyFoldedConstants rewrites mod according to the results of the constant
+// folding stage: folded rules are replaced with their values as are references
+// to folded documents. Unlike the modules held by the compiler, mod is expected
+// to be a source module, i.e., references are resolved against the package and
+// imports of mod first.
+func (c *Compiler) ApplyFoldedConstants(mod *Module) {
+	if len(c.folded) == 0 {
+		return
+	}
+
+	var ruleExports []Ref
+	if x, ok := c.getExports().Get(mod.Package.Path); ok {
+		ruleExports = x.([]Ref)
+	}
+	globals := getGlobals(mod.Package, ruleExports, mod.Imports)
+
+	for _, rule := range mod.Rules {
+		if value, ok := c.folded[rule.Ref().String()]; ok && !rule.Default {
+			foldRule(rule, value.Value)
+			continue
+		}
+		replaceFoldedSourceRefs(rule, globals, c.folded)
+	}
+}
+
+// replaceFoldedSourceRefs replaces references to folded documents in rule
+// with their values. Vars declared anywhere in the rule are never resolved
+// against globals, so shadowed names are left as-is.
+func replaceFoldedSourceRefs(rule *Rule, globals map[Var]*usedRef, folded map[string]*Term) {
+	declared := NewVarSet()
+	WalkVars(rule.Head.Args, func(v Var) bool {
+		declared.Add(v)
+		return false
+	})
+	for r := rule; r != nil; r = r.Else {
+		WalkBodies(r, func(b Body) bool {
+			declared.Update(declaredVars(b))
+			return false
+		})
+		WalkExprs(r, func(expr *Expr) bool {
+			if every, ok := expr.Terms.(*Every); ok {
+				declared.Update(every.KeyValueVars())
+			}
+			return false
+		})
+	}
+	ignore := &declaredVarStack{declared}
+
+	lookup := func(ref Ref) (*Term, bool) {
+		value, ok := folded[resolveRef(globals, ignore, ref).String()]
+		return value, ok
+	}
+
+	var vis *GenericVisitor
+	vis = NewGenericVisitor(func(x interface{}) bool {
+		switch x := x.(type) {
+		case *With:
+			vis.Walk(x.Value)
+			return true
+		case *Term:
+			switch v := x.Value.(type) {
+			case Var:
+				if value, ok := lookup(Ref{x}); ok {
+					x.Value = value.Value
+				}
+				return true
+			case Ref:
+				if value, ok := lookup(v); ok {
+					x.Value = value.Value
+					return true
+				}
+				for _, t := range v[1:] {
+					vis.Walk(t)
+				}
+				return true
+			}
+		}
+		return false
+	})
+
+	for r := rule; r != nil; r = r.Else {
+		vis.Walk(r.Body)
+		if r.Head.Value != nil {
+			vis.Walk(r.Head.Value)
+		}
+		if r.Head.Key != nil {
+			vis.Walk(r.Head.Key)
+		}
+	}
+}
+
+// foldConstants evaluates pure complete rules and replaces their bodies (and
+// references to them) with their values. Since this changes the rule bodies,
+// the dependency graph and rule indices are rebuilt afterwards.
+func (c *Compiler) foldConstants() {
+	if c.constantEvaluator == nil || c.Failed() {
+		return
+	}
+
+	f := &constantFolder{c: c, pure: map[*Rule]bool{}}
+	for _, name := range c.sorted {
+		WalkExprs(c.Modules[name], func(expr *Expr) bool {
+			for _, w := range expr.With {
+				if ref, ok := w.Target.Value.(Ref); ok {
+					f.mocked = append(f.mocked, ref)
+				}
+			}
+			return false
+		})
+	}
+
+	var paths []Ref
+	c.RuleTree.DepthFirst(func(node *TreeNode) bool {
+		if rules := extractRules(node.Values); len(rules) > 0 && f.foldable(rules) {
+			paths = append(paths, rules[0].Ref())
+		}
+		return false
+	})
+
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i].Compare(paths[j]) < 0
+	})
+
+	folded := map[string]*Term{}
+	for _, path := range paths {
+		value, ok, err := c.constantEvaluator(c, path)
+		if err != nil || !ok {
+			// Errors are left to be reported when the rule is evaluated.
+			continue
+		}
+		folded[path.String()] = NewTerm(value)
+		for _, rule := range c.GetRulesExact(path) {
+			if !rule.Default {
+				foldRule(rule, value)
+			}
+		}
+	}
+
+	if len(folded) == 0 {
+		return
+	}
+
+	c.folded = folded
+
+	for _, name := range c.sorted {
+		replaceFoldedRefs(c.Modules[name], folded)
+	}
+
+	c.setGraph()
+	c.ruleIndices = newRuleIndices()
+	c.buildRuleIndices()
+}
+
+type constantFolder struct {
+	c      *Compiler
+	pure   map[*Rule]bool
+	mocked []Ref // targets of the with keyword
+}
+
+// foldable returns true if rules define a complete document that is pure and
+// not trivially constant already.
+func (f *constantFolder) foldable(rules []*Rule) bool {
+	trivial := true
+	for _, rule := range rules {
+		if len(rule.Head.Args) > 0 || rule.Head.RuleKind() != SingleValue || !rule.Head.Ref().IsGround() {
+			return false
+		}
+		if f.isMocked(rule.Ref()) || !f.isPure(rule) {
+			return false
+		}
+		if !rule.Default && (rule.Else != nil || !isTrueBody(rule.Body) || !IsConstant(rule.Head.Value.Value)) {
+			trivial = false
+		}
+	}
+	return !trivial
+}
+
+// isPure returns true if the rule (and everything it refers to) does not depend
+// on input, base documents, the with keyword, documents or functions replaced
+// with the with keyword, or built-in functions that are non-deterministic or
+// have side effects.
+func (f *constantFolder) isPure(rule *Rule) bool {
+	if pure, ok := f.pure[rule]; ok {
+		return pure
+	}
+
+	// Rules cannot be recursive at this point, so it is safe to mark the rule
+	// before visiting its dependencies.
+	f.pure[rule] = true
+
+	pure := true
+	vis := NewGenericVisitor(func(x interface{}) bool {
+		if !pure {
+			return true
+		}
+		switch x := x.(type) {
+		case *Expr:
+			if len(x.With) > 0 {
+				pure = false
+			} else if x.IsCall() {
+				pure = f.isPureCall(x.Operator())
+			}
+		case Call:
+			pure = f.isPureCall(x[0].Value.(Ref))
+		case Ref:
+			pure = f.isPureRef(x)
+		}
+		return !pure
+	})
+	vis.Walk(rule)
+
+	f.pure[rule] = pure
+	return pure
+}
+
+func (f *constantFolder) isPureCall(operator Ref) bool {
+	if f.isMocked(operator) {
+		return false
+	}
+	name := operator.String()
+	if bi, ok := f.c.builtins[name]; ok {
+		_, impure := impureBuiltins[name]
+		return !bi.Nondeterministic && !impure
+	}
+	return f.isPureRef(operator)
+}
+
+func (f *constantFolder) isPureRef(ref Ref) bool {
+	if f.isMocked(ref) {
+		return false
+	}
+	switch {
+	case ref.HasPrefix(InputRootRef):
+		return false
+	case ref.HasPrefix(DefaultRootRef):
+		rules := f.c.GetRulesForVirtualDocument(ref)
+		if len(rules) == 0 {
+			// Base document or a document containing both base and virtual documents.
+			return false
+		}
+		for _, rule := range rules {
+			if !f.isPure(rule) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isMocked returns true if the document or function identified by ref, or a
+// document containing it or contained by it, is replaced with the with
+// keyword anywhere. Folding such documents would inline values the mocks no
+// longer apply to.
+func (f *constantFolder) isMocked(ref Ref) bool {
+	for _, target := range f.mocked {
+		if ref.HasPrefix(target) || target.HasPrefix(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrueBody(body Body) bool {
+	return len(body) == 1 && body[0].Equal(NewExpr(BooleanTerm(true)))
+}
+
+func foldRule(rule *Rule, value Value) {
+	loc := rule.Head.Location
+	if rule.Head.Value != nil {
+		loc = rule.Head.Value.Location
+	}
+	rule.Head.Value = NewTerm(value).SetLocation(loc)
+	rule.Body = NewBody(NewExpr(BooleanTerm(true).SetLocation(rule.Location)).SetLocation(rule.Location))
+	rule.Else = nil
+	rule.generatedBody = true
+}
+
+// replaceFoldedRefs replaces references to folded documents with their values.
+// With keyword targets are left untouched.
+func replaceFoldedRefs(mod *Module, folded map[string]*Term) {
+	var vis *GenericVisitor
+	vis = NewGenericVisitor(func(x interface{}) bool {
+		switch x := x.(type) {
+		case *With:
+			vis.Walk(x.Value)
+			return true
+		case *Term:
+			if ref, ok := x.Value.(Ref); ok {
+				if value, ok := folded[ref.String()]; ok {
+					x.Value = value.Value
+					return true
+				}
+			}
+		}
+		return false
+	})
+	for _, rule := range mod.Rules {
+		vis.Walk(rule.Body)
+		if rule.Else != nil {
+			vis.Walk(rule.Else)
+		}
+		if rule.Head.Value != nil {
+			vis.Walk(rule.Head.Value)
+		}
+		if rule.Head.Key != nil {
+			vis.Walk(rule.Head.Key)
+		}
+	}
+}
+
+func newRuleIndices() *util.HashMap {
+	return util.NewHashMap(func(a, b util.T) bool {
+		r1, r2 := a.(Ref), b.(Ref)
+		return r1.Equal(r2)
+	}, func(x util.T) int {
+		return x.(Ref).Hash()
+	})
+}
@@ -0,0 +1,238 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestCompilerFoldConstants(t *testing.T) {
+	module := MustParseModuleWithOpts(`package test
+
+import data.lib
+
+a := 1 + 2
+
+b := a * 2
+
+c := concat(",", ["x", "y"])
+
+d := count(input.xs)
+
+e := time.now_ns()
+
+f := x if {
+	x := data.base.y
+}
+
+g := 1 if print("side effect")
+
+h := lib.k
+
+i := "error" if {
+	to_number("x")
+}
+
+j := y if {
+	a := input.z
+	y := a
+}
+
+k if {
+	input.n < b
+	some x in [a]
+	x == a
+} else := false
+
+default l := false
+
+l if b > 5
+
+m if {
+	a == 3 with input as {}
+}`, ParserOptions{RegoVersion: RegoV1})
+
+	lib := MustParseModuleWithOpts(`package lib
+
+k := upper("k")`, ParserOptions{RegoVersion: RegoV1})
+
+	values := map[string]Value{
+		"data.test.a": Number("3"),
+		"data.test.b": Number("6"),
+		"data.test.c": String("x,y"),
+		"data.test.l": Boolean(true),
+		"data.test.h": String("K"),
+		"data.lib.k":  String("K"),
+	}
+
+	var evaluated []string
+	ev := func(_ *Compiler, ref Ref) (Value, bool, error) {
+		evaluated = append(evaluated, ref.String())
+		if ref.String() == "data.test.i" {
+			return nil, false, errors.New("conversion error")
+		}
+		v, ok := values[ref.String()]
+		return v, ok, nil
+	}
+
+	c := NewCompiler().WithConstantFolding(ev)
+	c.Compile(map[string]*Module{"test.rego": module, "lib.rego": lib})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	expEvaluated := []string{"data.lib.k", "data.test.a", "data.test.b", "data.test.c", "data.test.h", "data.test.i", "data.test.l"}
+	if !sort.StringsAreSorted(evaluated) || len(evaluated) != len(expEvaluated) {
+		t.Fatalf("expected %v to be evaluated but got: %v", expEvaluated, evaluated)
+	}
+	for i := range expEvaluated {
+		if evaluated[i] != expEvaluated[i] {
+			t.Fatalf("expected %v to be evaluated but got: %v", expEvaluated, evaluated)
+		}
+	}
+
+	if len(c.FoldedConstants()) != len(values) {
+		t.Fatalf("unexpected folded constants: %v", c.FoldedConstants())
+	}
+
+	exp := MustParseModuleWithOpts(`package test
+
+import data.lib
+
+a := 3
+
+b := 6
+
+c := "x,y"
+
+d := count(input.xs)
+
+e := time.now_ns()
+
+f := x if {
+	x := data.base.y
+}
+
+g := 1 if print("side effect")
+
+h := "K"
+
+i := "error" if {
+	to_number("x")
+}
+
+j := y if {
+	a := input.z
+	y := a
+}
+
+k if {
+	input.n < 6
+	some x in [3]
+	x == 3
+} else := false
+
+default l := false
+
+l := true
+
+m if {
+	3 == 3 with input as {}
+}`, ParserOptions{RegoVersion: RegoV1})
+
+	act := c.Modules["test.rego"]
+	for i := range exp.Rules {
+		expRule, actRule := exp.Rules[i], act.Rules[i]
+		switch actRule.Ref().String() {
+		case "data.test.a", "data.test.b", "data.test.c", "data.test.h":
+			if !actRule.Head.Value.Equal(expRule.Head.Value) || !isTrueBody(actRule.Body) {
+				t.Errorf("expected rule %d to be folded to %v but got: %v", i, expRule.Head.Value, actRule)
+			}
+		case "data.test.d", "data.test.e", "data.test.f", "data.test.i", "data.test.j":
+			if isTrueBody(actRule.Body) && IsConstant(actRule.Head.Value.Value) {
+				t.Errorf("expected rule %d not to be folded but got: %v", i, actRule)
+			}
+		}
+	}
+
+	// References to folded documents are replaced in source modules too.
+	src := module.Copy()
+	c.ApplyFoldedConstants(src)
+	if src.Rules[1].Head.Value.Value.Compare(Number("6")) != 0 {
+		t.Fatalf("expected b to be folded but got: %v", src.Rules[1])
+	}
+	if !src.Rules[10].Body.Equal(exp.Rules[10].Body) {
+		t.Fatalf("unexpected body for k: %v", src.Rules[10].Body)
+	}
+	if !src.Rules[10].Else.Head.Value.Equal(BooleanTerm(false)) {
+		t.Fatalf("unexpected else for k: %v", src.Rules[10].Else)
+	}
+	if !src.Rules[13].Body.Equal(exp.Rules[13].Body) {
+		t.Fatalf("unexpected value for m: %v", src.Rules[13])
+	}
+	if !src.Rules[9].Body.Equal(module.Rules[9].Body) {
+		t.Fatalf("expected shadowed var to be left as-is but got: %v", src.Rules[9])
+	}
+}
+
+func TestCompilerFoldConstantsWithMocks(t *testing.T) {
+	module := MustParseModuleWithOpts(`package test
+
+a := 1 + 2
+
+b := a * 2
+
+c := upper("c")
+
+d := count([c])
+
+p if b == 6
+
+q if d == 1
+
+test_p if p with data.test.a as 5
+
+test_q if q with count as 2`, ParserOptions{RegoVersion: RegoV1})
+
+	values := map[string]Value{
+		"data.test.a": Number("3"),
+		"data.test.b": Number("6"),
+		"data.test.c": String("C"),
+		"data.test.d": Number("1"),
+	}
+	ev := func(_ *Compiler, ref Ref) (Value, bool, error) {
+		v, ok := values[ref.String()]
+		return v, ok, nil
+	}
+
+	c := NewCompiler().WithConstantFolding(ev)
+	c.Compile(map[string]*Module{"test.rego": module})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	folded := c.FoldedConstants()
+	if len(folded) != 1 || !folded["data.test.c"].Equal(StringTerm("C")) {
+		t.Fatalf("expected only c to be folded but got: %v", folded)
+	}
+	if exp := MustParseBody("data.test.b = 6"); !c.Modules["test.rego"].Rules[4].Body.Equal(exp) {
+		t.Fatalf("expected reference to mocked document to be kept but got: %v", c.Modules["test.rego"].Rules[4].Body)
+	}
+}
+
+func TestCompilerFoldConstantsDisabled(t *testing.T) {
+	c := NewCompiler()
+	c.Compile(map[string]*Module{"test.rego": MustParseModule(`package test
+
+a := 1 + 2`)})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+	if len(c.FoldedConstants()) != 0 {
+		t.Fatalf("expected no folded constants but got: %v", c.FoldedConstants())
+	}
+}
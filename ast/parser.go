@@ -2257,6 +2257,8 @@ type rawAnnotation struct {
 	Scope            string                 `yaml:"scope"`
 	Title            string                 `yaml:"title"`
 	Entrypoint       bool                   `yaml:"entrypoint"`
+	Private          bool                   `yaml:"private"`
+	Memoize          bool                   `yaml:"memoize"`
 	Description      string                 `yaml:"description"`
 	Organizations    []string               `yaml:"organizations"`
 	RelatedResources []interface{}          `yaml:"related_resources"`
@@ -2315,6 +2317,8 @@ func (b *metadataParser) Parse() (*Annotations, error) {
 	result.comments = b.comments
 	result.Scope = raw.Scope
 	result.Entrypoint = raw.Entrypoint
+	result.Private = raw.Private
+	result.Memoize = raw.Memoize
 	result.Title = raw.Title
 	result.Description = raw.Description
 	result.Organizations = raw.Organizations
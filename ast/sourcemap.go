@@ -0,0 +1,82 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+// SourceMap maps vars generated by compiler rewrites to the terms they stand
+// in for. For example, given the expression "count(x[y].z) > 1" the compiler
+// generates "__local0__ = x[y].z; count(__local0__, __local1__); gt(__local1__, 1)".
+// The source map then contains {__local0__: x[y].z, __local1__: count(__local0__)}.
+//
+// The source map allows errors, traces and reports to refer to the source
+// written by the user instead of generated vars.
+type SourceMap map[Var]*Term
+
+// SourceMapper is implemented by query compilers that record the terms that
+// generated vars stand in for. It is not part of the QueryCompiler interface so
+// that existing implementations of QueryCompiler remain valid; the query
+// compilers returned by Compiler.QueryCompiler implement it.
+type SourceMapper interface {
+	SourceMap() SourceMap
+}
+
+// Term returns the term that v was generated for.
+func (sm SourceMap) Term(v Var) (*Term, bool) {
+	t, ok := sm[v]
+	return t, ok
+}
+
+// Location returns the location of the source that v was generated for. If v
+// was not generated by the compiler, nil is returned.
+func (sm SourceMap) Location(v Var) *Location {
+	if t, ok := sm[v]; ok {
+		return t.Location
+	}
+	return nil
+}
+
+// Original returns a copy of x where vars generated by the compiler have been
+// replaced, recursively, with the terms they were generated for.
+func (sm SourceMap) Original(x *Term) *Term {
+	if len(sm) == 0 || x == nil {
+		return x
+	}
+	cpy := x.Copy()
+	cpy.Value = sm.original(cpy.Value).(Value)
+	return cpy
+}
+
+// OriginalRef is like Original but operates on refs.
+func (sm SourceMap) OriginalRef(ref Ref) Ref {
+	if len(sm) == 0 {
+		return ref
+	}
+	return sm.original(ref.Copy()).(Ref)
+}
+
+// maxSourceMapExpansions bounds the number of vars replaced by a single call
+// to Original so that malformed (i.e., cyclic) source maps cannot cause the
+// rewrite to loop forever.
+const maxSourceMapExpansions = 1000
+
+func (sm SourceMap) original(x interface{}) interface{} {
+	expansions := 0
+	// NOTE: Transform visits the values returned by the callback, so vars
+	// nested inside of the source terms are replaced as well.
+	result, _ := TransformVars(x, func(v Var) (Value, error) {
+		t, ok := sm[v]
+		if !ok || expansions >= maxSourceMapExpansions || t.Vars().Contains(v) {
+			return v, nil
+		}
+		expansions++
+		return t.Copy().Value, nil
+	})
+	return result
+}
+
+func (sm SourceMap) add(v Var, t *Term) {
+	if sm != nil {
+		sm[v] = t
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompilerSourceMap(t *testing.T) {
+	c := NewCompiler()
+	c.Compile(map[string]*Module{
+		"test.rego": MustParseModule(`package test
+
+p {
+	count(input.x[_].y) > 1
+}`),
+	})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	var found []string
+	for v := range c.SourceMap {
+		orig := c.SourceMap.Original(VarTerm(string(v)))
+		found = append(found, orig.String())
+		if loc := c.SourceMap.Location(v); loc == nil || loc.Row != 4 {
+			t.Fatalf("expected location on row 4 for %v but got %v", v, loc)
+		}
+	}
+
+	exp := []string{"count(input.x[_].y)", "input.x[_].y"}
+	for _, e := range exp {
+		if !containsString(found, e) {
+			t.Fatalf("expected source map to contain %v but got %v", e, found)
+		}
+	}
+}
+
+func TestSourceMapOriginal(t *testing.T) {
+	sm := SourceMap{
+		Var("__local0__"): MustParseTerm("input.x"),
+		Var("__local1__"): CallTerm(RefTerm(VarTerm("count")), VarTerm("__local0__")),
+		Var("__local2__"): MustParseTerm("__local2__.y"),
+	}
+
+	tests := []struct {
+		term string
+		exp  string
+	}{
+		{term: "__local1__", exp: "count(input.x)"},
+		{term: "[__local0__, x]", exp: "[input.x, x]"},
+		{term: "__local0__[__local1__]", exp: "input.x[count(input.x)]"},
+		{term: "__local2__", exp: "__local2__"}, // self-referencing entries are not expanded
+		{term: "__local3__", exp: "__local3__"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.term, func(t *testing.T) {
+			term := MustParseTerm(tc.term)
+			act := sm.Original(term)
+			if act.String() != tc.exp {
+				t.Fatalf("expected %v but got %v", tc.exp, act)
+			}
+			if term.String() != tc.term {
+				t.Fatalf("expected %v to be unmodified but got %v", tc.term, term)
+			}
+		})
+	}
+}
+
+func TestTypeErrorsReferToSource(t *testing.T) {
+	tests := []struct {
+		note   string
+		module string
+		exp    string
+	}{
+		{
+			note:   "expanded call",
+			module: `p { abs(1).foo }`,
+			exp:    "undefined ref: abs(1).foo",
+		},
+		{
+			note:   "dynamic ref operand",
+			module: `p { a := [[1]]; a[count([1])][0].foo }`,
+			exp:    "undefined ref: a[count([1])][0].foo",
+		},
+		{
+			note:   "comprehension head",
+			module: `p { 1 = [x | x := 1][0].foo }`,
+			exp:    "undefined ref: [x | x = 1][0].foo",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			c := NewCompiler()
+			c.Compile(map[string]*Module{"test.rego": MustParseModule("package test\n" + tc.module)})
+			if !c.Failed() {
+				t.Fatal("expected error")
+			}
+			msg := c.Errors.Error()
+			if !strings.Contains(msg, tc.exp) || strings.Contains(msg, "__local") {
+				t.Fatalf("expected error containing %q but got: %v", tc.exp, msg)
+			}
+		})
+	}
+}
+
+func containsString(xs []string, x string) bool {
+	for _, s := range xs {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
@@ -29,6 +29,8 @@ type buildParams struct {
 	target             *util.EnumFlag
 	bundleMode         bool
 	pruneUnused        bool
+	pruneUnreachable   bool
+	foldConstants      bool
 	optimizationLevel  int
 	entrypoints        repeatedStringFlag
 	outputFile         string
@@ -130,6 +132,17 @@ the software asking for policy decisions, so that it can focus optimization effo
 ensure that document is not eliminated by the optimizer.
 Note: Unless the --prune-unused flag is used, any rule transitively referring to a 
 package or rule declared as an entrypoint will also be enumerated as an entrypoint.
+For the 'rego' target, the --prune-unreachable flag removes rules that are not
+reachable from any of the entrypoints.
+
+Constant Folding
+----------------
+
+The --fold-constants flag tells the 'build' command to evaluate rules that only
+depend on literals, deterministic built-in functions, and other such rules. The
+bodies of these rules are replaced with their values and references to them are
+replaced with the values themselves. Constant folding is not applied to the 'wasm'
+target.
 
 Signing
 -------
@@ -232,6 +245,8 @@ against OPA v0.22.0:
 
 	buildCommand.Flags().VarP(buildParams.target, "target", "t", "set the output bundle target type")
 	buildCommand.Flags().BoolVar(&buildParams.pruneUnused, "prune-unused", false, "exclude dependents of entrypoints")
+	buildCommand.Flags().BoolVar(&buildParams.pruneUnreachable, "prune-unreachable", false, "remove rules not reachable from entrypoints from rego bundles")
+	buildCommand.Flags().BoolVar(&buildParams.foldConstants, "fold-constants", false, "evaluate rules that only depend on literals and deterministic built-in functions")
 	buildCommand.Flags().BoolVar(&buildParams.debug, "debug", false, "enable debug output")
 	buildCommand.Flags().IntVarP(&buildParams.optimizationLevel, "optimize", "O", 0, "set optimization level")
 	buildCommand.Flags().VarP(&buildParams.entrypoints, "entrypoint", "e", "set slash separated entrypoint path")
@@ -294,6 +309,8 @@ func dobuild(params buildParams, args []string) error {
 		WithTarget(params.target.String()).
 		WithAsBundle(params.bundleMode).
 		WithPruneUnused(params.pruneUnused).
+		WithPruneUnreachable(params.pruneUnreachable).
+		WithConstantFolding(params.foldConstants).
 		WithOptimizationLevel(params.optimizationLevel).
 		WithOutput(buf).
 		WithEntrypoints(params.entrypoints.v...).
@@ -68,6 +68,7 @@ type evalCommandParams struct {
 	schema              *schemaFlags
 	target              *util.EnumFlag
 	timeout             time.Duration
+	limits              topdown.EvalLimits
 	optimizationLevel   int
 	entrypoints         repeatedStringFlag
 	strict              bool
@@ -120,6 +121,10 @@ func validateEvalParams(p *evalCommandParams, cmdArgs []string) error {
 		return errors.New("invalid output format for evaluation")
 	}
 
+	if p.limits.MaxSteps < 0 || p.limits.MaxTermBytes < 0 || p.limits.MaxResultBytes < 0 || p.limits.MaxHTTPSendCalls < 0 {
+		return errors.New("specify non-negative values for --max-steps, --max-term-bytes, --max-result-bytes and --max-http-send-calls")
+	}
+
 	if p.optimizationLevel > 0 {
 		if len(p.dataPaths.v) > 0 && p.bundlePaths.isFlagSet() {
 			return fmt.Errorf("specify either --data or --bundle flag with optimization level greater than 0")
@@ -307,6 +312,10 @@ access.
 	evalCommand.Flags().VarP(&params.prettyLimit, "pretty-limit", "", "set limit after which pretty output gets truncated")
 	evalCommand.Flags().BoolVarP(&params.failDefined, "fail-defined", "", false, "exits with non-zero exit code on defined/non-empty result and errors")
 	evalCommand.Flags().DurationVar(&params.timeout, "timeout", 0, "set eval timeout (default unlimited)")
+	evalCommand.Flags().Int64Var(&params.limits.MaxSteps, "max-steps", 0, "set maximum number of evaluation steps (default unlimited)")
+	evalCommand.Flags().Int64Var(&params.limits.MaxTermBytes, "max-term-bytes", 0, "set maximum size of terms produced by built-in functions (default unlimited)")
+	evalCommand.Flags().Int64Var(&params.limits.MaxResultBytes, "max-result-bytes", 0, "set maximum size of the results (default unlimited)")
+	evalCommand.Flags().Int64Var(&params.limits.MaxHTTPSendCalls, "max-http-send-calls", 0, "set maximum number of http.send calls (default unlimited)")
 
 	evalCommand.Flags().IntVarP(&params.optimizationLevel, "optimize", "O", 0, "set optimization level")
 	evalCommand.Flags().VarP(&params.entrypoints, "entrypoint", "e", "set slash separated entrypoint path")
@@ -649,6 +658,10 @@ func setupEval(args []string, params evalCommandParams) (*evalContext, error) {
 		evalArgs = append(evalArgs, rego.EvalQueryTracer(c))
 	}
 
+	if params.limits.Enabled() {
+		regoArgs = append(regoArgs, rego.Limits(params.limits))
+	}
+
 	if params.strictBuiltinErrors {
 		regoArgs = append(regoArgs, rego.StrictBuiltinErrors(true))
 		if params.showBuiltinErrors {
@@ -0,0 +1,171 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cmd/internal/env"
+	pr "github.com/open-policy-agent/opa/internal/presentation"
+	"github.com/open-policy-agent/opa/lint"
+	"github.com/open-policy-agent/opa/loader"
+	"github.com/open-policy-agent/opa/util"
+)
+
+type lintParams struct {
+	format       *util.EnumFlag
+	ignore       []string
+	rules        repeatedStringFlag
+	disable      []string
+	capabilities *capabilitiesFlag
+	v1Compatible bool
+}
+
+const lintFormatSARIF = "sarif"
+
+func newLintParams() lintParams {
+	return lintParams{
+		format: util.NewEnumFlag(checkFormatPretty, []string{
+			checkFormatPretty, checkFormatJSON, lintFormatSARIF,
+		}),
+		capabilities: newcapabilitiesFlag(),
+	}
+}
+
+func (p *lintParams) regoVersion() ast.RegoVersion {
+	if p.v1Compatible {
+		return ast.RegoV1
+	}
+	return ast.RegoV0
+}
+
+// lintModules lints the Rego files found under args and writes the report to
+// w. It returns true if any violations were found.
+func lintModules(ctx context.Context, params lintParams, args []string, w io.Writer) (bool, error) {
+
+	capabilities := params.capabilities.C
+	if capabilities == nil {
+		capabilities = ast.CapabilitiesForThisVersion()
+	}
+
+	f := loaderFilter{
+		Ignore:   params.ignore,
+		OnlyRego: true,
+	}
+
+	result, err := loader.NewFileLoader().
+		WithRegoVersion(params.regoVersion()).
+		WithCapabilities(capabilities).
+		Filtered(args, f.Apply)
+	if err != nil {
+		return false, err
+	}
+
+	sources := make(map[string][]byte, len(result.Modules))
+	for _, m := range result.Modules {
+		sources[m.Name] = m.Raw
+	}
+
+	var custom map[string]*ast.Module
+	if len(params.rules.v) > 0 {
+		rules, err := loader.NewFileLoader().
+			WithRegoVersion(params.regoVersion()).
+			WithCapabilities(capabilities).
+			Filtered(params.rules.v, loaderFilter{OnlyRego: true}.Apply)
+		if err != nil {
+			return false, err
+		}
+		custom = rules.ParsedModules()
+	}
+
+	report, err := lint.New().
+		WithSources(sources).
+		WithCustomRules(custom).
+		WithDisabledRules(params.disable).
+		WithRegoVersion(params.regoVersion()).
+		WithCapabilities(capabilities).
+		Lint(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	switch params.format.String() {
+	case lintFormatSARIF:
+		err = report.SARIF(w)
+	case checkFormatJSON:
+		err = pr.JSON(w, pr.Output{Errors: pr.NewOutputErrors(report.Errors())})
+	default:
+		if len(report.Violations) > 0 {
+			_, err = fmt.Fprintln(w, report.Errors())
+		}
+	}
+
+	return len(report.Violations) > 0, err
+}
+
+func init() {
+	lintParams := newLintParams()
+
+	lintCommand := &cobra.Command{
+		Use:   "lint <path> [path [...]]",
+		Short: "Lint Rego source files",
+		Long: `Lint Rego source files for style and correctness issues.
+
+The 'lint' command runs a set of built-in rules over the Rego source file(s) and
+reports any violations. If violations are found, 'lint' exits with a non-zero
+exit code.
+
+Custom rules written in Rego can be supplied with the '--rules' flag. Custom
+rules must be declared in packages under 'lint.rules' and define a partial set
+rule named 'report'. Each custom rule is evaluated with the parsed AST of the
+linted module bound to 'input':
+
+	package lint.rules.no_admin
+
+	import rego.v1
+
+	report contains violation if {
+		some rule in input.rules
+		rule.head.ref[0].value == "admin"
+		violation := {"message": "admin rules are forbidden", "location": rule.location}
+	}
+
+Violations can be suppressed with a '# lint:ignore <rule>' comment on the
+offending line or on the line preceding it. Rules can also be disabled for all
+files with the '--disable' flag.`,
+
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("specify at least one file")
+			}
+			return env.CmdFlags.CheckEnvironmentVariables(cmd)
+		},
+
+		Run: func(_ *cobra.Command, args []string) {
+			found, err := lintModules(context.Background(), lintParams, args, os.Stdout)
+			if err != nil {
+				outputErrors(lintParams.format.String(), err)
+				os.Exit(1)
+			}
+			if found {
+				os.Exit(1)
+			}
+		},
+	}
+
+	addIgnoreFlag(lintCommand.Flags(), &lintParams.ignore)
+	lintCommand.Flags().VarP(lintParams.format, "format", "f", "set output format")
+	lintCommand.Flags().VarP(&lintParams.rules, "rules", "r", "set path of custom lint rules written in Rego")
+	lintCommand.Flags().StringSliceVar(&lintParams.disable, "disable", []string{}, "set names of lint rules to disable")
+	addCapabilitiesFlag(lintCommand.Flags(), lintParams.capabilities)
+	addV1CompatibleFlag(lintCommand.Flags(), &lintParams.v1Compatible, false)
+	RootCommand.AddCommand(lintCommand)
+}
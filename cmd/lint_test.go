@@ -0,0 +1,119 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestLintFormats(t *testing.T) {
+	files := map[string]string{
+		"policy.rego": `package test
+
+fooBar := 1
+`,
+		"rules/no_foo.rego": `package lint.rules.no_foo
+
+import rego.v1
+
+report contains {"message": "no foo allowed"} if input["package"].path[1].value == "test"
+`,
+		"rules_v1/no_foo.rego": `package lint.rules.no_foo
+
+report contains {"message": "no foo allowed"} if input["package"].path[1].value == "test"
+`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		policy := filepath.Join(root, "policy.rego")
+
+		t.Run("pretty", func(t *testing.T) {
+			params := newLintParams()
+			var buf bytes.Buffer
+			found, err := lintModules(context.Background(), params, []string{policy}, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !found {
+				t.Fatal("expected violations")
+			}
+			if !strings.Contains(buf.String(), `rego_lint_error: rule name "fooBar" should be snake_case`) {
+				t.Fatalf("unexpected output: %v", buf.String())
+			}
+		})
+
+		t.Run("json with custom rules", func(t *testing.T) {
+			params := newLintParams()
+			_ = params.format.Set(checkFormatJSON)
+			_ = params.rules.Set(filepath.Join(root, "rules"))
+			var buf bytes.Buffer
+			if _, err := lintModules(context.Background(), params, []string{policy}, &buf); err != nil {
+				t.Fatal(err)
+			}
+
+			var output struct {
+				Errors []struct {
+					Code    string
+					Message string
+					Details struct {
+						Rule string
+					}
+				}
+			}
+			if err := json.Unmarshal(buf.Bytes(), &output); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(output.Errors) != 2 {
+				t.Fatalf("expected two errors but got: %v", buf.String())
+			}
+			for _, e := range output.Errors {
+				if e.Code != "rego_lint_error" {
+					t.Fatalf("unexpected error code: %v", e.Code)
+				}
+			}
+			if output.Errors[0].Details.Rule != "no_foo" || output.Errors[1].Details.Rule != "prefer-snake-case" {
+				t.Fatalf("unexpected errors: %v", buf.String())
+			}
+		})
+
+		t.Run("v1 compatible custom rules", func(t *testing.T) {
+			params := newLintParams()
+			params.v1Compatible = true
+			_ = params.rules.Set(filepath.Join(root, "rules_v1"))
+			var buf bytes.Buffer
+			if _, err := lintModules(context.Background(), params, []string{policy}, &buf); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), "no foo allowed") {
+				t.Fatalf("unexpected output: %v", buf.String())
+			}
+		})
+
+		t.Run("sarif with disabled rule", func(t *testing.T) {
+			params := newLintParams()
+			_ = params.format.Set(lintFormatSARIF)
+			params.disable = []string{"prefer-snake-case"}
+			var buf bytes.Buffer
+			found, err := lintModules(context.Background(), params, []string{policy}, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found {
+				t.Fatalf("expected no violations but got: %v", buf.String())
+			}
+			if !strings.Contains(buf.String(), `"version": "2.1.0"`) {
+				t.Fatalf("unexpected output: %v", buf.String())
+			}
+		})
+	})
+}
@@ -35,8 +35,12 @@ import (
 )
 
 const (
-	testPrettyOutput = "pretty"
-	testJSONOutput   = "json"
+	testPrettyOutput        = "pretty"
+	testJSONOutput          = "json"
+	testJUnitOutput         = "junit"
+	testTAPOutput           = "tap"
+	coverageCoberturaOutput = "cobertura"
+	coverageLCOVOutput      = "lcov"
 )
 
 type testCommandParams struct {
@@ -46,6 +50,8 @@ type testCommandParams struct {
 	outputFormat *util.EnumFlag
 	coverage     bool
 	threshold    float64
+	exprThresh   float64
+	branchThresh float64
 	timeout      time.Duration
 	ignore       []string
 	bundleMode   bool
@@ -58,6 +64,11 @@ type testCommandParams struct {
 	capabilities *capabilitiesFlag
 	schema       *schemaFlags
 	watch        bool
+	mutate       bool
+	updateSnaps  bool
+	record       bool
+	parallel     int
+	shard        string
 	stopChan     chan os.Signal
 	output       io.Writer
 	errOutput    io.Writer
@@ -66,7 +77,7 @@ type testCommandParams struct {
 
 func newTestCommandParams() testCommandParams {
 	return testCommandParams{
-		outputFormat: util.NewEnumFlag(testPrettyOutput, []string{testPrettyOutput, testJSONOutput, benchmarkGoBenchOutput}),
+		outputFormat: util.NewEnumFlag(testPrettyOutput, []string{testPrettyOutput, testJSONOutput, benchmarkGoBenchOutput, testJUnitOutput, testTAPOutput, coverageCoberturaOutput, coverageLCOVOutput}),
 		explain:      newExplainFlag([]string{explainModeFails, explainModeFull, explainModeNotes, explainModeDebug}),
 		target:       util.NewEnumFlag(compile.TargetRego, []string{compile.TargetRego, compile.TargetWasm}),
 		capabilities: newcapabilitiesFlag(),
@@ -101,10 +112,20 @@ func opaTest(args []string, testParams testCommandParams) (int, error) {
 		return 1, err
 	}
 
-	filter := loaderFilter{
-		Ignore: testParams.ignore,
+	if !isThresholdValid(testParams.exprThresh) || !isThresholdValid(testParams.branchThresh) {
+		fmt.Fprintln(testParams.errOutput, "Expression and branch coverage thresholds must be between 0 and 100")
+		return 1, err
 	}
 
+	if testParams.mutate {
+		if err := checkMutateParams(testParams); err != nil {
+			fmt.Fprintln(testParams.errOutput, err)
+			return 1, err
+		}
+	}
+
+	filter := testLoaderFilter(testParams)
+
 	var modules map[string]*ast.Module
 	var bundles map[string]*bundle.Bundle
 	var store storage.Store
@@ -134,6 +155,11 @@ func opaTest(args []string, testParams testCommandParams) (int, error) {
 		return 1, err
 	}
 
+	if testParams.mutate {
+		defer store.Abort(ctx, txn)
+		return runMutationTests(ctx, store, txn, runner, reporter, modules, testParams)
+	}
+
 	success := true
 	for i := 0; i < testParams.count; i++ {
 		exitCode, err := runTests(ctx, txn, runner, reporter, testParams)
@@ -211,6 +237,101 @@ func runTests(ctx context.Context, txn storage.Transaction, runner *tester.Runne
 	return exitCode, err
 }
 
+// testLoaderFilter returns the filter for loading the files under test. The
+// snapshot, fixture and cassette directories are not loaded as data.
+func testLoaderFilter(testParams testCommandParams) loaderFilter {
+	ignore := make([]string, 0, len(testParams.ignore)+3)
+	ignore = append(ignore, testParams.ignore...)
+	return loaderFilter{
+		Ignore: append(ignore, tester.SnapshotDir, tester.FixtureDir, tester.CassetteDir),
+	}
+}
+
+func checkMutateParams(testParams testCommandParams) error {
+	switch {
+	case testParams.bundleMode:
+		return fmt.Errorf("mutation testing is not supported in bundle mode")
+	case testParams.benchmark:
+		return fmt.Errorf("mutation testing is not supported when benchmarking tests")
+	case testParams.coverage || testParams.threshold > 0 || testParams.exprThresh > 0 || testParams.branchThresh > 0:
+		return fmt.Errorf("mutation testing is not supported when reporting coverage")
+	case testParams.watch:
+		return fmt.Errorf("mutation testing is not supported in watch mode")
+	case testParams.updateSnaps:
+		return fmt.Errorf("mutation testing is not supported when updating snapshots")
+	case testParams.record:
+		return fmt.Errorf("mutation testing is not supported when recording cassettes")
+	}
+	switch testParams.outputFormat.String() {
+	case testPrettyOutput, testJSONOutput:
+		return nil
+	}
+	return fmt.Errorf("output format %s is not supported for mutation testing", testParams.outputFormat.String())
+}
+
+// runMutationTests runs the tests for every mutant of the policies under test
+// and reports the mutants that survived, i.e., for which all tests passed.
+// The tests have to pass for the original policies.
+func runMutationTests(ctx context.Context, store storage.Store, txn storage.Transaction, runner *tester.Runner, reporter tester.Reporter, modules map[string]*ast.Module, testParams testCommandParams) (int, error) {
+	ch, err := runner.RunTests(ctx, txn)
+	if err != nil {
+		fmt.Fprintln(testParams.errOutput, err)
+		return 1, err
+	}
+
+	var results []*tester.Result
+	pass := true
+	for tr := range ch {
+		pass = pass && (tr.Pass() || tr.Skip)
+		results = append(results, tr)
+	}
+
+	if !pass {
+		dup := make(chan *tester.Result, len(results))
+		for _, tr := range results {
+			dup <- tr
+		}
+		close(dup)
+		if err := reporter.Report(dup); err != nil {
+			fmt.Fprintln(testParams.errOutput, err)
+		}
+		err := fmt.Errorf("mutation testing requires all tests to pass")
+		fmt.Fprintln(testParams.errOutput, err)
+		return 2, err
+	}
+
+	// Traces are not reported for mutants, and would only slow them down.
+	mutantParams := testParams
+	mutantParams.verbose = false
+
+	mutants := tester.Mutants(modules)
+	err = tester.RunMutants(ctx, txn, modules, mutants, func(modules map[string]*ast.Module) (*tester.Runner, error) {
+		runner, _, err := compileAndSetupTests(ctx, mutantParams, store, txn, modules, nil)
+		return runner, err
+	})
+	if err != nil {
+		fmt.Fprintln(testParams.errOutput, err)
+		return 1, err
+	}
+
+	if testParams.outputFormat.String() == testJSONOutput {
+		err = tester.JSONMutationReporter{Output: testParams.output}.Report(mutants)
+	} else {
+		err = tester.PrettyMutationReporter{Output: testParams.output, Verbose: testParams.verbose}.Report(mutants)
+	}
+	if err != nil {
+		fmt.Fprintln(testParams.errOutput, err)
+		return 1, err
+	}
+
+	for _, m := range mutants {
+		if m.Status == tester.MutantSurvived {
+			return 2, nil
+		}
+	}
+	return 0, nil
+}
+
 func filterTrace(params *testCommandParams, trace []*topdown.Event) []*topdown.Event {
 	// If an explain mode was specified, filter based
 	// on the mode. If no explain mode was specified,
@@ -237,6 +358,18 @@ func filterTrace(params *testCommandParams, trace []*topdown.Event) []*topdown.E
 	}
 }
 
+// parseShard parses a shard given as "i/n", i.e., the i-th of n shards.
+func parseShard(s string) (int, int, error) {
+	var shard, shards int
+	if n, err := fmt.Sscanf(s, "%d/%d", &shard, &shards); err != nil || n != 2 || fmt.Sprintf("%d/%d", shard, shards) != s {
+		return 0, 0, fmt.Errorf("invalid shard %q: expected i/n", s)
+	}
+	if shards < 1 || shard < 1 || shard > shards {
+		return 0, 0, fmt.Errorf("invalid shard %q: expected 1 <= i <= n", s)
+	}
+	return shard, shards, nil
+}
+
 func isThresholdValid(t float64) bool {
 	return 0 <= t && t <= 100
 }
@@ -274,9 +407,7 @@ func readWatcher(ctx context.Context, testParams testCommandParams, watcher *fsn
 }
 
 func processWatcherUpdate(ctx context.Context, testParams testCommandParams, paths []string, removed string, store storage.Store) {
-	filter := loaderFilter{
-		Ignore: testParams.ignore,
-	}
+	filter := testLoaderFilter(testParams)
 
 	var loadResult *initload.LoadPathsResult
 
@@ -355,10 +486,21 @@ func compileAndSetupTests(ctx context.Context, testParams testCommandParams, sto
 		return nil, nil, err
 	}
 
-	if testParams.threshold > 0 && !testParams.coverage {
+	if (testParams.threshold > 0 || testParams.exprThresh > 0 || testParams.branchThresh > 0) && !testParams.coverage {
 		testParams.coverage = true
 	}
 
+	switch testParams.outputFormat.String() {
+	case coverageCoberturaOutput, coverageLCOVOutput:
+		testParams.coverage = true
+	case testJUnitOutput, testTAPOutput:
+		if testParams.coverage {
+			errMsg := "output format %s is not supported when reporting coverage"
+			fmt.Fprintf(testParams.errOutput, errMsg+"\n", testParams.outputFormat.String())
+			return nil, nil, fmt.Errorf(errMsg, testParams.outputFormat.String())
+		}
+	}
+
 	var cov *cover.Cover
 	var coverTracer topdown.QueryTracer
 
@@ -391,7 +533,18 @@ func compileAndSetupTests(ctx context.Context, testParams testCommandParams, sto
 		SetBundles(bundles).
 		SetTimeout(timeout).
 		Filter(testParams.runRegex).
-		Target(testParams.target.String())
+		Target(testParams.target.String()).
+		Parallel(testParams.parallel).
+		UpdateSnapshots(testParams.updateSnaps).
+		RecordCassettes(testParams.record)
+
+	if testParams.shard != "" {
+		shard, shards, err := parseShard(testParams.shard)
+		if err != nil {
+			return nil, nil, err
+		}
+		runner.Shard(shard, shards)
+	}
 
 	var reporter tester.Reporter
 
@@ -403,6 +556,14 @@ func compileAndSetupTests(ctx context.Context, testParams testCommandParams, sto
 			reporter = tester.JSONReporter{
 				Output: testParams.output,
 			}
+		case testJUnitOutput:
+			reporter = tester.JUnitReporter{
+				Output: testParams.output,
+			}
+		case testTAPOutput:
+			reporter = tester.TAPReporter{
+				Output: testParams.output,
+			}
 		case benchmarkGoBenchOutput:
 			goBench = true
 			fallthrough
@@ -416,12 +577,37 @@ func compileAndSetupTests(ctx context.Context, testParams testCommandParams, sto
 			}
 		}
 	} else {
-		reporter = tester.JSONCoverageReporter{
-			Cover:     cov,
-			Modules:   modules,
-			Output:    testParams.output,
-			Threshold: testParams.threshold,
-			Verbose:   testParams.verbose,
+		switch testParams.outputFormat.String() {
+		case coverageCoberturaOutput:
+			reporter = tester.CoberturaCoverageReporter{
+				Cover:               cov,
+				Modules:             modules,
+				Output:              testParams.output,
+				Threshold:           testParams.threshold,
+				ExpressionThreshold: testParams.exprThresh,
+				BranchThreshold:     testParams.branchThresh,
+				Verbose:             testParams.verbose,
+			}
+		case coverageLCOVOutput:
+			reporter = tester.LCOVCoverageReporter{
+				Cover:               cov,
+				Modules:             modules,
+				Output:              testParams.output,
+				Threshold:           testParams.threshold,
+				ExpressionThreshold: testParams.exprThresh,
+				BranchThreshold:     testParams.branchThresh,
+				Verbose:             testParams.verbose,
+			}
+		default:
+			reporter = tester.JSONCoverageReporter{
+				Cover:               cov,
+				Modules:             modules,
+				Output:              testParams.output,
+				Threshold:           testParams.threshold,
+				ExpressionThreshold: testParams.exprThresh,
+				BranchThreshold:     testParams.branchThresh,
+				Verbose:             testParams.verbose,
+			}
 		}
 	}
 
@@ -503,6 +689,60 @@ Example benchmark run:
 
 The optional "gobench" output format conforms to the Go Benchmark Data Format.
 
+The "junit" and "tap" output formats report test results in the JUnit XML and
+Test Anything Protocol formats understood by most CI systems. The "cobertura"
+and "lcov" output formats enable coverage reporting and report coverage in the
+Cobertura XML and LCOV tracefile formats:
+
+	$ opa test --format=junit ./example/ > results.xml
+	$ opa test --format=lcov ./example/ > coverage.lcov
+
+The --mutate flag runs the tests against mutants of the policies under test, i.e.,
+copies of the policies with a single, small modification like a flipped comparison
+operator, a negated or removed expression, or a swapped constant. Mutants for which
+all tests pass survive, which indicates that the tests do not verify the behavior
+the mutated code implements. Test rules and files ending in _test.rego are not
+mutated. If any mutant survives, the command exits with a non-zero status:
+
+	$ opa test --mutate ./example/
+
+Test rules annotated with a "snapshot: true" custom annotation are snapshot tests:
+instead of being true, their value must match the JSON snapshot stored in the
+__snapshots__ directory next to the test file. The --update-snapshots flag stores
+the values of the snapshot tests as their snapshots:
+
+	$ opa test --update-snapshots ./example/
+
+Test data, inputs and http.send responses can be stored as JSON or YAML files in
+__fixtures__ directories next to the test files. The data.json, input.json and
+http_send.json files in __fixtures__/<package> apply to all tests of the package,
+and those in __fixtures__/<package>/<test> to a single test. Data is merged into
+the data of the tests, input is bound as the input, and http.send requests are
+answered with the matching response instead of being sent:
+
+	$ cat example/__fixtures__/authz/test_fetch/http_send.yaml
+	- request:
+	    method: GET
+	    url: https://users.example.com/alice
+	  response:
+	    status_code: 200
+	    body: {"name": "alice", "roles": ["admin"]}
+
+The --record flag records the calls of non-deterministic built-in functions like
+http.send, net.lookup_ip_addr and time.now_ns made by each test in a cassette file
+in the __cassettes__ directory next to the test file. When a test has a cassette,
+the recorded results are replayed instead of calling the functions, and calls that
+were not recorded fail the test:
+
+	$ opa test --record ./example/
+
+The --parallel flag runs up to the given number of tests concurrently. The results
+are reported in the same order as when the tests are run one at a time. The --shard
+flag splits the tests into n shards and only runs the tests of the i-th shard, e.g.,
+to split the tests across several CI machines:
+
+	$ opa test --parallel 8 --shard 2/4 ./example/
+
 The --watch flag can be used to monitor policy and data file-system changes. When a change is detected, OPA reloads
 the policy and data and then re-runs the tests. Watching individual files (rather than directories) is generally not
 recommended as some updates might cause them to be dropped by OPA.
@@ -533,9 +773,16 @@ recommended as some updates might cause them to be dropped by OPA.
 	testCommand.Flags().VarP(testParams.outputFormat, "format", "f", "set output format")
 	testCommand.Flags().BoolVarP(&testParams.coverage, "coverage", "c", false, "report coverage (overrides debug tracing)")
 	testCommand.Flags().Float64VarP(&testParams.threshold, "threshold", "", 0, "set coverage threshold and exit with non-zero status if coverage is less than threshold %")
+	testCommand.Flags().Float64Var(&testParams.exprThresh, "expression-threshold", 0, "set expression coverage threshold and exit with non-zero status if expression coverage is less than threshold %")
+	testCommand.Flags().Float64Var(&testParams.branchThresh, "branch-threshold", 0, "set branch coverage threshold and exit with non-zero status if branch coverage is less than threshold %")
 	testCommand.Flags().BoolVar(&testParams.benchmark, "bench", false, "benchmark the unit tests")
 	testCommand.Flags().StringVarP(&testParams.runRegex, "run", "r", "", "run only test cases matching the regular expression.")
 	testCommand.Flags().BoolVarP(&testParams.watch, "watch", "w", false, "watch command line files for changes")
+	testCommand.Flags().BoolVar(&testParams.mutate, "mutate", false, "run the tests against mutants of the policies under test and report the mutants that survive")
+	testCommand.Flags().BoolVar(&testParams.updateSnaps, "update-snapshots", false, "store the values of snapshot tests as their snapshots")
+	testCommand.Flags().IntVar(&testParams.parallel, "parallel", 1, "number of tests to run concurrently")
+	testCommand.Flags().StringVar(&testParams.shard, "shard", "", "only run the tests of the i-th of n shards, given as i/n")
+	testCommand.Flags().BoolVar(&testParams.record, "record", false, "record the calls of non-deterministic built-in functions made by the tests in cassettes")
 
 	// Shared flags
 	addBundleModeFlag(testCommand.Flags(), &testParams.bundleMode, false)
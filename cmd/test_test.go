@@ -903,6 +903,7 @@ func TestCoverageThreshold(t *testing.T) {
 		note              string
 		modules           map[string]string
 		threshold         float64
+		branchThreshold   float64
 		verbose           bool
 		expectedErrOutput string
 		expectedExitCode  int
@@ -981,6 +982,23 @@ Lines not covered:
 	%ROOT%/policy1.rego:6
 	%ROOT%/policy2.rego:2-4
 	%ROOT%/policy2.rego:7-8
+`,
+		},
+		{
+			note: "branch coverage threshold not met (verbose)",
+			modules: map[string]string{
+				"test.rego": `package test
+					p := 1 {
+						input.x
+					} else := 2
+					test_p { p == 1 with input.x as true }`,
+			},
+			branchThreshold:  100,
+			expectedExitCode: 2,
+			verbose:          true,
+			expectedErrOutput: `Branch coverage threshold not met: got 66.67 instead of 100.00
+Branches not covered:
+	%ROOT%/test.rego:4 (p)
 `,
 		},
 	}
@@ -992,6 +1010,7 @@ Lines not covered:
 
 				testParams := newTestCommandParams()
 				testParams.threshold = tc.threshold
+				testParams.branchThresh = tc.branchThreshold
 				testParams.verbose = tc.verbose
 				testParams.count = 1
 				testParams.errOutput = &buf
@@ -1014,6 +1033,279 @@ Lines not covered:
 	}
 }
 
+func TestCIOutputFormats(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+			p := 1
+			test_p { p == 1 }`,
+	}
+
+	tests := []struct {
+		format   string
+		coverage bool
+		contains []string
+		exitCode int
+	}{
+		{
+			format:   testJUnitOutput,
+			contains: []string{`<testsuite name="data.test" tests="1" failures="0" errors="0" skipped="0"`, `<testcase name="test_p" classname="data.test"`},
+		},
+		{
+			format:   testTAPOutput,
+			contains: []string{"TAP version 13\n1..1\nok 1 - data.test.test_p\n"},
+		},
+		{
+			format:   coverageCoberturaOutput,
+			contains: []string{`<coverage line-rate="1.0000"`, `<line number="2" hits="1"></line>`},
+		},
+		{
+			format:   coverageLCOVOutput,
+			contains: []string{"DA:2,1\nDA:3,1\nLH:2\nLF:2\n", "BRF:2\nBRH:2\nend_of_record\n"},
+		},
+		{
+			format:   testJUnitOutput,
+			coverage: true,
+			exitCode: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.format, func(t *testing.T) {
+			test.WithTempFS(files, func(root string) {
+				var buf, errBuf bytes.Buffer
+
+				testParams := newTestCommandParams()
+				testParams.count = 1
+				testParams.coverage = tc.coverage
+				testParams.output = &buf
+				testParams.errOutput = &errBuf
+				if err := testParams.outputFormat.Set(tc.format); err != nil {
+					t.Fatal(err)
+				}
+
+				exitCode, _ := opaTest([]string{root}, testParams)
+				if exitCode != tc.exitCode {
+					t.Fatalf("expected exit code %d but got %d: %s", tc.exitCode, exitCode, errBuf.String())
+				}
+				for _, exp := range tc.contains {
+					if !strings.Contains(buf.String(), exp) {
+						t.Errorf("expected output to contain:\n\n%v\n\nbut got:\n\n%v", exp, buf.String())
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestMutate(t *testing.T) {
+	policy := `package p
+		allow {
+			input.age >= 18
+		}`
+
+	tests := []struct {
+		note      string
+		test      string
+		format    string
+		bench     bool
+		exitCode  int
+		output    []string
+		errOutput string
+	}{
+		{
+			note: "all mutants killed",
+			test: `package p
+				test_adult { allow with input.age as 18 }
+				test_minor { not allow with input.age as 17 }`,
+			output: []string{"KILLED: 4/4\nMUTATION SCORE: 100.00%\n"},
+		},
+		{
+			note: "surviving mutants",
+			test: `package p
+				test_adult { allow with input.age as 30 }`,
+			exitCode: 2,
+			output: []string{
+				"p.rego:3: flip-comparison: input.age >= 18 -> input.age > 18: SURVIVED\n",
+				"KILLED: 1/4\nSURVIVED: 3/4\nMUTATION SCORE: 25.00%\n",
+			},
+		},
+		{
+			note: "json",
+			test: `package p
+				test_adult { allow with input.age as 30 }`,
+			format:   testJSONOutput,
+			exitCode: 2,
+			output:   []string{`"operator": "swap-constant",`, `"status": "survived"`, `"killed_by": "data.p.test_adult"`},
+		},
+		{
+			note: "failing tests",
+			test: `package p
+				test_adult { allow with input.age as 1 }`,
+			exitCode:  2,
+			output:    []string{"data.p.test_adult: FAIL"},
+			errOutput: "mutation testing requires all tests to pass\n",
+		},
+		{
+			note:      "unsupported flags",
+			bench:     true,
+			exitCode:  1,
+			errOutput: "mutation testing is not supported when benchmarking tests\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			files := map[string]string{
+				"p.rego":      policy,
+				"p_test.rego": tc.test,
+			}
+			test.WithTempFS(files, func(root string) {
+				var buf, errBuf bytes.Buffer
+
+				testParams := newTestCommandParams()
+				testParams.count = 1
+				testParams.mutate = true
+				testParams.benchmark = tc.bench
+				testParams.output = &buf
+				testParams.errOutput = &errBuf
+				if tc.format != "" {
+					if err := testParams.outputFormat.Set(tc.format); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				exitCode, _ := opaTest([]string{root}, testParams)
+				if exitCode != tc.exitCode {
+					t.Fatalf("expected exit code %d but got %d: %s", tc.exitCode, exitCode, errBuf.String())
+				}
+				for _, exp := range tc.output {
+					if !strings.Contains(buf.String(), exp) {
+						t.Errorf("expected output to contain:\n\n%v\n\nbut got:\n\n%v", exp, buf.String())
+					}
+				}
+				if errBuf.String() != tc.errOutput {
+					t.Errorf("expected error output %q but got %q", tc.errOutput, errBuf.String())
+				}
+			})
+		})
+	}
+}
+
+func TestPropertyTests(t *testing.T) {
+	files := map[string]string{
+		"schemas/order.json": `{
+			"type": "object",
+			"required": ["items"],
+			"properties": {
+				"items": {"type": "array", "items": {"type": "integer", "minimum": 1, "maximum": 100}}
+			}
+		}`,
+		"policy/p.rego": `package p
+			import rego.v1
+
+			total := sum(input.items)
+			`,
+		"policy/p_test.rego": `package p
+import rego.v1
+
+# METADATA
+# schemas:
+#   - input: schema.order
+# custom:
+#   property:
+#     runs: 20
+test_total_positive if total >= 0
+
+# METADATA
+# schemas:
+#   - input: schema.order
+# custom:
+#   property: true
+test_total_small if total < 100
+`,
+	}
+
+	test.WithTempFS(files, func(root string) {
+		var buf bytes.Buffer
+
+		testParams := newTestCommandParams()
+		testParams.count = 1
+		testParams.output = &buf
+		testParams.errOutput = io.Discard
+		testParams.schema.path = filepath.Join(root, "schemas")
+
+		exitCode, _ := opaTest([]string{filepath.Join(root, "policy")}, testParams)
+		if exitCode != 2 {
+			t.Fatalf("expected exit code 2 but got %d: %s", exitCode, buf.String())
+		}
+		for _, exp := range []string{
+			"data.p.test_total_small: FAIL",
+			`counterexample: {"items":[`,
+			"PASS: 1/2\nFAIL: 1/2\n",
+		} {
+			if !strings.Contains(buf.String(), exp) {
+				t.Errorf("expected output to contain:\n\n%v\n\nbut got:\n\n%v", exp, buf.String())
+			}
+		}
+	})
+}
+
+func TestParallelAndShards(t *testing.T) {
+	files := map[string]string{
+		"data.json": `{"limit": 3}`,
+		"p_test.rego": `package p
+
+test_a { data.limit == 3 }
+test_b { data.limit < 2 }
+test_c { true }
+test_d { true }
+`,
+	}
+
+	for _, bundleMode := range []bool{false, true} {
+		t.Run(fmt.Sprintf("bundle=%v", bundleMode), func(t *testing.T) {
+			test.WithTempFS(files, func(root string) {
+				var buf bytes.Buffer
+
+				testParams := newTestCommandParams()
+				testParams.count = 1
+				testParams.output = &buf
+				testParams.errOutput = io.Discard
+				testParams.bundleMode = bundleMode
+				testParams.parallel = 4
+				testParams.verbose = true
+
+				exitCode, _ := opaTest([]string{root}, testParams)
+				if exitCode != 2 {
+					t.Fatalf("expected exit code 2 but got %d: %s", exitCode, buf.String())
+				}
+				if !strings.Contains(buf.String(), "data.p.test_b: FAIL") || !strings.Contains(buf.String(), "PASS: 3/4\nFAIL: 1/4\n") {
+					t.Fatalf("unexpected output: %s", buf.String())
+				}
+
+				buf.Reset()
+				testParams.shard = "2/2"
+				exitCode, _ = opaTest([]string{root}, testParams)
+				if exitCode != 2 || !strings.Contains(buf.String(), "data.p.test_b: FAIL") || !strings.Contains(buf.String(), "PASS: 1/2\nFAIL: 1/2\n") {
+					t.Fatalf("expected shard 2/2 to run test_b and test_d but got %d: %s", exitCode, buf.String())
+				}
+			})
+		})
+	}
+
+	var errBuf bytes.Buffer
+	testParams := newTestCommandParams()
+	testParams.count = 1
+	testParams.output = io.Discard
+	testParams.errOutput = &errBuf
+	testParams.shard = "3/2"
+	test.WithTempFS(files, func(root string) {
+		if exitCode, _ := opaTest([]string{root}, testParams); exitCode != 1 || !strings.Contains(errBuf.String(), `invalid shard "3/2"`) {
+			t.Fatalf("expected invalid shard error but got %d: %s", exitCode, errBuf.String())
+		}
+	})
+}
+
 type loadType int
 
 const (
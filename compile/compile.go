@@ -31,6 +31,7 @@ import (
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown"
 )
 
 const (
@@ -64,6 +65,8 @@ type Compiler struct {
 	revision                     *string                    // the revision to set on the output bundle
 	asBundle                     bool                       // whether to assume bundle layout on file loading or not
 	pruneUnused                  bool                       // whether to extend the entrypoint set for semantic equivalence of built bundles
+	pruneUnreachable             bool                       // whether to remove rules not reachable from the entrypoints from rego bundles
+	foldConstants                bool                       // whether to evaluate constant rules at build-time
 	filter                       loader.Filter              // filter to apply to file loader
 	paths                        []string                   // file paths to load. TODO(tsandall): add support for supplying readers for embedded users.
 	entrypoints                  orderedStringSet           // policy entrypoints required for optimization and certain targets
@@ -122,6 +125,23 @@ func (c *Compiler) WithPruneUnused(enabled bool) *Compiler {
 	return c
 }
 
+// WithPruneUnreachable will make rules that are not reachable from any of the
+// entrypoints be removed from the modules of 'rego' bundles. It has no effect
+// on the other targets.
+func (c *Compiler) WithPruneUnreachable(enabled bool) *Compiler {
+	c.pruneUnreachable = enabled
+	return c
+}
+
+// WithConstantFolding enables evaluation of rules that only depend on literals
+// and deterministic built-in functions at build-time. The bodies of such rules
+// (and references to them) are replaced with their values. This has no effect
+// on the 'wasm' target.
+func (c *Compiler) WithConstantFolding(enabled bool) *Compiler {
+	c.foldConstants = enabled
+	return c
+}
+
 // WithEntrypoints sets the policy entrypoints on the compiler. Entrypoints tell the
 // compiler what rules to expect and where optimizations can be targeted. The wasm
 // target requires at least one entrypoint as does optimization.
@@ -302,20 +322,22 @@ func (c *Compiler) Build(ctx context.Context) error {
 		return err
 	}
 
+	moduleList := make([]*ast.Module, 0, len(c.bundle.Modules))
+	for _, modfile := range c.bundle.Modules {
+		moduleList = append(moduleList, modfile.Parsed)
+	}
+
 	// Extract annotations, and generate new entrypoints as needed.
+	var as *ast.AnnotationSet
 	if c.useRegoAnnotationEntrypoints {
-		moduleList := make([]*ast.Module, 0, len(c.bundle.Modules))
-		for _, modfile := range c.bundle.Modules {
-			moduleList = append(moduleList, modfile.Parsed)
-		}
-		as, errs := ast.BuildAnnotationSet(moduleList)
+		var errs ast.Errors
+		as, errs = ast.BuildAnnotationSet(moduleList)
 		if len(errs) > 0 {
 			return errs
 		}
-		ar := as.Flatten()
 
 		// Patch in entrypoints from Rego annotations.
-		err := addEntrypointsFromAnnotations(c, ar)
+		err := addEntrypointsFromAnnotations(c, as.Flatten())
 		if err != nil {
 			return err
 		}
@@ -332,6 +354,15 @@ func (c *Compiler) Build(ctx context.Context) error {
 		return err
 	}
 
+	// Private rules are not part of the bundle's API and so they cannot be
+	// used as entrypoints. Annotations are only processed when entrypoints
+	// are read from them.
+	if as != nil {
+		if err := c.checkPrivateEntrypoints(moduleList, as); err != nil {
+			return err
+		}
+	}
+
 	if err := c.optimize(ctx); err != nil {
 		return err
 	}
@@ -357,7 +388,9 @@ func (c *Compiler) Build(ctx context.Context) error {
 			Raw:  bs,
 		})
 	case TargetRego:
-		// nop
+		if err := c.rewriteRegoModules(); err != nil {
+			return err
+		}
 	}
 
 	if c.revision != nil {
@@ -456,6 +489,40 @@ func (c *Compiler) dedupEntrypointRefs() error {
 	return nil
 }
 
+// checkPrivateEntrypoints returns an error if any of the entrypoints under the
+// bundle roots refer to (a document inside of) a rule marked private within the
+// bundle roots.
+func (c *Compiler) checkPrivateEntrypoints(modules []*ast.Module, as *ast.AnnotationSet) error {
+	roots := newRefSet(ast.DefaultRootRef)
+	if c.bundle.Manifest.Roots != nil {
+		roots = newRefSet(stringsToRefs(*c.bundle.Manifest.Roots)...)
+	}
+
+	var errs ast.Errors
+	for _, ep := range c.entrypointrefs {
+		ref := ep.Value.(ast.Ref)
+		if !roots.ContainsPrefix(ref) {
+			continue
+		}
+		for _, m := range modules {
+			for _, rule := range m.Rules {
+				prefix := rule.Ref().GroundPrefix()
+				if !ref.HasPrefix(prefix) || !roots.ContainsPrefix(prefix) {
+					continue
+				}
+				if prefix := as.PrivateTo(rule); prefix != nil {
+					errs = append(errs, ast.NewError(ast.CompileErr, rule.Loc(), "entrypoint %v refers to private rule %v which is only visible within %v",
+						ref, rule.Ref(), prefix))
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
 // Bundle returns the compiled bundle. This function can be called to retrieve the
 // output of the compiler (as an alternative to having the bundle written to a stream.)
 func (c *Compiler) Bundle() *bundle.Bundle {
@@ -536,7 +603,7 @@ func (c *Compiler) initBundle(usePath bool) error {
 func (c *Compiler) optimize(ctx context.Context) error {
 	if c.optimizationLevel <= 0 {
 		var err error
-		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements)
+		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements, c.constantEvaluator())
 		return err
 	}
 
@@ -561,13 +628,83 @@ func (c *Compiler) optimize(ctx context.Context) error {
 	return nil
 }
 
+// constantEvaluator returns the evaluator used for constant folding or nil if
+// constant folding is disabled. Constant folding is not supported by the wasm
+// target because folded values could exceed what the wasm compiler supports.
+func (c *Compiler) constantEvaluator() ast.ConstantEvaluator {
+	if !c.foldConstants || c.target == TargetWasm {
+		return nil
+	}
+	return topdown.EvalConstant
+}
+
+// rewriteRegoModules applies the results of constant folding to the modules
+// of the bundle and, if pruning is enabled, removes rules that are not
+// reachable from the entrypoints.
+func (c *Compiler) rewriteRegoModules() error {
+	prune := c.pruneUnreachable && len(c.entrypointrefs) > 0
+	if !c.foldConstants && !prune {
+		return nil
+	}
+
+	// If optimizations were run, the AST compiler will not be set.
+	if c.compiler == nil {
+		var err error
+		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements, c.constantEvaluator())
+		if err != nil {
+			return err
+		}
+	}
+
+	var reachable map[*ast.Rule]struct{}
+	if prune {
+		reachable = map[*ast.Rule]struct{}{}
+		for _, ep := range c.entrypointrefs {
+			for _, rule := range c.compiler.GetRules(ep.Value.(ast.Ref)) {
+				transitiveDependencies(c.compiler, rule, reachable)
+			}
+		}
+	}
+
+	for i, mf := range c.bundle.Modules {
+		modified := false
+		if len(c.compiler.FoldedConstants()) > 0 {
+			orig := mf.Parsed.Copy()
+			c.compiler.ApplyFoldedConstants(mf.Parsed)
+			modified = !orig.Equal(mf.Parsed)
+		}
+
+		// The AST compiler operates on copies of the modules but preserves
+		// the order of rules, so rules can be mapped back to the originals.
+		if compiled, ok := c.compiler.Modules[mf.URL]; ok && reachable != nil && len(compiled.Rules) == len(mf.Parsed.Rules) {
+			rules := make([]*ast.Rule, 0, len(mf.Parsed.Rules))
+			for j, rule := range mf.Parsed.Rules {
+				if _, ok := reachable[compiled.Rules[j]]; !ok {
+					c.debug.Printf("pruning unreachable rule %v", rule.Ref())
+					modified = true
+					continue
+				}
+				rules = append(rules, rule)
+			}
+			mf.Parsed.Rules = rules
+		}
+
+		if modified {
+			// Drop the raw source so the module is formatted from the AST.
+			c.bundle.Modules[i].Raw = nil
+		}
+	}
+
+	return nil
+}
+
 func (c *Compiler) compilePlan(context.Context) error {
 
 	// Lazily compile the modules if needed. If optimizations were run, the
 	// AST compiler will not be set because the default target does not require it.
 	if c.compiler == nil {
 		var err error
-		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements)
+		c.compiler, err = compile(c.capabilities, c.bundle, c.debug, c.enablePrintStatements, c.constantEvaluator())
 		if err != nil {
 			return err
 		}
@@ -945,7 +1082,7 @@ func (o *optimizer) Do(ctx context.Context) error {
 	for i, e := range o.entrypoints {
 
 		var err error
-		o.compiler, err = compile(o.capabilities, o.bundle, o.debug, o.enablePrintStatements)
+		o.compiler, err = compile(o.capabilities, o.bundle, o.debug, o.enablePrintStatements, nil)
 		if err != nil {
 			return err
 		}
@@ -1202,7 +1339,7 @@ func (o *optimizer) getSupportModuleFilename(used map[string]int, module *ast.Mo
 
 var safePathPattern = regexp.MustCompile(`^[\w-_/]+$`)
 
-func compile(c *ast.Capabilities, b *bundle.Bundle, dbg debug.Debug, enablePrintStatements bool) (*ast.Compiler, error) {
+func compile(c *ast.Capabilities, b *bundle.Bundle, dbg debug.Debug, enablePrintStatements bool, ev ast.ConstantEvaluator) (*ast.Compiler, error) {
 
 	modules := map[string]*ast.Module{}
 
@@ -1214,7 +1351,7 @@ func compile(c *ast.Capabilities, b *bundle.Bundle, dbg debug.Debug, enablePrint
 		modules[mf.URL] = mf.Parsed
 	}
 
-	compiler := ast.NewCompiler().WithCapabilities(c).WithDebug(dbg.Writer()).WithEnablePrintStatements(enablePrintStatements)
+	compiler := ast.NewCompiler().WithCapabilities(c).WithDebug(dbg.Writer()).WithEnablePrintStatements(enablePrintStatements).WithConstantFolding(ev)
 	compiler.Compile(modules)
 
 	if compiler.Failed() {
@@ -1245,6 +1382,16 @@ func transitiveDependents(compiler *ast.Compiler, rule *ast.Rule, deps map[*ast.
 	}
 }
 
+func transitiveDependencies(compiler *ast.Compiler, rule *ast.Rule, deps map[*ast.Rule]struct{}) {
+	if _, ok := deps[rule]; ok {
+		return
+	}
+	deps[rule] = struct{}{}
+	for x := range compiler.Graph.Dependencies(rule) {
+		transitiveDependencies(compiler, x.(*ast.Rule), deps)
+	}
+}
+
 type orderedStringSet []string
 
 func (ss orderedStringSet) Append(s ...string) orderedStringSet {
@@ -1085,6 +1085,178 @@ func TestCompilerError(t *testing.T) {
 	}
 }
 
+func TestCompilerPrivateEntrypoint(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+
+# METADATA
+# private: true
+helper := 1
+
+p := helper`,
+		"other.rego": `package other
+
+q := data.test.p`,
+	}
+
+	tests := []struct {
+		note        string
+		entrypoint  string
+		annotations bool
+		exp         string
+	}{
+		{note: "public rule", entrypoint: "test/p", annotations: true},
+		{note: "private rule", entrypoint: "test/helper", annotations: true, exp: "entrypoint data.test.helper refers to private rule data.test.helper which is only visible within data.test"},
+		{note: "annotations not processed", entrypoint: "test/helper"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			test.WithTestFS(files, true, func(root string, fsys fs.FS) {
+				err := New().
+					WithFS(fsys).
+					WithPaths(root).
+					WithEntrypoints(tc.entrypoint).
+					WithRegoAnnotationEntrypoints(tc.annotations).
+					Build(context.Background())
+
+				if tc.exp == "" {
+					if err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				if err == nil || !strings.Contains(err.Error(), tc.exp) {
+					t.Fatalf("expected error containing %q but got: %v", tc.exp, err)
+				}
+			})
+		})
+	}
+}
+
+func TestCompilerConstantFoldingRegoTarget(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+
+import rego.v1
+
+limits := {"max": 2 * 5, "names": upper("abc")}
+
+max := limits.max
+
+unused := concat(",", ["a", "b"])
+
+now := time.now_ns()
+
+allow if input.n < max`,
+	}
+
+	tests := []struct {
+		note        string
+		prune       bool
+		pruneUnused bool
+		exp         string
+	}{
+		{
+			note: "fold",
+			exp: `package test
+
+import rego.v1
+
+limits := {"max": 10, "names": "ABC"}
+
+max := 10
+
+unused := "a,b"
+
+now := time.now_ns()
+
+allow if input.n < 10`,
+		},
+		{
+			note:        "prune unused does not affect rego target",
+			pruneUnused: true,
+			exp: `package test
+
+import rego.v1
+
+limits := {"max": 10, "names": "ABC"}
+
+max := 10
+
+unused := "a,b"
+
+now := time.now_ns()
+
+allow if input.n < 10`,
+		},
+		{
+			note:  "fold and prune",
+			prune: true,
+			exp: `package test
+
+import rego.v1
+
+allow if input.n < 10`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			test.WithTestFS(files, true, func(root string, fsys fs.FS) {
+				compiler := New().
+					WithFS(fsys).
+					WithPaths(root).
+					WithConstantFolding(true).
+					WithPruneUnreachable(tc.prune).
+					WithPruneUnused(tc.pruneUnused).
+					WithEntrypoints("test/allow")
+
+				if err := compiler.Build(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+
+				exp := ast.MustParseModuleWithOpts(tc.exp, ast.ParserOptions{RegoVersion: ast.RegoV1})
+				if act := compiler.bundle.Modules[0].Parsed; !act.Equal(exp) {
+					t.Fatalf("expected module:\n\n%v\n\ngot:\n\n%v", exp, act)
+				}
+			})
+		})
+	}
+}
+
+func TestCompilerMalformedMetadataWithoutAnnotationEntrypoints(t *testing.T) {
+	files := map[string]string{
+		"test.rego": `package test
+
+# METADATA
+# scope: [malformed
+p := 1`,
+	}
+
+	test.WithTestFS(files, true, func(root string, fsys fs.FS) {
+		err := New().
+			WithFS(fsys).
+			WithPaths(root).
+			WithEntrypoints("test/p").
+			Build(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = New().
+			WithFS(fsys).
+			WithPaths(root).
+			WithEntrypoints("test/p").
+			WithRegoAnnotationEntrypoints(true).
+			Build(context.Background())
+		if err == nil {
+			t.Fatal("expected error for malformed METADATA block")
+		}
+	})
+}
+
 func TestCompilerOptimizationL1(t *testing.T) {
 
 	files := map[string]string{
@@ -38,8 +38,10 @@ type Config struct {
 	PersistenceDirectory         *string                    `json:"persistence_directory,omitempty"`
 	DistributedTracing           json.RawMessage            `json:"distributed_tracing,omitempty"`
 	Server                       *struct {
-		Encoding json.RawMessage `json:"encoding,omitempty"`
-		Metrics  json.RawMessage `json:"metrics,omitempty"`
+		Encoding   json.RawMessage `json:"encoding,omitempty"`
+		Metrics    json.RawMessage `json:"metrics,omitempty"`
+		EvalLimits json.RawMessage `json:"eval_limits,omitempty"`
+		Profiling  json.RawMessage `json:"profiling,omitempty"`
 	} `json:"server,omitempty"`
 	Storage *struct {
 		Disk json.RawMessage `json:"disk,omitempty"`
@@ -16,13 +16,41 @@ import (
 
 // Cover computes and reports on coverage.
 type Cover struct {
-	hits map[string]map[Position]struct{}
+	hits     map[string]map[Position]struct{}
+	exprs    map[location]outcome
+	branches map[location]outcome
+
+	// last records the expression evaluated last by each query. An
+	// expression evaluated true if the query proceeds with the next one.
+	last map[uint64]*ast.Expr
+
+	// negations records the queries evaluating the complement of negated
+	// expressions, their outcomes are the inverse of the expressions'.
+	negations map[uint64]struct{}
+}
+
+// location identifies an expression or rule in a file.
+type location struct {
+	file     string
+	row, col int
 }
 
+type outcome uint8
+
+const (
+	outcomeEvaluated outcome = 1 << iota
+	outcomeTrue
+	outcomeFalse
+)
+
 // New returns a new Cover object.
 func New() *Cover {
 	return &Cover{
-		hits: map[string]map[Position]struct{}{},
+		hits:      map[string]map[Position]struct{}{},
+		exprs:     map[location]outcome{},
+		branches:  map[location]outcome{},
+		last:      map[uint64]*ast.Expr{},
+		negations: map[uint64]struct{}{},
 	}
 }
 
@@ -79,10 +107,13 @@ func (c *Cover) Report(modules map[string]*ast.Module) (report Report) {
 			report.Files[file] = fr
 		}
 		fr.NotCovered = sortedPositionSliceToRangeSlice(notCovered)
+		fr.Expressions = c.expressions(module)
+		fr.Branches = c.ruleBranches(module)
 	}
 
 	var coveredLoc, notCoveredLoc int
 	var overallCoverage float64
+	var exprs, branches Summary
 
 	for _, fr := range report.Files {
 		fr.Coverage = fr.computeCoveragePercentage()
@@ -90,6 +121,15 @@ func (c *Cover) Report(modules map[string]*ast.Module) (report Report) {
 		fr.NotCoveredLines = fr.locNotCovered()
 		coveredLoc += fr.CoveredLines
 		notCoveredLoc += fr.NotCoveredLines
+
+		if len(fr.Expressions) > 0 {
+			fr.ExpressionCoverage = expressionSummary(fr.Expressions)
+			exprs.add(fr.ExpressionCoverage)
+		}
+		if len(fr.Branches) > 0 {
+			fr.BranchCoverage = branchSummary(fr.Branches)
+			branches.add(fr.BranchCoverage)
+		}
 	}
 	totalLoc := coveredLoc + notCoveredLoc
 
@@ -100,9 +140,77 @@ func (c *Cover) Report(modules map[string]*ast.Module) (report Report) {
 	report.NotCoveredLines = notCoveredLoc
 	report.Coverage = overallCoverage
 
+	if exprs.Covered+exprs.NotCovered > 0 {
+		exprs.computeCoverage()
+		report.ExpressionCoverage = &exprs
+	}
+	if branches.Covered+branches.NotCovered > 0 {
+		branches.computeCoverage()
+		report.BranchCoverage = &branches
+	}
+
 	return
 }
 
+// expressions returns the coverage of the expressions in the module, ordered
+// by location.
+func (c *Cover) expressions(module *ast.Module) []Expression {
+	var result []Expression
+	ast.WalkExprs(module, func(x *ast.Expr) bool {
+		if includeExprInCoverage(x) {
+			o := c.exprs[newLocation(x.Location)]
+			result = append(result, Expression{
+				Row:       x.Location.Row,
+				Col:       x.Location.Col,
+				Evaluated: o&outcomeEvaluated != 0,
+				True:      o&outcomeTrue != 0,
+				False:     o&outcomeFalse != 0,
+			})
+		}
+		return false
+	})
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Row != result[j].Row {
+			return result[i].Row < result[j].Row
+		}
+		return result[i].Col < result[j].Col
+	})
+	return result
+}
+
+// ruleBranches returns the coverage of the rule bodies, including else
+// branches, in the module, ordered by location.
+func (c *Cover) ruleBranches(module *ast.Module) []Branch {
+	var result []Branch
+	elses := map[*ast.Rule]struct{}{}
+	ast.WalkRules(module, func(x *ast.Rule) bool {
+		if x.Else != nil {
+			elses[x.Else] = struct{}{}
+		}
+		if hasFileLocation(x.Location) {
+			_, isElse := elses[x]
+			o := c.branches[newLocation(x.Location)]
+			result = append(result, Branch{
+				Row:     x.Location.Row,
+				Col:     x.Location.Col,
+				Rule:    x.Head.Ref().String(),
+				Else:    isElse,
+				Default: x.Default,
+				Entered: o&outcomeEvaluated != 0,
+				Taken:   o&outcomeTrue != 0,
+			})
+		}
+		return false
+	})
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Row != result[j].Row {
+			return result[i].Row < result[j].Row
+		}
+		return result[i].Col < result[j].Col
+	})
+	return result
+}
+
 // Trace updates the coverage state.
 // Deprecated: Use TraceEvent instead.
 func (c *Cover) Trace(event *topdown.Event) {
@@ -112,17 +220,68 @@ func (c *Cover) Trace(event *topdown.Event) {
 // TraceEvent updates the coverage state.
 func (c *Cover) TraceEvent(event topdown.Event) {
 	switch event.Op {
+	case topdown.EnterOp:
+		// Query IDs are reused across evaluations, so the state of the
+		// query is reset when it is entered.
+		delete(c.last, event.QueryID)
+		delete(c.negations, event.QueryID)
+		switch node := event.Node.(type) {
+		case *ast.Rule:
+			c.setOutcome(c.branches, node.Location, outcomeEvaluated)
+		case ast.Body:
+			if parent := c.last[event.ParentID]; parent != nil && parent.Negated && event.QueryID != event.ParentID &&
+				len(node) == 1 && newLocation(node[0].Location) == newLocation(parent.Location) {
+				c.negations[event.QueryID] = struct{}{}
+			}
+		}
 	case topdown.ExitOp:
 		if rule, ok := event.Node.(*ast.Rule); ok {
 			c.setHit(rule.Head.Location)
+			c.setOutcome(c.branches, rule.Location, outcomeTrue)
+		}
+		if _, ok := c.negations[event.QueryID]; !ok {
+			if last := c.last[event.QueryID]; last != nil {
+				c.setOutcome(c.exprs, last.Location, outcomeTrue)
+			}
 		}
 	case topdown.EvalOp:
 		if expr := event.Node.(*ast.Expr); expr != nil {
-			c.setHit(expr.Location)
+			c.setHit(event.SourceLocation())
+			if _, ok := c.negations[event.QueryID]; ok {
+				return
+			}
+			c.setOutcome(c.exprs, expr.Location, outcomeEvaluated)
+			// Expressions rewritten by the compiler share the location of
+			// the original expression, which only evaluated true if the
+			// last of them did.
+			if last := c.last[event.QueryID]; last != nil && last.Index < expr.Index && newLocation(last.Location) != newLocation(expr.Location) {
+				c.setOutcome(c.exprs, last.Location, outcomeTrue)
+			}
+			c.last[event.QueryID] = expr
+		}
+	case topdown.FailOp:
+		if expr, ok := event.Node.(*ast.Expr); ok {
+			if _, ok := c.negations[event.QueryID]; !ok {
+				c.setOutcome(c.exprs, expr.Location, outcomeFalse)
+			}
 		}
 	}
 }
 
+func (c *Cover) setOutcome(m map[location]outcome, loc *ast.Location, o outcome) {
+	if hasFileLocation(loc) {
+		key := newLocation(loc)
+		m[key] |= o
+	}
+}
+
+func newLocation(loc *ast.Location) location {
+	if loc == nil {
+		return location{}
+	}
+	return location{file: loc.File, row: loc.Row, col: loc.Col}
+}
+
 func (c *Cover) setHit(loc *ast.Location) {
 	if hasFileLocation(loc) {
 		hits, ok := c.hits[loc.File]
@@ -160,13 +319,86 @@ func (r Range) In(row int) bool {
 	return row >= r.Start.Row && row <= r.End.Row
 }
 
+// Expression represents the coverage of a single expression. An expression
+// is covered if it evaluated true at least once.
+type Expression struct {
+	Row       int  `json:"row"`
+	Col       int  `json:"col"`
+	Evaluated bool `json:"evaluated,omitempty"`
+	True      bool `json:"true,omitempty"`
+
+	// False is set if the expression evaluated false or was undefined.
+	False bool `json:"false,omitempty"`
+}
+
+// Branch represents the coverage of a rule body, including else branches
+// and default rules. A branch is covered if its body evaluated true, i.e.,
+// the branch was taken, at least once.
+type Branch struct {
+	Row     int    `json:"row"`
+	Col     int    `json:"col"`
+	Rule    string `json:"rule"`
+	Else    bool   `json:"else,omitempty"`
+	Default bool   `json:"default,omitempty"`
+	Entered bool   `json:"entered,omitempty"`
+	Taken   bool   `json:"taken,omitempty"`
+}
+
+// Summary summarizes the expression or branch coverage of a file or report.
+type Summary struct {
+	Covered    int     `json:"covered"`
+	NotCovered int     `json:"not_covered"`
+	Coverage   float64 `json:"coverage"`
+}
+
+func (s *Summary) add(other *Summary) {
+	s.Covered += other.Covered
+	s.NotCovered += other.NotCovered
+}
+
+func (s *Summary) computeCoverage() {
+	if total := s.Covered + s.NotCovered; total > 0 {
+		s.Coverage = 100.0 * float64(s.Covered) / float64(total)
+	}
+}
+
+func expressionSummary(exprs []Expression) *Summary {
+	var s Summary
+	for _, x := range exprs {
+		if x.True {
+			s.Covered++
+		} else {
+			s.NotCovered++
+		}
+	}
+	s.computeCoverage()
+	return &s
+}
+
+func branchSummary(branches []Branch) *Summary {
+	var s Summary
+	for _, b := range branches {
+		if b.Taken {
+			s.Covered++
+		} else {
+			s.NotCovered++
+		}
+	}
+	s.computeCoverage()
+	return &s
+}
+
 // FileReport represents a coverage report for a single file.
 type FileReport struct {
-	Covered         []Range `json:"covered,omitempty"`
-	NotCovered      []Range `json:"not_covered,omitempty"`
-	CoveredLines    int     `json:"covered_lines,omitempty"`
-	NotCoveredLines int     `json:"not_covered_lines,omitempty"`
-	Coverage        float64 `json:"coverage,omitempty"`
+	Covered            []Range      `json:"covered,omitempty"`
+	NotCovered         []Range      `json:"not_covered,omitempty"`
+	CoveredLines       int          `json:"covered_lines,omitempty"`
+	NotCoveredLines    int          `json:"not_covered_lines,omitempty"`
+	Coverage           float64      `json:"coverage,omitempty"`
+	Expressions        []Expression `json:"expressions,omitempty"`
+	ExpressionCoverage *Summary     `json:"expression_coverage,omitempty"`
+	Branches           []Branch     `json:"branches,omitempty"`
+	BranchCoverage     *Summary     `json:"branch_coverage,omitempty"`
 }
 
 // IsCovered returns true if the row is marked as covered in the report.
@@ -228,10 +460,12 @@ func (fr *FileReport) computeCoveragePercentage() float64 {
 
 // Report represents a coverage report for a set of files.
 type Report struct {
-	Files           map[string]*FileReport `json:"files"`
-	CoveredLines    int                    `json:"covered_lines"`
-	NotCoveredLines int                    `json:"not_covered_lines"`
-	Coverage        float64                `json:"coverage"`
+	Files              map[string]*FileReport `json:"files"`
+	CoveredLines       int                    `json:"covered_lines"`
+	NotCoveredLines    int                    `json:"not_covered_lines"`
+	Coverage           float64                `json:"coverage"`
+	ExpressionCoverage *Summary               `json:"expression_coverage,omitempty"`
+	BranchCoverage     *Summary               `json:"branch_coverage,omitempty"`
 }
 
 // IsCovered returns true if the row in the given file is covered.
@@ -239,37 +473,76 @@ func (r Report) IsCovered(file string, row int) bool {
 	return r.Files[file].IsCovered(row)
 }
 
+// Types of coverage that thresholds can be set for.
+const (
+	CoverageTypeLine       = "line"
+	CoverageTypeExpression = "expression"
+	CoverageTypeBranch     = "branch"
+)
+
 // CoverageThresholdError represents an error raised when the global
 // code coverage percentage is lower than the specified threshold.
 type CoverageThresholdError struct {
 	Coverage  float64
 	Threshold float64
 	Report    *Report
+
+	// Type is the type of coverage that is lower than the threshold. If
+	// empty, it defaults to CoverageTypeLine.
+	Type string
 }
 
 func (e *CoverageThresholdError) Error() string {
 	var buffer bytes.Buffer
+	prefix := "Code"
+	switch e.Type {
+	case CoverageTypeExpression:
+		prefix = "Expression"
+	case CoverageTypeBranch:
+		prefix = "Branch"
+	}
 	buffer.WriteString(fmt.Sprintf(
-		"Code coverage threshold not met: got %.2f instead of %.2f",
+		"%s coverage threshold not met: got %.2f instead of %.2f",
+		prefix,
 		e.Coverage,
 		e.Threshold))
 
 	if e.Report != nil && len(e.Report.Files) > 0 {
-		buffer.WriteString("\nLines not covered:")
-
 		sorted := make([]string, 0, len(e.Report.Files))
 		for file := range e.Report.Files {
 			sorted = append(sorted, file)
 		}
 		sort.Strings(sorted)
 
-		for _, file := range sorted {
-			report := e.Report.Files[file]
-			for _, r := range report.NotCovered {
-				if r.Start.Row == r.End.Row {
-					buffer.WriteString(fmt.Sprintf("\n\t%s:%d", file, r.Start.Row))
-				} else {
-					buffer.WriteString(fmt.Sprintf("\n\t%s:%d-%d", file, r.Start.Row, r.End.Row))
+		switch e.Type {
+		case CoverageTypeExpression:
+			buffer.WriteString("\nExpressions not covered:")
+			for _, file := range sorted {
+				for _, x := range e.Report.Files[file].Expressions {
+					if !x.True {
+						buffer.WriteString(fmt.Sprintf("\n\t%s:%d:%d", file, x.Row, x.Col))
+					}
+				}
+			}
+		case CoverageTypeBranch:
+			buffer.WriteString("\nBranches not covered:")
+			for _, file := range sorted {
+				for _, b := range e.Report.Files[file].Branches {
+					if !b.Taken {
+						buffer.WriteString(fmt.Sprintf("\n\t%s:%d (%s)", file, b.Row, b.Rule))
+					}
+				}
+			}
+		default:
+			buffer.WriteString("\nLines not covered:")
+			for _, file := range sorted {
+				report := e.Report.Files[file]
+				for _, r := range report.NotCovered {
+					if r.Start.Row == r.End.Row {
+						buffer.WriteString(fmt.Sprintf("\n\t%s:%d", file, r.Start.Row))
+					} else {
+						buffer.WriteString(fmt.Sprintf("\n\t%s:%d-%d", file, r.Start.Row, r.End.Row))
+					}
 				}
 			}
 		}
@@ -278,6 +551,34 @@ func (e *CoverageThresholdError) Error() string {
 	return fmt.Sprint(buffer.String())
 }
 
+// CheckThresholds returns a CoverageThresholdError for the first type of
+// coverage, in the order of line, expression and branch coverage, that is
+// lower than its threshold. Thresholds that are zero are not checked.
+func (r Report) CheckThresholds(line, expression, branch float64) *CoverageThresholdError {
+	if r.Coverage < line {
+		return &CoverageThresholdError{Coverage: r.Coverage, Threshold: line}
+	}
+	if expression > 0 {
+		var coverage float64
+		if r.ExpressionCoverage != nil {
+			coverage = r.ExpressionCoverage.Coverage
+		}
+		if coverage < expression {
+			return &CoverageThresholdError{Coverage: coverage, Threshold: expression, Type: CoverageTypeExpression}
+		}
+	}
+	if branch > 0 {
+		var coverage float64
+		if r.BranchCoverage != nil {
+			coverage = r.BranchCoverage.Coverage
+		}
+		if coverage < branch {
+			return &CoverageThresholdError{Coverage: coverage, Threshold: branch, Type: CoverageTypeBranch}
+		}
+	}
+	return nil
+}
+
 func sortedPositionSliceToRangeSlice(sorted []Position) (result []Range) {
 	if len(sorted) == 0 {
 		return
@@ -14,6 +14,7 @@ import (
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/util"
 )
 
 func TestCover(t *testing.T) {
@@ -244,3 +245,133 @@ func TestCoverTraceConfig(t *testing.T) {
 		t.Fatalf("Expected config: %+v, got %+v", expected, conf)
 	}
 }
+
+func TestCoverExpressionsAndBranches(t *testing.T) {
+
+	cover := New()
+
+	module := `package test
+
+p {
+	input.a; input.b
+}
+
+q := "a" {
+	input.a
+} else := "b" {
+	not input.c
+} else := "c"
+
+r {
+	not startswith(input.s, "x")
+}
+`
+
+	parsedModule, err := ast.ParseModule("test.rego", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	for _, input := range []string{`{"a": false}`, `{"a": true, "b": true, "s": "x"}`, `{"s": "y"}`} {
+		eval := rego.New(
+			rego.Module("test.rego", module),
+			rego.Query("data.test"),
+			rego.Input(util.MustUnmarshalJSON([]byte(input))),
+			rego.QueryTracer(cover),
+		)
+		if _, err := eval.Eval(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := cover.Report(map[string]*ast.Module{
+		"test.rego": parsedModule,
+	})
+
+	fr := report.Files["test.rego"]
+
+	expectedExprs := []Expression{
+		{Row: 4, Col: 2, Evaluated: true, True: true, False: true},
+		{Row: 4, Col: 11, Evaluated: true, True: true},
+		{Row: 8, Col: 2, Evaluated: true, True: true, False: true},
+		{Row: 10, Col: 2, Evaluated: true, True: true},
+		{Row: 11, Col: 3},
+		{Row: 14, Col: 2, Evaluated: true, True: true, False: true},
+	}
+
+	if !reflect.DeepEqual(expectedExprs, fr.Expressions) {
+		t.Errorf("Expected expressions:\n\n%+v\n\nGot:\n\n%+v", expectedExprs, fr.Expressions)
+	}
+
+	expectedBranches := []Branch{
+		{Row: 3, Col: 1, Rule: "p", Entered: true, Taken: true},
+		{Row: 7, Col: 1, Rule: "q", Entered: true, Taken: true},
+		{Row: 9, Col: 3, Rule: "q", Else: true, Entered: true, Taken: true},
+		{Row: 11, Col: 3, Rule: "q", Else: true},
+		{Row: 13, Col: 1, Rule: "r", Entered: true, Taken: true},
+	}
+
+	if !reflect.DeepEqual(expectedBranches, fr.Branches) {
+		t.Errorf("Expected branches:\n\n%+v\n\nGot:\n\n%+v", expectedBranches, fr.Branches)
+	}
+
+	if exp := (Summary{Covered: 4, NotCovered: 1, Coverage: 80}); *report.BranchCoverage != exp {
+		t.Errorf("Expected branch coverage %+v but got %+v", exp, *report.BranchCoverage)
+	}
+
+	err = report.CheckThresholds(0, 0, 90)
+	if err == nil || err.Error() != "Branch coverage threshold not met: got 80.00 instead of 90.00" {
+		t.Errorf("Expected branch coverage threshold error but got: %v", err)
+	}
+}
+
+func TestCoverSourceMapLocations(t *testing.T) {
+	module, err := ast.ParseModule("test.rego", `package test
+
+p {
+	x := count(
+		[y |
+			y := input.xs[_]])
+	x > 0
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ast.NewCompiler()
+	c.Compile(map[string]*ast.Module{"test.rego": module})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	// The comprehension is rewritten into a generated expression that is
+	// located on the row the comprehension starts on.
+	var generated []int
+	ast.WalkExprs(c.Modules["test.rego"], func(x *ast.Expr) bool {
+		if x.Generated {
+			generated = append(generated, x.Location.Row)
+		}
+		return false
+	})
+	if len(generated) == 0 {
+		t.Fatal("Expected compiler to generate expressions")
+	}
+
+	cover := New()
+	_, err = rego.New(
+		rego.Compiler(c),
+		rego.Query("data.test.p"),
+		rego.Input(map[string]interface{}{"xs": []interface{}{1}}),
+		rego.QueryTracer(cover),
+	).Eval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := cover.Report(c.Modules)
+	for _, row := range append([]int{4, 6, 7}, generated...) {
+		if !report.IsCovered("test.rego", row) {
+			t.Errorf("Expected row %d to be covered but got %+v", row, report.Files["test.rego"])
+		}
+	}
+}
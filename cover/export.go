@@ -0,0 +1,206 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/version"
+)
+
+// line is a single line of a file that is either covered or not covered.
+type line struct {
+	row  int
+	hits int
+}
+
+// lines returns the lines reported for the file, ordered by row. The report
+// only records if lines were evaluated, so covered lines have one hit.
+func (fr *FileReport) lines() []line {
+	var result []line
+	for _, r := range fr.Covered {
+		for row := r.Start.Row; row <= r.End.Row; row++ {
+			result = append(result, line{row: row, hits: 1})
+		}
+	}
+	for _, r := range fr.NotCovered {
+		for row := r.Start.Row; row <= r.End.Row; row++ {
+			result = append(result, line{row: row})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].row < result[j].row
+	})
+	return result
+}
+
+func (r Report) sortedFiles() []string {
+	files := make([]string, 0, len(r.Files))
+	for file := range r.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// WriteLCOV writes the report in the LCOV tracefile format.
+func (r Report) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, file := range r.sortedFiles() {
+		fr := r.Files[file]
+		fmt.Fprintln(bw, "TN:")
+		fmt.Fprintf(bw, "SF:%s\n", file)
+		for _, l := range fr.lines() {
+			fmt.Fprintf(bw, "DA:%d,%d\n", l.row, l.hits)
+		}
+		fmt.Fprintf(bw, "LH:%d\n", fr.CoveredLines)
+		fmt.Fprintf(bw, "LF:%d\n", fr.CoveredLines+fr.NotCoveredLines)
+		if len(fr.Branches) > 0 {
+			// Every rule body is reported as a branch of its own block.
+			var taken int
+			for i, b := range fr.Branches {
+				hits := "-"
+				if b.Entered {
+					hits = "0"
+				}
+				if b.Taken {
+					hits = "1"
+					taken++
+				}
+				fmt.Fprintf(bw, "BRDA:%d,%d,0,%s\n", b.Row, i, hits)
+			}
+			fmt.Fprintf(bw, "BRF:%d\n", len(fr.Branches))
+			fmt.Fprintf(bw, "BRH:%d\n", taken)
+		}
+		fmt.Fprintln(bw, "end_of_record")
+	}
+	return bw.Flush()
+}
+
+type coberturaCoverage struct {
+	XMLName         xml.Name            `xml:"coverage"`
+	LineRate        string              `xml:"line-rate,attr"`
+	BranchRate      string              `xml:"branch-rate,attr"`
+	LinesCovered    int                 `xml:"lines-covered,attr"`
+	LinesValid      int                 `xml:"lines-valid,attr"`
+	BranchesCovered int                 `xml:"branches-covered,attr"`
+	BranchesValid   int                 `xml:"branches-valid,attr"`
+	Complexity      string              `xml:"complexity,attr"`
+	Version         string              `xml:"version,attr"`
+	Timestamp       int64               `xml:"timestamp,attr"`
+	Sources         []string            `xml:"sources>source"`
+	Packages        []*coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string            `xml:"name,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity string            `xml:"complexity,attr"`
+	Classes    []*coberturaClass `xml:"classes>class"`
+
+	covered, valid                 int
+	branchesCovered, branchesValid int
+}
+
+type coberturaClass struct {
+	Name       string           `xml:"name,attr"`
+	Filename   string           `xml:"filename,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Methods    struct{}         `xml:"methods"`
+	Lines      []*coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// WriteCobertura writes the report in the Cobertura XML format. Files are
+// grouped into one package per directory. The timestamp is given in
+// milliseconds since the epoch.
+func (r Report) WriteCobertura(w io.Writer, timestamp int64) error {
+	report := coberturaCoverage{
+		LineRate:     coberturaRate(r.CoveredLines, r.CoveredLines+r.NotCoveredLines),
+		BranchRate:   "0",
+		LinesCovered: r.CoveredLines,
+		LinesValid:   r.CoveredLines + r.NotCoveredLines,
+		Complexity:   "0",
+		Version:      version.Version,
+		Timestamp:    timestamp,
+		Sources:      []string{"."},
+	}
+	if r.BranchCoverage != nil {
+		report.BranchesCovered = r.BranchCoverage.Covered
+		report.BranchesValid = r.BranchCoverage.Covered + r.BranchCoverage.NotCovered
+		report.BranchRate = coberturaRate(report.BranchesCovered, report.BranchesValid)
+	}
+
+	packages := map[string]*coberturaPackage{}
+	for _, file := range r.sortedFiles() {
+		fr := r.Files[file]
+		name := path.Dir(filepath.ToSlash(file))
+		pkg, ok := packages[name]
+		if !ok {
+			pkg = &coberturaPackage{Name: name, BranchRate: "0", Complexity: "0"}
+			packages[name] = pkg
+			report.Packages = append(report.Packages, pkg)
+		}
+
+		class := &coberturaClass{
+			Name:       path.Base(filepath.ToSlash(file)),
+			Filename:   file,
+			LineRate:   coberturaRate(fr.CoveredLines, fr.CoveredLines+fr.NotCoveredLines),
+			BranchRate: "0",
+			Complexity: "0",
+		}
+		if fr.BranchCoverage != nil {
+			covered, valid := fr.BranchCoverage.Covered, fr.BranchCoverage.Covered+fr.BranchCoverage.NotCovered
+			class.BranchRate = coberturaRate(covered, valid)
+			pkg.branchesCovered += covered
+			pkg.branchesValid += valid
+		}
+		for _, l := range fr.lines() {
+			class.Lines = append(class.Lines, &coberturaLine{Number: l.row, Hits: l.hits})
+		}
+		pkg.Classes = append(pkg.Classes, class)
+		pkg.covered += fr.CoveredLines
+		pkg.valid += fr.CoveredLines + fr.NotCoveredLines
+	}
+
+	sort.SliceStable(report.Packages, func(i, j int) bool {
+		return report.Packages[i].Name < report.Packages[j].Name
+	})
+	for _, pkg := range report.Packages {
+		pkg.LineRate = coberturaRate(pkg.covered, pkg.valid)
+		pkg.BranchRate = coberturaRate(pkg.branchesCovered, pkg.branchesValid)
+	}
+
+	bs, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(bs))
+	return err
+}
+
+func coberturaRate(covered, valid int) string {
+	if valid == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(valid), 'f', 4, 64)
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cover
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/open-policy-agent/opa/version"
+)
+
+func exportTestReport() Report {
+	return Report{
+		Files: map[string]*FileReport{
+			"policies/b.rego": {
+				Covered:         []Range{{Position{3}, Position{4}}},
+				NotCovered:      []Range{{Position{6}, Position{6}}},
+				CoveredLines:    2,
+				NotCoveredLines: 1,
+				Branches: []Branch{
+					{Row: 3, Col: 1, Rule: "p", Entered: true, Taken: true},
+					{Row: 5, Col: 3, Rule: "p", Else: true, Entered: true},
+					{Row: 6, Col: 3, Rule: "p", Else: true},
+				},
+				BranchCoverage: &Summary{Covered: 1, NotCovered: 2},
+			},
+			"policies/a.rego": {
+				Covered:      []Range{{Position{5}, Position{5}}},
+				CoveredLines: 1,
+			},
+		},
+		CoveredLines:    3,
+		NotCoveredLines: 1,
+		BranchCoverage:  &Summary{Covered: 1, NotCovered: 2},
+	}
+}
+
+func TestReportWriteLCOV(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportTestReport().WriteLCOV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `TN:
+SF:policies/a.rego
+DA:5,1
+LH:1
+LF:1
+end_of_record
+TN:
+SF:policies/b.rego
+DA:3,1
+DA:4,1
+DA:6,0
+LH:2
+LF:3
+BRDA:3,0,0,1
+BRDA:5,1,0,0
+BRDA:6,2,0,-
+BRF:3
+BRH:1
+end_of_record
+`
+
+	if exp != buf.String() {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+	}
+}
+
+func TestReportWriteCobertura(t *testing.T) {
+	var buf bytes.Buffer
+	if err := exportTestReport().WriteCobertura(&buf, 1700000000000); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<coverage line-rate="0.7500" branch-rate="0.3333" lines-covered="3" lines-valid="4" branches-covered="1" branches-valid="3" complexity="0" version="%s" timestamp="1700000000000">
+  <sources>
+    <source>.</source>
+  </sources>
+  <packages>
+    <package name="policies" line-rate="0.7500" branch-rate="0.3333" complexity="0">
+      <classes>
+        <class name="a.rego" filename="policies/a.rego" line-rate="1.0000" branch-rate="0" complexity="0">
+          <methods></methods>
+          <lines>
+            <line number="5" hits="1"></line>
+          </lines>
+        </class>
+        <class name="b.rego" filename="policies/b.rego" line-rate="0.6667" branch-rate="0.3333" complexity="0">
+          <methods></methods>
+          <lines>
+            <line number="3" hits="1"></line>
+            <line number="4" hits="1"></line>
+            <line number="6" hits="0"></line>
+          </lines>
+        </class>
+      </classes>
+    </package>
+  </packages>
+</coverage>
+`, version.Version)
+
+	if exp != buf.String() {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+	}
+}
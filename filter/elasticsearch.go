@@ -0,0 +1,114 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+var wildcardEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`)
+
+type esWriter struct {
+	table string
+	errs  Errors
+}
+
+func translateElasticsearch(c cond) (*Result, error) {
+	w := &esWriter{}
+	query := w.query(c)
+	if len(w.errs) > 0 {
+		return nil, w.errs
+	}
+	return &Result{Query: query}, nil
+}
+
+type object = map[string]interface{}
+
+func (w *esWriter) query(c cond) object {
+	switch c := c.(type) {
+	case constant:
+		if c {
+			return object{"match_all": object{}}
+		}
+		return object{"match_none": object{}}
+	case and:
+		return object{"bool": object{"filter": w.queries(c)}}
+	case or:
+		return object{"bool": object{"should": w.queries(c), "minimum_should_match": 1}}
+	case not:
+		return object{"bool": object{"must_not": []interface{}{w.query(c.c)}}}
+	case compare:
+		field := w.field(c.col)
+		switch c.op {
+		case "eq", "neq":
+			// Elasticsearch does not distinguish null values from missing
+			// fields, whereas refs to missing fields are undefined in Rego.
+			if c.value == nil {
+				w.errs = append(w.errs, &Error{
+					Message:  "comparisons with null cannot be translated to elasticsearch queries",
+					Location: c.loc,
+				})
+				return nil
+			}
+			q := object{"term": object{field: c.value}}
+			if c.op == "neq" {
+				return object{"bool": object{
+					"filter":   []interface{}{object{"exists": object{"field": field}}},
+					"must_not": []interface{}{q},
+				}}
+			}
+			return q
+		default:
+			return object{"range": object{field: object{c.op: c.value}}}
+		}
+	case compareColumns:
+		w.errs = append(w.errs, &Error{
+			Message:  "comparisons between fields cannot be translated to elasticsearch queries",
+			Location: c.loc,
+		})
+		return nil
+	case in:
+		values := c.values
+		if values == nil {
+			values = []interface{}{}
+		}
+		return object{"terms": object{w.field(c.col): values}}
+	case like:
+		field := w.field(c.col)
+		switch c.kind {
+		case "prefix":
+			return object{"prefix": object{field: c.value}}
+		case "suffix":
+			return object{"wildcard": object{field: "*" + wildcardEscaper.Replace(c.value)}}
+		default:
+			return object{"wildcard": object{field: "*" + wildcardEscaper.Replace(c.value) + "*"}}
+		}
+	}
+	return nil
+}
+
+func (w *esWriter) queries(cs []cond) []interface{} {
+	result := make([]interface{}, len(cs))
+	for i := range cs {
+		result[i] = w.query(cs[i])
+	}
+	return result
+}
+
+// field returns the name of the field in the document; tables map to indices,
+// so they are not part of the name, and all columns must belong to the same
+// table.
+func (w *esWriter) field(col column) string {
+	if w.table == "" {
+		w.table = col.table
+	} else if w.table != col.table {
+		w.errs = append(w.errs, &Error{
+			Message:  fmt.Sprintf("%v and %v: only one index can be queried in elasticsearch queries", w.table, col.table),
+			Location: col.loc,
+		})
+	}
+	return strings.Join(col.path, ".")
+}
@@ -0,0 +1,519 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package filter translates the results of partial evaluation into SQL and
+// Elasticsearch queries for data filtering.
+//
+// Refs to unknowns in the partially evaluated queries are translated to
+// columns (fields). For example, with the unknown data.reports, the ref
+// data.reports[x].owner refers to the owner column of the reports table. The
+// queries are combined with OR, and the expressions of each query with AND.
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Target is the query language partially evaluated queries are translated to.
+type Target string
+
+// Supported targets.
+const (
+	TargetPostgres      Target = "sql+postgres"
+	TargetMySQL         Target = "sql+mysql"
+	TargetSQLite        Target = "sql+sqlite"
+	TargetElasticsearch Target = "elasticsearch"
+)
+
+// Targets returns the supported targets.
+func Targets() []Target {
+	return []Target{TargetPostgres, TargetMySQL, TargetSQLite, TargetElasticsearch}
+}
+
+// Valid returns true if t is a supported target.
+func (t Target) Valid() bool {
+	for _, other := range Targets() {
+		if t == other {
+			return true
+		}
+	}
+	return false
+}
+
+// Table maps an unknown to a table and its fields to columns. Without a
+// mapping, the table is named after the last element of the unknown and the
+// columns after the fields.
+type Table struct {
+	Name    string            `json:"table,omitempty"`
+	Columns map[string]string `json:"columns,omitempty"`
+}
+
+// Result is the translation of partially evaluated queries.
+type Result struct {
+	// Query is the condition of a SQL WHERE clause (string) or an
+	// Elasticsearch query (object).
+	Query interface{} `json:"query"`
+
+	// Args are the values of the parameters of SQL queries.
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// Error represents an expression that cannot be translated.
+type Error struct {
+	Message  string        `json:"message"`
+	Location *ast.Location `json:"location,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Location != nil {
+		return fmt.Sprintf("%v: %v", e.Location, e.Message)
+	}
+	return e.Message
+}
+
+// Errors represents a list of errors returned by translation.
+type Errors []*Error
+
+func (e Errors) Error() string {
+
+	if len(e) == 0 {
+		return "no error(s)"
+	}
+
+	if len(e) == 1 {
+		return fmt.Sprintf("1 error occurred: %v", e[0].Error())
+	}
+
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d errors occurred:\n%s", len(e), strings.Join(s, "\n"))
+}
+
+// Translator translates partially evaluated queries for a target.
+type Translator struct {
+	target   Target
+	unknowns []ast.Ref
+	mappings map[string]Table
+}
+
+// New returns a new Translator for the target.
+func New(target Target) *Translator {
+	return &Translator{
+		target:   target,
+		unknowns: []ast.Ref{ast.InputRootRef},
+	}
+}
+
+// WithUnknowns sets the unknowns that partial evaluation was run with. If not
+// set, input is assumed.
+func (t *Translator) WithUnknowns(unknowns []ast.Ref) *Translator {
+	t.unknowns = unknowns
+	return t
+}
+
+// WithMappings sets the tables the unknowns are mapped to, keyed by unknown,
+// e.g., "data.reports".
+func (t *Translator) WithMappings(mappings map[string]Table) *Translator {
+	t.mappings = mappings
+	return t
+}
+
+// Translate translates the partially evaluated queries. Support modules
+// cannot be translated; partial evaluation should be run in strict mode to
+// report the constructs requiring them.
+func (t *Translator) Translate(queries []ast.Body, support []*ast.Module) (*Result, error) {
+	if !t.target.Valid() {
+		return nil, fmt.Errorf("unsupported target %q", t.target)
+	}
+
+	var errs Errors
+	for _, m := range support {
+		errs = append(errs, &Error{
+			Message:  fmt.Sprintf("support module %v cannot be translated", m.Package.Path),
+			Location: m.Package.Location,
+		})
+	}
+
+	c := make(or, 0, len(queries))
+	for _, query := range queries {
+		q, err := t.query(query)
+		errs = append(errs, err...)
+		c = append(c, q)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	switch t.target {
+	case TargetElasticsearch:
+		return translateElasticsearch(c.simplify())
+	default:
+		return translateSQL(t.target, c.simplify())
+	}
+}
+
+// query translates the expressions of a query into a conjunction.
+func (t *Translator) query(query ast.Body) (cond, Errors) {
+	var errs Errors
+	rows := map[string]*ast.Term{}
+	c := make(and, 0, len(query))
+
+	for _, expr := range query {
+		x, err := t.expr(expr, rows)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c = append(c, x)
+	}
+
+	return c, errs
+}
+
+func (t *Translator) expr(expr *ast.Expr, rows map[string]*ast.Term) (cond, *Error) {
+	if len(expr.With) > 0 {
+		return nil, exprErr(expr, "with statements cannot be translated")
+	}
+
+	var c cond
+	var err *Error
+
+	switch terms := expr.Terms.(type) {
+	case *ast.Term:
+		c, err = t.term(expr, terms, rows)
+	case []*ast.Term:
+		c, err = t.call(expr, terms, rows)
+	default:
+		return nil, exprErr(expr, "%v expressions cannot be translated", expr.Terms)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if expr.Negated {
+		return not{c}, nil
+	}
+	return c, nil
+}
+
+// term translates an expression consisting of a single term, i.e., a boolean
+// or a column that must be true.
+func (t *Translator) term(expr *ast.Expr, term *ast.Term, rows map[string]*ast.Term) (cond, *Error) {
+	switch v := term.Value.(type) {
+	case ast.Boolean:
+		return constant(v), nil
+	case ast.Ref:
+		col, err := t.column(expr, v, rows)
+		if err != nil {
+			return nil, err
+		}
+		return compare{op: "eq", col: col, value: true, loc: expr.Location}, nil
+	}
+	return nil, exprErr(expr, "%v cannot be translated", term)
+}
+
+var comparisons = map[string]string{
+	ast.Equality.Name:      "eq",
+	ast.Equal.Name:         "eq",
+	ast.NotEqual.Name:      "neq",
+	ast.LessThan.Name:      "lt",
+	ast.LessThanEq.Name:    "lte",
+	ast.GreaterThan.Name:   "gt",
+	ast.GreaterThanEq.Name: "gte",
+}
+
+// swapped holds the comparison operators with their operands swapped.
+var swapped = map[string]string{
+	"eq":  "eq",
+	"neq": "neq",
+	"lt":  "gt",
+	"lte": "gte",
+	"gt":  "lt",
+	"gte": "lte",
+}
+
+var patterns = map[string]string{
+	ast.StartsWith.Name: "prefix",
+	ast.EndsWith.Name:   "suffix",
+	ast.Contains.Name:   "contains",
+}
+
+func (t *Translator) call(expr *ast.Expr, terms []*ast.Term, rows map[string]*ast.Term) (cond, *Error) {
+	name := expr.Operator().String()
+
+	if op, ok := comparisons[name]; ok && len(terms) == 3 {
+		a, b := terms[1], terms[2]
+		if !t.isColumn(a) {
+			a, b = b, a
+			op = swapped[op]
+		}
+		col, err := t.column(expr, a.Value, rows)
+		if err != nil {
+			return nil, err
+		}
+		if t.isColumn(b) {
+			other, err := t.column(expr, b.Value, rows)
+			if err != nil {
+				return nil, err
+			}
+			return compareColumns{op: op, left: col, right: other, loc: expr.Location}, nil
+		}
+		value, err := scalar(expr, b)
+		if err != nil {
+			return nil, err
+		}
+		return compare{op: op, col: col, value: value, loc: expr.Location}, nil
+	}
+
+	if kind, ok := patterns[name]; ok && len(terms) == 3 {
+		col, err := t.column(expr, terms[1].Value, rows)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := terms[2].Value.(ast.String)
+		if !ok {
+			return nil, exprErr(expr, "%v: pattern must be a string", name)
+		}
+		return like{kind: kind, col: col, value: string(s), loc: expr.Location}, nil
+	}
+
+	if name == ast.Member.Name && len(terms) == 3 {
+		col, err := t.column(expr, terms[1].Value, rows)
+		if err != nil {
+			return nil, err
+		}
+		var values []interface{}
+		var elems []*ast.Term
+		switch coll := terms[2].Value.(type) {
+		case *ast.Array:
+			coll.Foreach(func(x *ast.Term) { elems = append(elems, x) })
+		case ast.Set:
+			elems = coll.Slice()
+		default:
+			return nil, exprErr(expr, "%v: collection must be an array or a set", ast.Member.Infix)
+		}
+		for _, x := range elems {
+			value, err := scalar(expr, x)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return in{col: col, values: values, loc: expr.Location}, nil
+	}
+
+	return nil, exprErr(expr, "%v cannot be translated", expr)
+}
+
+func (t *Translator) isColumn(term *ast.Term) bool {
+	ref, ok := term.Value.(ast.Ref)
+	return ok && t.unknown(ref) != nil
+}
+
+// unknown returns the longest unknown prefixing ref.
+func (t *Translator) unknown(ref ast.Ref) ast.Ref {
+	var result ast.Ref
+	for _, u := range t.unknowns {
+		if ref.HasPrefix(u) && len(u) > len(result) {
+			result = u
+		}
+	}
+	return result
+}
+
+// column returns the column referred to by x. An element following the
+// unknown that is a variable refers to a row of the table; all refs to the
+// same table within a query must refer to the same row.
+func (t *Translator) column(expr *ast.Expr, x ast.Value, rows map[string]*ast.Term) (column, *Error) {
+	ref, ok := x.(ast.Ref)
+	if !ok {
+		return column{}, exprErr(expr, "%v: expected a reference to an unknown", x)
+	}
+	u := t.unknown(ref)
+	if u == nil {
+		return column{}, exprErr(expr, "%v: expected a reference to an unknown", ref)
+	}
+
+	rest := ref[len(u):]
+	if len(rest) > 0 {
+		if _, ok := rest[0].Value.(ast.Var); ok {
+			if row, ok := rows[u.String()]; ok && !row.Equal(rest[0]) {
+				return column{}, exprErr(expr, "%v: only one row of %v can be referred to in a query", ref, u)
+			}
+			rows[u.String()] = rest[0]
+			rest = rest[1:]
+		}
+	}
+
+	if len(rest) == 0 {
+		return column{}, exprErr(expr, "%v: expected a reference to a field", ref)
+	}
+
+	path := make([]string, len(rest))
+	for i := range rest {
+		s, ok := rest[i].Value.(ast.String)
+		if !ok {
+			return column{}, exprErr(expr, "%v: field names must be strings", ref)
+		}
+		path[i] = string(s)
+	}
+
+	table := t.mappings[u.String()]
+	if table.Name == "" {
+		table.Name = defaultTableName(u)
+	}
+	if name, ok := table.Columns[strings.Join(path, ".")]; ok {
+		path = []string{name}
+	}
+
+	return column{table: table.Name, path: path, loc: expr.Location}, nil
+}
+
+func defaultTableName(u ast.Ref) string {
+	if s, ok := u[len(u)-1].Value.(ast.String); ok {
+		return string(s)
+	}
+	return u[len(u)-1].String()
+}
+
+// scalar returns the Go value of a constant scalar term.
+func scalar(expr *ast.Expr, term *ast.Term) (interface{}, *Error) {
+	switch v := term.Value.(type) {
+	case ast.Null:
+		return nil, nil
+	case ast.Boolean:
+		return bool(v), nil
+	case ast.String:
+		return string(v), nil
+	case ast.Number:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return json.Number(v), nil
+	}
+	return nil, exprErr(expr, "%v: expected a string, number, boolean or null value", term)
+}
+
+func exprErr(expr *ast.Expr, f string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(f, a...), Location: expr.Location}
+}
+
+// cond is a condition translated from a partially evaluated query.
+type cond interface {
+	simplify() cond
+}
+
+type column struct {
+	table string
+	path  []string
+	loc   *ast.Location
+}
+
+type (
+	and      []cond
+	or       []cond
+	not      struct{ c cond }
+	constant bool
+
+	// compare compares a column with a value.
+	compare struct {
+		op    string
+		col   column
+		value interface{}
+		loc   *ast.Location
+	}
+
+	// compareColumns compares two columns.
+	compareColumns struct {
+		op          string
+		left, right column
+		loc         *ast.Location
+	}
+
+	// in checks if a column is equal to one of the values.
+	in struct {
+		col    column
+		values []interface{}
+		loc    *ast.Location
+	}
+
+	// like checks if a string column has a prefix, suffix or substring.
+	like struct {
+		kind  string
+		col   column
+		value string
+		loc   *ast.Location
+	}
+)
+
+func (c and) simplify() cond {
+	result := make(and, 0, len(c))
+	for _, x := range c {
+		switch x := x.simplify().(type) {
+		case constant:
+			if !x {
+				return x
+			}
+		case and:
+			result = append(result, x...)
+		default:
+			result = append(result, x)
+		}
+	}
+	switch len(result) {
+	case 0:
+		return constant(true)
+	case 1:
+		return result[0]
+	}
+	return result
+}
+
+func (c or) simplify() cond {
+	result := make(or, 0, len(c))
+	for _, x := range c {
+		switch x := x.simplify().(type) {
+		case constant:
+			if x {
+				return x
+			}
+		case or:
+			result = append(result, x...)
+		default:
+			result = append(result, x)
+		}
+	}
+	switch len(result) {
+	case 0:
+		return constant(false)
+	case 1:
+		return result[0]
+	}
+	return result
+}
+
+func (c not) simplify() cond {
+	switch x := c.c.simplify().(type) {
+	case constant:
+		return !x
+	case not:
+		return x.c
+	default:
+		return not{x}
+	}
+}
+
+func (c constant) simplify() cond       { return c }
+func (c compare) simplify() cond        { return c }
+func (c compareColumns) simplify() cond { return c }
+func (c in) simplify() cond             { return c }
+func (c like) simplify() cond           { return c }
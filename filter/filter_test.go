@@ -0,0 +1,355 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func parseQueries(qs []string) []ast.Body {
+	result := make([]ast.Body, len(qs))
+	for i := range qs {
+		result[i] = ast.MustParseBody(qs[i])
+	}
+	return result
+}
+
+func TestTranslateSQL(t *testing.T) {
+	tests := []struct {
+		note     string
+		target   Target
+		queries  []string
+		mappings map[string]Table
+		query    string
+		args     []interface{}
+	}{
+		{
+			note:    "no queries",
+			target:  TargetPostgres,
+			queries: []string{},
+			query:   "FALSE",
+		},
+		{
+			note:    "empty query",
+			target:  TargetPostgres,
+			queries: []string{"true"},
+			query:   "TRUE",
+		},
+		{
+			note:    "equality",
+			target:  TargetPostgres,
+			queries: []string{`"alice" = data.reports[x].owner`},
+			query:   `"reports"."owner" = $1`,
+			args:    []interface{}{"alice"},
+		},
+		{
+			note:    "comparisons",
+			target:  TargetPostgres,
+			queries: []string{`data.reports[x].size < 10; 3 < data.reports[x].size; data.reports[x].owner != "bob"`},
+			query:   `"reports"."size" < $1 AND "reports"."size" > $2 AND "reports"."owner" <> $3`,
+			args:    []interface{}{int64(10), int64(3), "bob"},
+		},
+		{
+			note:    "or",
+			target:  TargetPostgres,
+			queries: []string{`data.reports[x].public = true`, `data.reports[x].owner = "alice"; data.reports[x].size > 1.5`},
+			query:   `"reports"."public" = $1 OR ("reports"."owner" = $2 AND "reports"."size" > $3)`,
+			args:    []interface{}{true, "alice", json.Number("1.5")},
+		},
+		{
+			note:    "null",
+			target:  TargetPostgres,
+			queries: []string{`data.reports[x].deleted = null; data.reports[x].owner != null`},
+			query:   `"reports"."deleted" IS NULL AND "reports"."owner" IS NOT NULL`,
+		},
+		{
+			note:    "not",
+			target:  TargetPostgres,
+			queries: []string{`not data.reports[x].archived`},
+			query:   `("reports"."archived" = $1) IS NOT TRUE`,
+			args:    []interface{}{true},
+		},
+		{
+			note:    "not and",
+			target:  TargetPostgres,
+			queries: []string{`not data.reports[x].size > 10`, `not startswith(data.reports[x].name, "a")`},
+			query:   `("reports"."size" > $1) IS NOT TRUE OR ("reports"."name" LIKE $2 ESCAPE '!') IS NOT TRUE`,
+			args:    []interface{}{int64(10), "a%"},
+		},
+		{
+			note:    "in",
+			target:  TargetPostgres,
+			queries: []string{`internal.member_2(data.reports[x].owner, ["alice", "bob"])`},
+			query:   `"reports"."owner" IN ($1, $2)`,
+			args:    []interface{}{"alice", "bob"},
+		},
+		{
+			note:    "in empty",
+			target:  TargetPostgres,
+			queries: []string{`internal.member_2(data.reports[x].owner, set())`},
+			query:   `FALSE`,
+		},
+		{
+			note:    "like",
+			target:  TargetPostgres,
+			queries: []string{`startswith(data.reports[x].name, "100%_"); endswith(data.reports[x].name, "!"); contains(data.reports[x].name, "x")`},
+			query:   `"reports"."name" LIKE $1 ESCAPE '!' AND "reports"."name" LIKE $2 ESCAPE '!' AND "reports"."name" LIKE $3 ESCAPE '!'`,
+			args:    []interface{}{"100!%!_%", "%!!", "%x%"},
+		},
+		{
+			note:    "like mysql",
+			target:  TargetMySQL,
+			queries: []string{`startswith(data.reports[x].name, "A_"); contains(data.reports[x].name, "x")`},
+			query:   "`reports`.`name` LIKE CAST(? AS BINARY) ESCAPE '!' AND `reports`.`name` LIKE CAST(? AS BINARY) ESCAPE '!'",
+			args:    []interface{}{"A!_%", "%x%"},
+		},
+		{
+			note:    "glob sqlite",
+			target:  TargetSQLite,
+			queries: []string{`startswith(data.reports[x].name, "A*"); endswith(data.reports[x].name, "[?]"); contains(data.reports[x].name, "%_")`},
+			query:   `"reports"."name" GLOB ? AND "reports"."name" GLOB ? AND "reports"."name" GLOB ?`,
+			args:    []interface{}{"A[*]*", "*[[][?]]", "*%_*"},
+		},
+		{
+			note:    "columns",
+			target:  TargetPostgres,
+			queries: []string{`data.reports[x].owner = data.users[y].name`},
+			query:   `"reports"."owner" = "users"."name"`,
+		},
+		{
+			note:    "mappings",
+			target:  TargetPostgres,
+			queries: []string{`data.reports[x].owner = "alice"`},
+			mappings: map[string]Table{
+				"data.reports": {Name: "r", Columns: map[string]string{"owner": "owner_id"}},
+			},
+			query: `"r"."owner_id" = $1`,
+			args:  []interface{}{"alice"},
+		},
+		{
+			note:    "mysql",
+			target:  TargetMySQL,
+			queries: []string{`data.reports[x].owner = "alice"; data.reports[x].size > 1`},
+			query:   "`reports`.`owner` = ? AND `reports`.`size` > ?",
+			args:    []interface{}{"alice", int64(1)},
+		},
+		{
+			note:    "sqlite",
+			target:  TargetSQLite,
+			queries: []string{`data.reports[x].owner = "alice"`},
+			query:   `"reports"."owner" = ?`,
+			args:    []interface{}{"alice"},
+		},
+	}
+
+	unknowns := []ast.Ref{ast.MustParseRef("data.reports"), ast.MustParseRef("data.users")}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			result, err := New(tc.target).
+				WithUnknowns(unknowns).
+				WithMappings(tc.mappings).
+				Translate(parseQueries(tc.queries), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Query != tc.query {
+				t.Fatalf("expected query:\n\n%v\n\ngot:\n\n%v", tc.query, result.Query)
+			}
+			if !reflect.DeepEqual(result.Args, tc.args) {
+				t.Fatalf("expected args %v but got %v", tc.args, result.Args)
+			}
+		})
+	}
+}
+
+func TestTranslateElasticsearch(t *testing.T) {
+	tests := []struct {
+		note    string
+		queries []string
+		query   string
+	}{
+		{
+			note:    "no queries",
+			queries: []string{},
+			query:   `{"match_none": {}}`,
+		},
+		{
+			note:    "term",
+			queries: []string{`input.owner = "alice"`},
+			query:   `{"term": {"owner": "alice"}}`,
+		},
+		{
+			note:    "nested",
+			queries: []string{`input.meta.owner = "alice"`},
+			query:   `{"term": {"meta.owner": "alice"}}`,
+		},
+		{
+			note:    "bool",
+			queries: []string{`input.owner = "alice"; input.size >= 10`, `not input.archived; input.owner != "bob"`},
+			query: `{"bool": {"minimum_should_match": 1, "should": [
+				{"bool": {"filter": [{"term": {"owner": "alice"}}, {"range": {"size": {"gte": 10}}}]}},
+				{"bool": {"filter": [
+					{"bool": {"must_not": [{"term": {"archived": true}}]}},
+					{"bool": {"filter": [{"exists": {"field": "owner"}}], "must_not": [{"term": {"owner": "bob"}}]}}
+				]}}
+			]}}`,
+		},
+		{
+			note:    "terms",
+			queries: []string{`internal.member_2(input.owner, ["alice", "bob"])`},
+			query:   `{"terms": {"owner": ["alice", "bob"]}}`,
+		},
+		{
+			note:    "patterns",
+			queries: []string{`startswith(input.name, "a*"); endswith(input.name, "b?"); contains(input.name, "c")`},
+			query:   `{"bool": {"filter": [{"prefix": {"name": "a*"}}, {"wildcard": {"name": "*b\\?"}}, {"wildcard": {"name": "*c*"}}]}}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			result, err := New(TargetElasticsearch).Translate(parseQueries(tc.queries), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var exp interface{}
+			if err := util.UnmarshalJSON([]byte(tc.query), &exp); err != nil {
+				t.Fatal(err)
+			}
+			act := result.Query
+			if err := util.RoundTrip(&act); err != nil {
+				t.Fatal(err)
+			}
+			if util.Compare(exp, act) != 0 {
+				t.Fatalf("expected query:\n\n%v\n\ngot:\n\n%v", string(util.MustMarshalJSON(exp)), string(util.MustMarshalJSON(act)))
+			}
+		})
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	tests := []struct {
+		note    string
+		target  Target
+		queries []string
+		errs    []string
+	}{
+		{
+			note:    "unsupported call",
+			target:  TargetPostgres,
+			queries: []string{`count(data.reports[x].owners, 1)`},
+			errs:    []string{"count(data.reports[x].owners, 1) cannot be translated"},
+		},
+		{
+			note:    "all errors",
+			target:  TargetPostgres,
+			queries: []string{`data.reports[x].owner = y`, `data.reports[x].owner = "a"; data.reports[y].owner = "b"`},
+			errs: []string{
+				"y: expected a string, number, boolean or null value",
+				"only one row of data.reports can be referred to in a query",
+			},
+		},
+		{
+			note:    "not a field",
+			target:  TargetPostgres,
+			queries: []string{`data.reports[x] = "a"`},
+			errs:    []string{"expected a reference to a field"},
+		},
+		{
+			note:    "nested column",
+			target:  TargetMySQL,
+			queries: []string{`data.reports[x].meta.owner = "a"`},
+			errs:    []string{"nested field meta.owner of table reports cannot be translated to a column"},
+		},
+		{
+			note:    "field comparison",
+			target:  TargetElasticsearch,
+			queries: []string{`data.reports[x].owner = data.reports[x].author`},
+			errs:    []string{"comparisons between fields cannot be translated to elasticsearch queries"},
+		},
+		{
+			note:    "null comparison elasticsearch",
+			target:  TargetElasticsearch,
+			queries: []string{`data.reports[x].owner = null`, `data.reports[x].deleted != null`},
+			errs:    []string{"comparisons with null cannot be translated to elasticsearch queries"},
+		},
+		{
+			note:    "multiple indices elasticsearch",
+			target:  TargetElasticsearch,
+			queries: []string{`data.reports[x].owner = "a"`, `data.users[x].name = "a"`},
+			errs:    []string{"reports and users: only one index can be queried in elasticsearch queries"},
+		},
+		{
+			note:    "unknown target",
+			target:  Target("sql+oracle"),
+			queries: []string{`data.reports[x].owner = "a"`},
+			errs:    []string{`unsupported target "sql+oracle"`},
+		},
+	}
+
+	unknowns := []ast.Ref{ast.MustParseRef("data.reports"), ast.MustParseRef("data.users")}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			_, err := New(tc.target).WithUnknowns(unknowns).Translate(parseQueries(tc.queries), nil)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, exp := range tc.errs {
+				if !strings.Contains(err.Error(), exp) {
+					t.Fatalf("expected error to contain %q but got: %v", exp, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslatePartialResult(t *testing.T) {
+	module := `package example
+
+	allow {
+		input.method = "GET"
+		data.reports[x].owner = input.user
+	}
+
+	allow {
+		input.method = "GET"
+		data.reports[x].public
+	}`
+
+	pq, err := rego.New(
+		rego.Query("data.example.allow = true"),
+		rego.Module("example.rego", module),
+		rego.Input(map[string]interface{}{"method": "GET", "user": "alice"}),
+		rego.Unknowns([]string{"data.reports"}),
+	).Partial(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := New(TargetPostgres).
+		WithUnknowns([]ast.Ref{ast.MustParseRef("data.reports")}).
+		Translate(pq.Queries, pq.Support)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `"reports"."owner" = $1 OR "reports"."public" = $2`
+	if result.Query != exp {
+		t.Fatalf("expected query %v but got %v", exp, result.Query)
+	}
+	if !reflect.DeepEqual(result.Args, []interface{}{"alice", true}) {
+		t.Fatalf("unexpected args: %v", result.Args)
+	}
+}
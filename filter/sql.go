@@ -0,0 +1,181 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+var sqlOperators = map[string]string{
+	"eq":  "=",
+	"neq": "<>",
+	"lt":  "<",
+	"lte": "<=",
+	"gt":  ">",
+	"gte": ">=",
+}
+
+// sqlEscape is the escape character used in LIKE patterns.
+const sqlEscape = "!"
+
+var likeEscaper = strings.NewReplacer(sqlEscape, sqlEscape+sqlEscape, "%", sqlEscape+"%", "_", sqlEscape+"_")
+
+// globEscaper escapes the metacharacters of SQLite GLOB patterns, which have no
+// escape character, by wrapping them in character classes.
+var globEscaper = strings.NewReplacer("*", "[*]", "?", "[?]", "[", "[[]")
+
+type sqlWriter struct {
+	target Target
+	buf    strings.Builder
+	args   []interface{}
+	errs   Errors
+}
+
+func translateSQL(target Target, c cond) (*Result, error) {
+	w := &sqlWriter{target: target}
+	w.write(c)
+	if len(w.errs) > 0 {
+		return nil, w.errs
+	}
+	return &Result{Query: w.buf.String(), Args: w.args}, nil
+}
+
+func (w *sqlWriter) write(c cond) {
+	switch c := c.(type) {
+	case constant:
+		if c {
+			w.buf.WriteString("TRUE")
+		} else {
+			w.buf.WriteString("FALSE")
+		}
+	case and:
+		w.join(" AND ", c)
+	case or:
+		w.join(" OR ", c)
+	case not:
+		// Conditions over NULL columns are neither true nor false; negations
+		// of them hold like negations of undefined expressions do in Rego.
+		w.buf.WriteString("(")
+		w.write(c.c)
+		w.buf.WriteString(") IS NOT TRUE")
+	case compare:
+		w.column(c.col)
+		if c.value == nil {
+			if c.op == "eq" {
+				w.buf.WriteString(" IS NULL")
+				return
+			} else if c.op == "neq" {
+				w.buf.WriteString(" IS NOT NULL")
+				return
+			}
+		}
+		w.buf.WriteString(" " + sqlOperators[c.op] + " ")
+		w.param(c.value)
+	case compareColumns:
+		w.column(c.left)
+		w.buf.WriteString(" " + sqlOperators[c.op] + " ")
+		w.column(c.right)
+	case in:
+		if len(c.values) == 0 {
+			w.buf.WriteString("FALSE")
+			return
+		}
+		w.column(c.col)
+		w.buf.WriteString(" IN (")
+		for i, v := range c.values {
+			if i > 0 {
+				w.buf.WriteString(", ")
+			}
+			w.param(v)
+		}
+		w.buf.WriteString(")")
+	case like:
+		w.like(c)
+	}
+}
+
+// like writes a case-sensitive pattern match: LIKE is case-sensitive on
+// PostgreSQL only, so SQLite uses GLOB and MySQL compares binary strings.
+func (w *sqlWriter) like(c like) {
+	if w.target == TargetSQLite {
+		pattern := globEscaper.Replace(c.value)
+		switch c.kind {
+		case "prefix":
+			pattern = pattern + "*"
+		case "suffix":
+			pattern = "*" + pattern
+		default:
+			pattern = "*" + pattern + "*"
+		}
+		w.column(c.col)
+		w.buf.WriteString(" GLOB ")
+		w.param(pattern)
+		return
+	}
+
+	pattern := likeEscaper.Replace(c.value)
+	switch c.kind {
+	case "prefix":
+		pattern = pattern + "%"
+	case "suffix":
+		pattern = "%" + pattern
+	default:
+		pattern = "%" + pattern + "%"
+	}
+	w.column(c.col)
+	w.buf.WriteString(" LIKE ")
+	if w.target == TargetMySQL {
+		w.buf.WriteString("CAST(")
+		w.param(pattern)
+		w.buf.WriteString(" AS BINARY)")
+	} else {
+		w.param(pattern)
+	}
+	w.buf.WriteString(" ESCAPE '" + sqlEscape + "'")
+}
+
+func (w *sqlWriter) join(sep string, cs []cond) {
+	for i, c := range cs {
+		if i > 0 {
+			w.buf.WriteString(sep)
+		}
+		switch c.(type) {
+		case and, or:
+			w.buf.WriteString("(")
+			w.write(c)
+			w.buf.WriteString(")")
+		default:
+			w.write(c)
+		}
+	}
+}
+
+func (w *sqlWriter) column(col column) {
+	if len(col.path) > 1 {
+		w.errs = append(w.errs, &Error{
+			Message:  fmt.Sprintf("nested field %v of table %v cannot be translated to a column", strings.Join(col.path, "."), col.table),
+			Location: col.loc,
+		})
+		return
+	}
+	w.buf.WriteString(w.quote(col.table) + "." + w.quote(col.path[0]))
+}
+
+func (w *sqlWriter) quote(s string) string {
+	if w.target == TargetMySQL {
+		return "`" + strings.ReplaceAll(s, "`", "``") + "`"
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+func (w *sqlWriter) param(v interface{}) {
+	w.args = append(w.args, v)
+	if w.target == TargetPostgres {
+		fmt.Fprintf(&w.buf, "$%d", len(w.args))
+		return
+	}
+	w.buf.WriteString("?")
+}
@@ -0,0 +1,389 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+// Package lint implements style and correctness checks over Rego modules.
+//
+// Built-in rules are implemented in Go on top of the ast package's walkers.
+// Custom rules may be supplied as Rego modules: each module declared under
+// the data.lint.rules namespace is evaluated once per linted module with the
+// parsed AST of that module (as produced by rego.parse_module, but including
+// locations) bound to input. Violations are reported through a partial set
+// rule named report, e.g.
+//
+//	package lint.rules.no_admin
+//
+//	import rego.v1
+//
+//	report contains violation if {
+//		some rule in input.rules
+//		rule.head.ref[0].value == "admin"
+//		violation := {"message": "admin rules are forbidden", "location": rule.location}
+//	}
+package lint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	astJSON "github.com/open-policy-agent/opa/ast/json"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// LintErr indicates a lint rule has been violated.
+const LintErr = "rego_lint_error"
+
+// CustomRulesRoot is the path under which custom lint rules written in Rego
+// must be declared.
+var CustomRulesRoot = ast.MustParseRef("data.lint.rules")
+
+const ignoreDirective = "lint:ignore"
+
+// Violation represents a single lint rule violation.
+type Violation struct {
+	Rule     string        `json:"rule"`
+	Category string        `json:"category"`
+	Message  string        `json:"message"`
+	Location *ast.Location `json:"location,omitempty"`
+}
+
+func (v *Violation) Error() string {
+	return v.toError().Error()
+}
+
+func (v *Violation) toError() *ast.Error {
+	return &ast.Error{
+		Code:     LintErr,
+		Message:  v.Message,
+		Location: v.Location,
+		Details:  &violationDetails{Rule: v.Rule, Category: v.Category},
+	}
+}
+
+// violationDetails carries the rule information of a violation when it is
+// presented as an ast.Error.
+type violationDetails struct {
+	Rule     string `json:"rule"`
+	Category string `json:"category"`
+}
+
+func (d *violationDetails) Lines() []string {
+	return []string{fmt.Sprintf("rule: %v/%v", d.Category, d.Rule)}
+}
+
+// Report contains the violations found by a Linter.
+type Report struct {
+	Violations []*Violation `json:"violations,omitempty"`
+}
+
+// Errors returns the violations in r as AST errors so that they can be
+// presented in the same way as errors returned by the compiler.
+func (r Report) Errors() ast.Errors {
+	errs := make(ast.Errors, len(r.Violations))
+	for i := range r.Violations {
+		errs[i] = r.Violations[i].toError()
+	}
+	return errs
+}
+
+// Rule represents a built-in lint rule.
+type Rule struct {
+	Name        string
+	Category    string
+	Description string
+	Check       func(*ast.Module) []*Violation
+}
+
+// Linter runs a set of lint rules over Rego modules.
+type Linter struct {
+	sources      map[string][]byte
+	customRules  map[string]*ast.Module
+	compiler     *ast.Compiler
+	rules        []*Rule
+	disabled     map[string]struct{}
+	regoVersion  ast.RegoVersion
+	capabilities *ast.Capabilities
+}
+
+// New returns a new Linter that runs the built-in rules.
+func New() *Linter {
+	return &Linter{
+		rules:    BuiltinRules(),
+		disabled: map[string]struct{}{},
+	}
+}
+
+// WithSources sets the Rego source files to lint, keyed by file name.
+func (l *Linter) WithSources(sources map[string][]byte) *Linter {
+	l.sources = sources
+	return l
+}
+
+// WithCustomRules sets the Rego modules that implement custom lint rules.
+// Custom rules must be declared under the CustomRulesRoot namespace.
+func (l *Linter) WithCustomRules(modules map[string]*ast.Module) *Linter {
+	l.customRules = modules
+	return l
+}
+
+// WithRules replaces the set of built-in rules run by the Linter.
+func (l *Linter) WithRules(rules []*Rule) *Linter {
+	l.rules = rules
+	return l
+}
+
+// WithDisabledRules disables rules by name. Both built-in and custom rules
+// can be disabled.
+func (l *Linter) WithDisabledRules(names []string) *Linter {
+	for _, name := range names {
+		l.disabled[name] = struct{}{}
+	}
+	return l
+}
+
+// WithRegoVersion sets the Rego version used to parse the linted sources.
+func (l *Linter) WithRegoVersion(v ast.RegoVersion) *Linter {
+	l.regoVersion = v
+	return l
+}
+
+// WithCapabilities sets the capabilities used to parse the linted sources.
+func (l *Linter) WithCapabilities(c *ast.Capabilities) *Linter {
+	l.capabilities = c
+	return l
+}
+
+// Lint parses the sources and runs all enabled rules over them. Violations
+// suppressed by a lint:ignore comment are not included in the report.
+func (l *Linter) Lint(ctx context.Context) (Report, error) {
+
+	var report Report
+
+	if len(l.customRules) > 0 {
+		l.compiler = ast.NewCompiler().WithCapabilities(l.capabilities)
+		if l.compiler.Compile(l.customRules); l.compiler.Failed() {
+			return Report{}, l.compiler.Errors
+		}
+	}
+
+	names := make([]string, 0, len(l.sources))
+	for name := range l.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		module, err := ast.ParseModuleWithOpts(name, string(l.sources[name]), ast.ParserOptions{
+			ProcessAnnotation: true,
+			RegoVersion:       l.regoVersion,
+			Capabilities:      l.capabilities,
+			JSONOptions:       &inputJSONOptions,
+		})
+		if err != nil {
+			return Report{}, err
+		}
+		if module == nil {
+			continue
+		}
+
+		var violations []*Violation
+
+		for _, rule := range l.rules {
+			if l.isDisabled(rule.Name) {
+				continue
+			}
+			for _, v := range rule.Check(module) {
+				v.Rule = rule.Name
+				v.Category = rule.Category
+				violations = append(violations, v)
+			}
+		}
+
+		custom, err := l.evalCustomRules(ctx, module)
+		if err != nil {
+			return Report{}, err
+		}
+		violations = append(violations, custom...)
+
+		report.Violations = append(report.Violations, filterIgnored(module, violations)...)
+	}
+
+	sort.SliceStable(report.Violations, func(i, j int) bool {
+		if cmp := report.Violations[i].Location.Compare(report.Violations[j].Location); cmp != 0 {
+			return cmp < 0
+		}
+		return report.Violations[i].Rule < report.Violations[j].Rule
+	})
+
+	return report, nil
+}
+
+func (l *Linter) isDisabled(name string) bool {
+	_, ok := l.disabled[name]
+	return ok
+}
+
+// inputJSONOptions makes the AST passed to custom rules carry the locations
+// of the nodes so that rules can report where violations occurred.
+var inputJSONOptions = astJSON.Options{
+	MarshalOptions: astJSON.MarshalOptions{
+		IncludeLocation: astJSON.NodeToggle{
+			Term:     true,
+			Package:  true,
+			Comment:  true,
+			Import:   true,
+			Rule:     true,
+			Head:     true,
+			Expr:     true,
+			SomeDecl: true,
+			Every:    true,
+			With:     true,
+		},
+	},
+}
+
+func (l *Linter) evalCustomRules(ctx context.Context, module *ast.Module) ([]*Violation, error) {
+
+	if l.compiler == nil {
+		return nil, nil
+	}
+
+	bs, err := json.Marshal(module)
+	if err != nil {
+		return nil, err
+	}
+
+	var input interface{}
+	if err := util.UnmarshalJSON(bs, &input); err != nil {
+		return nil, err
+	}
+
+	rs, err := rego.New(
+		rego.Query(CustomRulesRoot.String()+"[name].report[violation]"),
+		rego.Compiler(l.compiler),
+		rego.Input(input),
+	).Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []*Violation
+
+	for _, r := range rs {
+		name, ok := r.Bindings["name"].(string)
+		if !ok {
+			continue
+		}
+		if l.isDisabled(name) {
+			continue
+		}
+		v, err := customViolation(name, module, r.Bindings["violation"])
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v)
+	}
+
+	return violations, nil
+}
+
+func customViolation(name string, module *ast.Module, x interface{}) (*Violation, error) {
+
+	obj, ok := x.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("lint rule %v: violation must be an object, got %T", name, x)
+	}
+
+	msg, ok := obj["message"].(string)
+	if !ok {
+		return nil, fmt.Errorf("lint rule %v: violation must have a string message", name)
+	}
+
+	v := &Violation{
+		Rule:     name,
+		Category: "custom",
+		Message:  msg,
+		Location: module.Package.Location,
+	}
+
+	if c, ok := obj["category"].(string); ok {
+		v.Category = c
+	}
+
+	if loc, ok := obj["location"].(map[string]interface{}); ok {
+		v.Location = &ast.Location{File: module.Package.Location.File}
+		if row, ok := jsonInt(loc["row"]); ok {
+			v.Location.Row = row
+		}
+		if col, ok := jsonInt(loc["col"]); ok {
+			v.Location.Col = col
+		}
+	}
+
+	return v, nil
+}
+
+func jsonInt(x interface{}) (int, bool) {
+	switch x := x.(type) {
+	case int:
+		return x, true
+	case float64:
+		return int(x), true
+	case interface{ Int64() (int64, error) }:
+		i, err := x.Int64()
+		return int(i), err == nil
+	}
+	return 0, false
+}
+
+// filterIgnored removes violations suppressed by lint:ignore comments. A
+// comment applies to the line it appears on and the line that follows it. It
+// may list the rules to suppress, separated by commas or spaces; if no rules
+// are listed, all violations are suppressed.
+func filterIgnored(module *ast.Module, violations []*Violation) []*Violation {
+
+	ignored := map[int][]string{}
+
+	for _, c := range module.Comments {
+		text := strings.TrimSpace(string(c.Text))
+		if !strings.HasPrefix(text, ignoreDirective) {
+			continue
+		}
+		rules := strings.FieldsFunc(strings.TrimPrefix(text, ignoreDirective), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(rules) == 0 {
+			rules = []string{"*"}
+		}
+		ignored[c.Location.Row] = append(ignored[c.Location.Row], rules...)
+		ignored[c.Location.Row+1] = append(ignored[c.Location.Row+1], rules...)
+	}
+
+	if len(ignored) == 0 {
+		return violations
+	}
+
+	result := violations[:0]
+
+	for _, v := range violations {
+		if v.Location == nil || !isIgnored(ignored[v.Location.Row], v.Rule) {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func isIgnored(rules []string, name string) bool {
+	for _, r := range rules {
+		if r == "*" || r == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+type violation struct {
+	rule string
+	row  int
+}
+
+func TestBuiltinRules(t *testing.T) {
+
+	tests := []struct {
+		note   string
+		module string
+		exp    []violation
+	}{
+		{
+			note: "constant condition",
+			module: `package test
+
+p {
+	1 == 1
+	input.x
+}
+
+q {
+	true
+}
+
+r := 1
+
+default s := false`,
+			exp: []violation{{"constant-condition", 4}, {"constant-condition", 9}},
+		},
+		{
+			note: "snake case",
+			module: `package test
+
+fooBar := 1
+
+foo_bar.bazQux := 2
+
+f(x) = x`,
+			exp: []violation{{"prefer-snake-case", 3}, {"prefer-snake-case", 5}},
+		},
+		{
+			note: "print and trace",
+			module: `package test
+
+p {
+	print(input.x)
+	trace("x")
+}`,
+			exp: []violation{{"print-or-trace-call", 4}, {"print-or-trace-call", 5}},
+		},
+		{
+			note: "unused import",
+			module: `package test
+
+import future.keywords.if
+import data.foo
+import data.bar as baz
+import input.qux
+
+p if foo.x
+q if qux`,
+			exp: []violation{{"unused-import", 5}},
+		},
+		{
+			note: "ignore comments",
+			module: `package test
+
+# lint:ignore prefer-snake-case
+fooBar := 1
+
+barBaz := 2 # lint:ignore
+
+# lint:ignore constant-condition
+quxCorge := 3`,
+			exp: []violation{{"prefer-snake-case", 9}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			report, err := New().WithSources(map[string][]byte{"test.rego": []byte(tc.module)}).Lint(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertViolations(t, tc.exp, report)
+		})
+	}
+}
+
+func TestDisabledRules(t *testing.T) {
+	module := `package test
+
+fooBar {
+	print("x")
+}`
+
+	report, err := New().
+		WithSources(map[string][]byte{"test.rego": []byte(module)}).
+		WithDisabledRules([]string{"prefer-snake-case"}).
+		Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertViolations(t, []violation{{"print-or-trace-call", 4}}, report)
+}
+
+func TestCustomRules(t *testing.T) {
+	custom := ast.MustParseModuleWithOpts(`package lint.rules.no_admin
+
+import rego.v1
+
+report contains violation if {
+	some rule in input.rules
+	rule.head.ref[0].value == "admin"
+	violation := {"message": "admin rules are forbidden", "location": rule.location}
+}`, ast.ParserOptions{})
+
+	module := `package test
+
+user := true
+
+admin := true # lint:ignore prefer-snake-case
+
+# lint:ignore no_admin
+admin := false`
+
+	report, err := New().
+		WithSources(map[string][]byte{"test.rego": []byte(module)}).
+		WithCustomRules(map[string]*ast.Module{"no_admin.rego": custom}).
+		Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertViolations(t, []violation{{"no_admin", 5}}, report)
+
+	v := report.Violations[0]
+	if v.Category != "custom" || v.Message != "admin rules are forbidden" || v.Location.File != "test.rego" {
+		t.Fatalf("unexpected violation: %+v", v)
+	}
+}
+
+func TestCustomRulesInvalidViolation(t *testing.T) {
+	custom := ast.MustParseModuleWithOpts(`package lint.rules.bad
+
+import rego.v1
+
+report contains "not an object"`, ast.ParserOptions{})
+
+	_, err := New().
+		WithSources(map[string][]byte{"test.rego": []byte(`package test`)}).
+		WithCustomRules(map[string]*ast.Module{"bad.rego": custom}).
+		Lint(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "violation must be an object") {
+		t.Fatalf("expected error but got: %v", err)
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	report, err := New().WithSources(map[string][]byte{"test.rego": []byte(`package test
+
+fooBar := 1`)}).Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `1 error occurred: test.rego:3: rego_lint_error: rule name "fooBar" should be snake_case
+	rule: style/prefer-snake-case`
+
+	if act := report.Errors().Error(); act != exp {
+		t.Fatalf("expected:\n\n%v\n\ngot:\n\n%v", exp, act)
+	}
+}
+
+func TestReportSARIF(t *testing.T) {
+	report, err := New().WithSources(map[string][]byte{"test.rego": []byte(`package test
+
+fooBar := 1`)}).Lint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := report.SARIF(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("unexpected log: %v", buf.String())
+	}
+
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 1 || run.Tool.Driver.Rules[0].ID != "prefer-snake-case" {
+		t.Fatalf("unexpected rules: %+v", run.Tool.Driver.Rules)
+	}
+
+	if len(run.Results) != 1 {
+		t.Fatalf("unexpected results: %+v", run.Results)
+	}
+
+	loc := run.Results[0].Locations[0].PhysicalLocation
+	if loc.ArtifactLocation.URI != "test.rego" || loc.Region.StartLine != 3 {
+		t.Fatalf("unexpected location: %+v", loc)
+	}
+}
+
+func assertViolations(t *testing.T, exp []violation, report Report) {
+	t.Helper()
+
+	var act []violation
+	for _, v := range report.Violations {
+		act = append(act, violation{v.Rule, v.Location.Row})
+	}
+
+	if !reflect.DeepEqual(exp, act) {
+		t.Fatalf("expected violations %v but got %v", exp, act)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// BuiltinRules returns the set of lint rules that ship with OPA.
+func BuiltinRules() []*Rule {
+	return []*Rule{
+		{
+			Name:        "constant-condition",
+			Category:    "bugs",
+			Description: "Condition always evaluates to the same value",
+			Check:       checkConstantCondition,
+		},
+		{
+			Name:        "prefer-snake-case",
+			Category:    "style",
+			Description: "Rule and function names should be snake_case",
+			Check:       checkSnakeCase,
+		},
+		{
+			Name:        "print-or-trace-call",
+			Category:    "testing",
+			Description: "Calls to print and trace should not be left in production policy",
+			Check:       checkPrintOrTrace,
+		},
+		{
+			Name:        "unused-import",
+			Category:    "imports",
+			Description: "Imported reference is never used",
+			Check:       checkUnusedImport,
+		},
+	}
+}
+
+var comparisonOperators = []*ast.Builtin{
+	ast.Equal,
+	ast.Equality,
+	ast.NotEqual,
+	ast.LessThan,
+	ast.LessThanEq,
+	ast.GreaterThan,
+	ast.GreaterThanEq,
+}
+
+func checkConstantCondition(module *ast.Module) []*Violation {
+
+	var violations []*Violation
+
+	ast.WalkRules(module, func(rule *ast.Rule) bool {
+		ast.WalkExprs(rule.Body, func(expr *ast.Expr) bool {
+			if expr.Generated || isImplicitBody(rule, expr) {
+				return false
+			}
+			switch terms := expr.Terms.(type) {
+			case *ast.Term:
+				if ast.IsScalar(terms.Value) {
+					violations = append(violations, &Violation{
+						Message:  fmt.Sprintf("expression %v is always %v", expr, terms),
+						Location: expr.Location,
+					})
+				}
+			case []*ast.Term:
+				if !isComparison(expr) {
+					return false
+				}
+				for _, operand := range terms[1:] {
+					if !ast.IsScalar(operand.Value) {
+						return false
+					}
+				}
+				violations = append(violations, &Violation{
+					Message:  fmt.Sprintf("expression %v compares constant values", expr),
+					Location: expr.Location,
+				})
+			}
+			return false
+		})
+		return false
+	})
+
+	return violations
+}
+
+// isImplicitBody returns true if expr is the body the parser generates for
+// rules declared without one, e.g. `p := 1` or `default p := false`. The
+// parser locates generated bodies at the rule, its head or its value.
+func isImplicitBody(rule *ast.Rule, expr *ast.Expr) bool {
+	t, ok := expr.Terms.(*ast.Term)
+	if !ok || t.Value.Compare(ast.Boolean(true)) != 0 {
+		return false
+	}
+	if expr.Location == nil {
+		return true
+	}
+	locs := []*ast.Location{rule.Location, rule.Head.Location}
+	if rule.Head.Value != nil {
+		locs = append(locs, rule.Head.Value.Location)
+	}
+	for _, loc := range locs {
+		if loc != nil && loc.Row == expr.Location.Row && loc.Col == expr.Location.Col {
+			return true
+		}
+	}
+	return false
+}
+
+func isComparison(expr *ast.Expr) bool {
+	op := expr.Operator()
+	for _, bi := range comparisonOperators {
+		if op.Equal(bi.Ref()) {
+			return true
+		}
+	}
+	return false
+}
+
+var snakeCase = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func checkSnakeCase(module *ast.Module) []*Violation {
+
+	var violations []*Violation
+
+	ast.WalkRules(module, func(rule *ast.Rule) bool {
+		for _, t := range rule.Head.Ref() {
+			var name string
+			switch v := t.Value.(type) {
+			case ast.Var:
+				if v.IsGenerated() || v.IsWildcard() {
+					continue
+				}
+				name = string(v)
+			case ast.String:
+				name = string(v)
+			default:
+				continue
+			}
+			if !snakeCase.MatchString(name) {
+				violations = append(violations, &Violation{
+					Message:  fmt.Sprintf("rule name %q should be snake_case", name),
+					Location: rule.Head.Location,
+				})
+			}
+		}
+		return false
+	})
+
+	return violations
+}
+
+func checkPrintOrTrace(module *ast.Module) []*Violation {
+
+	var violations []*Violation
+
+	ast.WalkExprs(module, func(expr *ast.Expr) bool {
+		if !expr.IsCall() {
+			return false
+		}
+		op := expr.Operator()
+		if op.Equal(ast.Print.Ref()) || op.Equal(ast.Trace.Ref()) {
+			violations = append(violations, &Violation{
+				Message:  fmt.Sprintf("call to %v found", op),
+				Location: expr.Location,
+			})
+		}
+		return false
+	})
+
+	return violations
+}
+
+func checkUnusedImport(module *ast.Module) []*Violation {
+
+	used := ast.NewVarSet()
+
+	vis := ast.NewGenericVisitor(func(x interface{}) bool {
+		if v, ok := x.(ast.Var); ok {
+			used.Add(v)
+		}
+		return false
+	})
+
+	for _, rule := range module.Rules {
+		vis.Walk(rule)
+	}
+
+	var violations []*Violation
+
+	for _, imp := range module.Imports {
+		path := imp.Path.Value.(ast.Ref)
+		if ast.FutureRootDocument.Equal(path[0]) || ast.RegoRootDocument.Equal(path[0]) {
+			continue
+		}
+		if !used.Contains(imp.Name()) {
+			violations = append(violations, &Violation{
+				Message:  fmt.Sprintf("import %v is never used", imp.Path),
+				Location: imp.Location,
+			})
+		}
+	}
+
+	return violations
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package lint
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/open-policy-agent/opa/version"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	Properties       struct {
+		Category string `json:"category"`
+	} `json:"properties"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	RuleIndex int             `json:"ruleIndex"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		Region struct {
+			StartLine   int `json:"startLine"`
+			StartColumn int `json:"startColumn,omitempty"`
+		} `json:"region"`
+	} `json:"physicalLocation"`
+}
+
+// SARIF writes r to w in the SARIF 2.1.0 format understood by code scanning
+// tools.
+func (r Report) SARIF(w io.Writer) error {
+
+	descriptions := map[string]string{}
+	for _, rule := range BuiltinRules() {
+		descriptions[rule.Name] = rule.Description
+	}
+
+	categories := map[string]string{}
+	for _, v := range r.Violations {
+		categories[v.Rule] = v.Category
+	}
+
+	ids := make([]string, 0, len(categories))
+	for id := range categories {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "opa lint",
+			Version:        version.Version,
+			InformationURI: "https://www.openpolicyagent.org/docs/latest/cli/",
+			Rules:          make([]sarifRule, len(ids)),
+		}},
+		Results: make([]sarifResult, len(r.Violations)),
+	}
+
+	index := map[string]int{}
+	for i, id := range ids {
+		index[id] = i
+		rule := sarifRule{ID: id, ShortDescription: sarifMessage{Text: descriptions[id]}}
+		if rule.ShortDescription.Text == "" {
+			rule.ShortDescription.Text = id
+		}
+		rule.Properties.Category = categories[id]
+		run.Tool.Driver.Rules[i] = rule
+	}
+
+	for i, v := range r.Violations {
+		result := sarifResult{
+			RuleID:    v.Rule,
+			RuleIndex: index[v.Rule],
+			Level:     "error",
+			Message:   sarifMessage{Text: v.Message},
+		}
+		if v.Location != nil {
+			var loc sarifLocation
+			loc.PhysicalLocation.ArtifactLocation.URI = v.Location.File
+			loc.PhysicalLocation.Region.StartLine = v.Location.Row
+			loc.PhysicalLocation.Region.StartColumn = v.Location.Col
+			result.Locations = []sarifLocation{loc}
+		}
+		run.Results[i] = result
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs:    []sarifRun{run},
+	})
+}
@@ -0,0 +1,76 @@
+package limits
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// Config represents the configuration for the Server.EvalLimits settings.
+// Zero values mean no limit.
+type Config struct {
+	MaxSteps         int64 `json:"max_steps,omitempty"`           // the maximum number of expressions evaluated per query
+	MaxTermBytes     int64 `json:"max_term_bytes,omitempty"`      // the maximum size of terms produced by built-in functions per query
+	MaxResultBytes   int64 `json:"max_result_bytes,omitempty"`    // the maximum size of the results of a query
+	MaxHTTPSendCalls int64 `json:"max_http_send_calls,omitempty"` // the maximum number of http.send calls per query
+}
+
+// ConfigBuilder assists in the construction of the plugin configuration.
+type ConfigBuilder struct {
+	raw []byte
+}
+
+// NewConfigBuilder returns a new ConfigBuilder to build and parse the server config
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+// WithBytes sets the raw server config
+func (b *ConfigBuilder) WithBytes(config []byte) *ConfigBuilder {
+	b.raw = config
+	return b
+}
+
+// Parse returns a valid Config object.
+func (b *ConfigBuilder) Parse() (*Config, error) {
+	var result Config
+
+	if b.raw == nil {
+		return &result, nil
+	}
+
+	if err := util.Unmarshal(b.raw, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, result.validate()
+}
+
+// EvalLimits returns the limits to evaluate queries with.
+func (c *Config) EvalLimits() topdown.EvalLimits {
+	return topdown.EvalLimits{
+		MaxSteps:         c.MaxSteps,
+		MaxTermBytes:     c.MaxTermBytes,
+		MaxResultBytes:   c.MaxResultBytes,
+		MaxHTTPSendCalls: c.MaxHTTPSendCalls,
+	}
+}
+
+func (c *Config) validate() error {
+	fields := []struct {
+		name  string
+		value int64
+	}{
+		{"max_steps", c.MaxSteps},
+		{"max_term_bytes", c.MaxTermBytes},
+		{"max_result_bytes", c.MaxResultBytes},
+		{"max_http_send_calls", c.MaxHTTPSendCalls},
+	}
+	for _, f := range fields {
+		if f.value < 0 {
+			return fmt.Errorf("invalid value for server.eval_limits.%s field, should be a non-negative number", f.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package limits
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{
+			input:   `{}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"max_steps": "not-a-number"}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"max_steps": -1}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"max_http_send_calls": -1}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"max_steps": 0, "max_term_bytes": 1024}`,
+			wantErr: false,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigValidation_case_%d", i), func(t *testing.T) {
+			_, err := NewConfigBuilder().WithBytes([]byte(test.input)).Parse()
+			if err != nil && !test.wantErr {
+				t.Fail()
+			}
+			if err == nil && test.wantErr {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected topdown.EvalLimits
+	}{
+		{
+			input: `{}`,
+		},
+		{
+			input:    `{"max_steps": 1000, "max_term_bytes": 2000, "max_result_bytes": 3000, "max_http_send_calls": 4}`,
+			expected: topdown.EvalLimits{MaxSteps: 1000, MaxTermBytes: 2000, MaxResultBytes: 3000, MaxHTTPSendCalls: 4},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigValue_case_%d", i), func(t *testing.T) {
+			config, err := NewConfigBuilder().WithBytes([]byte(test.input)).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.EvalLimits() != test.expected {
+				t.Fatalf("expected %+v but got %+v", test.expected, config.EvalLimits())
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package profiling
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-policy-agent/opa/util"
+)
+
+var defaultWindowSeconds = 300
+
+// Config represents the configuration for the Server.Profiling settings.
+type Config struct {
+	SampleRate    float64 `json:"sample_rate,omitempty"`    // the fraction of decisions evaluated with the profiler
+	WindowSeconds *int    `json:"window_seconds,omitempty"` // the duration of the window the profiles are aggregated over
+}
+
+// ConfigBuilder assists in the construction of the plugin configuration.
+type ConfigBuilder struct {
+	raw []byte
+}
+
+// NewConfigBuilder returns a new ConfigBuilder to build and parse the server config
+func NewConfigBuilder() *ConfigBuilder {
+	return &ConfigBuilder{}
+}
+
+// WithBytes sets the raw server config
+func (b *ConfigBuilder) WithBytes(config []byte) *ConfigBuilder {
+	b.raw = config
+	return b
+}
+
+// Parse returns a valid Config object with defaults injected.
+func (b *ConfigBuilder) Parse() (*Config, error) {
+	var result Config
+
+	if b.raw != nil {
+		if err := util.Unmarshal(b.raw, &result); err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, result.validateAndInjectDefaults()
+}
+
+// Enabled returns true if decisions are sampled.
+func (c *Config) Enabled() bool {
+	return c.SampleRate > 0
+}
+
+// Window returns the duration of the window the profiles are aggregated over.
+func (c *Config) Window() time.Duration {
+	return time.Duration(*c.WindowSeconds) * time.Second
+}
+
+func (c *Config) validateAndInjectDefaults() error {
+	if c.WindowSeconds == nil {
+		c.WindowSeconds = &defaultWindowSeconds
+	}
+
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("invalid value for server.profiling.sample_rate field, should be a number between 0 and 1")
+	}
+
+	if *c.WindowSeconds <= 0 {
+		return fmt.Errorf("invalid value for server.profiling.window_seconds field, should be a positive number")
+	}
+
+	return nil
+}
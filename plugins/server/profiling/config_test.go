@@ -0,0 +1,84 @@
+package profiling
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestConfigValidation(t *testing.T) {
+	tests := []struct {
+		input   string
+		wantErr bool
+	}{
+		{
+			input:   `{}`,
+			wantErr: false,
+		},
+		{
+			input:   `{"sample_rate": "not-a-number"}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"sample_rate": -0.1}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"sample_rate": 1.5}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"window_seconds": 0}`,
+			wantErr: true,
+		},
+		{
+			input:   `{"sample_rate": 0.01, "window_seconds": 60}`,
+			wantErr: false,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigValidation_case_%d", i), func(t *testing.T) {
+			_, err := NewConfigBuilder().WithBytes([]byte(test.input)).Parse()
+			if err != nil && !test.wantErr {
+				t.Fail()
+			}
+			if err == nil && test.wantErr {
+				t.Fail()
+			}
+		})
+	}
+}
+
+func TestConfigValue(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedRate   float64
+		expectedWindow time.Duration
+	}{
+		{
+			input:          `{}`,
+			expectedWindow: 5 * time.Minute,
+		},
+		{
+			input:          `{"sample_rate": 0.25, "window_seconds": 60}`,
+			expectedRate:   0.25,
+			expectedWindow: time.Minute,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("TestConfigValue_case_%d", i), func(t *testing.T) {
+			config, err := NewConfigBuilder().WithBytes([]byte(test.input)).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.SampleRate != test.expectedRate || config.Window() != test.expectedWindow {
+				t.Fatalf("expected %v and %v but got %v and %v", test.expectedRate, test.expectedWindow, config.SampleRate, config.Window())
+			}
+			if config.Enabled() != (test.expectedRate > 0) {
+				t.Fatalf("expected enabled to be %v", test.expectedRate > 0)
+			}
+		})
+	}
+}
@@ -22,6 +22,9 @@ type Profiler struct {
 	prevExpr        exprInfo
 }
 
+// unknownLocation is the location of expressions without a location.
+var unknownLocation = ast.NewLocation([]byte("???"), "", 0, 0)
+
 // exprInfo stores information about an expression.
 type exprInfo struct {
 	index    int
@@ -150,19 +153,20 @@ func (p *Profiler) TraceEvent(event topdown.Event) {
 	switch event.Op {
 	case topdown.EvalOp:
 		if expr, ok := event.Node.(*ast.Expr); ok && expr != nil {
-			p.processExpr(expr, event.Op)
+			p.processExpr(expr, event.SourceLocation(), event.Op)
 		}
 	case topdown.RedoOp:
 		if expr, ok := event.Node.(*ast.Expr); ok && expr != nil {
-			p.processExpr(expr, event.Op)
+			p.processExpr(expr, event.SourceLocation(), event.Op)
 		}
 	}
 }
 
-func (p *Profiler) processExpr(expr *ast.Expr, eventType topdown.Op) {
-	if expr.Location == nil {
-		// add fake location to group expressions without a location
-		expr.Location = ast.NewLocation([]byte("???"), "", 0, 0)
+func (p *Profiler) processExpr(expr *ast.Expr, location *ast.Location, eventType topdown.Op) {
+	if location == nil {
+		// use a fake location to group expressions without a location; the
+		// expression is not updated as it may be evaluated concurrently
+		location = unknownLocation
 	}
 
 	// set the active timer on the first expression
@@ -170,7 +174,7 @@ func (p *Profiler) processExpr(expr *ast.Expr, eventType topdown.Op) {
 		p.activeTimer = time.Now()
 		p.prevExpr = exprInfo{
 			op:       eventType,
-			location: expr.Location,
+			location: location,
 			index:    expr.Index,
 		}
 		return
@@ -207,7 +211,7 @@ func (p *Profiler) processExpr(expr *ast.Expr, eventType topdown.Op) {
 	p.activeTimer = time.Now()
 	p.prevExpr = exprInfo{
 		op:       eventType,
-		location: expr.Location,
+		location: location,
 		index:    expr.Index,
 	}
 }
@@ -217,7 +221,7 @@ func (p *Profiler) processLastExpr() {
 		Location: p.prevExpr.location,
 		Index:    p.prevExpr.index,
 	}
-	p.processExpr(&expr, p.prevExpr.op)
+	p.processExpr(&expr, expr.Location, p.prevExpr.op)
 }
 
 func (p *Profiler) calculateHitsByExprIndex() {
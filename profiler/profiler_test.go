@@ -511,3 +511,50 @@ func TestProfilerTraceConfig(t *testing.T) {
 		t.Fatalf("Expected config: %+v, got %+v", expected, conf)
 	}
 }
+
+func TestProfilerSourceMapLocations(t *testing.T) {
+	module, err := ast.ParseModule("test.rego", `package test
+
+p {
+	x := count(
+		[y | y := input.xs[_]])
+	x > 1
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := ast.NewCompiler()
+	c.Compile(map[string]*ast.Module{"test.rego": module})
+	if c.Failed() {
+		t.Fatal(c.Errors)
+	}
+
+	profiler := New()
+	_, err = rego.New(
+		rego.Compiler(c),
+		rego.Query("data.test.p"),
+		rego.Input(map[string]interface{}{"xs": []interface{}{1, 2}}),
+		rego.QueryTracer(profiler),
+	).Eval(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stat := range profiler.ReportTopNResults(0, nil) {
+		if stat.Location == unknownLocation {
+			t.Fatalf("Expected all expressions to be located but got %v", stat)
+		}
+	}
+
+	fr, ok := profiler.ReportByFile().Files["test.rego"]
+	if !ok {
+		t.Fatal("Expected file report for test.rego")
+	}
+	var rows []int
+	for _, stat := range fr.Result {
+		rows = append(rows, stat.Location.Row)
+	}
+	if !reflect.DeepEqual(rows, []int{4, 5, 6}) {
+		t.Fatalf("Expected expressions on rows 4, 5 and 6 but got %v", rows)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"time"
 
@@ -118,6 +119,13 @@ type EvalContext struct {
 	earlyExit              bool
 	interQueryBuiltinCache cache.InterQueryCache
 	ndBuiltinCache         builtins.NDBCache
+	replayNDBCache         bool
+	memoCache              *topdown.MemoCache
+	memoGeneration         *uint64
+	parallelism            int
+	httpSendScheduler      *topdown.HTTPSendScheduler
+	httpSendTransport      http.RoundTripper
+	limits                 topdown.EvalLimits
 	resolvers              []refResolver
 	sortSets               bool
 	copyMaps               bool
@@ -306,6 +314,60 @@ func EvalInterQueryBuiltinCache(c cache.InterQueryCache) EvalOption {
 	}
 }
 
+// EvalMemoCache sets the cache used to memoize the values of rules and functions
+// annotated with "memoize: true" across queries. If not set, the cache passed
+// to MemoCache is used.
+func EvalMemoCache(c *topdown.MemoCache) EvalOption {
+	return func(e *EvalContext) {
+		e.memoCache = c
+	}
+}
+
+// EvalMemoGeneration sets the generation of the memo cache (see
+// topdown.MemoCache.Generation) that was obtained before the transaction passed
+// to EvalTransaction was opened. Values are only memoized if the cache has not
+// been invalidated since. If no transaction is passed, the generation is
+// obtained before the transaction is opened.
+func EvalMemoGeneration(generation uint64) EvalOption {
+	return func(e *EvalContext) {
+		e.memoGeneration = &generation
+	}
+}
+
+// EvalHTTPSendScheduler sets the scheduler used by http.send to coalesce,
+// batch, and limit outbound requests. If not set, the scheduler passed to
+// HTTPSendScheduler is used.
+func EvalHTTPSendScheduler(s *topdown.HTTPSendScheduler) EvalOption {
+	return func(e *EvalContext) {
+		e.httpSendScheduler = s
+	}
+}
+
+// EvalHTTPSendTransport sets the transport http.send sends requests with. If
+// not set, the transport passed to HTTPSendTransport is used.
+func EvalHTTPSendTransport(t http.RoundTripper) EvalOption {
+	return func(e *EvalContext) {
+		e.httpSendTransport = t
+	}
+}
+
+// EvalLimits sets the limits on the resources the evaluation may use. If a
+// limit is exceeded, evaluation fails with a topdown.ResourceLimitErr error.
+// If not set, the limits passed to Limits are used.
+func EvalLimits(l topdown.EvalLimits) EvalOption {
+	return func(e *EvalContext) {
+		e.limits = l
+	}
+}
+
+// EvalParallelism sets the maximum number of rule bodies that may be evaluated
+// concurrently. If not set, the value passed to Parallelism is used.
+func EvalParallelism(n int) EvalOption {
+	return func(e *EvalContext) {
+		e.parallelism = n
+	}
+}
+
 // EvalNDBuiltinCache sets the non-deterministic builtin cache that built-in functions can
 // use during evaluation.
 func EvalNDBuiltinCache(c builtins.NDBCache) EvalOption {
@@ -382,6 +444,13 @@ func (pq preparedQuery) newEvalContext(ctx context.Context, options []EvalOption
 		printHook:           pq.r.printHook,
 		capabilities:        pq.r.capabilities,
 		strictBuiltinErrors: pq.r.strictBuiltinErrors,
+		memoCache:           pq.r.memoCache,
+		memoGeneration:      pq.r.memoGeneration,
+		parallelism:         pq.r.parallelism,
+		httpSendScheduler:   pq.r.httpSendScheduler,
+		httpSendTransport:   pq.r.httpSendTransport,
+		limits:              pq.r.limits,
+		replayNDBCache:      pq.r.replayNDBCache,
 	}
 
 	for _, o := range options {
@@ -406,6 +475,10 @@ func (pq preparedQuery) newEvalContext(ctx context.Context, options []EvalOption
 	}
 
 	if ectx.txn == nil {
+		if ectx.memoCache != nil {
+			generation := ectx.memoCache.Generation()
+			ectx.memoGeneration = &generation
+		}
 		ectx.txn, err = pq.r.store.NewTransaction(ctx)
 		if err != nil {
 			return nil, finishFunc, err
@@ -550,6 +623,7 @@ type Rego struct {
 	parsedUnknowns         []*ast.Term
 	disableInlining        []string
 	shallowInlining        bool
+	strictPartialEval      bool
 	skipPartialNamespace   bool
 	partialNamespace       string
 	modules                []rawModule
@@ -580,6 +654,13 @@ type Rego struct {
 	skipBundleVerification bool
 	interQueryBuiltinCache cache.InterQueryCache
 	ndBuiltinCache         builtins.NDBCache
+	replayNDBCache         bool
+	memoCache              *topdown.MemoCache
+	memoGeneration         *uint64
+	parallelism            int
+	httpSendScheduler      *topdown.HTTPSendScheduler
+	httpSendTransport      http.RoundTripper
+	limits                 topdown.EvalLimits
 	strictBuiltinErrors    bool
 	builtinErrorList       *[]topdown.Error
 	resolvers              []refResolver
@@ -589,6 +670,7 @@ type Rego struct {
 	generateJSON           func(*ast.Term, *EvalContext) (interface{}, error)
 	printHook              print.Hook
 	enablePrintStatements  bool
+	constantFolding        bool
 	distributedTacingOpts  tracing.Options
 	strict                 bool
 	pluginMgr              *plugins.Manager
@@ -894,6 +976,16 @@ func ShallowInlining(yes bool) func(r *Rego) {
 	}
 }
 
+// StrictPartialEval makes partial evaluation fail with a topdown.Errors error
+// listing the constructs it could not expand into flat queries (e.g., every
+// expressions with unknown domains, comprehensions with unknowns or with
+// statements), instead of saving them or generating support modules.
+func StrictPartialEval(yes bool) func(r *Rego) {
+	return func(r *Rego) {
+		r.strictPartialEval = yes
+	}
+}
+
 // SkipPartialNamespace disables namespacing of partial evalution results for support
 // rules generated from policy. Synthetic support rules are still namespaced.
 func SkipPartialNamespace(yes bool) func(r *Rego) {
@@ -1105,6 +1197,65 @@ func InterQueryBuiltinCache(c cache.InterQueryCache) func(r *Rego) {
 	}
 }
 
+// MemoCache sets the cache used to memoize the values of rules and functions
+// annotated with "memoize: true" across queries. The cache must be invalidated
+// by the caller when policies or data change (see topdown.MemoCache.Invalidate).
+func MemoCache(c *topdown.MemoCache) func(r *Rego) {
+	return func(r *Rego) {
+		r.memoCache = c
+	}
+}
+
+// MemoGeneration sets the generation of the memo cache (see
+// topdown.MemoCache.Generation) that was obtained before the transaction passed
+// to Transaction was opened. Values are only memoized if the cache has not been
+// invalidated since. If no transaction is passed, the generation is obtained
+// before the transaction is opened.
+func MemoGeneration(generation uint64) func(r *Rego) {
+	return func(r *Rego) {
+		r.memoGeneration = &generation
+	}
+}
+
+// Parallelism sets the maximum number of independent rule bodies (e.g., the
+// bodies of partial set rules like "deny") that may be evaluated concurrently.
+// Results, trace events, and print statement outputs are produced in the same
+// order as with sequential evaluation. Evaluation remains sequential if the
+// store does not support concurrent reads (e.g., the disk store). Values less
+// than two disable parallel evaluation (the default).
+func Parallelism(n int) func(r *Rego) {
+	return func(r *Rego) {
+		r.parallelism = n
+	}
+}
+
+// HTTPSendScheduler sets the scheduler used by http.send to coalesce identical
+// in-flight requests, batch requests, and limit the number of concurrent
+// requests per host. The scheduler should be shared by all queries.
+func HTTPSendScheduler(s *topdown.HTTPSendScheduler) func(r *Rego) {
+	return func(r *Rego) {
+		r.httpSendScheduler = s
+	}
+}
+
+// HTTPSendTransport sets the transport http.send sends requests with, e.g., to
+// answer them with canned responses in tests. The transport replaces the one
+// http.send would otherwise create, including its TLS and unix socket settings.
+func HTTPSendTransport(t http.RoundTripper) func(r *Rego) {
+	return func(r *Rego) {
+		r.httpSendTransport = t
+	}
+}
+
+// Limits sets the limits on the resources a single evaluation (or partial
+// evaluation) may use, e.g., the maximum number of evaluation steps. If a
+// limit is exceeded, evaluation fails with a topdown.ResourceLimitErr error.
+func Limits(l topdown.EvalLimits) func(r *Rego) {
+	return func(r *Rego) {
+		r.limits = l
+	}
+}
+
 // NDBuiltinCache sets the non-deterministic builtins cache.
 func NDBuiltinCache(c builtins.NDBCache) func(r *Rego) {
 	return func(r *Rego) {
@@ -1112,6 +1263,16 @@ func NDBuiltinCache(c builtins.NDBCache) func(r *Rego) {
 	}
 }
 
+// NDBuiltinCacheReplay tells the evaluator to take the results of all
+// non-deterministic built-in function calls from the cache set with
+// NDBuiltinCache, e.g., to replay recorded calls. Calls without a cached result
+// fail, instead of being evaluated.
+func NDBuiltinCacheReplay(yes bool) func(r *Rego) {
+	return func(r *Rego) {
+		r.replayNDBCache = yes
+	}
+}
+
 // StrictBuiltinErrors tells the evaluator to treat all built-in function errors as fatal errors.
 func StrictBuiltinErrors(yes bool) func(r *Rego) {
 	return func(r *Rego) {
@@ -1187,6 +1348,16 @@ func EnablePrintStatements(yes bool) func(r *Rego) {
 	}
 }
 
+// ConstantFolding enables or disables compile-time evaluation of rules that
+// only depend on literals and deterministic built-in functions. This option
+// only applies to policies passed as raw strings, i.e., this function will not
+// have any affect if the caller supplies the ast.Compiler instance.
+func ConstantFolding(yes bool) func(r *Rego) {
+	return func(r *Rego) {
+		r.constantFolding = yes
+	}
+}
+
 // Strict enables or disables strict-mode in the compiler
 func Strict(yes bool) func(r *Rego) {
 	return func(r *Rego) {
@@ -1232,6 +1403,10 @@ func New(options ...func(r *Rego)) *Rego {
 		if r.target == targetWasm {
 			r.compiler = r.compiler.WithEvalMode(ast.EvalModeIR)
 		}
+
+		if r.constantFolding {
+			r.compiler = r.compiler.WithConstantFolding(topdown.EvalConstant)
+		}
 	}
 
 	if r.store == nil {
@@ -1283,6 +1458,11 @@ func New(options ...func(r *Rego)) *Rego {
 func (r *Rego) Eval(ctx context.Context) (ResultSet, error) {
 	var err error
 	var txnClose transactionCloser
+	memoGeneration := r.memoGeneration
+	if r.txn == nil && r.memoCache != nil {
+		generation := r.memoCache.Generation()
+		memoGeneration = &generation
+	}
 	r.txn, txnClose, err = r.getTxn(ctx)
 	if err != nil {
 		return nil, err
@@ -1307,6 +1487,10 @@ func (r *Rego) Eval(ctx context.Context) (ResultSet, error) {
 		evalArgs = append(evalArgs, EvalNDBuiltinCache(r.ndBuiltinCache))
 	}
 
+	if memoGeneration != nil {
+		evalArgs = append(evalArgs, EvalMemoGeneration(*memoGeneration))
+	}
+
 	for _, qt := range r.queryTracers {
 		evalArgs = append(evalArgs, EvalQueryTracer(qt))
 	}
@@ -2097,6 +2281,11 @@ func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
 		WithIndexing(ectx.indexing).
 		WithEarlyExit(ectx.earlyExit).
 		WithInterQueryBuiltinCache(ectx.interQueryBuiltinCache).
+		WithMemoCache(ectx.memoCache).
+		WithParallelism(ectx.parallelism).
+		WithHTTPSendScheduler(ectx.httpSendScheduler).
+		WithHTTPSendTransport(ectx.httpSendTransport).
+		WithEvalLimits(ectx.limits).
 		WithStrictBuiltinErrors(r.strictBuiltinErrors).
 		WithBuiltinErrorList(r.builtinErrorList).
 		WithSeed(ectx.seed).
@@ -2108,7 +2297,12 @@ func (r *Rego) eval(ctx context.Context, ectx *EvalContext) (ResultSet, error) {
 	}
 
 	if ectx.ndBuiltinCache != nil {
-		q = q.WithNDBuiltinCache(ectx.ndBuiltinCache)
+		q = q.WithNDBuiltinCache(ectx.ndBuiltinCache).
+			WithNDBuiltinCacheReplay(ectx.replayNDBCache)
+	}
+
+	if ectx.memoGeneration != nil {
+		q = q.WithMemoGeneration(*ectx.memoGeneration)
 	}
 
 	for i := range ectx.queryTracers {
@@ -2281,6 +2475,7 @@ func (r *Rego) partialResult(ctx context.Context, pCfg *PrepareConfig) (PartialR
 		resolvers:           r.resolvers,
 		capabilities:        r.capabilities,
 		strictBuiltinErrors: r.strictBuiltinErrors,
+		limits:              r.limits,
 	}
 
 	disableInlining := r.disableInlining
@@ -2382,7 +2577,11 @@ func (r *Rego) partial(ctx context.Context, ectx *EvalContext) (*PartialQueries,
 		WithPartialNamespace(ectx.partialNamespace).
 		WithSkipPartialNamespace(r.skipPartialNamespace).
 		WithShallowInlining(r.shallowInlining).
+		WithStrictPartialEval(r.strictPartialEval).
 		WithInterQueryBuiltinCache(ectx.interQueryBuiltinCache).
+		WithHTTPSendScheduler(ectx.httpSendScheduler).
+		WithHTTPSendTransport(ectx.httpSendTransport).
+		WithEvalLimits(ectx.limits).
 		WithStrictBuiltinErrors(ectx.strictBuiltinErrors).
 		WithSeed(ectx.seed).
 		WithPrintHook(ectx.printHook)
@@ -2392,7 +2591,8 @@ func (r *Rego) partial(ctx context.Context, ectx *EvalContext) (*PartialQueries,
 	}
 
 	if ectx.ndBuiltinCache != nil {
-		q = q.WithNDBuiltinCache(ectx.ndBuiltinCache)
+		q = q.WithNDBuiltinCache(ectx.ndBuiltinCache).
+			WithNDBuiltinCacheReplay(ectx.replayNDBCache)
 	}
 
 	for i := range ectx.queryTracers {
@@ -2283,6 +2283,22 @@ func TestShallowInliningOption(t *testing.T) {
 	}
 }
 
+func TestStrictPartialEvalOption(t *testing.T) {
+	ctx := context.Background()
+	r := New(Query("data.test.p = true"), Module("test.rego", `
+		package test
+
+		import future.keywords.every
+
+		p { every x in input.xs { x > 1 } }
+	`), StrictPartialEval(true))
+
+	_, err := r.Partial(ctx)
+	if !topdown.IsUnsupportedPartial(err) {
+		t.Fatal("expected unsupported partial evaluation error but got:", err)
+	}
+}
+
 func TestRegoPartialResultSortedRules(t *testing.T) {
 	r := New(Query("data.test.p"), Module("example.rego", `
 		package test
@@ -2574,6 +2590,39 @@ func TestNDBCacheMarshalUnmarshalJSON(t *testing.T) {
 	}
 }
 
+func TestNDBCacheReplay(t *testing.T) {
+	ctx := context.Background()
+
+	ndBC := builtins.NDBCache{}
+	ndBC.Put("time.now_ns", ast.NewArray(), ast.Number("1451311705000000000"))
+
+	rs, err := New(
+		Query("time.now_ns()"),
+		NDBuiltinCache(ndBC),
+		NDBuiltinCacheReplay(true),
+	).Eval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertResultSet(t, rs, "[[1451311705000000000]]")
+
+	_, err = New(
+		Query(`rand.intn("a", 10)`),
+		NDBuiltinCache(ndBC),
+		NDBuiltinCacheReplay(true),
+	).Eval(ctx)
+	var topdownErr *topdown.Error
+	if !errors.As(err, &topdownErr) || topdownErr.Code != topdown.BuiltinErr {
+		t.Fatalf("expected builtin error but got: %v", err)
+	}
+	if exp := `rand.intn: no recorded result for arguments ["a", 10]`; topdownErr.Message != exp {
+		t.Fatalf("expected message %q but got %q", exp, topdownErr.Message)
+	}
+	if _, ok := ndBC["rand.intn"]; ok {
+		t.Fatal("expected no rand.intn cache entry")
+	}
+}
+
 func TestStrictBuiltinErrors(t *testing.T) {
 	_, err := New(Query("1/0"), StrictBuiltinErrors(true)).Eval(context.Background())
 	if err == nil {
@@ -2593,6 +2642,27 @@ func TestStrictBuiltinErrors(t *testing.T) {
 	}
 }
 
+func TestEvalLimits(t *testing.T) {
+	ctx := context.Background()
+	r := New(Query("numbers.range(1, 1000, xs); x := xs[_]; x > 0"), Limits(topdown.EvalLimits{MaxSteps: 100}))
+
+	_, err := r.Eval(ctx)
+	if !topdown.IsResourceLimit(err) {
+		t.Fatal("expected resource limit error but got:", err)
+	}
+
+	pq, err := New(Query("numbers.range(1, 1000, xs); x := xs[_]")).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pq.Eval(ctx, EvalLimits(topdown.EvalLimits{MaxResultBytes: 1024})); !topdown.IsResourceLimit(err) {
+		t.Fatal("expected resource limit error but got:", err)
+	}
+	if rs, err := pq.Eval(ctx); err != nil || len(rs) != 1000 {
+		t.Fatalf("expected 1000 results but got %d: %v", len(rs), err)
+	}
+}
+
 func TestBuiltinErrorList(t *testing.T) {
 	var buf []topdown.Error
 
@@ -2958,6 +3028,73 @@ func TestDescriptionRegisterBuiltinDyn(t *testing.T) {
 	}
 }
 
+func TestConstantFolding(t *testing.T) {
+	ctx := context.Background()
+
+	pq, err := New(
+		Query("data.test.allow"),
+		Module("test.rego", `package test
+
+import rego.v1
+
+max := 2 * 5
+
+allow if input.n < max`),
+		ConstantFolding(true),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := pq.Eval(ctx, EvalInput(map[string]interface{}{"n": 3}))
+	if err != nil {
+		t.Fatal(err)
+	} else if !rs.Allowed() {
+		t.Fatalf("expected allowed but got: %v", rs)
+	}
+
+	folded := pq.r.compiler.FoldedConstants()
+	if v, ok := folded["data.test.max"]; !ok || !v.Equal(ast.IntNumberTerm(10)) {
+		t.Fatalf("expected data.test.max to be folded but got: %v", folded)
+	}
+}
+
+func TestParallelism(t *testing.T) {
+	ctx := context.Background()
+
+	pq, err := New(
+		Query("data.test.deny"),
+		Module("test.rego", `package test
+
+import rego.v1
+
+deny contains "a" if input.a
+
+deny contains "b" if input.b
+
+deny contains x if {
+	some x in input.xs
+}`),
+		Parallelism(4),
+	).PrepareForEval(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := map[string]interface{}{"a": true, "b": false, "xs": []interface{}{"c", "d"}}
+
+	for _, opts := range [][]EvalOption{{}, {EvalParallelism(0)}} {
+		rs, err := pq.Eval(ctx, append(opts, EvalInput(input))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := []interface{}{"a", "c", "d"}
+		if len(rs) != 1 || !reflect.DeepEqual(rs[0].Expressions[0].Value, exp) {
+			t.Fatalf("expected %v but got: %v", exp, rs)
+		}
+	}
+}
+
 // unregisterBuiltin removes the builtin of the given name from ast.Builtins. This assists in
 // cleaning up custom functions added as part of certain test cases.
 func unregisterBuiltin(name string) {
@@ -233,6 +233,12 @@ type Params struct {
 	// the new behavior will be enabled by default.
 	V1Compatible bool
 
+	// ProcessAnnotations enables the processing of METADATA annotations of the
+	// policies loaded from files, bundles and the Policy API. Rules annotated as
+	// private or memoized are only enforced if annotations are processed, and
+	// malformed annotations make policies fail to load.
+	ProcessAnnotations bool
+
 	// CipherSuites specifies the list of enabled TLS 1.0–1.2 cipher suites
 	CipherSuites *[]uint16
 }
@@ -343,7 +349,7 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 	} else {
 		regoVersion = ast.RegoV0
 	}
-	loaded, err := initload.LoadPathsForRegoVersion(regoVersion, params.Paths, params.Filter, params.BundleMode, params.BundleVerificationConfig, params.SkipBundleVerification, false, nil, nil)
+	loaded, err := initload.LoadPathsForRegoVersion(regoVersion, params.Paths, params.Filter, params.BundleMode, params.BundleVerificationConfig, params.SkipBundleVerification, params.ProcessAnnotations, nil, nil)
 	if err != nil {
 		return nil, fmt.Errorf("load error: %w", err)
 	}
@@ -416,7 +422,7 @@ func NewRuntime(ctx context.Context, params Params) (*Runtime, error) {
 		plugins.WithPrometheusRegister(metrics),
 		plugins.WithTracerProvider(tracerProvider),
 		plugins.WithEnableTelemetry(params.EnableVersionCheck),
-		plugins.WithParserOptions(ast.ParserOptions{RegoVersion: regoVersion}))
+		plugins.WithParserOptions(ast.ParserOptions{RegoVersion: regoVersion, ProcessAnnotation: params.ProcessAnnotations}))
 	if err != nil {
 		return nil, fmt.Errorf("config error: %w", err)
 	}
@@ -819,7 +825,7 @@ func (rt *Runtime) readWatcher(ctx context.Context, watcher *fsnotify.Watcher, p
 
 func (rt *Runtime) processWatcherUpdate(ctx context.Context, paths []string, removed string) error {
 
-	return pathwatcher.ProcessWatcherUpdateForRegoVersion(ctx, rt.Manager.ParserOptions().RegoVersion, paths, removed, rt.Store, rt.Params.Filter, rt.Params.BundleMode, func(ctx context.Context, txn storage.Transaction, loaded *initload.LoadPathsResult) error {
+	return pathwatcher.ProcessWatcherUpdateForRegoVersion(ctx, rt.Manager.ParserOptions().RegoVersion, paths, removed, rt.Store, rt.Params.Filter, rt.Params.BundleMode, rt.Manager.ParserOptions().ProcessAnnotation, func(ctx context.Context, txn storage.Transaction, loaded *initload.LoadPathsResult) error {
 		_, err := initload.InsertAndCompile(ctx, initload.InsertAndCompileOptions{
 			Store:         rt.Store,
 			Txn:           txn,
@@ -1475,3 +1475,31 @@ func TestRuntimeWithExplicitBadMetricConfiguration(t *testing.T) {
 		}
 	})
 }
+
+func TestNewRuntimeProcessAnnotations(t *testing.T) {
+	fs := map[string]string{
+		"test/authz.rego": `package test
+
+# METADATA
+# title: a: b
+p := 1
+`,
+	}
+
+	test.WithTempFS(fs, func(rootDir string) {
+		ctx := context.Background()
+
+		// Annotations are not processed by default, so malformed annotations
+		// do not keep policies from loading.
+		params := NewParams()
+		params.Paths = []string{filepath.Join(rootDir, "test")}
+		if _, err := NewRuntime(ctx, params); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		params.ProcessAnnotations = true
+		if _, err := NewRuntime(ctx, params); err == nil || !strings.Contains(err.Error(), "yaml") {
+			t.Fatalf("Expected annotation error but got: %v", err)
+		}
+	})
+}
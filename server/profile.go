@@ -0,0 +1,359 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/profiler"
+	"github.com/open-policy-agent/opa/server/types"
+)
+
+// profileBuckets is the number of buckets the window of the decision profiles
+// is split into. The timings of a bucket expire at once.
+const profileBuckets = 10
+
+// decisionProfiles aggregates the expression timings of a sample of the
+// decisions evaluated by the server over a rolling window.
+type decisionProfiles struct {
+	mtx        sync.Mutex
+	sampleRate float64
+	window     time.Duration
+	buckets    [profileBuckets]profileBucket
+	now        func() time.Time
+	random     func() float64
+}
+
+// profileBucket holds the timings of the decisions evaluated in a part of the
+// window, by decision path.
+type profileBucket struct {
+	start time.Time
+	paths map[string]*pathProfile
+}
+
+type pathProfile struct {
+	decisions int
+	exprs     map[exprKey]*profiler.ExprStats
+}
+
+// exprKey identifies the expressions the profiler groups the timings by.
+type exprKey struct {
+	file string
+	row  int
+}
+
+func newDecisionProfiles(sampleRate float64, window time.Duration) *decisionProfiles {
+	return &decisionProfiles{
+		sampleRate: sampleRate,
+		window:     window,
+		now:        time.Now,
+		random:     rand.Float64,
+	}
+}
+
+// sample returns the profiler to evaluate a decision with, or nil if the
+// decision is not sampled.
+func (p *decisionProfiles) sample() *profiler.Profiler {
+	if p == nil || p.random() >= p.sampleRate {
+		return nil
+	}
+	return profiler.New()
+}
+
+// record adds the timings of a decision evaluated with the profiler returned
+// by sample to the window.
+func (p *decisionProfiles) record(urlPath string, prof *profiler.Profiler) {
+	if p == nil || prof == nil {
+		return
+	}
+
+	stats := prof.ReportTopNResults(0, nil)
+	path := stringPathToDataRef(urlPath).String()
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	bucket := p.bucket(p.now())
+	pp, ok := bucket.paths[path]
+	if !ok {
+		pp = &pathProfile{exprs: map[exprKey]*profiler.ExprStats{}}
+		bucket.paths[path] = pp
+	}
+	pp.add(1, stats)
+}
+
+// bucket returns the bucket of the window the time falls into. The bucket is
+// reset if it holds timings of an expired part of the window.
+func (p *decisionProfiles) bucket(t time.Time) *profileBucket {
+	width := p.window / profileBuckets
+	start := t.Truncate(width)
+	bucket := &p.buckets[(start.UnixNano()/int64(width))%profileBuckets]
+	if bucket.paths == nil || !bucket.start.Equal(start) {
+		bucket.start = start
+		bucket.paths = map[string]*pathProfile{}
+	}
+	return bucket
+}
+
+// report returns the timings of the decisions sampled in the window ending at
+// the given time. The decision paths and their expressions are sorted by
+// decreasing time.
+func (p *decisionProfiles) report(end time.Time) *types.ProfileV1 {
+	paths := map[string]*pathProfile{}
+
+	p.mtx.Lock()
+	cutoff := end.Add(-p.window)
+	for i := range p.buckets {
+		bucket := &p.buckets[i]
+		if bucket.paths == nil || !bucket.start.After(cutoff) {
+			continue
+		}
+		for path, pp := range bucket.paths {
+			merged, ok := paths[path]
+			if !ok {
+				merged = &pathProfile{exprs: map[exprKey]*profiler.ExprStats{}}
+				paths[path] = merged
+			}
+			stats := make([]profiler.ExprStats, 0, len(pp.exprs))
+			for _, stat := range pp.exprs {
+				stats = append(stats, *stat)
+			}
+			merged.add(pp.decisions, stats)
+		}
+	}
+	p.mtx.Unlock()
+
+	result := &types.ProfileV1{
+		SampleRate:    p.sampleRate,
+		WindowSeconds: int(p.window / time.Second),
+		Paths:         make([]types.DecisionProfileV1, 0, len(paths)),
+	}
+
+	for path, pp := range paths {
+		dp := types.DecisionProfileV1{
+			Path:      path,
+			Decisions: pp.decisions,
+			Exprs:     make([]profiler.ExprStats, 0, len(pp.exprs)),
+		}
+		for _, stat := range pp.exprs {
+			dp.TotalTimeNs += stat.ExprTimeNs
+			dp.Exprs = append(dp.Exprs, *stat)
+		}
+		sort.Slice(dp.Exprs, func(i, j int) bool {
+			a, b := dp.Exprs[i], dp.Exprs[j]
+			if a.ExprTimeNs != b.ExprTimeNs {
+				return a.ExprTimeNs > b.ExprTimeNs
+			}
+			if a.Location.File != b.Location.File {
+				return a.Location.File < b.Location.File
+			}
+			return a.Location.Row < b.Location.Row
+		})
+		result.Paths = append(result.Paths, dp)
+	}
+
+	sort.Slice(result.Paths, func(i, j int) bool {
+		a, b := result.Paths[i], result.Paths[j]
+		if a.TotalTimeNs != b.TotalTimeNs {
+			return a.TotalTimeNs > b.TotalTimeNs
+		}
+		return a.Path < b.Path
+	})
+
+	return result
+}
+
+// add adds the timings of the given number of decisions.
+func (pp *pathProfile) add(decisions int, stats []profiler.ExprStats) {
+	pp.decisions += decisions
+	for _, stat := range stats {
+		key := exprKey{file: stat.Location.File, row: stat.Location.Row}
+		agg, ok := pp.exprs[key]
+		if !ok {
+			cpy := stat
+			pp.exprs[key] = &cpy
+			continue
+		}
+		agg.ExprTimeNs += stat.ExprTimeNs
+		agg.NumEval += stat.NumEval
+		agg.NumRedo += stat.NumRedo
+		if stat.NumGenExpr > agg.NumGenExpr {
+			agg.NumGenExpr = stat.NumGenExpr
+		}
+	}
+}
+
+// protoField is the number of a field of a protocol buffer message. Like
+// runtime/pprof, the profile is encoded by hand, as it only takes a handful of
+// messages of varint and length-delimited fields.
+type protoField uint64
+
+const (
+	protoWireVarint = 0
+	protoWireBytes  = 2
+)
+
+// Field numbers of the messages of the pprof profile format, see
+// https://github.com/google/pprof/blob/main/proto/profile.proto.
+const (
+	pprofProfileSampleType        protoField = 1
+	pprofProfileSample            protoField = 2
+	pprofProfileLocation          protoField = 4
+	pprofProfileFunction          protoField = 5
+	pprofProfileStringTable       protoField = 6
+	pprofProfileTimeNanos         protoField = 9
+	pprofProfileDurationNanos     protoField = 10
+	pprofProfileDefaultSampleType protoField = 14
+
+	pprofValueTypeType protoField = 1
+	pprofValueTypeUnit protoField = 2
+
+	pprofSampleLocationID protoField = 1
+	pprofSampleValue      protoField = 2
+
+	pprofLocationID   protoField = 1
+	pprofLocationLine protoField = 4
+
+	pprofLineFunctionID protoField = 1
+	pprofLineLine       protoField = 2
+
+	pprofFunctionID        protoField = 1
+	pprofFunctionName      protoField = 2
+	pprofFunctionFilename  protoField = 4
+	pprofFunctionStartLine protoField = 5
+)
+
+// pprofBuilder encodes a profile in the protocol buffer format of pprof.
+type pprofBuilder struct {
+	buf       []byte
+	strings   map[string]uint64
+	table     []string
+	locations map[pprofFrame]uint64
+}
+
+// pprofFrame is the function and line of a location in a profile. Every
+// location has a function of its own.
+type pprofFrame struct {
+	name string
+	file string
+	line int
+}
+
+// writePprof writes the profile of the window ending at the given time in the
+// gzipped protocol buffer format of pprof. Every expression is a sample, which
+// is called by the decision path it was evaluated for.
+func writePprof(w io.Writer, profile *types.ProfileV1, end time.Time) error {
+	b := &pprofBuilder{
+		strings:   map[string]uint64{},
+		locations: map[pprofFrame]uint64{},
+	}
+	b.string("")
+
+	for _, st := range [][2]string{{"evaluations", "count"}, {"redos", "count"}, {"time", "nanoseconds"}} {
+		var vt []byte
+		vt = appendVarintField(vt, pprofValueTypeType, b.string(st[0]))
+		vt = appendVarintField(vt, pprofValueTypeUnit, b.string(st[1]))
+		b.buf = appendBytesField(b.buf, pprofProfileSampleType, vt)
+	}
+
+	for _, dp := range profile.Paths {
+		root := b.location(pprofFrame{name: dp.Path})
+		for _, stat := range dp.Exprs {
+			leaf := b.location(pprofFrame{
+				name: fmt.Sprintf("%v:%v", stat.Location.File, stat.Location.Row),
+				file: stat.Location.File,
+				line: stat.Location.Row,
+			})
+			var sample []byte
+			sample = appendPackedField(sample, pprofSampleLocationID, leaf, root)
+			sample = appendPackedField(sample, pprofSampleValue, uint64(stat.NumEval), uint64(stat.NumRedo), uint64(stat.ExprTimeNs))
+			b.buf = appendBytesField(b.buf, pprofProfileSample, sample)
+		}
+	}
+
+	window := time.Duration(profile.WindowSeconds) * time.Second
+	b.buf = appendVarintField(b.buf, pprofProfileTimeNanos, uint64(end.Add(-window).UnixNano()))
+	b.buf = appendVarintField(b.buf, pprofProfileDurationNanos, uint64(window))
+	b.buf = appendVarintField(b.buf, pprofProfileDefaultSampleType, b.string("time"))
+
+	// The string table is encoded last, as the strings are added while the
+	// other messages are encoded.
+	for _, s := range b.table {
+		b.buf = appendBytesField(b.buf, pprofProfileStringTable, []byte(s))
+	}
+
+	gw := gzip.NewWriter(w)
+	if _, err := gw.Write(b.buf); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// string returns the index of the string in the string table.
+func (b *pprofBuilder) string(s string) uint64 {
+	if i, ok := b.strings[s]; ok {
+		return i
+	}
+	i := uint64(len(b.table))
+	b.strings[s] = i
+	b.table = append(b.table, s)
+	return i
+}
+
+// location returns the ID of the location of the frame, encoding the location
+// and its function the first time the frame is seen.
+func (b *pprofBuilder) location(f pprofFrame) uint64 {
+	if id, ok := b.locations[f]; ok {
+		return id
+	}
+	id := uint64(len(b.locations) + 1)
+	b.locations[f] = id
+
+	var fn []byte
+	fn = appendVarintField(fn, pprofFunctionID, id)
+	fn = appendVarintField(fn, pprofFunctionName, b.string(f.name))
+	fn = appendVarintField(fn, pprofFunctionFilename, b.string(f.file))
+	fn = appendVarintField(fn, pprofFunctionStartLine, uint64(f.line))
+	b.buf = appendBytesField(b.buf, pprofProfileFunction, fn)
+
+	var line []byte
+	line = appendVarintField(line, pprofLineFunctionID, id)
+	line = appendVarintField(line, pprofLineLine, uint64(f.line))
+	var loc []byte
+	loc = appendVarintField(loc, pprofLocationID, id)
+	loc = appendBytesField(loc, pprofLocationLine, line)
+	b.buf = appendBytesField(b.buf, pprofProfileLocation, loc)
+
+	return id
+}
+
+func appendVarintField(b []byte, num protoField, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = binary.AppendUvarint(b, uint64(num)<<3|protoWireVarint)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytesField(b []byte, num protoField, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|protoWireBytes)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendPackedField(b []byte, num protoField, vs ...uint64) []byte {
+	var packed []byte
+	for _, v := range vs {
+		packed = binary.AppendUvarint(packed, v)
+	}
+	return appendBytesField(b, num, packed)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+func TestDecisionProfilesWindow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	p := newDecisionProfiles(0.5, time.Minute)
+	p.now = func() time.Time { return now }
+
+	exprs := []*ast.Expr{
+		ast.MustParseExpr("x = 1"),
+		ast.MustParseExpr("y = 2"),
+	}
+	for i, expr := range exprs {
+		expr.Location = ast.NewLocation(nil, "policy.rego", i+3, 1)
+	}
+
+	decide := func(path string, random float64) {
+		t.Helper()
+		p.random = func() float64 { return random }
+		prof := p.sample()
+		if random >= 0.5 {
+			if prof != nil {
+				t.Fatalf("Expected decision not to be sampled for %v", random)
+			}
+			return
+		}
+		for _, expr := range exprs {
+			prof.TraceEvent(topdown.Event{Op: topdown.EvalOp, Node: expr})
+		}
+		p.record(path, prof)
+	}
+
+	decide("a/b", 0.1)
+	decide("a/b", 0.9)
+	now = now.Add(30 * time.Second)
+	decide("a/b", 0.2)
+	decide("c", 0.3)
+
+	report := p.report(now)
+	if len(report.Paths) != 2 {
+		t.Fatalf("Expected two decision paths but got %+v", report.Paths)
+	}
+	decisions := map[string]int{}
+	for _, dp := range report.Paths {
+		decisions[dp.Path] = dp.Decisions
+		if len(dp.Exprs) != 2 {
+			t.Fatalf("Expected timings of two expressions for %v but got %v", dp.Path, dp.Exprs)
+		}
+	}
+	if decisions["data.a.b"] != 2 || decisions["data.c"] != 1 {
+		t.Fatalf("Expected sampled decisions to be counted but got %v", decisions)
+	}
+
+	// The timings of the first decision expire once the window has passed.
+	now = now.Add(45 * time.Second)
+	report = p.report(now)
+	decisions = map[string]int{}
+	for _, dp := range report.Paths {
+		decisions[dp.Path] = dp.Decisions
+	}
+	if len(decisions) != 2 || decisions["data.a.b"] != 1 || decisions["data.c"] != 1 {
+		t.Fatalf("Expected expired decisions to be dropped but got %v", decisions)
+	}
+
+	now = now.Add(time.Minute)
+	if report := p.report(now); len(report.Paths) != 0 {
+		t.Fatalf("Expected empty window but got %+v", report.Paths)
+	}
+}
@@ -26,6 +26,8 @@ import (
 	"time"
 
 	serverEncodingPlugin "github.com/open-policy-agent/opa/plugins/server/encoding"
+	serverLimitsPlugin "github.com/open-policy-agent/opa/plugins/server/limits"
+	serverProfilingPlugin "github.com/open-policy-agent/opa/plugins/server/profiling"
 
 	"github.com/gorilla/mux"
 	"go.opentelemetry.io/otel/attribute"
@@ -36,6 +38,7 @@ import (
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/bundle"
+	"github.com/open-policy-agent/opa/filter"
 	"github.com/open-policy-agent/opa/internal/json/patch"
 	"github.com/open-policy-agent/opa/logging"
 	"github.com/open-policy-agent/opa/metrics"
@@ -92,6 +95,7 @@ const (
 	PromHandlerV1Compile  = "v1/compile"
 	PromHandlerV1Config   = "v1/config"
 	PromHandlerV1Status   = "v1/status"
+	PromHandlerV1Profile  = "v1/profile"
 	PromHandlerIndex      = "index"
 	PromHandlerCatch      = "catchall"
 	PromHandlerHealth     = "health"
@@ -142,6 +146,10 @@ type Server struct {
 	metrics                Metrics
 	defaultDecisionPath    string
 	interQueryBuiltinCache iCache.InterQueryCache
+	httpSendScheduler      *topdown.HTTPSendScheduler
+	evalLimits             topdown.EvalLimits
+	profiles               *decisionProfiles
+	memoCache              *topdown.MemoCache
 	allPluginsOkOnce       bool
 	distributedTracingOpts tracing.Options
 	ndbCacheEnabled        bool
@@ -187,11 +195,25 @@ func New() *Server {
 func (s *Server) Init(ctx context.Context) (*Server, error) {
 	s.initRouters(ctx)
 
+	limits, err := s.initEvalLimits()
+	if err != nil {
+		return nil, err
+	}
+	s.evalLimits = limits
+
+	profiles, err := s.initDecisionProfiles()
+	if err != nil {
+		return nil, err
+	}
+	s.profiles = profiles
+
 	txn, err := s.store.NewTransaction(ctx, storage.WriteParams)
 	if err != nil {
 		return nil, err
 	}
 
+	s.memoCache = topdown.NewMemoCache()
+
 	// Register triggers so that if runtime reloads the policies, the
 	// server sees the change.
 	config := storage.TriggerConfig{
@@ -768,6 +790,35 @@ func (s *Server) initHandlerCompression(handler http.Handler) (http.Handler, err
 	return compressHandler, nil
 }
 
+func (s *Server) initEvalLimits() (topdown.EvalLimits, error) {
+	var limitsRawConfig json.RawMessage
+	serverConfig := s.manager.Config.Server
+	if serverConfig != nil {
+		limitsRawConfig = serverConfig.EvalLimits
+	}
+	limitsConfig, err := serverLimitsPlugin.NewConfigBuilder().WithBytes(limitsRawConfig).Parse()
+	if err != nil {
+		return topdown.EvalLimits{}, err
+	}
+	return limitsConfig.EvalLimits(), nil
+}
+
+func (s *Server) initDecisionProfiles() (*decisionProfiles, error) {
+	var profilingRawConfig json.RawMessage
+	serverConfig := s.manager.Config.Server
+	if serverConfig != nil {
+		profilingRawConfig = serverConfig.Profiling
+	}
+	profilingConfig, err := serverProfilingPlugin.NewConfigBuilder().WithBytes(profilingRawConfig).Parse()
+	if err != nil {
+		return nil, err
+	}
+	if !profilingConfig.Enabled() {
+		return nil, nil
+	}
+	return newDecisionProfiles(profilingConfig.SampleRate, profilingConfig.Window()), nil
+}
+
 func (s *Server) initRouters(ctx context.Context) {
 	mainRouter := s.router
 	if mainRouter == nil {
@@ -778,6 +829,7 @@ func (s *Server) initRouters(ctx context.Context) {
 
 	// authorizer, if configured, needs the iCache to be set up already
 	s.interQueryBuiltinCache = iCache.NewInterQueryCacheWithContext(ctx, s.manager.InterQueryBuiltinCacheConfig())
+	s.httpSendScheduler = topdown.NewHTTPSendScheduler(s.manager.InterQueryBuiltinCacheConfig())
 	s.manager.RegisterCacheTrigger(s.updateCacheConfig)
 
 	// Add authorization handler. This must come BEFORE authentication handler
@@ -838,6 +890,8 @@ func (s *Server) initRouters(ctx context.Context) {
 	mainRouter.Handle("/v1/compile", s.instrumentHandler(s.v1CompilePost, PromHandlerV1Compile)).Methods(http.MethodPost)
 	mainRouter.Handle("/v1/config", s.instrumentHandler(s.v1ConfigGet, PromHandlerV1Config)).Methods(http.MethodGet)
 	mainRouter.Handle("/v1/status", s.instrumentHandler(s.v1StatusGet, PromHandlerV1Status)).Methods(http.MethodGet)
+	mainRouter.Handle("/v1/profile", s.instrumentHandler(s.v1ProfileGet, PromHandlerV1Profile)).Methods(http.MethodGet)
+	mainRouter.Handle("/v1/profile/pprof", s.instrumentHandler(s.v1ProfilePprofGet, PromHandlerV1Profile)).Methods(http.MethodGet)
 	mainRouter.Handle("/", s.instrumentHandler(s.unversionedPost, PromHandlerIndex)).Methods(http.MethodPost)
 	mainRouter.Handle("/", s.instrumentHandler(s.indexGet, PromHandlerIndex)).Methods(http.MethodGet)
 
@@ -884,7 +938,7 @@ func (s *Server) instrumentHandler(handler func(http.ResponseWriter, *http.Reque
 	return httpHandler
 }
 
-func (s *Server) execQuery(ctx context.Context, br bundleRevisions, txn storage.Transaction, parsedQuery ast.Body, input ast.Value, rawInput *interface{}, m metrics.Metrics, explainMode types.ExplainModeV1, includeMetrics, includeInstrumentation, pretty bool) (*types.QueryResponseV1, error) {
+func (s *Server) execQuery(ctx context.Context, br bundleRevisions, txn storage.Transaction, memoGeneration uint64, parsedQuery ast.Body, input ast.Value, rawInput *interface{}, m metrics.Metrics, explainMode types.ExplainModeV1, includeMetrics, includeInstrumentation, pretty bool) (*types.QueryResponseV1, error) {
 	results := types.QueryResponseV1{}
 	logger := s.getDecisionLogger(br)
 
@@ -910,6 +964,10 @@ func (s *Server) execQuery(ctx context.Context, br bundleRevisions, txn storage.
 		rego.Runtime(s.runtime),
 		rego.UnsafeBuiltins(unsafeBuiltinsMap),
 		rego.InterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.HTTPSendScheduler(s.httpSendScheduler),
+		rego.Limits(s.evalLimits),
+		rego.MemoCache(s.memoCache),
+		rego.MemoGeneration(memoGeneration),
 		rego.PrintHook(s.manager.PrintHook()),
 		rego.EnablePrintStatements(s.manager.EnablePrintStatements()),
 		rego.DistributedTracingOpts(s.distributedTracingOpts),
@@ -997,7 +1055,7 @@ func getRevisions(ctx context.Context, store storage.Store, txn storage.Transact
 	return br, nil
 }
 
-func (s *Server) reload(context.Context, storage.Transaction, storage.TriggerEvent) {
+func (s *Server) reload(_ context.Context, _ storage.Transaction, event storage.TriggerEvent) {
 
 	// NOTE(tsandall): We currently rely on the storage txn to provide
 	// critical sections in the server.
@@ -1010,6 +1068,7 @@ func (s *Server) reload(context.Context, storage.Transaction, storage.TriggerEve
 	s.partials = map[string]rego.PartialResult{}
 	s.preparedEvalQueries = newCache(pqMaxCacheSize)
 	s.defaultDecisionPath = s.generateDefaultDecisionPath()
+	s.memoCache.Invalidate(event)
 }
 
 func (s *Server) unversionedPost(w http.ResponseWriter, r *http.Request) {
@@ -1035,6 +1094,10 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 	}
 
 	// Prepare for query.
+	// Obtain the memo cache generation before the transaction is opened so that
+	// values computed from a snapshot that has since been invalidated are not
+	// memoized.
+	memoGeneration := s.memoCache.Generation()
 	txn, err := s.store.NewTransaction(ctx)
 	if err != nil {
 		writer.ErrorAuto(w, err)
@@ -1095,18 +1158,27 @@ func (s *Server) v0QueryPath(w http.ResponseWriter, r *http.Request, urlPath str
 
 	evalOpts := []rego.EvalOption{
 		rego.EvalTransaction(txn),
+		rego.EvalMemoGeneration(memoGeneration),
 		rego.EvalParsedInput(input),
 		rego.EvalMetrics(m),
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.EvalHTTPSendScheduler(s.httpSendScheduler),
+		rego.EvalLimits(s.evalLimits),
 		rego.EvalNDBuiltinCache(ndbCache),
 	}
 
+	profile := s.profiles.sample()
+	if profile != nil {
+		evalOpts = append(evalOpts, rego.EvalQueryTracer(profile))
+	}
+
 	rs, err := preparedQuery.Eval(
 		ctx,
 		evalOpts...,
 	)
 
 	m.Timer(metrics.ServerHandler).Stop()
+	s.profiles.record(urlPath, profile)
 
 	// Handle results.
 	if err != nil {
@@ -1373,12 +1445,15 @@ func (s *Server) v1CompilePost(w http.ResponseWriter, r *http.Request) {
 		rego.ParsedInput(request.Input),
 		rego.ParsedUnknowns(request.Unknowns),
 		rego.DisableInlining(request.Options.DisableInlining),
+		rego.StrictPartialEval(request.Options.StrictPartialEval || request.Options.Target != ""),
 		rego.QueryTracer(buf),
 		rego.Instrument(includeInstrumentation),
 		rego.Metrics(m),
 		rego.Runtime(s.runtime),
 		rego.UnsafeBuiltins(unsafeBuiltinsMap),
 		rego.InterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.HTTPSendScheduler(s.httpSendScheduler),
+		rego.Limits(s.evalLimits),
 		rego.PrintHook(s.manager.PrintHook()),
 	)
 
@@ -1387,6 +1462,8 @@ func (s *Server) v1CompilePost(w http.ResponseWriter, r *http.Request) {
 		switch err := err.(type) {
 		case ast.Errors:
 			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgCompileModuleError).WithASTErrors(err))
+		case topdown.Errors:
+			writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgUnsupportedPartialError).WithTopdownErrors(err))
 		default:
 			writer.ErrorAuto(w, err)
 		}
@@ -1410,6 +1487,23 @@ func (s *Server) v1CompilePost(w http.ResponseWriter, r *http.Request) {
 		Support: pq.Support,
 	}
 
+	if request.Options.Target != "" {
+		translated, err := filter.New(request.Options.Target).
+			WithUnknowns(request.unknownRefs()).
+			WithMappings(request.Options.TargetMappings).
+			Translate(pq.Queries, pq.Support)
+		if err != nil {
+			switch err := err.(type) {
+			case filter.Errors:
+				writer.Error(w, http.StatusBadRequest, types.NewErrorV1(types.CodeInvalidParameter, types.MsgTranslationError).WithFilterErrors(err))
+			default:
+				writer.ErrorAuto(w, err)
+			}
+			return
+		}
+		i = translated
+	}
+
 	result.Result = &i
 
 	writer.JSONOK(w, result, pretty(r))
@@ -1450,6 +1544,7 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 	m.Timer(metrics.RegoInputParse).Stop()
 
 	// Prepare for query.
+	memoGeneration := s.memoCache.Generation()
 	c := storage.NewContext().WithMetrics(m)
 	txn, err := s.store.NewTransaction(ctx, storage.TransactionParams{Context: c})
 	if err != nil {
@@ -1514,20 +1609,29 @@ func (s *Server) v1DataGet(w http.ResponseWriter, r *http.Request) {
 
 	evalOpts := []rego.EvalOption{
 		rego.EvalTransaction(txn),
+		rego.EvalMemoGeneration(memoGeneration),
 		rego.EvalParsedInput(input),
 		rego.EvalMetrics(m),
 		rego.EvalQueryTracer(buf),
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.EvalHTTPSendScheduler(s.httpSendScheduler),
+		rego.EvalLimits(s.evalLimits),
 		rego.EvalInstrument(includeInstrumentation),
 		rego.EvalNDBuiltinCache(ndbCache),
 	}
 
+	profile := s.profiles.sample()
+	if profile != nil {
+		evalOpts = append(evalOpts, rego.EvalQueryTracer(profile))
+	}
+
 	rs, err := preparedQuery.Eval(
 		ctx,
 		evalOpts...,
 	)
 
 	m.Timer(metrics.ServerHandler).Stop()
+	s.profiles.record(urlPath, profile)
 
 	// Handle results.
 	if err != nil {
@@ -1667,6 +1771,7 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 
 	m.Timer(metrics.RegoInputParse).Stop()
 
+	memoGeneration := s.memoCache.Generation()
 	txn, err := s.store.NewTransaction(ctx, storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)})
 	if err != nil {
 		writer.ErrorAuto(w, err)
@@ -1733,20 +1838,29 @@ func (s *Server) v1DataPost(w http.ResponseWriter, r *http.Request) {
 
 	evalOpts := []rego.EvalOption{
 		rego.EvalTransaction(txn),
+		rego.EvalMemoGeneration(memoGeneration),
 		rego.EvalParsedInput(input),
 		rego.EvalMetrics(m),
 		rego.EvalQueryTracer(buf),
 		rego.EvalInterQueryBuiltinCache(s.interQueryBuiltinCache),
+		rego.EvalHTTPSendScheduler(s.httpSendScheduler),
+		rego.EvalLimits(s.evalLimits),
 		rego.EvalInstrument(includeInstrumentation),
 		rego.EvalNDBuiltinCache(ndbCache),
 	}
 
+	profile := s.profiles.sample()
+	if profile != nil {
+		evalOpts = append(evalOpts, rego.EvalQueryTracer(profile))
+	}
+
 	rs, err := preparedQuery.Eval(
 		ctx,
 		evalOpts...,
 	)
 
 	m.Timer(metrics.ServerHandler).Stop()
+	s.profiles.record(urlPath, profile)
 
 	// Handle results.
 	if err != nil {
@@ -2126,7 +2240,7 @@ func (s *Server) v1PoliciesPut(w http.ResponseWriter, r *http.Request) {
 	}
 
 	m.Timer(metrics.RegoModuleParse).Start()
-	parsedMod, err := ast.ParseModule(id, string(buf))
+	parsedMod, err := s.parseModule(id, string(buf))
 	m.Timer(metrics.RegoModuleParse).Stop()
 
 	if err != nil {
@@ -2224,6 +2338,7 @@ func (s *Server) v1QueryGet(w http.ResponseWriter, r *http.Request) {
 	explainMode := getExplain(r.URL.Query()["explain"], types.ExplainOffV1)
 	includeInstrumentation := getBoolParam(r.URL, types.ParamInstrumentV1, true)
 
+	memoGeneration := s.memoCache.Generation()
 	params := storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)}
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
@@ -2239,7 +2354,7 @@ func (s *Server) v1QueryGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	pretty := pretty(r)
-	results, err := s.execQuery(ctx, br, txn, parsedQuery, nil, nil, m, explainMode, includeMetrics(r), includeInstrumentation, pretty)
+	results, err := s.execQuery(ctx, br, txn, memoGeneration, parsedQuery, nil, nil, m, explainMode, includeMetrics(r), includeInstrumentation, pretty)
 	if err != nil {
 		switch err := err.(type) {
 		case ast.Errors:
@@ -2294,6 +2409,7 @@ func (s *Server) v1QueryPost(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	memoGeneration := s.memoCache.Generation()
 	params := storage.TransactionParams{Context: storage.NewContext().WithMetrics(m)}
 	txn, err := s.store.NewTransaction(ctx, params)
 	if err != nil {
@@ -2309,7 +2425,7 @@ func (s *Server) v1QueryPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results, err := s.execQuery(ctx, br, txn, parsedQuery, input, request.Input, m, explainMode, includeMetrics, includeInstrumentation, pretty)
+	results, err := s.execQuery(ctx, br, txn, memoGeneration, parsedQuery, input, request.Input, m, explainMode, includeMetrics, includeInstrumentation, pretty)
 	if err != nil {
 		switch err := err.(type) {
 		case ast.Errors:
@@ -2349,6 +2465,34 @@ func (s *Server) v1StatusGet(w http.ResponseWriter, r *http.Request) {
 	writer.JSONOK(w, types.StatusResponseV1{Result: &st}, pretty(r))
 }
 
+func (s *Server) v1ProfileGet(w http.ResponseWriter, r *http.Request) {
+	if s.profiles == nil {
+		writer.ErrorString(w, http.StatusNotFound, types.CodeProfilingDisabled, errors.New("decision profiling not enabled"))
+		return
+	}
+
+	writer.JSONOK(w, types.ProfileResponseV1{Result: s.profiles.report(s.profiles.now())}, pretty(r))
+}
+
+func (s *Server) v1ProfilePprofGet(w http.ResponseWriter, r *http.Request) {
+	if s.profiles == nil {
+		writer.ErrorString(w, http.StatusNotFound, types.CodeProfilingDisabled, errors.New("decision profiling not enabled"))
+		return
+	}
+
+	end := s.profiles.now()
+	var buf bytes.Buffer
+	if err := writePprof(&buf, s.profiles.report(end), end); err != nil {
+		writer.ErrorAuto(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
 func (s *Server) checkPolicyIDScope(ctx context.Context, txn storage.Transaction, id string) error {
 
 	bs, err := s.store.GetPolicy(ctx, txn, id)
@@ -2356,7 +2500,7 @@ func (s *Server) checkPolicyIDScope(ctx context.Context, txn storage.Transaction
 		return err
 	}
 
-	module, err := ast.ParseModule(id, string(bs))
+	module, err := s.parseModule(id, string(bs))
 	if err != nil {
 		return err
 	}
@@ -2364,6 +2508,13 @@ func (s *Server) checkPolicyIDScope(ctx context.Context, txn storage.Transaction
 	return s.checkPolicyPackageScope(ctx, txn, module.Package)
 }
 
+// parseModule parses a policy module. Annotations are only processed (and so
+// rules annotated as private or memoized only enforced) if the manager's parser
+// options enable them.
+func (s *Server) parseModule(id, src string) (*ast.Module, error) {
+	return ast.ParseModuleWithOpts(id, src, ast.ParserOptions{ProcessAnnotation: s.manager.ParserOptions().ProcessAnnotation})
+}
+
 func (s *Server) checkPolicyPackageScope(ctx context.Context, txn storage.Transaction, pkg *ast.Package) error {
 
 	path, err := pkg.Path.Ptr()
@@ -2488,7 +2639,7 @@ func (s *Server) loadModules(ctx context.Context, txn storage.Transaction) (map[
 			return nil, err
 		}
 
-		parsed, err := ast.ParseModule(id, string(bs))
+		parsed, err := s.parseModule(id, string(bs))
 		if err != nil {
 			return nil, err
 		}
@@ -2526,6 +2677,7 @@ func (s *Server) makeRego(ctx context.Context,
 		rego.Runtime(s.runtime),
 		rego.UnsafeBuiltins(unsafeBuiltinsMap),
 		rego.StrictBuiltinErrors(strictBuiltinErrors),
+		rego.MemoCache(s.memoCache),
 		rego.PrintHook(s.manager.PrintHook()),
 		rego.DistributedTracingOpts(s.distributedTracingOpts),
 	)
@@ -2632,6 +2784,7 @@ func isPathOwned(path, root []string) bool {
 
 func (s *Server) updateCacheConfig(cacheConfig *iCache.Config) {
 	s.interQueryBuiltinCache.UpdateConfig(cacheConfig)
+	s.httpSendScheduler.UpdateConfig(cacheConfig)
 }
 
 func (s *Server) updateNDCache(enabled bool) {
@@ -2826,7 +2979,28 @@ type compileRequest struct {
 }
 
 type compileRequestOptions struct {
-	DisableInlining []string
+	DisableInlining   []string
+	StrictPartialEval bool
+	Target            filter.Target
+	TargetMappings    map[string]filter.Table
+}
+
+// unknownRefs returns the unknowns of the request as refs, defaulting to input
+// like partial evaluation does.
+func (r *compileRequest) unknownRefs() []ast.Ref {
+	if r.Unknowns == nil {
+		return []ast.Ref{ast.InputRootRef}
+	}
+	refs := make([]ast.Ref, 0, len(r.Unknowns))
+	for _, u := range r.Unknowns {
+		switch v := u.Value.(type) {
+		case ast.Ref:
+			refs = append(refs, v)
+		case ast.Var:
+			refs = append(refs, ast.Ref{u})
+		}
+	}
+	return refs
 }
 
 func readInputCompilePostV1(r io.ReadCloser) (*compileRequest, *types.ErrorV1) {
@@ -2869,12 +3043,20 @@ func readInputCompilePostV1(r io.ReadCloser) (*compileRequest, *types.ErrorV1) {
 		}
 	}
 
+	target := filter.Target(request.Options.Target)
+	if target != "" && !target.Valid() {
+		return nil, types.NewErrorV1(types.CodeInvalidParameter, "unsupported target %q (supported targets: %v)", target, filter.Targets())
+	}
+
 	result := &compileRequest{
 		Query:    query,
 		Input:    input,
 		Unknowns: unknowns,
 		Options: compileRequestOptions{
-			DisableInlining: request.Options.DisableInlining,
+			DisableInlining:   request.Options.DisableInlining,
+			StrictPartialEval: request.Options.StrictPartialEval,
+			Target:            target,
+			TargetMappings:    request.Options.TargetMappings,
 		},
 	}
 
@@ -15,6 +15,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/binary"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
@@ -937,6 +938,54 @@ func TestCompileV1(t *testing.T) {
 					`)},
 			},
 		},
+		{
+			note: "support with strictPartialEval",
+			trs: []tr{
+				{http.MethodPut, "/policies/test", mod, 200, ""},
+				{http.MethodPost, "/compile", `{
+					"query": "data.test.r = true",
+					"options": { "strictPartialEval": true }
+				}`, 400, ""},
+			},
+		},
+		{
+			note: "target sql+postgres",
+			trs: []tr{
+				{http.MethodPost, "/compile", `{
+					"unknowns": ["data.reports"],
+					"query": "data.reports[x].owner = input.user; data.reports[x].size > 10",
+					"input": { "user": "alice" },
+					"options": { "target": "sql+postgres" }
+				}`, 200, `{"result": {"query": "\"reports\".\"owner\" = $1 AND \"reports\".\"size\" > $2", "args": ["alice", 10]}}`},
+			},
+		},
+		{
+			note: "target elasticsearch with mappings",
+			trs: []tr{
+				{http.MethodPost, "/compile", `{
+					"query": "input.owner = \"alice\"",
+					"options": { "target": "elasticsearch", "targetMappings": { "input": { "columns": { "owner": "owner_id" } } } }
+				}`, 200, `{"result": {"query": {"term": {"owner_id": "alice"}}}}`},
+			},
+		},
+		{
+			note: "target with untranslatable expression",
+			trs: []tr{
+				{http.MethodPost, "/compile", `{
+					"query": "count(input.xs) > 1",
+					"options": { "target": "sql+mysql" }
+				}`, 400, ""},
+			},
+		},
+		{
+			note: "unknown target",
+			trs: []tr{
+				{http.MethodPost, "/compile", `{
+					"query": "input.x = 1",
+					"options": { "target": "sql+oracle" }
+				}`, 400, ""},
+			},
+		},
 		{
 			note: "function without disableInlining",
 			trs: []tr{
@@ -2759,6 +2808,59 @@ func TestDataMetricsEval(t *testing.T) {
 	})
 }
 
+func TestDataMemoizedRuleInvalidatedOnDataWrite(t *testing.T) {
+	f := newFixtureWithParserOptions(t, ast.ParserOptions{ProcessAnnotation: true})
+
+	if err := f.v1(http.MethodPut, "/data/users", `["alice"]`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodPut, "/policies/test", `package test
+
+import rego.v1
+
+# METADATA
+# memoize: true
+users := {u | some u in data.users}
+
+n := count(users)`, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(expResult int, expHits uint64) {
+		t.Helper()
+		f.reset()
+		f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodGet, "/data/test/n?metrics", ""))
+
+		var result types.DataResponseV1
+		if err := util.NewJSONDecoder(f.recorder.Body).Decode(&result); err != nil {
+			t.Fatalf("Unexpected JSON decode error: %v", err)
+		}
+		if result.Result == nil || !reflect.DeepEqual(*result.Result, json.Number(fmt.Sprint(expResult))) {
+			t.Fatalf("Expected result %d but got %v", expResult, f.recorder.Body)
+		}
+		var hits uint64
+		if v, ok := result.Metrics["counter_eval_op_memo_cache_hit"]; ok {
+			n, _ := v.(json.Number).Int64()
+			hits = uint64(n)
+		}
+		if hits != expHits {
+			t.Fatalf("Expected %d memo cache hits but got metrics %v", expHits, result.Metrics)
+		}
+	}
+
+	get(1, 0)
+	get(1, 1)
+
+	// Writing the data the memoized rule depends on drops its values.
+	if err := f.v1(http.MethodPut, "/data/users", `["alice", "bob"]`, 204, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	get(2, 0)
+	get(2, 1)
+}
+
 func testDataMetrics(t *testing.T, f *fixture, url string, expected []string) {
 	t.Helper()
 	f.reset()
@@ -2939,6 +3041,66 @@ q[x] { p[x] }`,
 	}
 }
 
+func TestPoliciesPutV1MalformedAnnotations(t *testing.T) {
+	policy := `package test
+
+# METADATA
+# title: a: b
+p := 1`
+
+	// Annotations are not processed unless the manager is configured to.
+	f := newFixture(t)
+	if err := f.v1(http.MethodPut, "/policies/test", policy, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodGet, "/data/test/p", "", 200, `{"result": 1}`); err != nil {
+		t.Fatal(err)
+	}
+
+	f = newFixtureWithParserOptions(t, ast.ParserOptions{ProcessAnnotation: true})
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodPut, "/policies/test", policy))
+	if f.recorder.Code != 400 || !strings.Contains(f.recorder.Body.String(), "yaml") {
+		t.Fatalf("Expected annotation error but got %v", f.recorder)
+	}
+}
+
+func TestPoliciesPutV1PrivateRule(t *testing.T) {
+	f := newFixtureWithParserOptions(t, ast.ParserOptions{ProcessAnnotation: true})
+
+	if err := f.v1(http.MethodPut, "/policies/lib", `package lib
+
+# METADATA
+# private: true
+helper := 1
+
+p := helper`, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	req := newReqV1(http.MethodPut, "/policies/app", `package app
+
+q := data.lib.helper`)
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, req)
+
+	if f.recorder.Code != 400 {
+		t.Fatalf("Expected bad request but got %v", f.recorder)
+	}
+
+	exp := "data.lib.helper refers to private rule data.lib.helper which is only visible within data.lib"
+	if !strings.Contains(f.recorder.Body.String(), exp) {
+		t.Fatalf("Expected error containing %q but got %v", exp, f.recorder.Body.String())
+	}
+
+	// Public rules in the same package can still be referenced.
+	if err := f.v1(http.MethodPut, "/policies/app", `package app
+
+q := data.lib.p`, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPoliciesPutV1Noop(t *testing.T) {
 	f := newFixture(t)
 	err := f.v1("PUT", "/policies/test?metrics", `package foo`, 200, "")
@@ -3249,6 +3411,113 @@ func TestStatusV1(t *testing.T) {
 	}
 }
 
+func TestProfileV1(t *testing.T) {
+	f := newFixture(t)
+
+	// Expect HTTP 404 if decisions are not sampled
+	for _, path := range []string{"/profile", "/profile/pprof"} {
+		if err := f.v1(http.MethodGet, path, "", 404, `{"code": "profiling_disabled", "message": "decision profiling not enabled"}`); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f = newFixtureWithConfig(t, `{"server":{"profiling":{"sample_rate": 1, "window_seconds": 60}}}`)
+
+	if err := f.v1(http.MethodPut, "/policies/test", `package test
+
+p {
+	input.x == 1
+	q
+}
+
+q {
+	count([1, 2, 3]) == 3
+}
+`, 200, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodPost, "/data/test/p", `{"input": {"x": 1}}`, 200, `{"result": true}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v1(http.MethodGet, "/data/test/p?input=%7B%22x%22%3A1%7D", "", 200, `{"result": true}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.v0(http.MethodPost, "/data/test/p", `{"x": 2}`, 404, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.v1(http.MethodGet, "/profile", "", 200, ""); err != nil {
+		t.Fatal(err)
+	}
+	var resp types.ProfileResponseV1
+	if err := util.NewJSONDecoder(f.recorder.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result == nil || resp.Result.SampleRate != 1 || resp.Result.WindowSeconds != 60 || len(resp.Result.Paths) != 1 {
+		t.Fatalf("Expected profile of one decision path but got %+v", resp.Result)
+	}
+	dp := resp.Result.Paths[0]
+	if dp.Path != "data.test.p" || dp.Decisions != 3 {
+		t.Fatalf("Expected 3 decisions for data.test.p but got %v for %v", dp.Decisions, dp.Path)
+	}
+	rows := map[int]int{}
+	for _, stat := range dp.Exprs {
+		if stat.Location.File == "test" {
+			rows[stat.Location.Row] = stat.NumEval
+		}
+	}
+	// The rule is not evaluated for the input of the v0 decision.
+	if rows[4] != 2 || rows[9] == 0 {
+		t.Fatalf("Expected evaluations of the expressions of test but got %v", dp.Exprs)
+	}
+
+	f.reset()
+	f.server.Handler.ServeHTTP(f.recorder, newReqV1(http.MethodGet, "/profile/pprof", ""))
+	if f.recorder.Code != 200 {
+		t.Fatalf("Expected pprof profile but got %v", f.recorder)
+	}
+	gr, err := gzip.NewReader(f.recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The string table of the profile holds the names of the decision path
+	// and the expressions.
+	strs := map[string]bool{}
+	for len(bs) > 0 {
+		tag, n := binary.Uvarint(bs)
+		if n <= 0 {
+			t.Fatalf("invalid tag at %d", len(bs))
+		}
+		bs = bs[n:]
+		v, n := binary.Uvarint(bs)
+		if n <= 0 {
+			t.Fatalf("invalid field value at %d", len(bs))
+		}
+		bs = bs[n:]
+		switch tag & 7 {
+		case 0: // varint
+		case 2: // length-delimited
+			if tag>>3 == 6 {
+				strs[string(bs[:v])] = true
+			}
+			bs = bs[v:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+	}
+	for _, s := range []string{"data.test.p", "test:4", "test:9", "time", "nanoseconds"} {
+		if !strs[s] {
+			t.Errorf("Expected %q in string table but got %v", s, strs)
+		}
+	}
+}
+
 func TestStatusV1MetricsWithSystemAuthzPolicy(t *testing.T) {
 
 	ctx := context.Background()
@@ -4376,6 +4645,32 @@ func newFixture(t *testing.T, opts ...func(*Server)) *fixture {
 	}
 }
 
+func newFixtureWithParserOptions(t *testing.T, popts ast.ParserOptions) *fixture {
+	ctx := context.Background()
+	store := inmem.New()
+	m, err := plugins.New([]byte{}, "test", store, plugins.WithParserOptions(popts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	server, err := New().
+		WithAddresses([]string{"localhost:8182"}).
+		WithStore(store).
+		WithManager(m).
+		Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fixture{
+		server:   server,
+		recorder: httptest.NewRecorder(),
+		t:        t,
+	}
+}
+
 func newFixtureWithConfig(t *testing.T, config string, opts ...func(*Server)) *fixture {
 	ctx := context.Background()
 	server := New().
@@ -4513,6 +4808,9 @@ type variant struct {
 
 func executeRequests(t *testing.T, reqs []tr, variants ...variant) {
 	t.Helper()
+	if len(variants) == 0 {
+		variants = []variant{{name: "default"}}
+	}
 	for _, v := range variants {
 		t.Run(v.name, func(t *testing.T) {
 			f := newFixture(t, v.opts...)
@@ -12,6 +12,8 @@ import (
 	"strings"
 
 	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/filter"
+	"github.com/open-policy-agent/opa/profiler"
 	"github.com/open-policy-agent/opa/topdown"
 	"github.com/open-policy-agent/opa/util"
 )
@@ -26,6 +28,7 @@ const (
 	CodeResourceNotFound  = "resource_not_found"
 	CodeResourceConflict  = "resource_conflict"
 	CodeUndefinedDocument = "undefined_document"
+	CodeProfilingDisabled = "profiling_disabled"
 )
 
 // ErrorV1 models an error response sent to the client.
@@ -63,6 +66,24 @@ func (e *ErrorV1) WithASTErrors(errors []*ast.Error) *ErrorV1 {
 	return e
 }
 
+// WithTopdownErrors updates e to include detailed evaluation errors.
+func (e *ErrorV1) WithTopdownErrors(errors topdown.Errors) *ErrorV1 {
+	e.Errors = make([]error, len(errors))
+	for i := range e.Errors {
+		e.Errors[i] = errors[i]
+	}
+	return e
+}
+
+// WithFilterErrors updates e to include detailed translation errors.
+func (e *ErrorV1) WithFilterErrors(errors filter.Errors) *ErrorV1 {
+	e.Errors = make([]error, len(errors))
+	for i := range e.Errors {
+		e.Errors[i] = errors[i]
+	}
+	return e
+}
+
 // Bytes marshals e with indentation for readability.
 func (e *ErrorV1) Bytes() []byte {
 	bs, _ := json.MarshalIndent(e, "", "  ")
@@ -75,6 +96,8 @@ const (
 	MsgParseQueryError            = "error(s) occurred while parsing query"
 	MsgCompileQueryError          = "error(s) occurred while compiling query"
 	MsgEvaluationError            = "error(s) occurred while evaluating query"
+	MsgUnsupportedPartialError    = "partial evaluation could not expand query into flat queries"
+	MsgTranslationError           = "error(s) occurred while translating partial evaluation result"
 	MsgUnauthorizedUndefinedError = "authorization policy missing or undefined"
 	MsgUnauthorizedError          = "request rejected by administrative policy"
 	MsgUndefinedError             = "document missing or undefined"
@@ -372,7 +395,10 @@ type CompileRequestV1 struct {
 	Query    string       `json:"query"`
 	Unknowns *[]string    `json:"unknowns"`
 	Options  struct {
-		DisableInlining []string `json:"disableInlining,omitempty"`
+		DisableInlining   []string                `json:"disableInlining,omitempty"`
+		StrictPartialEval bool                    `json:"strictPartialEval,omitempty"`
+		Target            string                  `json:"target,omitempty"`
+		TargetMappings    map[string]filter.Table `json:"targetMappings,omitempty"`
 	} `json:"options,omitempty"`
 }
 
@@ -406,6 +432,28 @@ type StatusResponseV1 struct {
 	Result *interface{} `json:"result,omitempty"`
 }
 
+// ProfileResponseV1 models the response message for the Profile API.
+type ProfileResponseV1 struct {
+	Result *ProfileV1 `json:"result,omitempty"`
+}
+
+// ProfileV1 models the expression timings of the decisions sampled by the
+// server over a rolling window.
+type ProfileV1 struct {
+	SampleRate    float64             `json:"sample_rate"`
+	WindowSeconds int                 `json:"window_seconds"`
+	Paths         []DecisionProfileV1 `json:"paths"`
+}
+
+// DecisionProfileV1 models the expression timings of the decisions sampled
+// for a path.
+type DecisionProfileV1 struct {
+	Path        string               `json:"path"`
+	Decisions   int                  `json:"decisions"`
+	TotalTimeNs int64                `json:"total_time_ns"`
+	Exprs       []profiler.ExprStats `json:"exprs"`
+}
+
 // HealthResponseV1 models the response message for Health API operations.
 type HealthResponseV1 struct {
 	Error string `json:"error,omitempty"`
@@ -290,6 +290,11 @@ func (db *store) Register(_ context.Context, txn storage.Transaction, config sto
 	return h, nil
 }
 
+// ConcurrentReads returns true because reads do not modify transactions.
+func (db *store) ConcurrentReads() bool {
+	return true
+}
+
 func (db *store) Read(_ context.Context, txn storage.Transaction, path storage.Path) (interface{}, error) {
 	underlying, err := db.underlying(txn)
 	if err != nil {
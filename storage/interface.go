@@ -49,6 +49,12 @@ type MakeDirer interface {
 	MakeDir(context.Context, Transaction, Path) error
 }
 
+// ConcurrentReader defines the interface a Store could realize to indicate
+// that a transaction may be read from multiple goroutines at the same time.
+type ConcurrentReader interface {
+	ConcurrentReads() bool
+}
+
 // TransactionParams describes a new transaction.
 type TransactionParams struct {
 
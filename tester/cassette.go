@@ -0,0 +1,158 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// CassetteDir is the name of the directories the calls of non-deterministic
+// built-in functions, e.g., http.send or time.now_ns, recorded by tests are
+// stored in. The cassettes of a test file are stored in a directory named
+// after the file, e.g., the calls recorded by test_x in authz_test.rego are
+// stored in __cassettes__/authz_test/test_x.json.
+//
+// Cassettes use the format of the non-deterministic builtin cache in decision
+// logs. If a test has a cassette, the recorded results are returned by the
+// calls instead of evaluating them, and calls that were not recorded fail.
+const CassetteDir = "__cassettes__"
+
+// cassetteFile returns the file the calls recorded by the named test declared
+// by the rule are stored in, or an empty string if the rule was not loaded
+// from a file.
+func cassetteFile(rule *ast.Rule, name string) string {
+	loc := rule.Loc()
+	if loc == nil || loc.File == "" {
+		return ""
+	}
+	base := strings.TrimSuffix(filepath.Base(loc.File), filepath.Ext(loc.File))
+	return filepath.Join(filepath.Dir(loc.File), CassetteDir, base, snapshotFileName(name)+".json")
+}
+
+// cassetteOptions returns the options recording or replaying the calls of the
+// named test. The returned function stores the recorded calls and must be
+// called once the test has been evaluated.
+func (r *Runner) cassetteOptions(rule *ast.Rule, name string) ([]func(*rego.Rego), func() error, error) {
+	done := func() error { return nil }
+
+	file := cassetteFile(rule, name)
+	if file == "" {
+		return nil, done, nil
+	}
+
+	if r.recordCassettes {
+		// The calls of all queries of a test, e.g., the runs of a property
+		// test, are recorded in the same cassette.
+		cache, ok := r.cassettes[file]
+		if !ok {
+			cache = builtins.NDBCache{}
+			if r.cassettes == nil {
+				r.cassettes = map[string]builtins.NDBCache{}
+			}
+			r.cassettes[file] = cache
+		}
+		return []func(*rego.Rego){rego.NDBuiltinCache(cache)}, func() error {
+			return writeCassette(file, cache)
+		}, nil
+	}
+
+	cache, ok := r.cassettes[file]
+	if !ok {
+		var err error
+		if cache, err = readCassette(file); err != nil {
+			return nil, done, err
+		}
+		if r.cassettes == nil {
+			r.cassettes = map[string]builtins.NDBCache{}
+		}
+		r.cassettes[file] = cache
+	}
+	if cache == nil {
+		return nil, done, nil
+	}
+
+	return []func(*rego.Rego){rego.NDBuiltinCache(cache), rego.NDBuiltinCacheReplay(true)}, done, nil
+}
+
+// readCassette reads the calls recorded in the file, or returns nil if the
+// file does not exist.
+func readCassette(file string) (builtins.NDBCache, error) {
+	bs, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var cache builtins.NDBCache
+	if err := util.UnmarshalJSON(bs, &cache); err != nil {
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+
+	// The arguments of the calls are encoded as JSON object keys, so they are
+	// decoded into arrays again to look up the calls.
+	for name, calls := range cache {
+		decoded := ast.NewObject()
+		err := calls.Iter(func(k, v *ast.Term) error {
+			s, ok := k.Value.(ast.String)
+			if !ok {
+				decoded.Insert(k, v)
+				return nil
+			}
+			var args interface{}
+			if err := util.UnmarshalJSON([]byte(s), &args); err != nil {
+				return err
+			}
+			a, err := ast.InterfaceToValue(args)
+			if err != nil {
+				return err
+			}
+			decoded.Insert(ast.NewTerm(a), v)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v: invalid arguments: %w", file, name, err)
+		}
+		cache[name] = decoded
+	}
+
+	return cache, nil
+}
+
+// writeCassette stores the recorded calls in the file. If no calls were
+// recorded, the file is removed.
+func writeCassette(file string, cache builtins.NDBCache) error {
+	if len(cache) == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	bs, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bs, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, buf.Bytes(), 0o644)
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+func TestRecordAndReplayCassettes(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// The responses are recorded from http.send mocks, so that the replayed
+	// calls can be told apart from calls that are sent.
+	mocks := filepath.Join(dir, FixtureDir, "authz", "http_send.json")
+	if err := os.MkdirAll(filepath.Dir(mocks), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mocks, []byte(`[{"request": {"url": "https://users.example.com/alice"}, "response": {"body": {"role": "admin"}}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "authz_test.rego")
+	src := `package authz
+import rego.v1
+
+user(name) := http.send({"method": "GET", "url": sprintf("https://users.example.com/%s", [name])}).body
+
+test_user if user("alice").role == "admin"
+
+test_now if time.now_ns() > 0
+
+test_pure if 1 + 1 == 2
+`
+
+	run := func(record bool, src string) map[string]*Result {
+		t.Helper()
+		module, err := ast.ParseModuleWithOpts(file, src, ast.ParserOptions{RegoVersion: ast.RegoV1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := inmem.New()
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+
+		ch, err := NewRunner().
+			SetStore(store).
+			SetModules(map[string]*ast.Module{file: module}).
+			RaiseBuiltinErrors(true).
+			RecordCassettes(record).
+			RunTests(ctx, txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := map[string]*Result{}
+		for tr := range ch {
+			results[tr.Name] = tr
+		}
+		return results
+	}
+
+	for name, tr := range run(true, src) {
+		if !tr.Pass() {
+			t.Fatalf("Expected %v to pass while recording but got %v", name, tr)
+		}
+	}
+
+	cassettes := filepath.Join(dir, CassetteDir, "authz_test")
+	for _, name := range []string{"test_user", "test_now"} {
+		if _, err := os.Stat(filepath.Join(cassettes, name+".json")); err != nil {
+			t.Fatalf("Expected cassette for %v: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(cassettes, "test_pure.json")); !os.IsNotExist(err) {
+		t.Fatalf("Expected no cassette for test_pure but got %v", err)
+	}
+
+	cache, err := readCassette(filepath.Join(cassettes, "test_now.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, ok := cache.Get("time.now_ns", ast.NewArray())
+	if !ok {
+		t.Fatalf("Expected recorded time.now_ns call but got %v", cache)
+	}
+
+	if err := os.Remove(mocks); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := strings.Replace(src, "test_now if time.now_ns() > 0", "test_now if time.now_ns() == "+recorded.String(), 1)
+	replayed = strings.Replace(replayed, `test_user if user("alice").role == "admin"`, `test_user if {
+	user("alice").role == "admin"
+	user("bob")
+}`, 1)
+
+	results := run(false, replayed)
+	if tr := results["test_now"]; !tr.Pass() {
+		t.Errorf("Expected test_now to replay recorded time %v but got %v", recorded, tr)
+	}
+	if tr := results["test_pure"]; !tr.Pass() {
+		t.Errorf("Expected test_pure to pass but got %v", tr)
+	}
+	tr := results["test_user"]
+	if tr.Error == nil || !strings.Contains(tr.Error.Error(), "http.send: no recorded result for arguments") || !strings.Contains(tr.Error.Error(), "https://users.example.com/bob") {
+		t.Errorf("Expected unrecorded call error but got %v", tr)
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// FixtureDir is the name of the directories test fixtures are stored in. The
+// fixtures of a package are stored in a directory named after the package,
+// next to its test files, e.g., the fixtures of the tests in package
+// authz.users are stored in __fixtures__/authz.users. The fixtures of a single
+// test are stored in a directory named after the test inside the package's
+// directory, e.g., __fixtures__/authz.users/test_admin.
+//
+// A fixture directory may contain the following files, encoded as JSON or
+// YAML:
+//
+//   - data.json: merged into the data of the tests.
+//   - input.json: bound as the input of the tests.
+//   - http_send.json: a list of responses returned by http.send, see
+//     HTTPSendMock. If any are set, http.send fails for requests that have no
+//     matching response instead of sending them.
+//
+// The fixtures of a test take precedence over the fixtures of its package.
+const FixtureDir = "__fixtures__"
+
+const (
+	fixtureDataFile     = "data"
+	fixtureInputFile    = "input"
+	fixtureHTTPSendFile = "http_send"
+)
+
+var fixtureExts = []string{".json", ".yaml", ".yml"}
+
+// HTTPSendMock is a response returned by http.send for the requests matching
+// the method and URL in a fixture. If the method is empty, requests with any
+// method match.
+type HTTPSendMock struct {
+	Request struct {
+		Method string `json:"method,omitempty"`
+		URL    string `json:"url"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int               `json:"status_code,omitempty"`
+		Headers    map[string]string `json:"headers,omitempty"`
+		// Body is encoded as JSON, RawBody is returned as is.
+		Body    interface{} `json:"body,omitempty"`
+		RawBody *string     `json:"raw_body,omitempty"`
+	} `json:"response"`
+}
+
+func (m *HTTPSendMock) matches(req *http.Request) bool {
+	return (m.Request.Method == "" || strings.EqualFold(m.Request.Method, req.Method)) && m.Request.URL == req.URL.String()
+}
+
+func (m *HTTPSendMock) response(req *http.Request) (*http.Response, error) {
+	status := m.Response.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := http.Header{}
+	for k, v := range m.Response.Headers {
+		header.Set(k, v)
+	}
+
+	var body []byte
+	switch {
+	case m.Response.RawBody != nil:
+		body = []byte(*m.Response.RawBody)
+	case m.Response.Body != nil:
+		var err error
+		if body, err = json.Marshal(m.Response.Body); err != nil {
+			return nil, err
+		}
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/json")
+		}
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// fixture holds the files loaded from a fixture directory.
+type fixture struct {
+	data  map[string]interface{}
+	input ast.Value
+	mocks []*HTTPSendMock
+}
+
+// loadFixture loads the fixture directory. Loaded directories are cached, so
+// that the fixtures of a package are only read once. If the directory does not
+// exist, the fixture is empty.
+func (r *Runner) loadFixture(dir string) (*fixture, error) {
+	if f, ok := r.fixtures[dir]; ok {
+		return f, nil
+	}
+
+	f := &fixture{}
+
+	bs, file, err := readFixtureFile(dir, fixtureDataFile)
+	if err != nil {
+		return nil, err
+	} else if bs != nil {
+		if err := util.Unmarshal(bs, &f.data); err != nil {
+			return nil, fmt.Errorf("%v: data fixture must be an object: %w", file, err)
+		}
+	}
+
+	bs, file, err = readFixtureFile(dir, fixtureInputFile)
+	if err != nil {
+		return nil, err
+	} else if bs != nil {
+		var input interface{}
+		if err := util.Unmarshal(bs, &input); err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+		if f.input, err = ast.InterfaceToValue(input); err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+	}
+
+	bs, file, err = readFixtureFile(dir, fixtureHTTPSendFile)
+	if err != nil {
+		return nil, err
+	} else if bs != nil {
+		if err := util.Unmarshal(bs, &f.mocks); err != nil {
+			return nil, fmt.Errorf("%v: %w", file, err)
+		}
+		for i, m := range f.mocks {
+			if m == nil || m.Request.URL == "" {
+				return nil, fmt.Errorf("%v: http.send mock %d: missing request url", file, i)
+			}
+		}
+	}
+
+	if r.fixtures == nil {
+		r.fixtures = map[string]*fixture{}
+	}
+	r.fixtures[dir] = f
+	return f, nil
+}
+
+// readFixtureFile returns the contents and path of the named fixture file in
+// the directory, or nil if there is no such file.
+func readFixtureFile(dir, name string) ([]byte, string, error) {
+	for _, ext := range fixtureExts {
+		file := filepath.Join(dir, name+ext)
+		bs, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, "", err
+		}
+		return bs, file, nil
+	}
+	return nil, "", nil
+}
+
+// fixtureOptions returns the options applying the fixtures of the package and
+// of the test declared by the rule to the test's queries. The returned
+// function must be called once the test has run.
+func (r *Runner) fixtureOptions(ctx context.Context, txn storage.Transaction, mod *ast.Module, rule *ast.Rule) ([]func(*rego.Rego), func(), error) {
+	done := func() {}
+
+	loc := rule.Loc()
+	if loc == nil || loc.File == "" {
+		return nil, done, nil
+	}
+
+	dir := filepath.Join(filepath.Dir(loc.File), FixtureDir, strings.TrimPrefix(mod.Package.Path.String(), "data."))
+	pkg, err := r.loadFixture(dir)
+	if err != nil {
+		return nil, done, err
+	}
+	test, err := r.loadFixture(filepath.Join(dir, ruleName(rule.Head)))
+	if err != nil {
+		return nil, done, err
+	}
+
+	var options []func(*rego.Rego)
+
+	if pkg.data != nil || test.data != nil {
+		base, err := r.store.Read(ctx, txn, storage.Path{})
+		if err != nil {
+			return nil, done, err
+		}
+		obj, ok := base.(map[string]interface{})
+		if !ok {
+			return nil, done, fmt.Errorf("data fixtures require a store with object data")
+		}
+		store := inmem.NewFromObjectWithOpts(mergeFixtureData(mergeFixtureData(obj, pkg.data), test.data), inmem.OptRoundTripOnWrite(false))
+		ftxn, err := store.NewTransaction(ctx)
+		if err != nil {
+			return nil, done, err
+		}
+		done = func() { store.Abort(ctx, ftxn) }
+		options = append(options, rego.Store(store), rego.Transaction(ftxn))
+	}
+
+	if test.input != nil {
+		options = append(options, rego.ParsedInput(test.input))
+	} else if pkg.input != nil {
+		options = append(options, rego.ParsedInput(pkg.input))
+	}
+
+	if mocks := append(append([]*HTTPSendMock{}, test.mocks...), pkg.mocks...); len(mocks) > 0 {
+		options = append(options, rego.HTTPSendTransport(httpSendMockTransport(mocks)))
+	}
+
+	return options, done, nil
+}
+
+// mergeFixtureData returns the result of merging b into a, without modifying
+// either. Objects are merged recursively, other values in b replace the values
+// in a.
+func mergeFixtureData(a, b map[string]interface{}) map[string]interface{} {
+	if len(b) == 0 {
+		return a
+	}
+	result := make(map[string]interface{}, len(a)+len(b))
+	for k, v := range a {
+		result[k] = v
+	}
+	for k, v := range b {
+		if x, ok := result[k].(map[string]interface{}); ok {
+			if y, ok := v.(map[string]interface{}); ok {
+				result[k] = mergeFixtureData(x, y)
+				continue
+			}
+		}
+		result[k] = v
+	}
+	return result
+}
+
+// httpSendMockTransport answers the requests issued by http.send with the
+// first matching mock, instead of sending them.
+type httpSendMockTransport []*HTTPSendMock
+
+func (mocks httpSendMockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, m := range mocks {
+		if m.matches(req) {
+			return m.response(req)
+		}
+	}
+	return nil, fmt.Errorf("no http.send mock matches request %v %v", req.Method, req.URL)
+}
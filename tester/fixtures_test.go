@@ -0,0 +1,134 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestRunTestsWithFixtures(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"__fixtures__/authz.users/data.json":                    `{"users": {"alice": {"role": "admin"}, "bob": {"role": "viewer"}}, "limits": {"max": 10}}`,
+		"__fixtures__/authz.users/input.yaml":                   "user: alice\n",
+		"__fixtures__/authz.users/test_bob/input.json":          `{"user": "bob"}`,
+		"__fixtures__/authz.users/test_bob/data.yaml":           "limits:\n  min: 1\n",
+		"__fixtures__/authz.users/test_unmocked/http_send.json": `[{"request": {"url": "https://users.example.com/alice"}, "response": {}}]`,
+		"__fixtures__/authz.users/test_fetch/http_send.yaml": `
+- request:
+    method: GET
+    url: https://users.example.com/alice
+  response:
+    body: {"name": "alice", "roles": ["admin"]}
+- request:
+    url: https://users.example.com/carol
+  response:
+    status_code: 404
+    raw_body: not found
+`,
+	}
+	for file, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file := filepath.Join(dir, "users_test.rego")
+	module, err := ast.ParseModuleWithOpts(file, `package authz.users
+import rego.v1
+
+role := data.users[input.user].role
+
+fetch(name) := http.send({"method": "GET", "url": sprintf("https://users.example.com/%s", [name]), "raise_error": false})
+
+test_alice if {
+	role == "admin"
+	data.limits.max == 10
+	data.base == "store"
+}
+
+test_bob if {
+	role == "viewer"
+	data.limits == {"min": 1, "max": 10}
+}
+
+test_with_input if {
+	role == "viewer" with input.user as "bob"
+}
+
+test_fetch if {
+	fetch("alice").body.roles == ["admin"]
+	fetch("carol").status_code == 404
+	fetch("carol").raw_body == "not found"
+}
+
+test_unmocked if {
+	http.send({"method": "GET", "url": "https://users.example.com/dave"})
+}
+`, ast.ParserOptions{RegoVersion: ast.RegoV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := inmem.NewFromObject(map[string]interface{}{"base": "store"})
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	ch, err := NewRunner().SetStore(store).SetModules(map[string]*ast.Module{file: module}).RaiseBuiltinErrors(true).RunTests(ctx, txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := map[string]*Result{}
+	for tr := range ch {
+		results[tr.Name] = tr
+	}
+
+	for _, name := range []string{"test_alice", "test_bob", "test_with_input", "test_fetch"} {
+		if tr := results[name]; !tr.Pass() {
+			t.Errorf("Expected %v to pass but got %v", name, tr)
+		}
+	}
+
+	tr := results["test_unmocked"]
+	if tr.Error == nil || !strings.Contains(tr.Error.Error(), "no http.send mock matches request GET https://users.example.com/dave") {
+		t.Errorf("Expected unmocked request error but got %v", tr)
+	}
+
+	// Fixtures are not written to the store.
+	if _, err := store.Read(ctx, txn, storage.MustParsePath("/users")); !storage.IsNotFound(err) {
+		t.Errorf("Expected fixture data to be absent from store but got %v", err)
+	}
+}
+
+func TestMergeFixtureData(t *testing.T) {
+	a := util.MustUnmarshalJSON([]byte(`{"x": {"y": 1, "z": [1]}, "w": 2}`)).(map[string]interface{})
+	b := util.MustUnmarshalJSON([]byte(`{"x": {"z": [2], "v": {"u": 3}}, "w": {"t": 4}}`)).(map[string]interface{})
+
+	result := mergeFixtureData(a, b)
+
+	exp := util.MustUnmarshalJSON([]byte(`{"x": {"y": 1, "z": [2], "v": {"u": 3}}, "w": {"t": 4}}`))
+	if util.Compare(result, exp) != 0 {
+		t.Fatalf("Expected %v but got %v", exp, result)
+	}
+	if orig := util.MustUnmarshalJSON([]byte(`{"x": {"y": 1, "z": [1]}, "w": 2}`)); util.Compare(a, orig) != 0 {
+		t.Fatalf("Expected merge to leave %v unchanged but got %v", orig, a)
+	}
+}
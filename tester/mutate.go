@@ -0,0 +1,397 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// Mutation operators applied to the policies under test.
+const (
+	// MutateFlipComparison replaces a comparison operator, e.g., < with <=.
+	MutateFlipComparison = "flip-comparison"
+
+	// MutateNegateExpression negates an expression, or removes the negation
+	// of a negated expression.
+	MutateNegateExpression = "negate-expression"
+
+	// MutateDropExpression removes an expression from a rule body.
+	MutateDropExpression = "drop-expression"
+
+	// MutateSwapConstant replaces a boolean, number or string constant.
+	MutateSwapConstant = "swap-constant"
+)
+
+// Mutant states.
+const (
+	// MutantKilled indicates that at least one test failed for the mutant.
+	MutantKilled = "killed"
+
+	// MutantSurvived indicates that all tests passed for the mutant, i.e.,
+	// the tests do not verify the behavior the mutated code implements.
+	MutantSurvived = "survived"
+
+	// MutantInvalid indicates that the mutant could not be compiled.
+	MutantInvalid = "invalid"
+)
+
+// Mutant is a single, small modification of a policy under test.
+type Mutant struct {
+	Location *ast.Location `json:"location"`
+	Operator string        `json:"operator"`
+	Original string        `json:"original"`
+	Mutation string        `json:"mutation"`
+	Status   string        `json:"status,omitempty"`
+
+	// KilledBy is the name of the first test that failed for the mutant.
+	KilledBy string `json:"killed_by,omitempty"`
+
+	file string
+	site int
+}
+
+func (m *Mutant) String() string {
+	return fmt.Sprintf("%v: %v: %v -> %v", m.Location, m.Operator, m.Original, m.Mutation)
+}
+
+// Apply returns a copy of the modules with the mutant's module replaced by
+// its mutated copy. The modules themselves are not modified.
+func (m *Mutant) Apply(modules map[string]*ast.Module) map[string]*ast.Module {
+	result := make(map[string]*ast.Module, len(modules))
+	for file, module := range modules {
+		result[file] = module
+	}
+	module := modules[m.file].Copy()
+	mutationSites(module)[m.site].apply()
+	result[m.file] = module
+	return result
+}
+
+// Mutants returns the mutants of the given modules. Test rules and files
+// ending in _test.rego are not mutated.
+func Mutants(modules map[string]*ast.Module) []*Mutant {
+	files := make([]string, 0, len(modules))
+	for file := range modules {
+		if !strings.HasSuffix(file, "_test.rego") {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	var result []*Mutant
+	for _, file := range files {
+		for i, s := range mutationSites(modules[file]) {
+			result = append(result, &Mutant{
+				Location: s.loc,
+				Operator: s.operator,
+				Original: s.original,
+				Mutation: s.mutation,
+				file:     file,
+				site:     i,
+			})
+		}
+	}
+	return result
+}
+
+// RunMutants runs the tests for each of the mutants and sets their status.
+// As compilers cannot be reused, the runner function is called for every
+// mutant with the mutated modules and must return a new Runner.
+func RunMutants(ctx context.Context, txn storage.Transaction, modules map[string]*ast.Module, mutants []*Mutant, runner func(map[string]*ast.Module) (*Runner, error)) error {
+	for _, m := range mutants {
+		r, err := runner(m.Apply(modules))
+		if err != nil {
+			return err
+		}
+
+		ch, err := r.RunTests(ctx, txn)
+		if err != nil {
+			m.Status = MutantInvalid
+			continue
+		}
+
+		m.Status = MutantSurvived
+		for tr := range ch {
+			if m.Status == MutantSurvived && (tr.Fail || tr.Error != nil) {
+				m.Status = MutantKilled
+				m.KilledBy = tr.Package + "." + tr.Name
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutationSite is a single mutation of a module. Sites are enumerated in a
+// deterministic order, so the sites of a module's copy match the original's.
+type mutationSite struct {
+	operator string
+	loc      *ast.Location
+	original string
+	mutation string
+	apply    func()
+}
+
+var flippedComparisons = map[string]*ast.Builtin{
+	ast.Equal.Name:         ast.NotEqual,
+	ast.NotEqual.Name:      ast.Equal,
+	ast.LessThan.Name:      ast.LessThanEq,
+	ast.LessThanEq.Name:    ast.LessThan,
+	ast.GreaterThan.Name:   ast.GreaterThanEq,
+	ast.GreaterThanEq.Name: ast.GreaterThan,
+}
+
+func mutationSites(module *ast.Module) []mutationSite {
+	var sites []mutationSite
+	for _, rule := range module.Rules {
+		if name := ruleName(rule.Head); strings.HasPrefix(name, TestPrefix) || strings.HasPrefix(name, SkipTestPrefix) {
+			continue
+		}
+		for r := rule; r != nil; r = r.Else {
+			if r.Head.Value != nil {
+				sites = append(sites, constantSites(r.Head.Value)...)
+			}
+			// Comprehensions in the head are mutated as well.
+			for _, x := range []interface{}{r.Head, r.Body} {
+				ast.WalkBodies(x, func(body ast.Body) bool {
+					for i := range body {
+						sites = append(sites, exprSites(body, i)...)
+					}
+					return false
+				})
+			}
+		}
+	}
+	return sites
+}
+
+func exprSites(body ast.Body, i int) []mutationSite {
+	expr := body[i]
+	if !hasFileLocation(expr.Location) {
+		return nil
+	}
+
+	// Rule bodies that are always true, e.g., of constants, do not contain
+	// any logic to mutate.
+	if t, ok := expr.Terms.(*ast.Term); ok && !expr.Negated && len(expr.With) == 0 && t.Value.Compare(ast.Boolean(true)) == 0 {
+		return nil
+	}
+
+	var sites []mutationSite
+
+	if terms, ok := expr.Terms.([]*ast.Term); ok && !expr.Negated {
+		if flipped, ok := flippedComparisons[expr.Operator().String()]; ok {
+			op := ast.NewTerm(flipped.Ref()).SetLocation(terms[0].Location)
+			mutated := expr.Copy()
+			mutated.Terms.([]*ast.Term)[0] = op
+			sites = append(sites, mutationSite{
+				operator: MutateFlipComparison,
+				loc:      expr.Location,
+				original: formatNode(expr),
+				mutation: formatNode(mutated),
+				apply: func() {
+					terms[0] = op
+				},
+			})
+		}
+	}
+
+	switch expr.Terms.(type) {
+	case []*ast.Term, *ast.Term:
+		if !expr.IsAssignment() && !expr.IsEquality() {
+			mutated := expr.Copy()
+			mutated.Negated = !mutated.Negated
+			sites = append(sites, mutationSite{
+				operator: MutateNegateExpression,
+				loc:      expr.Location,
+				original: formatNode(expr),
+				mutation: formatNode(mutated),
+				apply: func() {
+					expr.Negated = !expr.Negated
+				},
+			})
+		}
+	}
+
+	if _, ok := expr.Terms.(*ast.SomeDecl); !ok {
+		// Replacing the expression with true is equivalent to removing it,
+		// but keeps the body non-empty.
+		replacement := ast.NewExpr(ast.BooleanTerm(true)).SetLocation(expr.Location)
+		replacement.Index = expr.Index
+		sites = append(sites, mutationSite{
+			operator: MutateDropExpression,
+			loc:      expr.Location,
+			original: formatNode(expr),
+			mutation: formatNode(replacement),
+			apply: func() {
+				body[i] = replacement
+			},
+		})
+	}
+
+	switch terms := expr.Terms.(type) {
+	case []*ast.Term:
+		for _, t := range terms {
+			sites = append(sites, constantSites(t)...)
+		}
+	case *ast.Term:
+		sites = append(sites, constantSites(terms)...)
+	}
+
+	return sites
+}
+
+// constantSites returns the sites of the constants in x. Constants in
+// references, with modifiers, object keys and sets are not mutated, neither
+// are constants in nested bodies, which are mutated on their own.
+func constantSites(x interface{}) []mutationSite {
+	var sites []mutationSite
+	var vis *ast.GenericVisitor
+	vis = ast.NewGenericVisitor(func(x interface{}) bool {
+		switch x := x.(type) {
+		case ast.Ref, ast.Set, *ast.With, *ast.ArrayComprehension, *ast.SetComprehension, *ast.ObjectComprehension:
+			return true
+		case ast.Object:
+			x.Foreach(func(_, v *ast.Term) {
+				vis.Walk(v)
+			})
+			return true
+		case *ast.Term:
+			if !hasFileLocation(x.Location) {
+				return false
+			}
+			if value := swapConstant(x.Value); value != nil {
+				term := x
+				sites = append(sites, mutationSite{
+					operator: MutateSwapConstant,
+					loc:      term.Location,
+					original: term.String(),
+					mutation: value.String(),
+					apply: func() {
+						term.Value = value
+					},
+				})
+			}
+		}
+		return false
+	})
+	vis.Walk(x)
+	return sites
+}
+
+// formatNode returns the formatted source of x.
+func formatNode(x interface{}) string {
+	bs, err := format.Ast(x)
+	if err != nil {
+		return fmt.Sprint(x)
+	}
+	return strings.TrimSpace(string(bs))
+}
+
+func swapConstant(v ast.Value) ast.Value {
+	switch v := v.(type) {
+	case ast.Boolean:
+		return !v
+	case ast.Number:
+		if i, ok := v.Int(); ok {
+			return ast.IntNumberTerm(i + 1).Value
+		}
+		if f, ok := v.Float64(); ok {
+			return ast.FloatNumberTerm(f + 1).Value
+		}
+	case ast.String:
+		if v == "" {
+			return ast.String("mutant")
+		}
+		return ast.String("")
+	}
+	return nil
+}
+
+func hasFileLocation(loc *ast.Location) bool {
+	return loc != nil && loc.File != ""
+}
+
+// PrettyMutationReporter reports mutation testing results in a simple human
+// readable format.
+type PrettyMutationReporter struct {
+	Output  io.Writer
+	Verbose bool
+}
+
+// Report prints the surviving mutants, or all mutants if verbose, and a
+// summary to the reporter's output.
+func (r PrettyMutationReporter) Report(mutants []*Mutant) error {
+	var killed, survived, invalid int
+	dirty := false
+
+	for _, m := range mutants {
+		switch m.Status {
+		case MutantKilled:
+			killed++
+		case MutantSurvived:
+			survived++
+		case MutantInvalid:
+			invalid++
+		}
+		if m.Status == MutantSurvived || r.Verbose {
+			dirty = true
+			fmt.Fprintf(r.Output, "%v: %v", m, strings.ToUpper(m.Status))
+			if m.KilledBy != "" {
+				fmt.Fprintf(r.Output, " (by %v)", m.KilledBy)
+			}
+			fmt.Fprintln(r.Output)
+		}
+	}
+
+	if dirty {
+		fmt.Fprintln(r.Output, strings.Repeat("-", 80))
+	}
+
+	total := len(mutants)
+	if killed != 0 {
+		fmt.Fprintf(r.Output, "KILLED: %d/%d\n", killed, total)
+	}
+	if survived != 0 {
+		fmt.Fprintf(r.Output, "SURVIVED: %d/%d\n", survived, total)
+	}
+	if invalid != 0 {
+		fmt.Fprintf(r.Output, "INVALID: %d/%d\n", invalid, total)
+	}
+	if killed+survived != 0 {
+		fmt.Fprintf(r.Output, "MUTATION SCORE: %.2f%%\n", 100*float64(killed)/float64(killed+survived))
+	}
+
+	return nil
+}
+
+// JSONMutationReporter reports mutation testing results as an array of JSON
+// objects.
+type JSONMutationReporter struct {
+	Output io.Writer
+}
+
+// Report prints the mutants to the reporter's output.
+func (r JSONMutationReporter) Report(mutants []*Mutant) error {
+	if mutants == nil {
+		mutants = []*Mutant{}
+	}
+	encoder := json.NewEncoder(r.Output)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(mutants)
+}
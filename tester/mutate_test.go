@@ -0,0 +1,139 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+const mutatePolicy = `package p
+
+import rego.v1
+
+default allow := false
+
+allow if {
+	input.age >= 18
+	not input.banned
+}
+
+names := [n | some n in input.names; n != ""]
+`
+
+func TestMutants(t *testing.T) {
+	modules := map[string]*ast.Module{
+		"p.rego": mustParseMutateModule(t, "p.rego", mutatePolicy),
+		"p_test.rego": mustParseMutateModule(t, "p_test.rego", `package p
+			import rego.v1
+			test_allow if allow with input as {"age": 18}`),
+	}
+
+	var got []string
+	for _, m := range Mutants(modules) {
+		got = append(got, m.String())
+	}
+
+	exp := []string{
+		"p.rego:5: swap-constant: false -> true",
+		"p.rego:8: flip-comparison: input.age >= 18 -> input.age > 18",
+		"p.rego:8: negate-expression: input.age >= 18 -> not input.age >= 18",
+		"p.rego:8: drop-expression: input.age >= 18 -> true",
+		"p.rego:8: swap-constant: 18 -> 19",
+		"p.rego:9: negate-expression: not input.banned -> input.banned",
+		"p.rego:9: drop-expression: not input.banned -> true",
+		"p.rego:12: flip-comparison: n != \"\" -> n == \"\"",
+		"p.rego:12: negate-expression: n != \"\" -> not n != \"\"",
+		"p.rego:12: drop-expression: n != \"\" -> true",
+		"p.rego:12: swap-constant: \"\" -> \"mutant\"",
+	}
+
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Expected mutants:\n\n%v\n\nGot:\n\n%v", exp, got)
+	}
+}
+
+func TestRunMutants(t *testing.T) {
+	ctx := context.Background()
+	files := map[string]string{
+		"/p.rego": mutatePolicy,
+		"/p_test.rego": `package p
+			import rego.v1
+
+			test_allow if allow with input as {"age": 18}
+			test_deny_banned if not allow with input as {"age": 18, "banned": true}
+			`,
+	}
+
+	modules := map[string]*ast.Module{}
+	for file, src := range files {
+		modules[file] = mustParseMutateModule(t, file, src)
+	}
+
+	store := inmem.New()
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	mutants := Mutants(modules)
+	err := RunMutants(ctx, txn, modules, mutants, func(modules map[string]*ast.Module) (*Runner, error) {
+		return NewRunner().SetStore(store).SetModules(modules), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := map[string]string{}
+	for _, m := range mutants {
+		status[m.Operator+" "+m.Original] = m.Status
+	}
+
+	exp := map[string]string{
+		"swap-constant false":                MutantKilled,
+		"flip-comparison input.age >= 18":    MutantKilled,
+		"negate-expression input.age >= 18":  MutantKilled,
+		"drop-expression input.age >= 18":    MutantSurvived,
+		"swap-constant 18":                   MutantKilled,
+		"negate-expression not input.banned": MutantKilled,
+		"drop-expression not input.banned":   MutantKilled,
+		"flip-comparison n != \"\"":          MutantSurvived,
+		"negate-expression n != \"\"":        MutantSurvived,
+		"drop-expression n != \"\"":          MutantSurvived,
+		"swap-constant \"\"":                 MutantSurvived,
+	}
+
+	if !reflect.DeepEqual(exp, status) {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, status)
+	}
+
+	var buf bytes.Buffer
+	if err := (PrettyMutationReporter{Output: &buf}).Report(mutants[:4]); err != nil {
+		t.Fatal(err)
+	}
+
+	expOutput := `/p.rego:8: drop-expression: input.age >= 18 -> true: SURVIVED
+--------------------------------------------------------------------------------
+KILLED: 3/4
+SURVIVED: 1/4
+MUTATION SCORE: 75.00%
+`
+	if buf.String() != expOutput {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", expOutput, buf.String())
+	}
+}
+
+func mustParseMutateModule(t *testing.T, file, src string) *ast.Module {
+	t.Helper()
+	module, err := ast.ParseModuleWithOpts(file, src, ast.ParserOptions{RegoVersion: ast.RegoV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return module
+}
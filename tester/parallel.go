@@ -0,0 +1,162 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// testCase is a test rule to run.
+type testCase struct {
+	module *ast.Module
+	rule   *ast.Rule
+}
+
+// shardTests returns the tests of the shard with the given index, starting at
+// 1. The tests are assigned to the shards in turn, so that the tests of a
+// package are spread across the shards.
+func shardTests(tests []testCase, index, count int) []testCase {
+	result := make([]testCase, 0, len(tests)/count+1)
+	for i := index - 1; i < len(tests); i += count {
+		result = append(result, tests[i])
+	}
+	return result
+}
+
+// testWorker runs tests on its own read transaction.
+type testWorker struct {
+	runner *Runner
+	txn    storage.Transaction
+}
+
+// newTestWorkers returns n workers running tests concurrently. The data
+// visible in txn, which may include uncommitted writes like activated bundles,
+// is copied into a store every worker opens a read transaction on. The returned
+// function must be called once the workers are done.
+func (r *Runner) newTestWorkers(ctx context.Context, txn storage.Transaction, n int) ([]*testWorker, func(), error) {
+	data, err := r.store.Read(ctx, txn, storage.Path{})
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("running tests in parallel requires a store with object data")
+	}
+	store := inmem.NewFromObjectWithOpts(obj, inmem.OptRoundTripOnWrite(false))
+
+	// The coverage tracer is shared by all workers.
+	var cover topdown.QueryTracer
+	if r.cover != nil {
+		cover = &syncQueryTracer{tracer: r.cover}
+	}
+
+	workers := make([]*testWorker, 0, n)
+	done := func() {
+		for _, w := range workers {
+			store.Abort(ctx, w.txn)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		wtxn, err := store.NewTransaction(ctx)
+		if err != nil {
+			done()
+			return nil, nil, err
+		}
+		cpy := *r
+		cpy.store = store
+		cpy.cover = cover
+		cpy.fixtures = nil
+		cpy.cassettes = nil
+		workers = append(workers, &testWorker{runner: &cpy, txn: wtxn})
+	}
+
+	return workers, done, nil
+}
+
+// runParallel runs the tests on the workers and sends the results in the order
+// of the tests. Each test is run with the timeout of the runner. If a test
+// stops the run, e.g., because it was cancelled, the remaining tests are not
+// run.
+func runParallel(ctx context.Context, workers []*testWorker, tests []testCase, runFunc run, ch chan<- *Result) {
+	type slot struct {
+		tr   *Result
+		stop bool
+		done chan struct{}
+	}
+
+	slots := make([]slot, len(tests))
+	for i := range slots {
+		slots[i].done = make(chan struct{})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	next := make(chan int)
+	go func() {
+		defer close(next)
+		for i := range tests {
+			next <- i
+		}
+	}()
+
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *testWorker) {
+			defer wg.Done()
+			for i := range next {
+				if ctx.Err() == nil {
+					tc := tests[i]
+					slots[i].tr, slots[i].stop = func() (*Result, bool) {
+						runCtx, cancel := context.WithTimeout(ctx, w.runner.timeout)
+						defer cancel()
+						return runFunc(w.runner, runCtx, w.txn, tc.module, tc.rule)
+					}()
+				}
+				close(slots[i].done)
+			}
+		}(w)
+	}
+
+	for i := range slots {
+		<-slots[i].done
+		if slots[i].tr != nil {
+			ch <- slots[i].tr
+		}
+		if slots[i].stop {
+			return
+		}
+	}
+}
+
+// syncQueryTracer serializes the events traced by concurrently running tests.
+type syncQueryTracer struct {
+	mtx    sync.Mutex
+	tracer topdown.QueryTracer
+}
+
+func (t *syncQueryTracer) Enabled() bool {
+	return t.tracer.Enabled()
+}
+
+func (t *syncQueryTracer) TraceEvent(evt topdown.Event) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.tracer.TraceEvent(evt)
+}
+
+func (t *syncQueryTracer) Config() topdown.TraceConfig {
+	return t.tracer.Config()
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/tester"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/util/test"
+)
+
+func TestRunParallel(t *testing.T) {
+	registerSleepBuiltin()
+
+	ctx := context.Background()
+
+	var tests strings.Builder
+	for i := 0; i < 20; i++ {
+		// Later tests finish first, which must not change the order of the
+		// results.
+		fmt.Fprintf(&tests, "test_%02d { test.sleep(\"%dms\"); data.x == %d }\n", i, 20-i, i%3)
+	}
+	files := map[string]string{
+		"/a_test.rego": "package a\n\n" + tests.String() + "test_slow { test.sleep(\"500ms\") }\n",
+		"/b_test.rego": "package b\n\ntest_cover { data.a.y == 1 }\n",
+		"/a.rego":      "package a\n\ny := 1\n",
+		"/data.json":   `{"x": 1}`,
+	}
+
+	test.WithTempFS(files, func(d string) {
+		run := func(parallel int, tracer *cover.Cover) []*tester.Result {
+			t.Helper()
+			modules, store, err := tester.Load([]string{d}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+			defer store.Abort(ctx, txn)
+
+			// Data written in the transaction is visible to tests run
+			// concurrently.
+			if err := store.Write(ctx, txn, storage.ReplaceOp, storage.MustParsePath("/x"), 0); err != nil {
+				t.Fatal(err)
+			}
+
+			runner := tester.NewRunner().
+				SetStore(store).
+				SetModules(modules).
+				SetTimeout(250 * time.Millisecond).
+				Parallel(parallel)
+			if tracer != nil {
+				runner.SetCoverageQueryTracer(tracer)
+			}
+			ch, err := runner.RunTests(ctx, txn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var results []*tester.Result
+			for tr := range ch {
+				results = append(results, tr)
+			}
+			return results
+		}
+
+		summary := func(results []*tester.Result) []string {
+			var result []string
+			for _, tr := range results {
+				result = append(result, fmt.Sprintf("%v.%v %v %v", tr.Package, tr.Name, tr.Pass(), topdown.IsCancel(tr.Error)))
+			}
+			return result
+		}
+
+		exp := summary(run(1, nil))
+		if len(exp) != 22 {
+			t.Fatalf("Expected 22 results but got %v", exp)
+		}
+
+		tracer := cover.New()
+		if act := summary(run(8, tracer)); !reflect.DeepEqual(exp, act) {
+			t.Fatalf("Expected results:\n%v\n\nGot:\n%v", strings.Join(exp, "\n"), strings.Join(act, "\n"))
+		}
+
+		for _, s := range exp {
+			switch {
+			case strings.HasPrefix(s, "data.a.test_slow"):
+				if s != "data.a.test_slow false true" {
+					t.Errorf("Expected test_slow to time out but got %v", s)
+				}
+			case strings.HasPrefix(s, "data.a.test_00"), strings.HasPrefix(s, "data.a.test_03"):
+				if !strings.HasSuffix(s, " true false") {
+					t.Errorf("Expected pass but got %v", s)
+				}
+			}
+		}
+
+		if report := tracer.Report(nil); len(report.Files) == 0 {
+			t.Errorf("Expected coverage of tests run concurrently")
+		}
+	})
+}
+
+func TestRunShards(t *testing.T) {
+	ctx := context.Background()
+
+	var tests strings.Builder
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&tests, "test_%d { true }\n", i)
+	}
+	files := map[string]string{
+		"/a_test.rego": "package a\n\n" + tests.String(),
+	}
+
+	test.WithTempFS(files, func(d string) {
+		modules, store, err := tester.Load([]string{d}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen := map[string]int{}
+		for shard := 1; shard <= 3; shard++ {
+			txn := storage.NewTransactionOrDie(ctx, store)
+			ch, err := tester.NewRunner().SetStore(store).SetModules(modules).Shard(shard, 3).RunTests(ctx, txn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for tr := range ch {
+				seen[tr.Name]++
+				n++
+			}
+			store.Abort(ctx, txn)
+			if n < 3 || n > 4 {
+				t.Errorf("Expected 3 or 4 tests in shard %d but got %d", shard, n)
+			}
+		}
+
+		if len(seen) != 10 {
+			t.Errorf("Expected all tests to run but got %v", seen)
+		}
+		for name, n := range seen {
+			if n != 1 {
+				t.Errorf("Expected %v to run once but ran %d times", name, n)
+			}
+		}
+
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+		if _, err := tester.NewRunner().SetStore(store).SetModules(modules).Shard(4, 3).RunTests(ctx, txn); err == nil || err.Error() != "invalid shard 4/3" {
+			t.Errorf("Expected invalid shard error but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,927 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+// PropertyKey is the key of the custom annotation that declares a test rule
+// as a property test. The value is either true, or an object with the
+// optional "runs" and "seed" keys.
+const PropertyKey = "property"
+
+const (
+	defaultPropertyRuns = 100
+	maxPropertyShrinks  = 1000
+
+	// maxGenerateDepth limits the nesting of generated values for recursive
+	// and unconstrained schemas.
+	maxGenerateDepth = 5
+)
+
+// PropertyResult describes the evaluation of a property test.
+type PropertyResult struct {
+	// Runs is the number of generated inputs the test was evaluated with,
+	// including the first failing one.
+	Runs int `json:"runs"`
+
+	// Seed is the seed of the input generator. Running the test with the same
+	// seed generates the same inputs.
+	Seed int64 `json:"seed"`
+
+	// Shrinks is the number of times the failing input was simplified.
+	Shrinks int `json:"shrinks,omitempty"`
+
+	// Counterexample is the smallest input found for which the test fails.
+	Counterexample interface{} `json:"counterexample,omitempty"`
+}
+
+func (p *PropertyResult) String() string {
+	bs, err := json.Marshal(p.Counterexample)
+	if err != nil {
+		bs = []byte(fmt.Sprint(p.Counterexample))
+	}
+	return fmt.Sprintf("counterexample: %s (seed: %d, run: %d, shrinks: %d)", bs, p.Seed, p.Runs, p.Shrinks)
+}
+
+type propertyConfig struct {
+	runs   int
+	seed   int64
+	schema interface{}
+}
+
+// propertyConfig returns the configuration of the property test declared by
+// the rule's annotations, or nil if the rule is not a property test. The input
+// schema is the one closest to the rule.
+func (r *Runner) propertyConfig(rule *ast.Rule) (*propertyConfig, error) {
+	value := r.customAnnotation(rule, PropertyKey)
+	if value == nil {
+		return nil, nil
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(testRef(rule).String()))
+	cfg := &propertyConfig{
+		runs: defaultPropertyRuns,
+		seed: int64(h.Sum64() & math.MaxInt64),
+	}
+
+	switch v := value.(type) {
+	case bool:
+		if !v {
+			return nil, nil
+		}
+	case map[string]interface{}:
+		for key, x := range v {
+			n, ok := schemaNumber(x)
+			if !ok || n != math.Trunc(n) {
+				return nil, fmt.Errorf("invalid property test configuration: %v must be an integer", key)
+			}
+			switch key {
+			case "runs":
+				if n < 1 {
+					return nil, fmt.Errorf("invalid property test configuration: runs must be positive")
+				}
+				cfg.runs = int(n)
+			case "seed":
+				cfg.seed = int64(n)
+			default:
+				return nil, fmt.Errorf("invalid property test configuration: unknown key %v", key)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid property test configuration: expected true or object")
+	}
+
+	for _, ref := range r.compiler.GetAnnotationSet().Chain(rule) {
+		if ref.Annotations == nil {
+			continue
+		}
+		for _, s := range ref.Annotations.Schemas {
+			if !s.Path.Equal(ast.InputRootRef) {
+				continue
+			}
+			if s.Definition != nil {
+				cfg.schema = *s.Definition
+				return cfg, nil
+			}
+			cfg.schema = r.compiler.GetSchemaSet().Get(s.Schema)
+			if cfg.schema == nil {
+				return nil, fmt.Errorf("undefined schema: %v", s.Schema)
+			}
+			return cfg, nil
+		}
+	}
+
+	return nil, fmt.Errorf("property test requires an input schema")
+}
+
+// runPropertyTest evaluates the test with inputs generated from the input
+// schema. The first failing input is shrunk to the smallest input for which
+// the test still fails. The generated inputs take precedence over the input
+// set by the options.
+func (r *Runner) runPropertyTest(ctx context.Context, txn storage.Transaction, mod *ast.Module, rule *ast.Rule, cfg *propertyConfig, options ...func(*rego.Rego)) (*Result, bool) {
+	name := rule.Head.Ref().String()
+	gen := newInputGenerator(cfg.schema, cfg.seed)
+	var duration time.Duration
+
+	run := func(input interface{}) (*Result, bool) {
+		v, err := ast.InterfaceToValue(input)
+		if err != nil {
+			tr := newResult(rule.Loc(), mod.Package.Path.String(), name, 0, nil, nil)
+			tr.Error = err
+			return tr, false
+		}
+		tr, stop := r.runTestQuery(ctx, txn, mod, rule, name, rule.Path(), false, append(options[:len(options):len(options)], rego.ParsedInput(v))...)
+		duration += tr.Duration
+		return tr, stop
+	}
+
+	for i := 1; i <= cfg.runs; i++ {
+		input := gen.generate(gen.root, 0)
+		tr, stop := run(input)
+		if stop || tr.Pass() {
+			if stop {
+				tr.Duration = duration
+				return tr, true
+			}
+			continue
+		}
+
+		result := &PropertyResult{Runs: i, Seed: cfg.seed}
+		for result.Shrinks < maxPropertyShrinks {
+			shrunk := false
+			for _, c := range gen.shrink(gen.root, input) {
+				ctr, stop := run(c)
+				if stop {
+					ctr.Duration = duration
+					return ctr, true
+				}
+				if !ctr.Pass() {
+					input, tr, shrunk = c, ctr, true
+					result.Shrinks++
+					break
+				}
+			}
+			if !shrunk {
+				break
+			}
+		}
+
+		result.Counterexample = input
+		tr.Property = result
+		tr.Duration = duration
+		return tr, false
+	}
+
+	tr := newResult(rule.Loc(), mod.Package.Path.String(), name, duration, nil, nil)
+	tr.Property = &PropertyResult{Runs: cfg.runs, Seed: cfg.seed}
+	return tr, false
+}
+
+// inputGenerator generates values conforming to a JSON schema, and simpler
+// variants of them. Not all keywords are supported: string patterns and
+// numeric multiples of non-integers are ignored, and only local references
+// are resolved.
+type inputGenerator struct {
+	root interface{}
+	rand *rand.Rand
+}
+
+func newInputGenerator(schema interface{}, seed int64) *inputGenerator {
+	return &inputGenerator{
+		root: schema,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+var allSchemaTypes = []string{"null", "boolean", "integer", "number", "string", "array", "object"}
+
+// resolve returns the schema with local references resolved and allOf
+// subschemas merged. The boolean schema true, and any schema that cannot be
+// resolved, resolves to the empty schema.
+func (g *inputGenerator) resolve(schema interface{}) map[string]interface{} {
+	for i := 0; i < 32; i++ {
+		s, ok := schema.(map[string]interface{})
+		if !ok {
+			return map[string]interface{}{}
+		}
+		ref, ok := s["$ref"].(string)
+		if !ok {
+			if allOf, ok := s["allOf"].([]interface{}); ok {
+				merged := withoutKeys(s, "allOf")
+				for _, sub := range allOf {
+					merged = mergeSchemas(merged, g.resolve(sub))
+				}
+				return merged
+			}
+			return s
+		}
+		schema = resolvePointer(g.root, ref)
+	}
+	return map[string]interface{}{}
+}
+
+// resolvePointer resolves a reference of the form #/path/to/schema.
+func resolvePointer(root interface{}, ref string) interface{} {
+	if !strings.HasPrefix(ref, "#") {
+		return nil
+	}
+	x := root
+	for _, part := range strings.Split(strings.TrimPrefix(ref[1:], "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		obj, ok := x.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		x = obj[part]
+	}
+	return x
+}
+
+func withoutKeys(s map[string]interface{}, keys ...string) map[string]interface{} {
+	result := make(map[string]interface{}, len(s))
+	for k, v := range s {
+		result[k] = v
+	}
+	for _, k := range keys {
+		delete(result, k)
+	}
+	return result
+}
+
+// mergeSchemas merges the keywords of b into a. Properties and required
+// properties are combined, all other keywords of a take precedence.
+func mergeSchemas(a, b map[string]interface{}) map[string]interface{} {
+	result := withoutKeys(a)
+	for k, v := range b {
+		switch k {
+		case "properties":
+			props := map[string]interface{}{}
+			if p, ok := result[k].(map[string]interface{}); ok {
+				for name, s := range p {
+					props[name] = s
+				}
+			}
+			if p, ok := v.(map[string]interface{}); ok {
+				for name, s := range p {
+					if _, ok := props[name]; !ok {
+						props[name] = s
+					}
+				}
+			}
+			result[k] = props
+		case "required":
+			required, _ := result[k].([]interface{})
+			if r, ok := v.([]interface{}); ok {
+				required = append(append([]interface{}{}, required...), r...)
+			}
+			result[k] = required
+		default:
+			if _, ok := result[k]; !ok {
+				result[k] = v
+			}
+		}
+	}
+	return result
+}
+
+// alternatives returns the schemas of the anyOf or oneOf keyword merged with
+// the remaining keywords of the schema.
+func alternatives(s map[string]interface{}) []interface{} {
+	for _, key := range []string{"anyOf", "oneOf"} {
+		if alts, ok := s[key].([]interface{}); ok && len(alts) > 0 {
+			base := withoutKeys(s, "anyOf", "oneOf")
+			result := make([]interface{}, len(alts))
+			for i, alt := range alts {
+				result[i] = map[string]interface{}{"allOf": []interface{}{base, alt}}
+			}
+			return result
+		}
+	}
+	return nil
+}
+
+func schemaTypes(s map[string]interface{}, depth int) []string {
+	var types []string
+	switch t := s["type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, x := range t {
+			if str, ok := x.(string); ok {
+				types = append(types, str)
+			}
+		}
+	}
+	if len(types) > 0 {
+		return types
+	}
+
+	for _, key := range []string{"properties", "required", "additionalProperties"} {
+		if _, ok := s[key]; ok {
+			return []string{"object"}
+		}
+	}
+	for _, key := range []string{"items", "prefixItems", "minItems", "maxItems"} {
+		if _, ok := s[key]; ok {
+			return []string{"array"}
+		}
+	}
+	for _, key := range []string{"minLength", "maxLength", "format", "pattern"} {
+		if _, ok := s[key]; ok {
+			return []string{"string"}
+		}
+	}
+	for _, key := range []string{"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"} {
+		if _, ok := s[key]; ok {
+			return []string{"number"}
+		}
+	}
+	if depth >= maxGenerateDepth {
+		return allSchemaTypes[:5]
+	}
+	return allSchemaTypes
+}
+
+func (g *inputGenerator) generate(schema interface{}, depth int) interface{} {
+	if b, ok := schema.(bool); ok && !b {
+		return nil
+	}
+	s := g.resolve(schema)
+
+	if c, ok := s["const"]; ok {
+		return c
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[g.rand.Intn(len(enum))]
+	}
+	if alts := alternatives(s); alts != nil {
+		return g.generate(alts[g.rand.Intn(len(alts))], depth)
+	}
+
+	types := schemaTypes(s, depth)
+	switch types[g.rand.Intn(len(types))] {
+	case "null":
+		return nil
+	case "boolean":
+		return g.rand.Intn(2) == 0
+	case "integer":
+		return g.generateInteger(s)
+	case "number":
+		return g.generateNumber(s)
+	case "string":
+		return g.generateString(s)
+	case "array":
+		return g.generateArray(s, depth)
+	case "object":
+		return g.generateObject(s, depth)
+	}
+	return nil
+}
+
+func (g *inputGenerator) generateObject(s map[string]interface{}, depth int) interface{} {
+	props, _ := s["properties"].(map[string]interface{})
+	required := requiredProperties(s)
+
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	for k := range required {
+		if _, ok := props[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := map[string]interface{}{}
+	for _, k := range keys {
+		if required[k] || (depth < maxGenerateDepth && g.rand.Intn(2) == 0) {
+			result[k] = g.generate(propertySchema(s, k), depth+1)
+		}
+	}
+	return result
+}
+
+func requiredProperties(s map[string]interface{}) map[string]bool {
+	result := map[string]bool{}
+	required, _ := s["required"].([]interface{})
+	for _, x := range required {
+		if k, ok := x.(string); ok {
+			result[k] = true
+		}
+	}
+	return result
+}
+
+func propertySchema(s map[string]interface{}, key string) interface{} {
+	if props, ok := s["properties"].(map[string]interface{}); ok {
+		if p, ok := props[key]; ok {
+			return p
+		}
+	}
+	if p, ok := s["additionalProperties"].(map[string]interface{}); ok {
+		return p
+	}
+	return true
+}
+
+func (g *inputGenerator) generateArray(s map[string]interface{}, depth int) interface{} {
+	lo, hi := arrayLength(s)
+	if depth >= maxGenerateDepth {
+		hi = lo
+	}
+	n := lo + g.rand.Intn(hi-lo+1)
+	unique, _ := s["uniqueItems"].(bool)
+
+	result := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		item := g.generate(itemSchema(s, i), depth+1)
+		// Retry a few times if the item is a duplicate. If no unique item can
+		// be found, the array is only extended up to its minimum length.
+		for j := 0; unique && j < 10 && containsValue(result, item); j++ {
+			item = g.generate(itemSchema(s, i), depth+1)
+		}
+		if unique && containsValue(result, item) {
+			if len(result) >= lo {
+				break
+			}
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func arrayLength(s map[string]interface{}) (int, int) {
+	lo, hi := 0, -1
+	if n, ok := schemaNumber(s["minItems"]); ok {
+		lo = int(n)
+	}
+	if n, ok := schemaNumber(s["maxItems"]); ok {
+		hi = int(n)
+	}
+	if hi < lo {
+		hi = lo + 4
+	}
+	return lo, hi
+}
+
+func itemSchema(s map[string]interface{}, i int) interface{} {
+	for _, key := range []string{"prefixItems", "items"} {
+		if tuple, ok := s[key].([]interface{}); ok {
+			if i < len(tuple) {
+				return tuple[i]
+			}
+			if key == "items" {
+				if additional, ok := s["additionalItems"]; ok {
+					return additional
+				}
+				return true
+			}
+		}
+	}
+	if items, ok := s["items"]; ok {
+		if _, ok := items.([]interface{}); !ok {
+			return items
+		}
+	}
+	return true
+}
+
+func containsValue(xs []interface{}, x interface{}) bool {
+	for _, y := range xs {
+		if reflect.DeepEqual(x, y) {
+			return true
+		}
+	}
+	return false
+}
+
+const stringAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 -_"
+
+func (g *inputGenerator) generateString(s map[string]interface{}) interface{} {
+	if format, ok := s["format"].(string); ok {
+		if str, ok := g.generateFormat(format); ok {
+			return str
+		}
+	}
+
+	lo, hi := stringLength(s)
+	n := lo + g.rand.Intn(hi-lo+1)
+	return g.randomString(n)
+}
+
+func (g *inputGenerator) randomString(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		sb.WriteByte(stringAlphabet[g.rand.Intn(len(stringAlphabet))])
+	}
+	return sb.String()
+}
+
+func stringLength(s map[string]interface{}) (int, int) {
+	lo, hi := 0, -1
+	if n, ok := schemaNumber(s["minLength"]); ok {
+		lo = int(n)
+	}
+	if n, ok := schemaNumber(s["maxLength"]); ok {
+		hi = int(n)
+	}
+	if hi < lo {
+		hi = lo + 8
+	}
+	return lo, hi
+}
+
+func (g *inputGenerator) generateFormat(format string) (string, bool) {
+	switch format {
+	case "date-time":
+		return g.randomTime().Format(time.RFC3339), true
+	case "date":
+		return g.randomTime().Format("2006-01-02"), true
+	case "time":
+		return g.randomTime().Format("15:04:05Z07:00"), true
+	case "email":
+		return strings.ToLower(g.randomString(1+g.rand.Intn(8))) + "@example.com", true
+	case "hostname":
+		return fmt.Sprintf("host%d.example.com", g.rand.Intn(100)), true
+	case "uri":
+		return fmt.Sprintf("https://example.com/%d", g.rand.Intn(1000)), true
+	case "uuid":
+		bs := make([]byte, 16)
+		g.rand.Read(bs)
+		return fmt.Sprintf("%x-%x-%x-%x-%x", bs[0:4], bs[4:6], bs[6:8], bs[8:10], bs[10:]), true
+	case "ipv4":
+		return fmt.Sprintf("%d.%d.%d.%d", g.rand.Intn(256), g.rand.Intn(256), g.rand.Intn(256), g.rand.Intn(256)), true
+	case "ipv6":
+		return fmt.Sprintf("2001:db8::%x", g.rand.Intn(0x10000)), true
+	}
+	return "", false
+}
+
+func (g *inputGenerator) randomTime() time.Time {
+	return time.Unix(946684800+g.rand.Int63n(50*365*24*3600), 0).UTC()
+}
+
+// numberBounds returns the inclusive bounds of numbers conforming to the
+// schema. For integers, exclusive bounds are converted to inclusive ones.
+func numberBounds(s map[string]interface{}, integer bool) (float64, float64) {
+	lo, hi := math.Inf(-1), math.Inf(1)
+	var exclusiveLo, exclusiveHi bool
+	if n, ok := schemaNumber(s["minimum"]); ok {
+		lo = n
+	}
+	if n, ok := schemaNumber(s["maximum"]); ok {
+		hi = n
+	}
+	// Draft 4 declares exclusive bounds with booleans, later drafts with
+	// numbers.
+	switch x := s["exclusiveMinimum"].(type) {
+	case bool:
+		exclusiveLo = x
+	default:
+		if n, ok := schemaNumber(x); ok && n >= lo {
+			lo, exclusiveLo = n, true
+		}
+	}
+	switch x := s["exclusiveMaximum"].(type) {
+	case bool:
+		exclusiveHi = x
+	default:
+		if n, ok := schemaNumber(x); ok && n <= hi {
+			hi, exclusiveHi = n, true
+		}
+	}
+
+	switch {
+	case integer && exclusiveLo && lo == math.Trunc(lo):
+		lo++
+	case integer:
+		lo = math.Ceil(lo)
+	case exclusiveLo:
+		lo = math.Nextafter(lo, math.Inf(1))
+	}
+	switch {
+	case integer && exclusiveHi && hi == math.Trunc(hi):
+		hi--
+	case integer:
+		hi = math.Floor(hi)
+	case exclusiveHi:
+		hi = math.Nextafter(hi, math.Inf(-1))
+	}
+
+	switch {
+	case math.IsInf(lo, -1) && math.IsInf(hi, 1):
+		lo, hi = -1000, 1000
+	case math.IsInf(lo, -1):
+		lo = hi - 1000
+	case math.IsInf(hi, 1):
+		hi = lo + 1000
+	}
+	return lo, hi
+}
+
+func (g *inputGenerator) generateInteger(s map[string]interface{}) interface{} {
+	lo, hi := numberBounds(s, true)
+	step := 1.0
+	if m, ok := schemaNumber(s["multipleOf"]); ok && m >= 1 && m == math.Trunc(m) {
+		step = m
+	}
+	lo, hi = math.Ceil(lo/step), math.Floor(hi/step)
+	if hi < lo {
+		return int64(lo * step)
+	}
+
+	// Boundaries and zero are more likely to reveal bugs than other values.
+	var n float64
+	switch g.rand.Intn(4) {
+	case 0:
+		n = []float64{lo, hi, math.Max(lo, math.Min(hi, 0))}[g.rand.Intn(3)]
+	default:
+		n = lo + float64(g.rand.Int63n(int64(hi-lo)+1))
+	}
+	return int64(n * step)
+}
+
+func (g *inputGenerator) generateNumber(s map[string]interface{}) interface{} {
+	lo, hi := numberBounds(s, false)
+	if hi < lo {
+		return lo
+	}
+	if g.rand.Intn(4) == 0 {
+		return []float64{lo, hi, math.Max(lo, math.Min(hi, 0))}[g.rand.Intn(3)]
+	}
+	n := lo + g.rand.Float64()*(hi-lo)
+	// Prefer short decimals, unless they fall out of bounds.
+	if rounded := math.Round(n*100) / 100; rounded >= lo && rounded <= hi {
+		n = rounded
+	}
+	return n
+}
+
+// shrink returns values simpler than v that conform to the schema, ordered
+// from simplest to least simple.
+func (g *inputGenerator) shrink(schema interface{}, v interface{}) []interface{} {
+	s := g.resolve(schema)
+
+	if _, ok := s["const"]; ok {
+		return nil
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		var result []interface{}
+		for _, x := range enum {
+			if reflect.DeepEqual(x, v) {
+				break
+			}
+			result = append(result, x)
+		}
+		return result
+	}
+	if alts := alternatives(s); alts != nil {
+		var result []interface{}
+		for _, alt := range alts {
+			if typeMatches(schemaTypes(g.resolve(alt), 0), v) {
+				result = append(result, g.shrink(alt, v)...)
+			}
+		}
+		return result
+	}
+
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return []interface{}{false}
+		}
+	case string:
+		return shrinkString(s, v)
+	case int64, float64:
+		return shrinkNumber(s, v)
+	case []interface{}:
+		return g.shrinkArray(s, v)
+	case map[string]interface{}:
+		return g.shrinkObject(s, v)
+	}
+	return nil
+}
+
+func typeMatches(types []string, v interface{}) bool {
+	for _, t := range types {
+		switch v.(type) {
+		case nil:
+			if t == "null" {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case int64:
+			if t == "integer" || t == "number" {
+				return true
+			}
+		case float64:
+			if t == "number" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *inputGenerator) shrinkObject(s map[string]interface{}, v map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(v))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	required := requiredProperties(s)
+
+	var result []interface{}
+	for _, k := range keys {
+		if !required[k] {
+			c := withoutKeys(v, k)
+			result = append(result, c)
+		}
+	}
+	for _, k := range keys {
+		for _, x := range g.shrink(propertySchema(s, k), v[k]) {
+			c := withoutKeys(v)
+			c[k] = x
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func (g *inputGenerator) shrinkArray(s map[string]interface{}, v []interface{}) []interface{} {
+	lo, _ := arrayLength(s)
+	_, tuple := s["prefixItems"]
+	if _, ok := s["items"].([]interface{}); ok {
+		tuple = true
+	}
+
+	var result []interface{}
+	if len(v) > lo {
+		// Items of tuples are removed from the end only, so the remaining
+		// items still conform to their schemas.
+		result = append(result, v[:lo])
+		if half := len(v) / 2; half > lo {
+			result = append(result, v[:half])
+		}
+		if tuple {
+			result = append(result, v[:len(v)-1])
+		} else {
+			for i := range v {
+				c := append(append([]interface{}{}, v[:i]...), v[i+1:]...)
+				result = append(result, c)
+			}
+		}
+	}
+
+	unique, _ := s["uniqueItems"].(bool)
+	for i := range v {
+		for _, x := range g.shrink(itemSchema(s, i), v[i]) {
+			if unique && containsValue(v, x) {
+				continue
+			}
+			c := append([]interface{}{}, v...)
+			c[i] = x
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+func shrinkString(s map[string]interface{}, v string) []interface{} {
+	if _, ok := s["format"]; ok {
+		return nil
+	}
+	if _, ok := s["pattern"]; ok {
+		return nil
+	}
+	lo, _ := stringLength(s)
+	runes := []rune(v)
+
+	var result []interface{}
+	add := func(x string) {
+		if x == v {
+			return
+		}
+		for _, y := range result {
+			if y == x {
+				return
+			}
+		}
+		result = append(result, x)
+	}
+	if len(runes) > lo {
+		add(string(runes[:lo]))
+		if half := len(runes) / 2; half > lo {
+			add(string(runes[:half]))
+		}
+		add(string(runes[:len(runes)-1]))
+	}
+	add(strings.Repeat("a", len(runes)))
+	return result
+}
+
+func shrinkNumber(s map[string]interface{}, v interface{}) []interface{} {
+	_, integer := v.(int64)
+	n, _ := schemaNumber(v)
+	lo, hi := numberBounds(s, integer)
+
+	var result []interface{}
+	add := func(x float64) {
+		if x == n || x < lo || x > hi {
+			return
+		}
+		var y interface{} = x
+		if integer {
+			y = int64(x)
+		}
+		for _, z := range result {
+			if z == y {
+				return
+			}
+		}
+		result = append(result, y)
+	}
+
+	if !integer {
+		// Fractions are shrunk to whole numbers first.
+		if t := math.Trunc(n); t != n {
+			add(t)
+			add(math.Ceil(n))
+			return result
+		}
+	}
+
+	target := math.Max(lo, math.Min(hi, 0))
+	if integer {
+		target = math.Ceil(target)
+	}
+	step := 1.0
+	if m, ok := schemaNumber(s["multipleOf"]); ok && m > 0 {
+		// Shrink in steps of the multiple, so the results remain multiples.
+		step = m
+		target = math.Ceil(target/m) * m
+	}
+	add(target)
+	// Approach the target in halving steps, so the smallest failing value is
+	// found in a logarithmic number of shrinks.
+	for d := math.Trunc((n-target)/step/2) * step; math.Abs(d) >= step; d = math.Trunc(d/step/2) * step {
+		add(n - d)
+	}
+	add(n - math.Copysign(step, n-target))
+	return result
+}
+
+// schemaNumber returns the number x decoded from JSON or YAML.
+func schemaNumber(x interface{}) (float64, bool) {
+	switch x := x.(type) {
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float64:
+		return x, true
+	case json.Number:
+		f, err := x.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
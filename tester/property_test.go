@@ -0,0 +1,214 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/gojsonschema"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/util"
+)
+
+const propertyTestSchema = `{
+	"type": "object",
+	"required": ["user"],
+	"properties": {
+		"user": {"$ref": "#/definitions/user"},
+		"amount": {"type": "number", "exclusiveMinimum": 0, "maximum": 10},
+		"ids": {"type": "array", "items": {"type": "integer", "multipleOf": 5}, "uniqueItems": true, "maxItems": 4},
+		"created": {"type": "string", "format": "date-time"},
+		"note": {"anyOf": [{"type": "null"}, {"type": "string", "maxLength": 3}]},
+		"pair": {"type": "array", "items": [{"type": "boolean"}, {"const": "x"}], "minItems": 2, "maxItems": 2}
+	},
+	"definitions": {
+		"user": {
+			"allOf": [
+				{"type": "object", "required": ["name"], "properties": {"name": {"type": "string", "minLength": 1}}},
+				{"required": ["age", "role"], "properties": {
+					"age": {"type": "integer", "minimum": 0, "maximum": 150},
+					"role": {"enum": ["viewer", "editor", "admin"]},
+					"tags": {"type": "array", "items": {"type": "string"}}
+				}}
+			]
+		}
+	}
+}`
+
+func TestInputGeneratorConformsToSchema(t *testing.T) {
+	schema := util.MustUnmarshalJSON([]byte(propertyTestSchema))
+	validator, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(v interface{}) {
+		t.Helper()
+		result, err := validator.Validate(gojsonschema.NewGoLoader(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Valid() {
+			bs, _ := json.Marshal(v)
+			t.Fatalf("Generated value %s does not conform to schema: %v", bs, result.Errors())
+		}
+	}
+
+	gen := newInputGenerator(schema, 42)
+	for i := 0; i < 200; i++ {
+		v := gen.generate(gen.root, 0)
+		validate(v)
+		for _, c := range gen.shrink(gen.root, v) {
+			validate(c)
+		}
+	}
+
+	// The same seed generates the same values.
+	a, b := newInputGenerator(schema, 7), newInputGenerator(schema, 7)
+	for i := 0; i < 10; i++ {
+		if x, y := a.generate(a.root, 0), b.generate(b.root, 0); !reflect.DeepEqual(x, y) {
+			t.Fatalf("Expected same values for same seed but got %v and %v", x, y)
+		}
+	}
+}
+
+func TestRunPropertyTests(t *testing.T) {
+	ctx := context.Background()
+
+	files := map[string]string{
+		"/p.rego": `package p
+			import rego.v1
+
+			discount := 10 if input.user.age >= 65
+			default discount := 0
+
+			allow if input.user.role != "admin"
+			`,
+		"/p_test.rego": `package p
+import rego.v1
+
+# METADATA
+# schemas:
+#   - input: schema.input
+# custom:
+#   property:
+#     runs: 50
+test_discount_bounded if {
+	discount >= 0
+	discount <= 10
+}
+
+# METADATA
+# schemas:
+#   - input: schema.input
+# custom:
+#   property: true
+test_allow if allow
+
+# METADATA
+# schemas:
+#   - input:
+#       type: object
+#       required: [count]
+#       properties:
+#         count: {type: integer, minimum: 0, maximum: 1000}
+# custom:
+#   property:
+#     seed: 1
+test_small if input.count < 100
+
+# METADATA
+# custom:
+#   property: true
+test_no_schema if true
+
+# METADATA
+# schemas:
+#   - input: schema.input
+test_not_property if true
+`,
+	}
+
+	modules := map[string]*ast.Module{}
+	for file, src := range files {
+		module, err := ast.ParseModuleWithOpts(file, src, ast.ParserOptions{RegoVersion: ast.RegoV1, ProcessAnnotation: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		modules[file] = module
+	}
+
+	schemaSet := ast.NewSchemaSet()
+	schemaSet.Put(ast.MustParseRef("schema.input"), util.MustUnmarshalJSON([]byte(propertyTestSchema)))
+
+	store := inmem.New()
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	compiler := ast.NewCompiler().WithSchemas(schemaSet).WithUseTypeCheckAnnotations(true)
+	ch, err := NewRunner().SetCompiler(compiler).SetStore(store).SetModules(modules).RunTests(ctx, txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := map[string]*Result{}
+	var buf bytes.Buffer
+	rch := make(chan *Result)
+	go func() {
+		defer close(rch)
+		for tr := range ch {
+			results[tr.Name] = tr
+			rch <- tr
+		}
+	}()
+	if err := (PrettyReporter{Output: &buf}).Report(rch); err != nil {
+		t.Fatal(err)
+	}
+
+	if tr := results["test_discount_bounded"]; !tr.Pass() || tr.Property == nil || tr.Property.Runs != 50 {
+		t.Errorf("Expected test_discount_bounded to pass after 50 runs but got %v (%+v)", tr, tr.Property)
+	}
+
+	tr := results["test_allow"]
+	if !tr.Fail || tr.Property == nil {
+		t.Fatalf("Expected test_allow to fail but got %v", tr)
+	}
+	exp := map[string]interface{}{"user": map[string]interface{}{"name": "a", "age": int64(0), "role": "admin"}}
+	if !reflect.DeepEqual(tr.Property.Counterexample, exp) {
+		t.Errorf("Expected counterexample %v but got %v", exp, tr.Property.Counterexample)
+	}
+
+	tr = results["test_small"]
+	if !tr.Fail || tr.Property == nil || tr.Property.Seed != 1 {
+		t.Fatalf("Expected test_small to fail with seed 1 but got %v", tr)
+	}
+	if exp := map[string]interface{}{"count": int64(100)}; !reflect.DeepEqual(tr.Property.Counterexample, exp) {
+		t.Errorf("Expected counterexample %v but got %v", exp, tr.Property.Counterexample)
+	}
+
+	if tr := results["test_no_schema"]; tr.Error == nil || tr.Error.Error() != "property test requires an input schema" {
+		t.Errorf("Expected missing schema error but got %v", tr)
+	}
+
+	if tr := results["test_not_property"]; !tr.Pass() || tr.Property != nil {
+		t.Errorf("Expected test_not_property to run once but got %v", tr)
+	}
+
+	for _, line := range []string{
+		`counterexample: {"user":{"age":0,"name":"a","role":"admin"}} (seed: `,
+		`counterexample: {"count":100} (seed: 1, run: `,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected output to contain %q but got:\n\n%v", line, buf.String())
+		}
+	}
+}
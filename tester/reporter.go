@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/cover"
@@ -50,7 +51,7 @@ func (r PrettyReporter) Report(ch chan *Result) error {
 			errs++
 		} else if tr.Fail {
 			fail++
-			failures = append(failures, tr)
+			failures = append(failures, tr.failures()...)
 		}
 		results = append(results, tr)
 	}
@@ -86,11 +87,15 @@ func (r PrettyReporter) Report(ch chan *Result) error {
 				lastFile = tr.Location.File
 			}
 			dirty = true
-			fmt.Fprintln(r.Output, tr)
-			if len(tr.Output) > 0 {
-				fmt.Fprintln(r.Output)
-				fmt.Fprintln(newIndentingWriter(r.Output), strings.TrimSpace(string(tr.Output)))
-				fmt.Fprintln(r.Output)
+			r.printResult(r.Output, tr)
+			for _, sub := range tr.SubResults {
+				if r.Verbose || !sub.Pass() {
+					w := newIndentingWriter(r.Output)
+					r.printResult(w, sub)
+					if sub.Error != nil {
+						fmt.Fprintf(w, "  %v\n", sub.Error)
+					}
+				}
 			}
 		}
 		if tr.Error != nil {
@@ -124,6 +129,21 @@ func (r PrettyReporter) Report(ch chan *Result) error {
 	return nil
 }
 
+func (r PrettyReporter) printResult(w io.Writer, tr *Result) {
+	fmt.Fprintln(w, tr)
+	if tr.Property != nil && (tr.Fail || tr.Error != nil) {
+		fmt.Fprintf(w, "  %v\n", tr.Property)
+	}
+	if tr.Snapshot != nil && (tr.Fail || tr.Snapshot.Updated) {
+		fmt.Fprintln(newIndentingWriter(w), tr.Snapshot)
+	}
+	if len(tr.Output) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(newIndentingWriter(w), strings.TrimSpace(string(tr.Output)))
+		fmt.Fprintln(w)
+	}
+}
+
 func (r PrettyReporter) hl() {
 	fmt.Fprintln(r.Output, strings.Repeat("-", 80))
 }
@@ -177,72 +197,120 @@ func (r JSONReporter) Report(ch chan *Result) error {
 
 // JSONCoverageReporter reports coverage as a JSON structure.
 type JSONCoverageReporter struct {
-	Cover     *cover.Cover
-	Modules   map[string]*ast.Module
-	Output    io.Writer
-	Threshold float64
-	Verbose   bool
+	Cover               *cover.Cover
+	Modules             map[string]*ast.Module
+	Output              io.Writer
+	Threshold           float64
+	ExpressionThreshold float64
+	BranchThreshold     float64
+	Verbose             bool
 }
 
 // Report prints the test report to the reporter's output. If any tests fail or
 // encounter errors, this function returns an error.
 func (r JSONCoverageReporter) Report(ch chan *Result) error {
+	report, err := coverageReport(ch, r.Cover, r.Modules, r.Threshold, r.ExpressionThreshold, r.BranchThreshold, r.Verbose)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(r.Output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// CoberturaCoverageReporter reports coverage in the Cobertura XML format.
+type CoberturaCoverageReporter struct {
+	Cover               *cover.Cover
+	Modules             map[string]*ast.Module
+	Output              io.Writer
+	Threshold           float64
+	ExpressionThreshold float64
+	BranchThreshold     float64
+	Verbose             bool
+}
+
+// Report prints the coverage report to the reporter's output. If any tests
+// fail or encounter errors, this function returns an error.
+func (r CoberturaCoverageReporter) Report(ch chan *Result) error {
+	report, err := coverageReport(ch, r.Cover, r.Modules, r.Threshold, r.ExpressionThreshold, r.BranchThreshold, r.Verbose)
+	if err != nil {
+		return err
+	}
+	return report.WriteCobertura(r.Output, time.Now().UnixMilli())
+}
+
+// LCOVCoverageReporter reports coverage in the LCOV tracefile format.
+type LCOVCoverageReporter struct {
+	Cover               *cover.Cover
+	Modules             map[string]*ast.Module
+	Output              io.Writer
+	Threshold           float64
+	ExpressionThreshold float64
+	BranchThreshold     float64
+	Verbose             bool
+}
+
+// Report prints the coverage report to the reporter's output. If any tests
+// fail or encounter errors, this function returns an error.
+func (r LCOVCoverageReporter) Report(ch chan *Result) error {
+	report, err := coverageReport(ch, r.Cover, r.Modules, r.Threshold, r.ExpressionThreshold, r.BranchThreshold, r.Verbose)
+	if err != nil {
+		return err
+	}
+	return report.WriteLCOV(r.Output)
+}
+
+// coverageReport drains the test results and returns the coverage report, or
+// an error if a test did not pass or the coverage is below a threshold.
+func coverageReport(ch chan *Result, cov *cover.Cover, modules map[string]*ast.Module, threshold, expressionThreshold, branchThreshold float64, verbose bool) (*cover.Report, error) {
 	for tr := range ch {
 		if !tr.Pass() {
 			if tr.Error != nil {
-				return tr.Error
+				return nil, tr.Error
 			}
-			return errors.New(tr.String())
+			return nil, errors.New(tr.String())
 		}
 	}
-	report := r.Cover.Report(r.Modules)
-
-	if report.Coverage < r.Threshold {
-		err := cover.CoverageThresholdError{
-			Coverage:  report.Coverage,
-			Threshold: r.Threshold,
-		}
+	report := cov.Report(modules)
 
-		if r.Verbose {
+	if err := report.CheckThresholds(threshold, expressionThreshold, branchThreshold); err != nil {
+		if verbose {
 			err.Report = &report
 		}
 
-		return &err
+		return nil, err
 	}
 
-	encoder := json.NewEncoder(r.Output)
-	encoder.SetIndent("", "  ")
-	return encoder.Encode(report)
+	return &report, nil
 }
 
 type indentingWriter struct {
-	w io.Writer
+	w       io.Writer
+	midLine bool
 }
 
-func newIndentingWriter(w io.Writer) indentingWriter {
-	return indentingWriter{
+func newIndentingWriter(w io.Writer) *indentingWriter {
+	return &indentingWriter{
 		w: w,
 	}
 }
 
-func (w indentingWriter) Write(bs []byte) (int, error) {
+func (w *indentingWriter) Write(bs []byte) (int, error) {
 	var written int
-	// insert indentation at the start of every line.
-	indent := true
+	// insert indentation at the start of every non-empty line.
 	for _, b := range bs {
-		if indent {
-			wrote, err := w.w.Write([]byte("  "))
-			if err != nil {
+		if !w.midLine && b != '\n' {
+			if _, err := w.w.Write([]byte("  ")); err != nil {
 				return written, err
 			}
-			written += wrote
 		}
 		wrote, err := w.w.Write([]byte{b})
 		if err != nil {
 			return written, err
 		}
 		written += wrote
-		indent = b == '\n'
+		w.midLine = b != '\n'
 	}
 	return written, nil
 }
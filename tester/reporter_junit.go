@@ -0,0 +1,181 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// JUnitReporter reports test results in the JUnit XML format understood by
+// most CI systems. Tests are grouped into one test suite per package and the
+// cases of parameterized tests are reported as individual test cases.
+type JUnitReporter struct {
+	Output io.Writer
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	TestCases []*junitTestCase `xml:"testcase"`
+
+	duration time.Duration
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Body    string `xml:",chardata"`
+}
+
+// Report prints the test report to the reporter's output.
+func (r JUnitReporter) Report(ch chan *Result) error {
+	report := junitTestSuites{Name: "opa"}
+	suites := map[string]*junitTestSuite{}
+	var duration time.Duration
+
+	for tr := range ch {
+		for _, c := range flattenResult(tr) {
+			suite, ok := suites[c.Package]
+			if !ok {
+				suite = &junitTestSuite{Name: c.Package}
+				suites[c.Package] = suite
+				report.Suites = append(report.Suites, suite)
+			}
+			tc := &junitTestCase{
+				Name:      c.Name,
+				ClassName: c.Package,
+				Time:      junitSeconds(c.Duration),
+			}
+			if c.Location != nil {
+				tc.File = c.Location.File
+				tc.Line = c.Location.Row
+			}
+
+			var out bytes.Buffer
+			out.Write(c.Output)
+
+			switch {
+			case c.Skip:
+				suite.Skipped++
+				tc.Skipped = &junitMessage{Message: "test skipped"}
+			case c.Error != nil:
+				suite.Errors++
+				tc.Error = &junitMessage{Message: c.Error.Error(), Type: "error"}
+			case c.Fail:
+				suite.Failures++
+				tc.Failure = &junitMessage{Message: "test failed", Type: "fail", Body: failureDetails(c)}
+			}
+
+			// Traces are only recorded when tracing is enabled, e.g., with
+			// `opa test --verbose`.
+			if len(c.Trace) > 0 {
+				if out.Len() > 0 {
+					out.WriteString("\n")
+				}
+				topdown.PrettyTraceWithLocation(&out, c.Trace)
+			}
+			tc.SystemOut = out.String()
+
+			suite.Tests++
+			suite.duration += c.Duration
+			duration += c.Duration
+			suite.TestCases = append(suite.TestCases, tc)
+		}
+	}
+
+	for _, suite := range report.Suites {
+		suite.Time = junitSeconds(suite.duration)
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+	}
+	report.Time = junitSeconds(duration)
+
+	bs, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(r.Output, xml.Header)
+	fmt.Fprintln(r.Output, string(bs))
+	return nil
+}
+
+// flattenResult returns the cases of a parameterized test, or the result
+// itself. The outcome of a parameterized test is fully determined by its
+// cases, so nothing is lost by reporting the cases only.
+func flattenResult(tr *Result) []*Result {
+	if len(tr.SubResults) == 0 {
+		return []*Result{tr}
+	}
+	return tr.SubResults
+}
+
+// failureDetails describes where a failed test stopped evaluating and, for
+// property and snapshot tests, the input it failed for and the differences
+// to the snapshot.
+func failureDetails(tr *Result) string {
+	var sb strings.Builder
+	if tr.FailedAt != nil {
+		if tr.FailedAt.Location != nil {
+			fmt.Fprintf(&sb, "%v: ", tr.FailedAt.Location)
+		}
+		sb.WriteString(tr.FailedAt.String())
+	}
+	if tr.Fail || tr.Error != nil {
+		var details []fmt.Stringer
+		if tr.Property != nil {
+			details = append(details, tr.Property)
+		}
+		if tr.Snapshot != nil {
+			details = append(details, tr.Snapshot)
+		}
+		for _, x := range details {
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(x.String())
+		}
+	}
+	return sb.String()
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
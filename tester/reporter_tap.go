@@ -0,0 +1,80 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// TAPReporter reports test results in the Test Anything Protocol (TAP)
+// version 13 format. Details about failures and errors are reported in YAML
+// blocks, print output and traces as diagnostic lines.
+type TAPReporter struct {
+	Output io.Writer
+}
+
+// Report prints the test report to the reporter's output.
+func (r TAPReporter) Report(ch chan *Result) error {
+	var results []*Result
+	for tr := range ch {
+		results = append(results, flattenResult(tr)...)
+	}
+
+	fmt.Fprintln(r.Output, "TAP version 13")
+	fmt.Fprintf(r.Output, "1..%d\n", len(results))
+
+	for i, tr := range results {
+		status := "ok"
+		if tr.Fail || tr.Error != nil {
+			status = "not ok"
+		}
+		fmt.Fprintf(r.Output, "%s %d - %s.%s", status, i+1, tr.Package, tr.Name)
+		if tr.Skip {
+			fmt.Fprint(r.Output, " # SKIP")
+		}
+		fmt.Fprintln(r.Output)
+
+		if !tr.Pass() && !tr.Skip {
+			r.printDetails(tr)
+		}
+
+		var diag bytes.Buffer
+		diag.Write(tr.Output)
+		if len(tr.Trace) > 0 {
+			topdown.PrettyTraceWithLocation(&diag, tr.Trace)
+		}
+		for _, line := range strings.Split(strings.TrimRight(diag.String(), "\n"), "\n") {
+			if line != "" {
+				fmt.Fprintf(r.Output, "# %s\n", line)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r TAPReporter) printDetails(tr *Result) {
+	fmt.Fprintln(r.Output, "  ---")
+	if tr.Error != nil {
+		fmt.Fprintf(r.Output, "  message: %q\n", tr.Error.Error())
+		fmt.Fprintln(r.Output, "  severity: error")
+	} else {
+		fmt.Fprintln(r.Output, `  message: "test failed"`)
+		fmt.Fprintln(r.Output, "  severity: fail")
+	}
+	if details := failureDetails(tr); details != "" {
+		fmt.Fprintf(r.Output, "  failed_at: %q\n", details)
+	}
+	if tr.Location != nil {
+		fmt.Fprintf(r.Output, "  at: %q\n", tr.Location.String())
+	}
+	fmt.Fprintf(r.Output, "  duration_ms: %.3f\n", float64(tr.Duration.Microseconds())/1000)
+	fmt.Fprintln(r.Output, "  ...")
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/topdown"
@@ -238,6 +239,177 @@ ERROR: 1/7
 	}
 }
 
+func TestPrettyReporterSubResults(t *testing.T) {
+	loc := &ast.Location{File: "policy1.rego"}
+	ts := []*Result{
+		{
+			Package:  "data.foo.bar",
+			Name:     "test_add",
+			Fail:     true,
+			Location: loc,
+			SubResults: []*Result{
+				{Package: "data.foo.bar", Name: "test_add.one", Location: loc},
+				{Package: "data.foo.bar", Name: "test_add.two", Fail: true, Trace: getFakeTraceEvents(), Output: []byte("case two\n"), Location: loc},
+				{Package: "data.foo.bar", Name: "test_add.three", Error: fmt.Errorf("some err"), Location: loc},
+			},
+		},
+	}
+
+	tests := []struct {
+		verbose bool
+		exp     string
+	}{
+		{
+			exp: `policy1.rego:
+data.foo.bar.test_add: FAIL (0s)
+  data.foo.bar.test_add.two: FAIL (0s)
+
+    case two
+
+  data.foo.bar.test_add.three: ERROR (0s)
+    some err
+--------------------------------------------------------------------------------
+FAIL: 1/1
+`,
+		},
+		{
+			verbose: true,
+			exp: `FAILURES
+--------------------------------------------------------------------------------
+data.foo.bar.test_add.two: FAIL (0s)
+
+  query:1     | Fail true = false
+
+SUMMARY
+--------------------------------------------------------------------------------
+policy1.rego:
+data.foo.bar.test_add: FAIL (0s)
+  data.foo.bar.test_add.one: PASS (0s)
+  data.foo.bar.test_add.two: FAIL (0s)
+
+    case two
+
+  data.foo.bar.test_add.three: ERROR (0s)
+    some err
+--------------------------------------------------------------------------------
+FAIL: 1/1
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		r := PrettyReporter{
+			Output:  &buf,
+			Verbose: tc.verbose,
+		}
+		if err := r.Report(resultsChan(ts)); err != nil {
+			t.Fatal(err)
+		}
+		if tc.exp != buf.String() {
+			t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", tc.exp, buf.String())
+		}
+	}
+}
+
+func ciReporterResults() []*Result {
+	loc := &ast.Location{File: "policy1.rego", Row: 3}
+	return []*Result{
+		{Package: "data.foo.bar", Name: "test_baz", Duration: 1500 * time.Microsecond, Location: loc},
+		{Package: "data.foo.bar", Name: "test_qux", Error: fmt.Errorf("some err"), Location: loc},
+		{Package: "data.foo.bar", Name: "test_corge", Fail: true, Trace: getFakeTraceEvents(), Output: []byte("fake print output\n"), Location: loc},
+		{Package: "data.foo.bar", Name: "todo_test_qux", Skip: true, Location: loc},
+		{
+			Package:  "data.foo.baz",
+			Name:     "test_add",
+			Fail:     true,
+			Location: loc,
+			SubResults: []*Result{
+				{Package: "data.foo.baz", Name: "test_add.one", Location: loc},
+				{Package: "data.foo.baz", Name: "test_add.two", Fail: true, Location: loc},
+			},
+		},
+	}
+}
+
+func TestJUnitReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := JUnitReporter{Output: &buf}
+	if err := r.Report(resultsChan(ciReporterResults())); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="opa" tests="6" failures="2" errors="1" skipped="1" time="0.002">
+  <testsuite name="data.foo.bar" tests="4" failures="1" errors="1" skipped="1" time="0.002">
+    <testcase name="test_baz" classname="data.foo.bar" file="policy1.rego" line="3" time="0.002"></testcase>
+    <testcase name="test_qux" classname="data.foo.bar" file="policy1.rego" line="3" time="0.000">
+      <error message="some err" type="error"></error>
+    </testcase>
+    <testcase name="test_corge" classname="data.foo.bar" file="policy1.rego" line="3" time="0.000">
+      <failure message="test failed" type="fail"></failure>
+      <system-out>fake print output&#xA;&#xA;query:1     | Fail true = false&#xA;</system-out>
+    </testcase>
+    <testcase name="todo_test_qux" classname="data.foo.bar" file="policy1.rego" line="3" time="0.000">
+      <skipped message="test skipped"></skipped>
+    </testcase>
+  </testsuite>
+  <testsuite name="data.foo.baz" tests="2" failures="1" errors="0" skipped="0" time="0.000">
+    <testcase name="test_add.one" classname="data.foo.baz" file="policy1.rego" line="3" time="0.000"></testcase>
+    <testcase name="test_add.two" classname="data.foo.baz" file="policy1.rego" line="3" time="0.000">
+      <failure message="test failed" type="fail"></failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+
+	if exp != buf.String() {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+	}
+}
+
+func TestTAPReporter(t *testing.T) {
+	var buf bytes.Buffer
+	r := TAPReporter{Output: &buf}
+	if err := r.Report(resultsChan(ciReporterResults())); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `TAP version 13
+1..6
+ok 1 - data.foo.bar.test_baz
+not ok 2 - data.foo.bar.test_qux
+  ---
+  message: "some err"
+  severity: error
+  at: "policy1.rego:3"
+  duration_ms: 0.000
+  ...
+not ok 3 - data.foo.bar.test_corge
+  ---
+  message: "test failed"
+  severity: fail
+  at: "policy1.rego:3"
+  duration_ms: 0.000
+  ...
+# fake print output
+# query:1     | Fail true = false
+ok 4 - data.foo.bar.todo_test_qux # SKIP
+ok 5 - data.foo.baz.test_add.one
+not ok 6 - data.foo.baz.test_add.two
+  ---
+  message: "test failed"
+  severity: fail
+  at: "policy1.rego:3"
+  duration_ms: 0.000
+  ...
+`
+
+	if exp != buf.String() {
+		t.Fatalf("Expected:\n\n%v\n\nGot:\n\n%v", exp, buf.String())
+	}
+}
+
 func TestJSONReporter(t *testing.T) {
 	var buf bytes.Buffer
 	ts := []*Result{
@@ -24,6 +24,7 @@ import (
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/builtins"
 )
 
 // TestPrefix declares the prefix for all test rules.
@@ -68,6 +69,9 @@ type Result struct {
 	Output          []byte                   `json:"output,omitempty"`
 	FailedAt        *ast.Expr                `json:"failed_at,omitempty"`
 	BenchmarkResult *testing.BenchmarkResult `json:"benchmark_result,omitempty"`
+	SubResults      []*Result                `json:"sub_results,omitempty"`
+	Property        *PropertyResult          `json:"property,omitempty"`
+	Snapshot        *SnapshotResult          `json:"snapshot,omitempty"`
 }
 
 func newResult(loc *ast.Location, pkg, name string, duration time.Duration, trace []*topdown.Event, output []byte) *Result {
@@ -93,6 +97,21 @@ func (r *Result) String() string {
 	return fmt.Sprintf("%v.%v: %v (%v)", r.Package, r.Name, r.outcome(), r.Duration)
 }
 
+// failures returns the failed sub-results of parameterized tests, or the
+// result itself.
+func (r *Result) failures() []*Result {
+	if len(r.SubResults) == 0 {
+		return []*Result{r}
+	}
+	var result []*Result
+	for _, sub := range r.SubResults {
+		if sub.Fail {
+			result = append(result, sub)
+		}
+	}
+	return result
+}
+
 func (r *Result) outcome() string {
 	if r.Pass() {
 		return "PASS"
@@ -126,6 +145,14 @@ type Runner struct {
 	filter                string
 	target                string // target type (wasm, rego, etc.)
 	customBuiltins        []*Builtin
+	testRegex             *regexp.Regexp
+	updateSnapshots       bool
+	fixtures              map[string]*fixture
+	recordCassettes       bool
+	cassettes             map[string]builtins.NDBCache
+	parallel              int
+	shard                 int
+	shards                int
 }
 
 // NewRunner returns a new runner.
@@ -206,6 +233,38 @@ func (r *Runner) EnableTracing(yes bool) *Runner {
 	return r
 }
 
+// UpdateSnapshots stores the values of snapshot tests as their snapshots,
+// instead of comparing them with the stored snapshots.
+func (r *Runner) UpdateSnapshots(yes bool) *Runner {
+	r.updateSnapshots = yes
+	return r
+}
+
+// RecordCassettes stores the calls of non-deterministic built-in functions
+// made by the tests in cassettes, instead of replaying the calls stored in
+// them (see CassetteDir).
+func (r *Runner) RecordCassettes(yes bool) *Runner {
+	r.recordCassettes = yes
+	return r
+}
+
+// Parallel sets the number of tests run concurrently. Values less than two run
+// the tests one at a time (the default). Results are reported in the same
+// order regardless of the number of tests run concurrently.
+func (r *Runner) Parallel(n int) *Runner {
+	r.parallel = n
+	return r
+}
+
+// Shard splits the tests into count shards and only runs the tests of the
+// shard with the given index, starting at 1, e.g., to split the tests across
+// several machines. Each test belongs to exactly one shard.
+func (r *Runner) Shard(index, count int) *Runner {
+	r.shard = index
+	r.shards = count
+	return r
+}
+
 // SetRuntime sets runtime information to expose to the evaluation engine.
 func (r *Runner) SetRuntime(term *ast.Term) *Runner {
 	r.runtime = term
@@ -269,20 +328,26 @@ func (r *Runner) Run(ctx context.Context, modules map[string]*ast.Module) (ch ch
 
 // RunTests executes tests found in either modules or bundles loaded on the runner.
 func (r *Runner) RunTests(ctx context.Context, txn storage.Transaction) (ch chan *Result, err error) {
-	return r.runTests(ctx, txn, true, r.runTest)
+	return r.runTests(ctx, txn, true, r.parallel, (*Runner).runTest)
 }
 
 // RunBenchmarks executes tests similar to tester.Runner#RunTests but will repeat
 // a number of times to get stable performance metrics.
 func (r *Runner) RunBenchmarks(ctx context.Context, txn storage.Transaction, options BenchmarkOptions) (ch chan *Result, err error) {
-	return r.runTests(ctx, txn, false, func(ctx context.Context, txn storage.Transaction, module *ast.Module, rule *ast.Rule) (result *Result, b bool) {
+	return r.runTests(ctx, txn, false, 1, func(r *Runner, ctx context.Context, txn storage.Transaction, module *ast.Module, rule *ast.Rule) (result *Result, b bool) {
 		return r.runBenchmark(ctx, txn, module, rule, options)
 	})
 }
 
-type run func(context.Context, storage.Transaction, *ast.Module, *ast.Rule) (*Result, bool)
+// run runs a test with the runner, which is a copy of the original runner
+// when tests are run concurrently.
+type run func(*Runner, context.Context, storage.Transaction, *ast.Module, *ast.Rule) (*Result, bool)
+
+func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePrintStatements bool, parallel int, runFunc run) (chan *Result, error) {
+	if r.shards > 0 && (r.shard < 1 || r.shard > r.shards) {
+		return nil, fmt.Errorf("invalid shard %d/%d", r.shard, r.shards)
+	}
 
-func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePrintStatements bool, runFunc run) (chan *Result, error) {
 	var testRegex *regexp.Regexp
 	var err error
 
@@ -292,6 +357,7 @@ func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePr
 			return nil, err
 		}
 	}
+	r.testRegex = testRegex
 
 	if r.compiler == nil {
 		capabilities := ast.CapabilitiesForThisVersion()
@@ -361,25 +427,50 @@ func (r *Runner) runTests(ctx context.Context, txn storage.Transaction, enablePr
 
 	sort.Strings(filenames)
 
+	var tests []testCase
+	for _, name := range filenames {
+		module := r.compiler.Modules[name]
+		for _, rule := range module.Rules {
+			if !r.shouldRun(rule, testRegex) {
+				continue
+			}
+			tests = append(tests, testCase{module: module, rule: rule})
+		}
+	}
+
+	if r.shards > 0 {
+		tests = shardTests(tests, r.shard, r.shards)
+	}
+
+	if parallel > 1 && len(tests) > 1 {
+		workers, done, err := r.newTestWorkers(ctx, txn, min(parallel, len(tests)))
+		if err != nil {
+			return nil, err
+		}
+		ch := make(chan *Result)
+		go func() {
+			defer close(ch)
+			defer done()
+			runParallel(ctx, workers, tests, runFunc, ch)
+		}()
+		return ch, nil
+	}
+
 	ch := make(chan *Result)
 
 	go func() {
 		defer close(ch)
-		for _, name := range filenames {
-			module := r.compiler.Modules[name]
-			for _, rule := range module.Rules {
-				if !r.shouldRun(rule, testRegex) {
-					continue
-				}
-				tr, stop := func() (*Result, bool) {
-					runCtx, cancel := context.WithTimeout(ctx, r.timeout)
-					defer cancel()
-					return runFunc(runCtx, txn, module, rule)
-				}()
+		for _, tc := range tests {
+			tr, stop := func() (*Result, bool) {
+				runCtx, cancel := context.WithTimeout(ctx, r.timeout)
+				defer cancel()
+				return runFunc(r, runCtx, txn, tc.module, tc.rule)
+			}()
+			if tr != nil {
 				ch <- tr
-				if stop {
-					return
-				}
+			}
+			if stop {
+				return
 			}
 		}
 	}()
@@ -395,15 +486,57 @@ func (r *Runner) shouldRun(rule *ast.Rule, testRegex *regexp.Regexp) bool {
 		return false
 	}
 
-	// Even with the prefix it needs to pass the regex (if applicable)
-	fullName := rule.Ref().String()
-	if testRegex != nil && !testRegex.MatchString(fullName) {
+	// Even with the prefix it needs to pass the regex (if applicable).
+	// Parameterized tests are run if the regex matches any of their cases,
+	// which is only known once their cases have been evaluated.
+	fullName := testRef(rule).String()
+	parameterized := parameterKey(rule) != nil && !strings.HasPrefix(ruleName, SkipTestPrefix)
+	if testRegex != nil && !testRegex.MatchString(fullName) && !parameterized {
 		return false
 	}
 
 	return true
 }
 
+// parameterKey returns the variable a parameterized test rule, e.g.,
+// test_add[note] { some note, tc in cases; ... }, is keyed by. Each value of
+// the variable is a test case.
+func parameterKey(rule *ast.Rule) *ast.Term {
+	key := rule.Head.Key
+	if key == nil {
+		if ref := rule.Head.Ref(); len(ref) > 1 {
+			key = ref[len(ref)-1]
+		}
+	}
+	if key == nil {
+		return nil
+	}
+	if _, ok := key.Value.(ast.Var); !ok {
+		return nil
+	}
+	return key
+}
+
+// testRef returns the ref of the test rule without the key of parameterized
+// tests.
+func testRef(rule *ast.Rule) ast.Ref {
+	ref := rule.Ref()
+	if key := parameterKey(rule); key != nil && ref[len(ref)-1].Equal(key) {
+		return ref[:len(ref)-1]
+	}
+	return ref
+}
+
+// testName returns the name of the test rule relative to its package, e.g.,
+// test_add.
+func testName(rule *ast.Rule) string {
+	ref := rule.Head.Ref()
+	if key := parameterKey(rule); key != nil && ref[len(ref)-1].Equal(key) {
+		ref = ref[:len(ref)-1]
+	}
+	return ref.String()
+}
+
 // rewriteDuplicateTestNames will rewrite duplicate test names to have a numbered suffix.
 // This uses a global "count" of each to ensure compiling more than once as new modules
 // are added can't introduce duplicates again.
@@ -418,11 +551,12 @@ func rewriteDuplicateTestNames(compiler *ast.Compiler) *ast.Error {
 			key := rule.Ref().String()
 			if k, ok := count[key]; ok {
 				ref := rule.Head.Ref()
+				i := nameIndex(ref)
 				newName := fmt.Sprintf("%s#%02d", name, k)
-				if len(ref) == 1 {
+				if i == 0 {
 					ref[0] = ast.VarTerm(newName)
 				} else {
-					ref[len(ref)-1] = ast.StringTerm(newName)
+					ref[i] = ast.StringTerm(newName)
 				}
 				rule.Head.SetRef(ref)
 			}
@@ -432,6 +566,24 @@ func rewriteDuplicateTestNames(compiler *ast.Compiler) *ast.Error {
 	return nil
 }
 
+// customAnnotation returns the value of the key in the custom annotations
+// declared for the rule, or nil if the key is not set.
+func (r *Runner) customAnnotation(rule *ast.Rule, key string) interface{} {
+	as := r.compiler.GetAnnotationSet()
+	if as == nil {
+		return nil
+	}
+	for _, ref := range as.Chain(rule) {
+		if ref.Annotations == nil || (ref.Annotations.Scope != "rule" && ref.Annotations.Scope != "document") {
+			continue
+		}
+		if v, ok := ref.Annotations.Custom[key]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
 // ruleName is a helper to be used when checking if a function
 // (a) is a test, or
 // (b) needs to be skipped
@@ -439,7 +591,7 @@ func rewriteDuplicateTestNames(compiler *ast.Compiler) *ast.Error {
 // use rule.Head.Ref()
 func ruleName(h *ast.Head) string {
 	ref := h.Ref()
-	switch last := ref[len(ref)-1].Value.(type) {
+	switch last := ref[nameIndex(ref)].Value.(type) {
 	case ast.Var:
 		return string(last)
 	case ast.String:
@@ -449,7 +601,140 @@ func ruleName(h *ast.Head) string {
 	}
 }
 
+// nameIndex returns the index of the rule name in the head ref, skipping the
+// variable parameterized tests are keyed by, e.g., test_add[note].
+func nameIndex(ref ast.Ref) int {
+	i := len(ref) - 1
+	if _, ok := ref[i].Value.(ast.Var); ok && i > 0 {
+		i--
+	}
+	return i
+}
+
 func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.Module, rule *ast.Rule) (*Result, bool) {
+	ruleName := ruleName(rule.Head)
+	if strings.HasPrefix(ruleName, SkipTestPrefix) { // TODO(sr): add test
+		tr := newResult(rule.Loc(), mod.Package.Path.String(), testName(rule), 0*time.Second, nil, nil)
+		tr.Skip = true
+		return tr, false
+	}
+
+	options, done, err := r.fixtureOptions(ctx, txn, mod, rule)
+	if err != nil {
+		tr := newResult(rule.Loc(), mod.Package.Path.String(), testName(rule), 0, nil, nil)
+		tr.Error = err
+		return tr, false
+	}
+	defer done()
+
+	if key := parameterKey(rule); key != nil {
+		return r.runParameterizedTest(ctx, txn, mod, rule, key, options...)
+	}
+
+	cfg, err := r.propertyConfig(rule)
+	if err != nil {
+		tr := newResult(rule.Loc(), mod.Package.Path.String(), testName(rule), 0, nil, nil)
+		tr.Error = err
+		return tr, false
+	}
+	if cfg != nil {
+		return r.runPropertyTest(ctx, txn, mod, rule, cfg, options...)
+	}
+
+	return r.runTestQuery(ctx, txn, mod, rule, rule.Head.Ref().String(), rule.Path(), false, options...)
+}
+
+// runParameterizedTest runs each case of a parameterized test as a sub-test.
+// The cases are the values of the key variable bound by the expressions of
+// the rule body up to the first one referring to it. The options are applied
+// to the queries of all cases.
+func (r *Runner) runParameterizedTest(ctx context.Context, txn storage.Transaction, mod *ast.Module, rule *ast.Rule, key *ast.Term, options ...func(*rego.Rego)) (*Result, bool) {
+	tr := newResult(rule.Loc(), mod.Package.Path.String(), testName(rule), 0, nil, nil)
+
+	cases, err := r.parameterizedTestCases(ctx, txn, rule, key, options...)
+	if err != nil {
+		tr.Error = err
+		return tr, topdown.IsCancel(err) && ctx.Err() != context.DeadlineExceeded
+	}
+
+	name := rule.Head.Ref()
+	if name[len(name)-1].Equal(key) {
+		name = name[:len(name)-1]
+	}
+	filterAll := r.testRegex == nil || r.testRegex.MatchString(testRef(rule).String())
+
+	for _, c := range cases {
+		path := rule.Path().Append(c)
+		if !filterAll && !r.testRegex.MatchString(path.String()) {
+			continue
+		}
+		sub, stop := r.runTestQuery(ctx, txn, mod, rule, name.Append(c).String(), path, rule.Head.Value == nil, options...)
+		tr.SubResults = append(tr.SubResults, sub)
+		tr.Duration += sub.Duration
+		if !sub.Pass() {
+			tr.Fail = true
+		}
+		if stop {
+			return tr, true
+		}
+	}
+
+	if len(tr.SubResults) == 0 {
+		if !filterAll {
+			return nil, false
+		}
+		tr.Fail = true
+	}
+
+	return tr, false
+}
+
+// parameterizedTestCases returns the sorted values of the key of a
+// parameterized test.
+func (r *Runner) parameterizedTestCases(ctx context.Context, txn storage.Transaction, rule *ast.Rule, key *ast.Term, options ...func(*rego.Rego)) ([]*ast.Term, error) {
+	var query ast.Body
+	for _, expr := range rule.Body {
+		query.Append(expr.Copy())
+		if expr.Vars(ast.VarVisitorParams{}).Contains(key.Value.(ast.Var)) {
+			break
+		}
+	}
+
+	// Bind the key to a var that is not filtered from the results.
+	vars := rule.Body.Vars(ast.VarVisitorParams{})
+	caseVar := ast.Var("case")
+	for i := 0; vars.Contains(caseVar); i++ {
+		caseVar = ast.Var(fmt.Sprintf("case%d", i))
+	}
+	query.Append(ast.Equality.Expr(ast.NewTerm(caseVar), key))
+
+	rs, err := rego.New(append([]func(*rego.Rego){
+		rego.Store(r.store),
+		rego.Transaction(txn),
+		rego.Compiler(r.compiler),
+		rego.ParsedQuery(query),
+		rego.Runtime(r.runtime),
+	}, options...)...).Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set := ast.NewSet()
+	for _, result := range rs {
+		v, err := ast.InterfaceToValue(result.Bindings[string(caseVar)])
+		if err != nil {
+			return nil, err
+		}
+		set.Add(ast.NewTerm(v))
+	}
+
+	return set.Slice(), nil
+}
+
+// runTestQuery evaluates the test rule (or test case) at path. The test passes
+// if it evaluates to true or, if defined is set, to any value. The options are
+// applied to the query, e.g., to provide an input.
+func (r *Runner) runTestQuery(ctx context.Context, txn storage.Transaction, mod *ast.Module, rule *ast.Rule, name string, path ast.Ref, defined bool, options ...func(*rego.Rego)) (*Result, bool) {
 	var bufferTracer *topdown.BufferTracer
 	var bufFailureLineTracer *topdown.BufferTracer
 	var tracer topdown.QueryTracer
@@ -461,26 +746,26 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 		tracer = bufferTracer
 	}
 
-	ruleName := ruleName(rule.Head)
-	if strings.HasPrefix(ruleName, SkipTestPrefix) { // TODO(sr): add test
-		tr := newResult(rule.Loc(), mod.Package.Path.String(), rule.Head.Ref().String(), 0*time.Second, nil, nil)
-		tr.Skip = true
+	cassette, saveCassette, err := r.cassetteOptions(rule, name)
+	if err != nil {
+		tr := newResult(rule.Loc(), mod.Package.Path.String(), name, 0, nil, nil)
+		tr.Error = err
 		return tr, false
 	}
 
 	printbuf := bytes.NewBuffer(nil)
 	var builtinErrors []topdown.Error
-	rg := rego.New(
+	rg := rego.New(append(append([]func(*rego.Rego){
 		rego.Store(r.store),
 		rego.Transaction(txn),
 		rego.Compiler(r.compiler),
-		rego.Query(rule.Path().String()),
+		rego.Query(path.String()),
 		rego.QueryTracer(tracer),
 		rego.Runtime(r.runtime),
 		rego.Target(r.target),
 		rego.PrintHook(topdown.NewPrintHook(printbuf)),
 		rego.BuiltinErrorList(&builtinErrors),
-	)
+	}, options...), cassette...)...)
 
 	// Register custom builtins on rego instance
 	for _, v := range r.customBuiltins {
@@ -491,12 +776,16 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 	rs, err := rg.Eval(ctx)
 	dt := time.Since(t0)
 
+	if saveErr := saveCassette(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+
 	var trace []*topdown.Event
 	if bufferTracer != nil {
 		trace = *bufferTracer
 	}
 
-	tr := newResult(rule.Loc(), mod.Package.Path.String(), rule.Head.Ref().String(), dt, trace, printbuf.Bytes())
+	tr := newResult(rule.Loc(), mod.Package.Path.String(), name, dt, trace, printbuf.Bytes())
 
 	// If there was an error other than errors from builtins, prefer that error.
 	if err != nil {
@@ -519,7 +808,14 @@ func (r *Runner) runTest(ctx context.Context, txn storage.Transaction, mod *ast.
 		if bufFailureLineTracer != nil {
 			tr.FailedAt = getFailedAtFromTrace(bufFailureLineTracer)
 		}
-	} else if b, ok := rs[0].Expressions[0].Value.(bool); !ok || !b {
+	} else if r.isSnapshotTest(rule) {
+		tr.Snapshot, err = r.checkSnapshot(rule, name, rs[0].Expressions[0].Value)
+		if err != nil {
+			tr.Error = err
+		} else if !tr.Snapshot.Updated && !tr.Snapshot.Pass() {
+			tr.Fail = true
+		}
+	} else if b, ok := rs[0].Expressions[0].Value.(bool); !defined && (!ok || !b) {
 		tr.Fail = true
 	}
 
@@ -530,7 +826,7 @@ func (r *Runner) runBenchmark(ctx context.Context, txn storage.Transaction, mod
 	tr := &Result{
 		Location: rule.Loc(),
 		Package:  mod.Package.Path.String(),
-		Name:     rule.Head.Ref().String(), // TODO(sr): test
+		Name:     testName(rule), // TODO(sr): test
 	}
 
 	var stop bool
@@ -586,7 +882,7 @@ func (r *Runner) runBenchmark(ctx context.Context, txn storage.Transaction, mod
 			} else if len(rs) == 0 {
 				tr.Fail = true
 				b.Fatal("Expected boolean result, got `undefined`")
-			} else if pass, ok := rs[0].Expressions[0].Value.(bool); !ok || !pass {
+			} else if pass, ok := rs[0].Expressions[0].Value.(bool); parameterKey(rule) == nil && (!ok || !pass) {
 				tr.Fail = true
 				b.Fatal("Expected test to evaluate as true, got false")
 			}
@@ -337,6 +337,116 @@ func TestRunWithFilterRegex(t *testing.T) {
 	})
 }
 
+func TestRunParameterized(t *testing.T) {
+	files := map[string]string{
+		"/a_test.rego": `package foo
+			import future.keywords.in
+
+			cases := {"one": {"a": 1, "b": 2}, "two": {"a": 2, "b": 4}, "three": {"a": 3, "b": 4}}
+
+			test_add[note] {
+				some note, tc in cases
+				tc.a + 1 == tc.b
+			}
+
+			test_lt[note] := true {
+				tc := cases[note]
+				tc.a < tc.b
+			}
+
+			test_none[note] {
+				some note, tc in {}
+				tc
+			}
+
+			todo_test_skip[note] {
+				some note, tc in cases
+				false
+			}
+			`,
+		"/b_test.rego": `package bar
+			import rego.v1
+
+			test_ref_head[x] if {
+				some x in [1, 2]
+				x < 3
+			}
+			`,
+	}
+
+	tests := []struct {
+		note   string
+		regex  string
+		result map[string]expectedTestResult
+		subs   map[string]map[string]bool
+	}{
+		{
+			note: "all cases",
+			result: map[string]expectedTestResult{
+				"test_add":       {wantFail: true},
+				"test_lt":        {},
+				"test_none":      {wantFail: true},
+				"todo_test_skip": {wantSkip: true},
+				"test_ref_head":  {},
+			},
+			subs: map[string]map[string]bool{
+				"test_add":      {"test_add.one": true, "test_add.three": true, "test_add.two": false},
+				"test_lt":       {"test_lt.one": true, "test_lt.three": true, "test_lt.two": true},
+				"test_ref_head": {"test_ref_head[1]": true, "test_ref_head[2]": true},
+			},
+		},
+		{
+			note:  "filter by test",
+			regex: "test_lt",
+			result: map[string]expectedTestResult{
+				"test_lt": {},
+			},
+			subs: map[string]map[string]bool{
+				"test_lt": {"test_lt.one": true, "test_lt.three": true, "test_lt.two": true},
+			},
+		},
+		{
+			note:  "filter by case",
+			regex: "test_add.t",
+			result: map[string]expectedTestResult{
+				"test_add": {wantFail: true},
+			},
+			subs: map[string]map[string]bool{
+				"test_add": {"test_add.three": true, "test_add.two": false},
+			},
+		},
+	}
+
+	test.WithTempFS(files, func(d string) {
+		for _, tc := range tests {
+			t.Run(tc.note, func(t *testing.T) {
+				rs, _ := doTestRunWithTmpDir(t, d, testRunConfig{filter: tc.regex})
+				if len(rs) != len(tc.result) {
+					t.Fatalf("Expected %d results but got %d: %v", len(tc.result), len(rs), rs)
+				}
+				for _, r := range rs {
+					exp, ok := tc.result[r.Name]
+					if !ok {
+						t.Fatalf("Unexpected result %v", r)
+					}
+					if exp.wantFail != r.Fail || exp.wantSkip != r.Skip || r.Error != nil {
+						t.Errorf("Expected %+v for %v but got: %v", exp, r.Name, r)
+					}
+					subs := map[string]bool{}
+					for _, sub := range r.SubResults {
+						subs[sub.Name] = sub.Pass()
+					}
+					if len(subs) > 0 || len(tc.subs[r.Name]) > 0 {
+						if !reflect.DeepEqual(subs, tc.subs[r.Name]) {
+							t.Errorf("Expected sub-results %v for %v but got %v", tc.subs[r.Name], r.Name, subs)
+						}
+					}
+				}
+			})
+		}
+	})
+}
+
 func TestRunnerCancel(t *testing.T) {
 	testCancel(t, false)
 }
@@ -0,0 +1,272 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/util"
+)
+
+// SnapshotKey is the key of the custom annotation that declares a test rule
+// as a snapshot test. Instead of being true, the value of a snapshot test must
+// match the snapshot stored for it.
+const SnapshotKey = "snapshot"
+
+// SnapshotDir is the name of the directories snapshots are stored in. The
+// snapshots of a test file are stored in a directory named after the file,
+// e.g., the snapshot of test_x in authz_test.rego is stored in
+// __snapshots__/authz_test/test_x.json.
+const SnapshotDir = "__snapshots__"
+
+// SnapshotResult describes the comparison of a snapshot test's value with its
+// stored snapshot.
+type SnapshotResult struct {
+	File string `json:"file"`
+
+	// Missing is set if no snapshot is stored for the test.
+	Missing bool `json:"missing,omitempty"`
+
+	// Updated is set if the snapshot was written with the test's value.
+	Updated bool `json:"updated,omitempty"`
+
+	// Diff is the list of differences between the stored snapshot and the
+	// test's value.
+	Diff []*SnapshotDiff `json:"diff,omitempty"`
+}
+
+// SnapshotDiff is a difference between a snapshot and a test's value. The
+// values are encoded as JSON. If a value is absent at the path, the expected
+// or actual value is empty.
+type SnapshotDiff struct {
+	Path     string `json:"path"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+func (d *SnapshotDiff) String() string {
+	switch {
+	case d.Expected == "":
+		return fmt.Sprintf("%v: unexpected %v", d.Path, d.Actual)
+	case d.Actual == "":
+		return fmt.Sprintf("%v: missing %v", d.Path, d.Expected)
+	}
+	return fmt.Sprintf("%v: expected %v, got %v", d.Path, d.Expected, d.Actual)
+}
+
+// Pass returns true if the test's value matches the snapshot.
+func (s *SnapshotResult) Pass() bool {
+	return !s.Missing && len(s.Diff) == 0
+}
+
+func (s *SnapshotResult) String() string {
+	switch {
+	case s.Updated:
+		return fmt.Sprintf("snapshot updated: %v", s.File)
+	case s.Missing:
+		return fmt.Sprintf("snapshot missing: %v (run with --update-snapshots to create it)", s.File)
+	case len(s.Diff) == 0:
+		return fmt.Sprintf("snapshot matches: %v", s.File)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "snapshot mismatch: %v", s.File)
+	for _, d := range s.Diff {
+		fmt.Fprintf(&sb, "\n  %v", d)
+	}
+	return sb.String()
+}
+
+func (r *Runner) isSnapshotTest(rule *ast.Rule) bool {
+	b, ok := r.customAnnotation(rule, SnapshotKey).(bool)
+	return ok && b
+}
+
+// snapshotFile returns the file the snapshot of the named test declared by the
+// rule is stored in.
+func snapshotFile(rule *ast.Rule, name string) (string, error) {
+	loc := rule.Loc()
+	if loc == nil || loc.File == "" {
+		return "", fmt.Errorf("snapshot tests require policies loaded from files")
+	}
+	base := strings.TrimSuffix(filepath.Base(loc.File), filepath.Ext(loc.File))
+	return filepath.Join(filepath.Dir(loc.File), SnapshotDir, base, snapshotFileName(name)+".json"), nil
+}
+
+// snapshotFileName replaces the characters of a test name that are not safe to
+// use in file names, e.g., the brackets and quotes of parameterized tests.
+func snapshotFileName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// checkSnapshot compares the value with the stored snapshot of the named test,
+// or stores it if snapshots are updated.
+func (r *Runner) checkSnapshot(rule *ast.Rule, name string, value interface{}) (*SnapshotResult, error) {
+	file, err := snapshotFile(rule, name)
+	if err != nil {
+		return nil, err
+	}
+	result := &SnapshotResult{File: file}
+
+	if r.updateSnapshots {
+		bs, err := snapshotJSON(value, "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(file, []byte(bs+"\n"), 0o644); err != nil {
+			return nil, err
+		}
+		result.Updated = true
+		return result, nil
+	}
+
+	bs, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		result.Missing = true
+		return result, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var expected interface{}
+	if err := util.UnmarshalJSON(bs, &expected); err != nil {
+		return nil, fmt.Errorf("%v: %w", file, err)
+	}
+
+	result.Diff, err = diffSnapshot("$", expected, value)
+	return result, err
+}
+
+// diffSnapshot returns the differences between the expected and actual values.
+// Objects are compared key by key and arrays element by element, so that a
+// difference deep inside a large value is reported at its path.
+func diffSnapshot(path string, expected, actual interface{}) ([]*SnapshotDiff, error) {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		if a, ok := actual.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(e)+len(a))
+			for k := range e {
+				keys = append(keys, k)
+			}
+			for k := range a {
+				if _, ok := e[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+
+			var result []*SnapshotDiff
+			for _, k := range keys {
+				p := snapshotPath(path, k)
+				ev, eok := e[k]
+				av, aok := a[k]
+				var diff []*SnapshotDiff
+				var err error
+				switch {
+				case !aok:
+					diff, err = snapshotDiff(p, ev, nil, true, false)
+				case !eok:
+					diff, err = snapshotDiff(p, nil, av, false, true)
+				default:
+					diff, err = diffSnapshot(p, ev, av)
+				}
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, diff...)
+			}
+			return result, nil
+		}
+	case []interface{}:
+		if a, ok := actual.([]interface{}); ok {
+			var result []*SnapshotDiff
+			for i := 0; i < len(e) || i < len(a); i++ {
+				p := fmt.Sprintf("%v[%d]", path, i)
+				var diff []*SnapshotDiff
+				var err error
+				switch {
+				case i >= len(a):
+					diff, err = snapshotDiff(p, e[i], nil, true, false)
+				case i >= len(e):
+					diff, err = snapshotDiff(p, nil, a[i], false, true)
+				default:
+					diff, err = diffSnapshot(p, e[i], a[i])
+				}
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, diff...)
+			}
+			return result, nil
+		}
+	}
+
+	// Values are compared as Rego values, so that numbers are equal regardless
+	// of their representation, e.g., 1 and 1.0.
+	ev, err := ast.InterfaceToValue(expected)
+	if err != nil {
+		return nil, err
+	}
+	av, err := ast.InterfaceToValue(actual)
+	if err != nil {
+		return nil, err
+	}
+	if ev.Compare(av) == 0 {
+		return nil, nil
+	}
+	return snapshotDiff(path, expected, actual, true, true)
+}
+
+func snapshotDiff(path string, expected, actual interface{}, hasExpected, hasActual bool) ([]*SnapshotDiff, error) {
+	d := &SnapshotDiff{Path: path}
+	var err error
+	if hasExpected {
+		if d.Expected, err = snapshotJSON(expected, ""); err != nil {
+			return nil, err
+		}
+	}
+	if hasActual {
+		if d.Actual, err = snapshotJSON(actual, ""); err != nil {
+			return nil, err
+		}
+	}
+	return []*SnapshotDiff{d}, nil
+}
+
+// snapshotJSON encodes the value as JSON without escaping HTML characters.
+func snapshotJSON(x interface{}, indent string) (string, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", indent)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(x); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// snapshotPath appends the key to the path, in the syntax of Rego references.
+func snapshotPath(path, key string) string {
+	if ast.IsVarCompatibleString(key) {
+		return path + "." + key
+	}
+	return path + "[" + strconv.Quote(key) + "]"
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package tester
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+func TestRunSnapshotTests(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	file := filepath.Join(dir, "authz_test.rego")
+
+	src := `package authz
+import rego.v1
+
+decision := {"allow": input.role == "admin", "reasons": [r | some r in input.reasons], "<tag>": "x"}
+
+# METADATA
+# custom:
+#   snapshot: true
+test_admin := d if {
+	d := decision with input as {"role": "admin", "reasons": ["a"]}
+}
+
+# METADATA
+# custom:
+#   snapshot: true
+test_guest := d if {
+	d := decision with input as {"role": "guest", "reasons": ["b", "c"]}
+}
+
+test_plain if true
+`
+
+	run := func(update bool) map[string]*Result {
+		t.Helper()
+		module, err := ast.ParseModuleWithOpts(file, src, ast.ParserOptions{RegoVersion: ast.RegoV1, ProcessAnnotation: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		store := inmem.New()
+		txn := storage.NewTransactionOrDie(ctx, store)
+		defer store.Abort(ctx, txn)
+
+		ch, err := NewRunner().
+			SetStore(store).
+			SetModules(map[string]*ast.Module{file: module}).
+			UpdateSnapshots(update).
+			RunTests(ctx, txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results := map[string]*Result{}
+		for tr := range ch {
+			results[tr.Name] = tr
+		}
+		return results
+	}
+
+	results := run(false)
+	if tr := results["test_admin"]; !tr.Fail || tr.Snapshot == nil || !tr.Snapshot.Missing {
+		t.Fatalf("Expected test_admin to fail with missing snapshot but got %v", tr)
+	}
+	if tr := results["test_plain"]; !tr.Pass() || tr.Snapshot != nil {
+		t.Fatalf("Expected test_plain to pass without snapshot but got %v", tr)
+	}
+
+	results = run(true)
+	for _, name := range []string{"test_admin", "test_guest"} {
+		if tr := results[name]; !tr.Pass() || tr.Snapshot == nil || !tr.Snapshot.Updated {
+			t.Fatalf("Expected %v to update its snapshot but got %v", name, tr)
+		}
+	}
+
+	snapshot := filepath.Join(dir, SnapshotDir, "authz_test", "test_guest.json")
+	bs, err := os.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := `{
+  "<tag>": "x",
+  "allow": false,
+  "reasons": [
+    "b",
+    "c"
+  ]
+}
+`
+	if string(bs) != exp {
+		t.Fatalf("Expected snapshot:\n%v\n\nGot:\n%v", exp, string(bs))
+	}
+
+	results = run(false)
+	for _, name := range []string{"test_admin", "test_guest"} {
+		if tr := results[name]; !tr.Pass() || tr.Snapshot == nil || !tr.Snapshot.Pass() {
+			t.Fatalf("Expected %v to match its snapshot but got %v", name, tr)
+		}
+	}
+
+	changed := `{"allow": true, "reasons": ["b", "d", "e"], "extra": 1.0}`
+	if err := os.WriteFile(snapshot, []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	results = run(false)
+	tr := results["test_guest"]
+	if !tr.Fail || tr.Snapshot == nil {
+		t.Fatalf("Expected test_guest to fail but got %v", tr)
+	}
+	expDiff := []*SnapshotDiff{
+		{Path: `$["<tag>"]`, Actual: `"x"`},
+		{Path: "$.allow", Expected: "true", Actual: "false"},
+		{Path: "$.extra", Expected: "1.0"},
+		{Path: "$.reasons[1]", Expected: `"d"`, Actual: `"c"`},
+		{Path: "$.reasons[2]", Expected: `"e"`},
+	}
+	if !reflect.DeepEqual(tr.Snapshot.Diff, expDiff) {
+		t.Fatalf("Expected diff %v but got %v", expDiff, tr.Snapshot.Diff)
+	}
+
+	var buf bytes.Buffer
+	rch := make(chan *Result, 1)
+	rch <- tr
+	close(rch)
+	if err := (PrettyReporter{Output: &buf}).Report(rch); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"snapshot mismatch: " + snapshot,
+		`$["<tag>"]: unexpected "x"`,
+		"$.allow: expected true, got false",
+		"$.extra: missing 1.0",
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Errorf("Expected report to contain %q but got:\n%v", line, buf.String())
+		}
+	}
+}
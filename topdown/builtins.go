@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net/http"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
@@ -52,8 +53,11 @@ type (
 		ParentID               uint64                // identifies parent of query being evaluated
 		PrintHook              print.Hook            // provides callback function to use for printing
 		DistributedTracingOpts tracing.Options       // options to be used by distributed tracing.
+		HTTPSendScheduler      *HTTPSendScheduler    // coordinates outbound http.send requests across queries
+		HTTPSendTransport      http.RoundTripper     // sends http.send requests instead of the default transport
 		rand                   *rand.Rand            // randomization source for non-security-sensitive operations
 		Capabilities           *ast.Capabilities
+		limiter                *evalLimiter // resource limits of the evaluation
 	}
 
 	// BuiltinFunc defines an interface for implementing built-in functions.
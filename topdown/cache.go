@@ -166,6 +166,10 @@ func (s *refStack) Push(refs []ast.Ref) {
 	s.sl = append(s.sl, refStackElem{refs: refs})
 }
 
+func (s *refStack) copy() *refStack {
+	return &refStack{sl: append([]refStackElem(nil), s.sl...)}
+}
+
 func (s *refStack) Pop() {
 	s.sl = s.sl[:len(s.sl)-1]
 }
@@ -276,6 +280,17 @@ func (s *functionMocksStack) Push() {
 	s.stack = append(s.stack, newFunctionMocksElem())
 }
 
+// copy returns a copy of the stack that can be pushed to and popped from
+// independently. Frames are never modified once put and so they are shared.
+func (s *functionMocksStack) copy() *functionMocksStack {
+	cpy := &functionMocksStack{stack: make([]*functionMocksElem, len(s.stack))}
+	for i := range s.stack {
+		elem := append(functionMocksElem(nil), *s.stack[i]...)
+		cpy.stack[i] = &elem
+	}
+	return cpy
+}
+
 func (s *functionMocksStack) Pop() {
 	s.stack = s.stack[:len(s.stack)-1]
 }
@@ -21,21 +21,70 @@ const (
 	defaultMaxSizeBytes                      = int64(0)   // unlimited
 	defaultForcedEvictionThresholdPercentage = int64(100) // trigger at max_size_bytes
 	defaultStaleEntryEvictionPeriodSeconds   = int64(0)   // never
+	defaultDistributedTimeoutMillis          = int64(500)
+	defaultDistributedRetryAfterSeconds      = int64(10)
+
+	// DistributedCacheTypeRedis selects a backend that speaks the Redis protocol.
+	DistributedCacheTypeRedis = "redis"
 )
 
 // Config represents the configuration of the inter-query cache.
 type Config struct {
 	InterQueryBuiltinCache InterQueryBuiltinCacheConfig `json:"inter_query_builtin_cache"`
+	HTTPSend               HTTPSendConfig               `json:"http_send"`
 }
 
 // InterQueryBuiltinCacheConfig represents the configuration of the inter-query cache that built-in functions can utilize.
 // MaxSizeBytes - max capacity of cache in bytes
 // ForcedEvictionThresholdPercentage - capacity usage in percentage after which forced FIFO eviction starts
 // StaleEntryEvictionPeriodSeconds - time period between end of previous and start of new stale entry eviction routine
+// Distributed - shared cache backend used in addition to the local cache
 type InterQueryBuiltinCacheConfig struct {
-	MaxSizeBytes                      *int64 `json:"max_size_bytes,omitempty"`
-	ForcedEvictionThresholdPercentage *int64 `json:"forced_eviction_threshold_percentage,omitempty"`
-	StaleEntryEvictionPeriodSeconds   *int64 `json:"stale_entry_eviction_period_seconds,omitempty"`
+	MaxSizeBytes                      *int64                  `json:"max_size_bytes,omitempty"`
+	ForcedEvictionThresholdPercentage *int64                  `json:"forced_eviction_threshold_percentage,omitempty"`
+	StaleEntryEvictionPeriodSeconds   *int64                  `json:"stale_entry_eviction_period_seconds,omitempty"`
+	Distributed                       *DistributedCacheConfig `json:"distributed,omitempty"`
+}
+
+// DistributedCacheConfig represents the configuration of a cache backend shared by OPA instances.
+// Type - type of the backend, e.g. "redis"
+// KeyPrefix - prefix of the keys stored in the backend
+// TimeoutMillis - timeout of requests to the backend
+// RetryAfterSeconds - time period during which the backend is not used after a failed request
+// Redis - configuration of the "redis" backend
+type DistributedCacheConfig struct {
+	Type              string       `json:"type"`
+	KeyPrefix         string       `json:"key_prefix,omitempty"`
+	TimeoutMillis     *int64       `json:"timeout_ms,omitempty"`
+	RetryAfterSeconds *int64       `json:"retry_after_seconds,omitempty"`
+	Redis             *RedisConfig `json:"redis,omitempty"`
+}
+
+// RedisConfig represents the configuration of a backend that speaks the Redis protocol.
+// Address - host and port of the server
+// Username, Password - credentials sent with the AUTH command, if set (requires TLS)
+// Database - index of the database selected with the SELECT command
+// MaxIdleConnections - max number of idle connections kept open
+// TLS - whether connections to the server use TLS
+// TLSCACertFile - path of the CA certificate used to verify the server, instead of the system roots
+// AllowInsecureTLS - whether the certificate of the server is not verified
+type RedisConfig struct {
+	Address            string `json:"address"`
+	Username           string `json:"username,omitempty"`
+	Password           string `json:"password,omitempty"`
+	Database           int    `json:"database,omitempty"`
+	MaxIdleConnections int    `json:"max_idle_connections,omitempty"`
+	TLS                bool   `json:"tls,omitempty"`
+	TLSCACertFile      string `json:"tls_ca_cert_file,omitempty"`
+	AllowInsecureTLS   bool   `json:"allow_insecure_tls,omitempty"`
+}
+
+// HTTPSendConfig represents the configuration of how requests issued by http.send are scheduled across queries.
+// CoalesceRequests - whether identical in-flight requests are coalesced into a single outbound request (default: false)
+// MaxConcurrentRequestsPerHost - max number of concurrent outbound requests per host (default: unlimited)
+type HTTPSendConfig struct {
+	CoalesceRequests             *bool  `json:"coalesce_requests,omitempty"`
+	MaxConcurrentRequestsPerHost *int64 `json:"max_concurrent_requests_per_host,omitempty"`
 }
 
 // ParseCachingConfig returns the config for the inter-query cache.
@@ -89,9 +138,56 @@ func (c *Config) validateAndInjectDefaults() error {
 			return fmt.Errorf("invalid stale_entry_eviction_period_seconds %v", period)
 		}
 	}
+	if c.HTTPSend.MaxConcurrentRequestsPerHost != nil {
+		maxConcurrent := *c.HTTPSend.MaxConcurrentRequestsPerHost
+		if maxConcurrent < 0 {
+			return fmt.Errorf("invalid max_concurrent_requests_per_host %v", maxConcurrent)
+		}
+	}
+	if c.InterQueryBuiltinCache.Distributed != nil {
+		return c.InterQueryBuiltinCache.Distributed.validateAndInjectDefaults()
+	}
 	return nil
 }
 
+func (c *DistributedCacheConfig) validateAndInjectDefaults() error {
+	switch c.Type {
+	case DistributedCacheTypeRedis:
+		if c.Redis == nil || c.Redis.Address == "" {
+			return fmt.Errorf("missing distributed cache redis address")
+		}
+		if c.Redis.Database < 0 {
+			return fmt.Errorf("invalid distributed cache redis database %v", c.Redis.Database)
+		}
+		if c.Redis.Password != "" && !c.Redis.TLS {
+			return fmt.Errorf("distributed cache redis password requires tls")
+		}
+	default:
+		return fmt.Errorf("invalid distributed cache type %q", c.Type)
+	}
+	if c.TimeoutMillis == nil {
+		timeout := new(int64)
+		*timeout = defaultDistributedTimeoutMillis
+		c.TimeoutMillis = timeout
+	} else if *c.TimeoutMillis <= 0 {
+		return fmt.Errorf("invalid distributed cache timeout_ms %v", *c.TimeoutMillis)
+	}
+	if c.RetryAfterSeconds == nil {
+		retryAfter := new(int64)
+		*retryAfter = defaultDistributedRetryAfterSeconds
+		c.RetryAfterSeconds = retryAfter
+	} else if *c.RetryAfterSeconds < 0 {
+		return fmt.Errorf("invalid distributed cache retry_after_seconds %v", *c.RetryAfterSeconds)
+	}
+	// Settings the backend cannot be created with (e.g., an unreadable CA
+	// certificate) are reported here rather than when the cache is created.
+	b, err := newDistributedBackend(c)
+	if err != nil {
+		return err
+	}
+	return b.Close()
+}
+
 // InterQueryCacheValue defines the interface for the data that the inter-query cache holds.
 type InterQueryCacheValue interface {
 	SizeInBytes() int64
@@ -108,13 +204,25 @@ type InterQueryCache interface {
 	Clone(value InterQueryCacheValue) (InterQueryCacheValue, error)
 }
 
+// InterQueryCacheRefresher is implemented by inter-query caches that keep track
+// of the values being refreshed in the background (e.g., stale http.send
+// responses), so that at most one refresh per key is in progress at any time.
+type InterQueryCacheRefresher interface {
+	// StartRefresh marks the value of key as being refreshed. It returns false
+	// if a refresh of the value is already in progress.
+	StartRefresh(key ast.Value) bool
+	// FinishRefresh marks the refresh of the value of key as done.
+	FinishRefresh(key ast.Value)
+}
+
 // NewInterQueryCache returns a new inter-query cache.
 // The cache uses a FIFO eviction policy when it reaches the forced eviction threshold.
+// If a distributed cache is configured, the cache is backed by it (see NewDistributedInterQueryCache).
 // Parameters:
 //
 //	config - to configure the InterQueryCache
 func NewInterQueryCache(config *Config) InterQueryCache {
-	return newCache(config)
+	return withDistributedBackend(newCache(config), config)
 }
 
 // NewInterQueryCacheWithContext returns a new inter-query cache with context.
@@ -145,7 +253,7 @@ func NewInterQueryCacheWithContext(ctx context.Context, config *Config) InterQue
 		}()
 	}
 
-	return iqCache
+	return withDistributedBackend(iqCache, config)
 }
 
 type cacheItem struct {
@@ -155,22 +263,43 @@ type cacheItem struct {
 }
 
 type cache struct {
-	items  map[string]cacheItem
-	usage  int64
-	config *Config
-	l      *list.List
-	mtx    sync.Mutex
+	items      map[string]cacheItem
+	refreshing map[string]struct{}
+	usage      int64
+	config     *Config
+	l          *list.List
+	mtx        sync.Mutex
 }
 
 func newCache(config *Config) *cache {
 	return &cache{
-		items:  map[string]cacheItem{},
-		usage:  0,
-		config: config,
-		l:      list.New(),
+		items:      map[string]cacheItem{},
+		refreshing: map[string]struct{}{},
+		usage:      0,
+		config:     config,
+		l:          list.New(),
 	}
 }
 
+// StartRefresh marks the value of k as being refreshed, unless it already is.
+func (c *cache) StartRefresh(k ast.Value) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	key := k.String()
+	if _, ok := c.refreshing[key]; ok {
+		return false
+	}
+	c.refreshing[key] = struct{}{}
+	return true
+}
+
+// FinishRefresh marks the refresh of the value of k as done.
+func (c *cache) FinishRefresh(k ast.Value) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.refreshing, k.String())
+}
+
 // InsertWithExpiry inserts a key k into the cache with value v with an expiration time expiresAt.
 // A zero time value for expiresAt indicates no expiry
 func (c *cache) InsertWithExpiry(k ast.Value, v InterQueryCacheValue, expiresAt time.Time) (dropped int) {
@@ -39,6 +39,14 @@ func TestParseCachingConfig(t *testing.T) {
 			input:   []byte(`{"inter_query_builtin_cache": {"max_size_bytes": "100"},}`),
 			wantErr: true,
 		},
+		"default_http_send": {
+			input:   []byte(`{"http_send": {}}`),
+			wantErr: false,
+		},
+		"bad_max_concurrent_requests_per_host": {
+			input:   []byte(`{"http_send": {"max_concurrent_requests_per_host": -1}}`),
+			wantErr: true,
+		},
 	}
 
 	for name, tc := range tests {
@@ -75,6 +83,45 @@ func TestParseCachingConfig(t *testing.T) {
 	if !reflect.DeepEqual(config, expected) {
 		t.Fatalf("want %v got %v", expected, config)
 	}
+
+	// http.send scheduling specified
+	in = `{"http_send": {"coalesce_requests": false, "max_concurrent_requests_per_host": 10}}`
+
+	config, err = ParseCachingConfig([]byte(in))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if *config.HTTPSend.CoalesceRequests || *config.HTTPSend.MaxConcurrentRequestsPerHost != 10 {
+		t.Fatalf("unexpected http_send config %+v", config.HTTPSend)
+	}
+
+	// distributed cache specified
+	in = `{"inter_query_builtin_cache": {"distributed": {"type": "redis", "redis": {"address": "localhost:6379"}}}}`
+
+	config, err = ParseCachingConfig([]byte(in))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	distributed := config.InterQueryBuiltinCache.Distributed
+	if *distributed.TimeoutMillis != defaultDistributedTimeoutMillis || *distributed.RetryAfterSeconds != defaultDistributedRetryAfterSeconds {
+		t.Fatalf("unexpected distributed config %+v", distributed)
+	}
+
+	for _, in := range []string{
+		`{"inter_query_builtin_cache": {"distributed": {"type": "memcached"}}}`,
+		`{"inter_query_builtin_cache": {"distributed": {"type": "redis"}}}`,
+		`{"inter_query_builtin_cache": {"distributed": {"type": "redis", "redis": {"address": "localhost:6379"}, "timeout_ms": 0}}}`,
+		`{"inter_query_builtin_cache": {"distributed": {"type": "redis", "redis": {"address": "localhost:6379"}, "retry_after_seconds": -1}}}`,
+		`{"inter_query_builtin_cache": {"distributed": {"type": "redis", "redis": {"address": "localhost:6379", "password": "secret"}}}}`,
+		`{"inter_query_builtin_cache": {"distributed": {"type": "redis", "redis": {"address": "localhost", "tls": true}}}}`,
+		`{"inter_query_builtin_cache": {"distributed": {"type": "redis", "redis": {"address": "localhost:6379", "tls": true, "tls_ca_cert_file": "/does/not/exist.pem"}}}}`,
+	} {
+		if _, err := ParseCachingConfig([]byte(in)); err == nil {
+			t.Fatalf("Expected error for %v", in)
+		}
+	}
 }
 
 func TestInsert(t *testing.T) {
@@ -534,3 +581,25 @@ func (p testInterQueryCacheValue) SizeInBytes() int64 {
 func (p testInterQueryCacheValue) Clone() (InterQueryCacheValue, error) {
 	return &testInterQueryCacheValue{value: p.value, size: p.size}, nil
 }
+
+func TestInterQueryCacheRefresher(t *testing.T) {
+	for _, c := range []InterQueryCache{NewInterQueryCache(nil), NewDistributedInterQueryCache(nil, nil)} {
+		r, ok := c.(InterQueryCacheRefresher)
+		if !ok {
+			t.Fatalf("expected %T to keep track of refreshes", c)
+		}
+		if !r.StartRefresh(ast.String("a")) {
+			t.Fatal("expected refresh to start")
+		}
+		if r.StartRefresh(ast.String("a")) {
+			t.Fatal("expected refresh to be in progress")
+		}
+		if !r.StartRefresh(ast.String("b")) {
+			t.Fatal("expected refresh of other key to start")
+		}
+		r.FinishRefresh(ast.String("a"))
+		if !r.StartRefresh(ast.String("a")) {
+			t.Fatal("expected refresh to start again")
+		}
+	}
+}
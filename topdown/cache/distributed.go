@@ -0,0 +1,310 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// DistributedBackend defines the interface for a cache backend that is shared
+// by OPA instances. Values are opaque bytes. Implementations must be safe for
+// concurrent use.
+type DistributedBackend interface {
+	// Get returns the value stored for key. If no value is stored, found is false.
+	Get(ctx context.Context, key string) (value []byte, found bool, err error)
+	// Set stores value for key. If ttl is positive, the value expires after ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete deletes the value stored for key, if any.
+	Delete(ctx context.Context, key string) error
+	// Close releases the resources held by the backend.
+	Close() error
+}
+
+// MarshalableInterQueryCacheValue is implemented by inter-query cache values
+// that can be stored in a distributed cache backend. Values that do not
+// implement it are only cached locally.
+type MarshalableInterQueryCacheValue interface {
+	InterQueryCacheValue
+
+	// InterQueryCacheValueType returns the name the type of the value is
+	// registered with (see RegisterInterQueryCacheValueType).
+	InterQueryCacheValueType() string
+
+	// MarshalInterQueryCacheValue returns the serialized value.
+	MarshalInterQueryCacheValue() ([]byte, error)
+}
+
+// InterQueryCacheValueUnmarshaler returns the value serialized by
+// MarshalableInterQueryCacheValue.MarshalInterQueryCacheValue.
+type InterQueryCacheValueUnmarshaler func(data []byte) (InterQueryCacheValue, error)
+
+var valueTypes = struct {
+	sync.RWMutex
+	m map[string]InterQueryCacheValueUnmarshaler
+}{m: map[string]InterQueryCacheValueUnmarshaler{}}
+
+// RegisterInterQueryCacheValueType registers the function that unmarshals
+// values of the named type read from a distributed cache backend.
+func RegisterInterQueryCacheValueType(name string, unmarshal InterQueryCacheValueUnmarshaler) {
+	valueTypes.Lock()
+	defer valueTypes.Unlock()
+	valueTypes.m[name] = unmarshal
+}
+
+func getValueType(name string) (InterQueryCacheValueUnmarshaler, bool) {
+	valueTypes.RLock()
+	defer valueTypes.RUnlock()
+	f, ok := valueTypes.m[name]
+	return f, ok
+}
+
+// distributedCacheEntry is the representation of values stored in a
+// distributed cache backend.
+type distributedCacheEntry struct {
+	Type      string    `json:"type"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Value     []byte    `json:"value"`
+}
+
+// NewDistributedInterQueryCache returns an inter-query cache that stores
+// values in backend in addition to a local cache created with config. Values
+// are read from the local cache first. Values read from the backend are
+// inserted into the local cache with their original expiry.
+//
+// If a request to the backend fails, the backend is not used for the
+// configured retry period and the local cache is used on its own.
+func NewDistributedInterQueryCache(config *Config, backend DistributedBackend) InterQueryCache {
+	return newDistributedCache(newCache(config), config, backend, false)
+}
+
+func withDistributedBackend(local *cache, config *Config) InterQueryCache {
+	if config == nil || config.InterQueryBuiltinCache.Distributed == nil {
+		return local
+	}
+	// The configuration is validated when it is parsed. If the backend still
+	// cannot be created (e.g., the CA certificate was removed since), the
+	// local cache is used on its own until UpdateConfig succeeds in creating
+	// it.
+	backend, _ := newDistributedBackend(config.InterQueryBuiltinCache.Distributed)
+	return newDistributedCache(local, config, backend, true)
+}
+
+func newDistributedBackend(config *DistributedCacheConfig) (DistributedBackend, error) {
+	switch config.Type {
+	case DistributedCacheTypeRedis:
+		b, err := newRedisBackend(config)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("invalid distributed cache type %q", config.Type)
+	}
+}
+
+type distributedCache struct {
+	local *cache
+
+	mtx         sync.Mutex
+	backend     DistributedBackend
+	managed     bool // backend was created from config
+	config      *DistributedCacheConfig
+	unavailable time.Time // backend is not used until then
+}
+
+func newDistributedCache(local *cache, config *Config, backend DistributedBackend, managed bool) *distributedCache {
+	var dc *DistributedCacheConfig
+	if config != nil {
+		dc = config.InterQueryBuiltinCache.Distributed
+	}
+	return &distributedCache{local: local, backend: backend, managed: managed, config: dc}
+}
+
+func (c *distributedCache) Get(k ast.Value) (InterQueryCacheValue, bool) {
+	if v, ok := c.local.Get(k); ok {
+		return v, true
+	}
+
+	backend, key, ctx, cancel := c.acquire(k)
+	if backend == nil {
+		return nil, false
+	}
+	defer cancel()
+
+	bs, found, err := backend.Get(ctx, key)
+	if err != nil {
+		c.markUnavailable()
+		return nil, false
+	}
+	if !found {
+		return nil, false
+	}
+
+	var entry distributedCacheEntry
+	if err := json.Unmarshal(bs, &entry); err != nil {
+		return nil, false
+	}
+	unmarshal, ok := getValueType(entry.Type)
+	if !ok {
+		return nil, false
+	}
+	v, err := unmarshal(entry.Value)
+	if err != nil {
+		return nil, false
+	}
+
+	c.local.InsertWithExpiry(k, v, entry.ExpiresAt)
+	return v, true
+}
+
+func (c *distributedCache) Insert(k ast.Value, v InterQueryCacheValue) int {
+	return c.InsertWithExpiry(k, v, time.Time{})
+}
+
+func (c *distributedCache) InsertWithExpiry(k ast.Value, v InterQueryCacheValue, expiresAt time.Time) int {
+	dropped := c.local.InsertWithExpiry(k, v, expiresAt)
+
+	mv, ok := v.(MarshalableInterQueryCacheValue)
+	if !ok {
+		return dropped
+	}
+
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		if ttl = time.Until(expiresAt); ttl <= 0 {
+			return dropped
+		}
+	}
+
+	value, err := mv.MarshalInterQueryCacheValue()
+	if err != nil {
+		return dropped
+	}
+	bs, err := json.Marshal(distributedCacheEntry{Type: mv.InterQueryCacheValueType(), ExpiresAt: expiresAt, Value: value})
+	if err != nil {
+		return dropped
+	}
+
+	backend, key, ctx, cancel := c.acquire(k)
+	if backend == nil {
+		return dropped
+	}
+	defer cancel()
+
+	if err := backend.Set(ctx, key, bs, ttl); err != nil {
+		c.markUnavailable()
+	}
+	return dropped
+}
+
+func (c *distributedCache) Delete(k ast.Value) {
+	c.local.Delete(k)
+
+	backend, key, ctx, cancel := c.acquire(k)
+	if backend == nil {
+		return
+	}
+	defer cancel()
+
+	if err := backend.Delete(ctx, key); err != nil {
+		c.markUnavailable()
+	}
+}
+
+// UpdateConfig updates the configuration of the local cache. If the
+// distributed cache configuration changed, or the backend could not be created
+// from it before, the backend is (re)created. Backends passed to
+// NewDistributedInterQueryCache are kept.
+func (c *distributedCache) UpdateConfig(config *Config) {
+	if config == nil {
+		return
+	}
+	c.local.UpdateConfig(config)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	dc := config.InterQueryBuiltinCache.Distributed
+	if !c.managed || (reflect.DeepEqual(c.config, dc) && (c.backend != nil || dc == nil)) {
+		return
+	}
+
+	var backend DistributedBackend
+	if dc != nil {
+		var err error
+		if backend, err = newDistributedBackend(dc); err != nil {
+			return
+		}
+	}
+	if c.backend != nil {
+		_ = c.backend.Close()
+	}
+	c.backend, c.config, c.unavailable = backend, dc, time.Time{}
+}
+
+// StartRefresh marks the value of k as being refreshed by this OPA instance,
+// unless it already is.
+func (c *distributedCache) StartRefresh(k ast.Value) bool {
+	return c.local.StartRefresh(k)
+}
+
+// FinishRefresh marks the refresh of the value of k as done.
+func (c *distributedCache) FinishRefresh(k ast.Value) {
+	c.local.FinishRefresh(k)
+}
+
+func (c *distributedCache) Clone(value InterQueryCacheValue) (InterQueryCacheValue, error) {
+	return c.local.Clone(value)
+}
+
+// acquire returns the backend, the key of k in the backend, and a context for
+// a request to it, unless the backend is unavailable.
+func (c *distributedCache) acquire(k ast.Value) (DistributedBackend, string, context.Context, context.CancelFunc) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.backend == nil || time.Now().Before(c.unavailable) {
+		return nil, "", nil, nil
+	}
+
+	timeout := time.Duration(defaultDistributedTimeoutMillis) * time.Millisecond
+	if c.config != nil && c.config.TimeoutMillis != nil {
+		timeout = time.Duration(*c.config.TimeoutMillis) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return c.backend, c.key(k), ctx, cancel
+}
+
+func (c *distributedCache) markUnavailable() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	retryAfter := time.Duration(defaultDistributedRetryAfterSeconds) * time.Second
+	if c.config != nil && c.config.RetryAfterSeconds != nil {
+		retryAfter = time.Duration(*c.config.RetryAfterSeconds) * time.Second
+	}
+	c.unavailable = time.Now().Add(retryAfter)
+}
+
+// key returns the key of k in the backend. Keys are hashed because cache
+// keys (e.g., http.send requests) may contain credentials. The caller must
+// hold the lock.
+func (c *distributedCache) key(k ast.Value) string {
+	sum := sha256.Sum256([]byte(k.String()))
+	var prefix string
+	if c.config != nil {
+		prefix = c.config.KeyPrefix
+	}
+	return prefix + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,208 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+type marshalableTestValue struct {
+	testInterQueryCacheValue
+	data string
+}
+
+func (marshalableTestValue) InterQueryCacheValueType() string {
+	return "test"
+}
+
+func (v marshalableTestValue) MarshalInterQueryCacheValue() ([]byte, error) {
+	return []byte(v.data), nil
+}
+
+func (v marshalableTestValue) Clone() (InterQueryCacheValue, error) {
+	return v, nil
+}
+
+func init() {
+	RegisterInterQueryCacheValueType("test", func(data []byte) (InterQueryCacheValue, error) {
+		return marshalableTestValue{data: string(data)}, nil
+	})
+}
+
+func newTestDistributedConfig(t *testing.T, addr string) *Config {
+	t.Helper()
+	config, err := ParseCachingConfig([]byte(fmt.Sprintf(`{"inter_query_builtin_cache": {"distributed": {
+		"type": "redis",
+		"key_prefix": "opa:",
+		"retry_after_seconds": 60,
+		"redis": {"address": %q}
+	}}}`, addr)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestDistributedInterQueryCache(t *testing.T) {
+	f := newFakeRedis(t, "")
+	config := newTestDistributedConfig(t, f.Addr())
+
+	replica1 := NewInterQueryCache(config)
+	replica2 := NewInterQueryCache(config)
+
+	if _, ok := replica1.(*distributedCache); !ok {
+		t.Fatalf("expected distributed cache but got %T", replica1)
+	}
+
+	k := ast.String("key")
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	replica1.InsertWithExpiry(k, marshalableTestValue{data: "value"}, expiresAt)
+
+	v, ok := replica2.Get(k)
+	if !ok || v.(marshalableTestValue).data != "value" {
+		t.Fatalf("expected value to be shared but got: %v", v)
+	}
+
+	// The value read from the backend is cached locally with its expiry.
+	local := replica2.(*distributedCache).local
+	if item, ok := local.unsafeGet(k); !ok || !item.expiresAt.Equal(expiresAt) {
+		t.Fatalf("expected local value expiring at %v but got: %v", expiresAt, item)
+	}
+
+	// Keys are hashed and prefixed.
+	f.mtx.Lock()
+	for key := range f.data[0] {
+		if !strings.HasPrefix(key, "opa:") || strings.Contains(key, "key") {
+			t.Fatalf("unexpected key: %v", key)
+		}
+	}
+	f.mtx.Unlock()
+
+	// Values that cannot be marshaled are only cached locally.
+	replica1.Insert(ast.String("local"), testInterQueryCacheValue{})
+	if _, ok := replica2.Get(ast.String("local")); ok {
+		t.Fatal("expected value not to be shared")
+	}
+
+	// Expired values are not stored in the backend.
+	replica1.InsertWithExpiry(ast.String("expired"), marshalableTestValue{data: "x"}, time.Now().Add(-time.Second))
+	if _, ok := replica2.Get(ast.String("expired")); ok {
+		t.Fatal("expected expired value not to be shared")
+	}
+
+	replica1.Delete(k)
+	replica2.(*distributedCache).local.Delete(k)
+	if _, ok := replica2.Get(k); ok {
+		t.Fatal("expected value to be deleted")
+	}
+}
+
+func TestDistributedInterQueryCacheUnavailable(t *testing.T) {
+	f := newFakeRedis(t, "")
+	config := newTestDistributedConfig(t, f.Addr())
+	c := NewInterQueryCache(config)
+
+	f.SetDown(true)
+
+	// The local cache is used while the backend is unavailable.
+	c.Insert(ast.String("a"), marshalableTestValue{data: "a"})
+	if v, ok := c.Get(ast.String("a")); !ok || v.(marshalableTestValue).data != "a" {
+		t.Fatalf("expected local value but got: %v", v)
+	}
+
+	f.SetDown(false)
+
+	// After a failed request the backend is not used until the retry period passed.
+	c.Insert(ast.String("b"), marshalableTestValue{data: "b"})
+	if cmds := f.Commands(); len(cmds) != 0 {
+		t.Fatalf("expected backend not to be used but got: %v", cmds)
+	}
+
+	dc := c.(*distributedCache)
+	dc.mtx.Lock()
+	dc.unavailable = time.Time{}
+	dc.mtx.Unlock()
+
+	c.Insert(ast.String("c"), marshalableTestValue{data: "c"})
+	if cmds := f.Commands(); len(cmds) != 1 || cmds[0] != "SET" {
+		t.Fatalf("expected backend to be used again but got: %v", cmds)
+	}
+}
+
+func TestDistributedInterQueryCacheUpdateConfig(t *testing.T) {
+	f1 := newFakeRedis(t, "")
+	f2 := newFakeRedis(t, "")
+
+	c := NewInterQueryCache(newTestDistributedConfig(t, f1.Addr()))
+	c.Insert(ast.String("a"), marshalableTestValue{data: "a"})
+
+	c.UpdateConfig(newTestDistributedConfig(t, f2.Addr()))
+	c.Insert(ast.String("b"), marshalableTestValue{data: "b"})
+
+	if len(f1.Commands()) != 1 || len(f2.Commands()) != 1 {
+		t.Fatalf("expected backend to be replaced but got: %v, %v", f1.Commands(), f2.Commands())
+	}
+
+	config, err := ParseCachingConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.UpdateConfig(config)
+	c.Insert(ast.String("c"), marshalableTestValue{data: "c"})
+	if len(f2.Commands()) != 1 {
+		t.Fatalf("expected backend to be removed but got: %v", f2.Commands())
+	}
+}
+
+func TestDistributedInterQueryCacheBackendRetriedOnUpdateConfig(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pem, err := os.ReadFile(f.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(caFile, pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseCachingConfig([]byte(fmt.Sprintf(`{"inter_query_builtin_cache": {"distributed": {
+		"type": "redis",
+		"redis": {"address": %q, "password": "secret", "tls": true, "tls_ca_cert_file": %q}
+	}}}`, f.Addr(), caFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CA certificate is removed after the configuration was parsed, so
+	// the backend cannot be created.
+	if err := os.Remove(caFile); err != nil {
+		t.Fatal(err)
+	}
+	c := NewInterQueryCache(config)
+	if _, ok := c.(*distributedCache); !ok {
+		t.Fatalf("expected distributed cache but got %T", c)
+	}
+	c.Insert(ast.String("a"), marshalableTestValue{data: "a"})
+	if cmds := f.Commands(); len(cmds) != 0 {
+		t.Fatalf("expected no commands but got: %v", cmds)
+	}
+
+	// Updating to the same configuration creates the backend once it can be.
+	if err := os.WriteFile(caFile, pem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c.UpdateConfig(config)
+	c.Insert(ast.String("b"), marshalableTestValue{data: "b"})
+	if cmds := f.Commands(); strings.Join(cmds, ",") != "AUTH,SET" {
+		t.Fatalf("expected backend to be created but got: %v", cmds)
+	}
+}
@@ -0,0 +1,339 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisMaxIdleConnections = 16
+
+	// maxRedisBulkLength is the largest bulk string or array accepted in a
+	// reply. It matches the default limit of the Redis server
+	// (proto-max-bulk-len) and keeps a misbehaving server from making us
+	// allocate arbitrarily large buffers.
+	maxRedisBulkLength = 512 * 1024 * 1024
+)
+
+// redisBackend is a DistributedBackend that speaks the Redis serialization
+// protocol (RESP). Only the commands needed by the cache are supported.
+// Credentials are only sent over TLS connections, and every command is
+// bounded by the configured timeout if the context has no earlier deadline.
+type redisBackend struct {
+	config  *RedisConfig
+	tls     *tls.Config
+	timeout time.Duration
+
+	mtx    sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+// redisError is an error reply sent by the server.
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+var (
+	errRedisClosed       = errors.New("redis: backend closed")
+	errRedisInsecureAuth = errors.New("redis: refusing to send credentials without tls")
+)
+
+func newRedisBackend(config *DistributedCacheConfig) (*redisBackend, error) {
+	b := &redisBackend{
+		config:  config.Redis,
+		timeout: time.Duration(defaultDistributedTimeoutMillis) * time.Millisecond,
+	}
+	if config.TimeoutMillis != nil {
+		b.timeout = time.Duration(*config.TimeoutMillis) * time.Millisecond
+	}
+
+	if !b.config.TLS {
+		if b.config.Password != "" {
+			return nil, errRedisInsecureAuth
+		}
+		return b, nil
+	}
+
+	host, _, err := net.SplitHostPort(b.config.Address)
+	if err != nil {
+		return nil, fmt.Errorf("redis: invalid address: %w", err)
+	}
+	b.tls = &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: b.config.AllowInsecureTLS,
+	}
+	if b.config.TLSCACertFile != "" {
+		pem, err := os.ReadFile(b.config.TLSCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("redis: failed to read CA certificate: %w", err)
+		}
+		b.tls.RootCAs = x509.NewCertPool()
+		if !b.tls.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("redis: failed to parse CA certificate")
+		}
+	}
+	return b, nil
+}
+
+func (b *redisBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := b.do(ctx, "GET", []byte(key))
+	if err != nil {
+		return nil, false, err
+	}
+	switch v := reply.(type) {
+	case nil:
+		return nil, false, nil
+	case []byte:
+		return v, true, nil
+	default:
+		return nil, false, fmt.Errorf("redis: unexpected reply to GET: %v", v)
+	}
+}
+
+func (b *redisBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := [][]byte{[]byte(key), value}
+	if ttl > 0 {
+		ms := ttl.Milliseconds()
+		if ms == 0 {
+			ms = 1
+		}
+		args = append(args, []byte("PX"), []byte(strconv.FormatInt(ms, 10)))
+	}
+	_, err := b.do(ctx, "SET", args...)
+	return err
+}
+
+func (b *redisBackend) Delete(ctx context.Context, key string) error {
+	_, err := b.do(ctx, "DEL", []byte(key))
+	return err
+}
+
+func (b *redisBackend) Close() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.closed = true
+	for _, c := range b.idle {
+		_ = c.conn.Close()
+	}
+	b.idle = nil
+	return nil
+}
+
+// do sends the command to the server and returns the reply. Error replies
+// are returned as errors.
+func (b *redisBackend) do(ctx context.Context, cmd string, args ...[]byte) (interface{}, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	c, err := b.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(ctx, cmd, args...)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		_ = c.conn.Close()
+		return nil, err
+	}
+
+	b.put(c)
+	return reply, err
+}
+
+func (b *redisBackend) get(ctx context.Context) (*redisConn, error) {
+	b.mtx.Lock()
+	if b.closed {
+		b.mtx.Unlock()
+		return nil, errRedisClosed
+	}
+	if n := len(b.idle); n > 0 {
+		c := b.idle[n-1]
+		b.idle = b.idle[:n-1]
+		b.mtx.Unlock()
+		return c, nil
+	}
+	b.mtx.Unlock()
+
+	var conn net.Conn
+	var err error
+	if b.tls != nil {
+		d := tls.Dialer{Config: b.tls}
+		conn, err = d.DialContext(ctx, "tcp", b.config.Address)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", b.config.Address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+
+	if b.config.Password != "" {
+		args := [][]byte{[]byte(b.config.Password)}
+		if b.config.Username != "" {
+			args = [][]byte{[]byte(b.config.Username), []byte(b.config.Password)}
+		}
+		if _, err := c.do(ctx, "AUTH", args...); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	if b.config.Database != 0 {
+		if _, err := c.do(ctx, "SELECT", []byte(strconv.Itoa(b.config.Database))); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (b *redisBackend) put(c *redisConn) {
+	maxIdle := b.config.MaxIdleConnections
+	if maxIdle <= 0 {
+		maxIdle = defaultRedisMaxIdleConnections
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.closed || len(b.idle) >= maxIdle {
+		_ = c.conn.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+func (c *redisConn) do(ctx context.Context, cmd string, args ...[]byte) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	} else if err := c.conn.SetDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+
+	if err := writeRedisCommand(c.w, cmd, args...); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.r)
+}
+
+// writeRedisCommand writes the command as an array of bulk strings.
+func writeRedisCommand(w *bufio.Writer, cmd string, args ...[]byte) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n", len(arg)); err != nil {
+			return err
+		}
+		if _, err := w.Write(arg); err != nil {
+			return err
+		}
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRedisReply reads a reply. Simple strings are returned as strings, bulk
+// strings as byte slices (nil for null bulk strings), integers as int64, and
+// arrays as slices of replies.
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readRedisLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk string length: %w", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > maxRedisBulkLength {
+			return nil, fmt.Errorf("redis: bulk string length %d exceeds limit", n)
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length: %w", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if n > maxRedisBulkLength {
+			return nil, fmt.Errorf("redis: array length %d exceeds limit", n)
+		}
+		// The array grows with the elements read rather than the announced
+		// length.
+		arr := make([]interface{}, 0, min(n, 64))
+		for i := 0; i < n; i++ {
+			elem, err := readRedisReply(r)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, elem)
+		}
+		return arr, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+	}
+}
+
+func readRedisLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: malformed reply")
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,319 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-process server that speaks enough of the Redis protocol
+// to test the redis backend. Servers requiring a password only accept TLS
+// connections.
+type fakeRedis struct {
+	t        *testing.T
+	ln       net.Listener
+	password string
+	caFile   string
+
+	mtx      sync.Mutex
+	data     map[int]map[string]fakeRedisItem
+	commands []string
+	down     bool
+}
+
+type fakeRedisItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{t: t, ln: ln, password: password, data: map[int]map[string]fakeRedisItem{}}
+	if password != "" {
+		// Borrow the certificate of the httptest package, which is valid
+		// for 127.0.0.1.
+		ts := httptest.NewUnstartedServer(nil)
+		ts.StartTLS()
+		cert, config := ts.Certificate(), ts.TLS.Clone()
+		ts.Close()
+
+		f.caFile = filepath.Join(t.TempDir(), "ca.pem")
+		if err := os.WriteFile(f.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		f.ln = tls.NewListener(ln, config)
+	}
+	go f.serve()
+	t.Cleanup(func() { _ = ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) Addr() string {
+	return f.ln.Addr().String()
+}
+
+// SetDown makes the server close connections without replying.
+func (f *fakeRedis) SetDown(down bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.down = down
+}
+
+func (f *fakeRedis) Commands() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return append([]string(nil), f.commands...)
+}
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authenticated := f.password == ""
+	db := 0
+
+	for {
+		reply, err := readRedisReply(r)
+		if err != nil {
+			return
+		}
+		arr, ok := reply.([]interface{})
+		if !ok || len(arr) == 0 {
+			return
+		}
+		args := make([]string, len(arr))
+		for i := range arr {
+			args[i] = string(arr[i].([]byte))
+		}
+		cmd := strings.ToUpper(args[0])
+
+		f.mtx.Lock()
+		if f.down {
+			f.mtx.Unlock()
+			return
+		}
+		f.commands = append(f.commands, cmd)
+		if f.data[db] == nil {
+			f.data[db] = map[string]fakeRedisItem{}
+		}
+		data := f.data[db]
+
+		var out string
+		switch {
+		case cmd == "AUTH":
+			if args[len(args)-1] == f.password {
+				authenticated = true
+				out = "+OK\r\n"
+			} else {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			out = "-NOAUTH Authentication required.\r\n"
+		case cmd == "SELECT":
+			db, _ = strconv.Atoi(args[1])
+			out = "+OK\r\n"
+		case cmd == "GET":
+			item, ok := data[args[1]]
+			if !ok || (!item.expiresAt.IsZero() && time.Now().After(item.expiresAt)) {
+				out = "$-1\r\n"
+			} else {
+				out = "$" + strconv.Itoa(len(item.value)) + "\r\n" + string(item.value) + "\r\n"
+			}
+		case cmd == "SET":
+			item := fakeRedisItem{value: []byte(args[2])}
+			if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+				ms, _ := strconv.Atoi(args[4])
+				item.expiresAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+			data[args[1]] = item
+			out = "+OK\r\n"
+		case cmd == "DEL":
+			_, ok := data[args[1]]
+			delete(data, args[1])
+			if ok {
+				out = ":1\r\n"
+			} else {
+				out = ":0\r\n"
+			}
+		default:
+			out = "-ERR unknown command\r\n"
+		}
+		f.mtx.Unlock()
+
+		if _, err := w.WriteString(out); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func TestRedisBackend(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	b, err := newRedisBackend(&DistributedCacheConfig{Redis: &RedisConfig{Address: f.Addr(), Username: "opa", Password: "secret", Database: 2, TLS: true, TLSCACertFile: f.caFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if _, found, err := b.Get(ctx, "k"); err != nil || found {
+		t.Fatalf("expected no value but got found=%v err=%v", found, err)
+	}
+
+	if err := b.Set(ctx, "k", []byte("hello\r\nworld"), 0); err != nil {
+		t.Fatal(err)
+	}
+	value, found, err := b.Get(ctx, "k")
+	if err != nil || !found || !bytes.Equal(value, []byte("hello\r\nworld")) {
+		t.Fatalf("unexpected value %q (found=%v, err=%v)", value, found, err)
+	}
+
+	if err := b.Set(ctx, "ttl", []byte("v"), time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, found, err := b.Get(ctx, "ttl"); err != nil || found {
+		t.Fatalf("expected value to expire but got found=%v err=%v", found, err)
+	}
+
+	if err := b.Delete(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, err := b.Get(ctx, "k"); err != nil || found {
+		t.Fatalf("expected value to be deleted but got found=%v err=%v", found, err)
+	}
+
+	// The connection is reused, so AUTH and SELECT are only sent once.
+	exp := []string{"AUTH", "SELECT", "GET", "SET", "GET", "SET", "GET", "DEL", "GET"}
+	if cmds := f.Commands(); strings.Join(cmds, ",") != strings.Join(exp, ",") {
+		t.Fatalf("expected commands %v but got %v", exp, cmds)
+	}
+}
+
+func TestRedisBackendErrors(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	if _, err := newRedisBackend(&DistributedCacheConfig{Redis: &RedisConfig{Address: f.Addr(), Password: "secret"}}); err != errRedisInsecureAuth {
+		t.Fatalf("expected insecure auth error but got: %v", err)
+	}
+
+	b, err := newRedisBackend(&DistributedCacheConfig{Redis: &RedisConfig{Address: f.Addr(), Password: "secret", TLS: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Get(ctx, "k"); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("expected certificate error but got: %v", err)
+	}
+	b.Close()
+
+	b, err = newRedisBackend(&DistributedCacheConfig{Redis: &RedisConfig{Address: f.Addr(), Password: "wrong", TLS: true, TLSCACertFile: f.caFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	var rerr redisError
+	if _, _, err := b.Get(ctx, "k"); !errors.As(err, &rerr) || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Fatalf("expected auth error but got: %v", err)
+	}
+
+	b.Close()
+	if _, _, err := b.Get(ctx, "k"); err != errRedisClosed {
+		t.Fatalf("expected closed error but got: %v", err)
+	}
+
+	f.SetDown(true)
+	b, err = newRedisBackend(&DistributedCacheConfig{Redis: &RedisConfig{Address: f.Addr(), Password: "secret", TLS: true, TLSCACertFile: f.caFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, _, err := b.Get(ctx, "k"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestRedisBackendTimeout(t *testing.T) {
+	// The server accepts connections but never replies.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	timeout := int64(20)
+	b, err := newRedisBackend(&DistributedCacheConfig{TimeoutMillis: &timeout, Redis: &RedisConfig{Address: ln.Addr().String()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	start := time.Now()
+	var nerr net.Error
+	if _, _, err := b.Get(context.Background(), "k"); !errors.As(err, &nerr) || !nerr.Timeout() {
+		t.Fatalf("expected timeout error but got: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected request to time out after %dms but took %v", timeout, d)
+	}
+}
+
+func TestReadRedisReplyLimits(t *testing.T) {
+	for _, in := range []string{
+		"$" + strconv.Itoa(maxRedisBulkLength+1) + "\r\n",
+		"*" + strconv.Itoa(maxRedisBulkLength+1) + "\r\n",
+	} {
+		if _, err := readRedisReply(bufio.NewReader(strings.NewReader(in))); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+			t.Fatalf("expected limit error for %q but got: %v", in, err)
+		}
+	}
+
+	// Arrays are only allocated for the elements actually sent.
+	if _, err := readRedisReply(bufio.NewReader(strings.NewReader("*1000000\r\n:1\r\n"))); err != io.ErrUnexpectedEOF && err != io.EOF {
+		t.Fatalf("expected EOF but got: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage/inmem"
+)
+
+// constantEvalLimits bounds the resources used to evaluate a single document at
+// compile-time. Documents exceeding them are left to be evaluated at run-time.
+var constantEvalLimits = EvalLimits{
+	MaxSteps:       100000,
+	MaxTermBytes:   1 << 20,
+	MaxResultBytes: 1 << 20,
+}
+
+// constantEvalTimeout is the maximum duration of evaluating a single document
+// at compile-time.
+const constantEvalTimeout = time.Second
+
+// EvalConstant evaluates the document identified by ref without any input or
+// base documents. It implements ast.ConstantEvaluator and is meant to be
+// passed to (*ast.Compiler).WithConstantFolding. Built-in function errors are
+// returned instead of being treated as undefined so that they are still
+// reported when the document is evaluated at run-time. The evaluation is
+// aborted with an error if it exceeds the step, size, or time limits for
+// constant documents.
+func EvalConstant(c *ast.Compiler, ref ast.Ref) (ast.Value, bool, error) {
+	ctx := context.Background()
+	store := inmem.New()
+	txn, err := store.NewTransaction(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer store.Abort(ctx, txn)
+
+	cancel := NewCancel()
+	timer := time.AfterFunc(constantEvalTimeout, cancel.Cancel)
+	defer timer.Stop()
+
+	x := ast.VarTerm("x")
+	qrs, err := NewQuery(ast.NewBody(ast.Equality.Expr(ast.NewTerm(ref), x))).
+		WithCompiler(c).
+		WithStore(store).
+		WithTransaction(txn).
+		WithStrictBuiltinErrors(true).
+		WithEvalLimits(constantEvalLimits).
+		WithCancel(cancel).
+		Run(ctx)
+	if err != nil || len(qrs) == 0 {
+		return nil, false, err
+	}
+
+	return qrs[0][x.Value.(ast.Var)].Value, true, nil
+}
+
+var _ ast.ConstantEvaluator = EvalConstant
@@ -0,0 +1,55 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestEvalConstant(t *testing.T) {
+	module := ast.MustParseModuleWithOpts(`package test
+
+import rego.v1
+
+a := {"x": 1 + 2, "y": upper("y")}
+
+b := a.x * 2
+
+c if false
+
+d := to_number("x")
+
+e := count([x | some x in numbers.range(1, 1000000)])`, ast.ParserOptions{RegoVersion: ast.RegoV1})
+
+	compiler := ast.NewCompiler().WithConstantFolding(EvalConstant)
+	if compiler.Compile(map[string]*ast.Module{"test.rego": module}); compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+
+	exp := map[string]*ast.Term{
+		"data.test.a": ast.MustParseTerm(`{"x": 3, "y": "Y"}`),
+		"data.test.b": ast.IntNumberTerm(6),
+	}
+
+	folded := compiler.FoldedConstants()
+	if len(folded) != len(exp) {
+		t.Fatalf("expected %v but got: %v", exp, folded)
+	}
+	for path, value := range exp {
+		if !folded[path].Equal(value) {
+			t.Fatalf("expected %v to be %v but got: %v", path, value, folded[path])
+		}
+	}
+
+	if _, _, err := EvalConstant(compiler, ast.MustParseRef("data.test.d")); err == nil {
+		t.Fatal("expected built-in error")
+	}
+
+	if _, _, err := EvalConstant(compiler, ast.MustParseRef("data.test.e")); !IsResourceLimit(err) {
+		t.Fatalf("expected resource limit error but got: %v", err)
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// cronSearchYears bounds the search for fire times of schedules that never
+// (or very rarely) fire, e.g., "0 0 30 2 *".
+const cronSearchYears = 8
+
+// cronSchedule is a parsed cron expression. Each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar record if the day fields are unrestricted: if both
+	// day fields are restricted, either of them has to match.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression with the five standard fields (minute,
+// hour, day of month, month and day of week) or one of the @yearly,
+// @annually, @monthly, @weekly, @daily, @midnight and @hourly macros.
+func parseCron(expr string) (*cronSchedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression must have %d fields but got %d", len(cronFields), len(fields))
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		bits[i], err = cronFields[i].parse(field)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Sunday is both 0 and 7.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: isCronWildcard(fields[2]),
+		dowStar: isCronWildcard(fields[4]),
+	}, nil
+}
+
+func isCronWildcard(field string) bool {
+	return strings.HasPrefix(field, "*") || field == "?"
+}
+
+func (f cronField) parse(field string) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		lo, hi, step := f.min, f.max, 1
+
+		rng := item
+		if i := strings.IndexByte(item, '/'); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %v field", item[i+1:], f.name)
+			}
+			rng = item[:i]
+		}
+
+		switch {
+		case rng == "*" || rng == "?" && (f.name == "day of month" || f.name == "day of week"):
+		default:
+			var err error
+			if i := strings.IndexByte(rng, '-'); i >= 0 {
+				if lo, err = f.value(rng[:i]); err != nil {
+					return 0, err
+				}
+				if hi, err = f.value(rng[i+1:]); err != nil {
+					return 0, err
+				}
+				if lo > hi {
+					return 0, fmt.Errorf("invalid range %q in %v field", rng, f.name)
+				}
+			} else {
+				if lo, err = f.value(rng); err != nil {
+					return 0, err
+				}
+				hi = lo
+				if step != 1 {
+					hi = f.max
+				}
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %q in %v field (must be %d-%d)", s, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (s *cronSchedule) match(t time.Time) bool {
+	return s.month&(1<<uint(t.Month())) != 0 &&
+		s.matchDay(t) &&
+		s.hour&(1<<uint(t.Hour())) != 0 &&
+		s.minute&(1<<uint(t.Minute())) != 0
+}
+
+// next returns the first fire time after t, in t's location.
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	limit := t.Year() + cronSearchYears
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for t.Year() <= limit {
+		y, m, d := t.Date()
+		// Hours are skipped by adding the remaining minutes as the start of the
+		// next hour may not exist in t's location, e.g., due to daylight saving
+		// time.
+		nextHour := t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		var n time.Time
+		switch {
+		case s.month&(1<<uint(m)) == 0:
+			n = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(t):
+			n = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			n = nextHour
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			n = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+		if !n.After(t) {
+			n = nextHour
+		}
+		t = n
+	}
+
+	return time.Time{}, false
+}
+
+// prev returns the last fire time before t, in t's location.
+func (s *cronSchedule) prev(t time.Time) (time.Time, bool) {
+	loc := t.Location()
+	limit := t.Year() - cronSearchYears
+	start := t
+	t = t.Truncate(time.Minute)
+	if !t.Before(start) {
+		t = t.Add(-time.Minute)
+	}
+
+	for t.Year() >= limit {
+		y, m, d := t.Date()
+		prevHour := t.Add(-time.Duration(t.Minute()+1) * time.Minute)
+		var n time.Time
+		switch {
+		case s.month&(1<<uint(m)) == 0:
+			n = time.Date(y, m, 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !s.matchDay(t):
+			n = time.Date(y, m, d, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			n = prevHour
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			n = t.Add(-time.Minute)
+		default:
+			return t, true
+		}
+		if !n.Before(t) {
+			n = prevHour
+		}
+		t = n
+	}
+
+	return time.Time{}, false
+}
+
+func cronOperands(name string, operands []*ast.Term) (*cronSchedule, time.Time, error) {
+	expr, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	schedule, err := parseCron(string(expr))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", name, err)
+	}
+	t, _, err := tzTime(operands[1].Value)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return schedule, t, nil
+}
+
+func builtinTimeCronMatch(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	schedule, t, err := cronOperands(ast.TimeCronMatch.Name, operands)
+	if err != nil {
+		return err
+	}
+	return iter(ast.BooleanTerm(schedule.match(t)))
+}
+
+func builtinTimeCronNext(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	schedule, t, err := cronOperands(ast.TimeCronNext.Name, operands)
+	if err != nil {
+		return err
+	}
+	next, ok := schedule.next(t)
+	if !ok {
+		return fmt.Errorf("%s: no fire time within %d years", ast.TimeCronNext.Name, cronSearchYears)
+	}
+	return toSafeUnixNano(next, iter)
+}
+
+func builtinTimeCronPrev(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	schedule, t, err := cronOperands(ast.TimeCronPrev.Name, operands)
+	if err != nil {
+		return err
+	}
+	prev, ok := schedule.prev(t)
+	if !ok {
+		return fmt.Errorf("%s: no fire time within %d years", ast.TimeCronPrev.Name, cronSearchYears)
+	}
+	return toSafeUnixNano(prev, iter)
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.TimeCronMatch.Name, builtinTimeCronMatch)
+	RegisterBuiltinFunc(ast.TimeCronNext.Name, builtinTimeCronNext)
+	RegisterBuiltinFunc(ast.TimeCronPrev.Name, builtinTimeCronPrev)
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronDaylightSavingTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		note string
+		cron string
+		from time.Time
+		next string
+		prev string
+	}{
+		{
+			note: "spring forward",
+			cron: "30 * * * *",
+			from: time.Date(2024, 3, 10, 1, 45, 0, 0, loc),
+			next: "2024-03-10T03:30:00-04:00",
+			prev: "2024-03-10T01:30:00-05:00",
+		},
+		{
+			note: "fall back",
+			cron: "30 1 * * *",
+			from: time.Date(2024, 11, 3, 1, 45, 0, 0, loc).Add(time.Hour),
+			next: "2024-11-04T01:30:00-05:00",
+			prev: "2024-11-03T01:30:00-05:00",
+		},
+		{
+			note: "skipped hour",
+			cron: "30 2 * * *",
+			from: time.Date(2024, 3, 9, 12, 0, 0, 0, loc),
+			next: "2024-03-11T02:30:00-04:00",
+			prev: "2024-03-09T02:30:00-05:00",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			s, err := parseCron(tc.cron)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, ok := s.next(tc.from)
+			if !ok || next.Format(time.RFC3339) != tc.next {
+				t.Errorf("expected next %v but got %v", tc.next, next.Format(time.RFC3339))
+			}
+			prev, ok := s.prev(tc.from)
+			if !ok || prev.Format(time.RFC3339) != tc.prev {
+				t.Errorf("expected prev %v but got %v", tc.prev, prev.Format(time.RFC3339))
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ package topdown
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/open-policy-agent/opa/ast"
 )
@@ -57,8 +58,54 @@ const (
 
 	// WithMergeErr indicates that the real and replacement data could not be merged.
 	WithMergeErr string = "eval_with_merge_error"
+
+	// ResourceLimitErr indicates evaluation stopped because it exceeded one of
+	// the configured resource limits (see EvalLimits).
+	ResourceLimitErr string = "eval_resource_limit_error"
+
+	// UnsupportedPartialErr indicates partial evaluation could not expand a
+	// construct into flat queries and strict partial evaluation is enabled
+	// (see Query.WithStrictPartialEval).
+	UnsupportedPartialErr string = "eval_unsupported_partial_error"
 )
 
+// Errors represents a list of errors returned by evaluation.
+type Errors []*Error
+
+func (es Errors) Error() string {
+
+	if len(es) == 0 {
+		return "no error(s)"
+	}
+
+	if len(es) == 1 {
+		return fmt.Sprintf("1 error occurred: %v", es[0].Error())
+	}
+
+	s := make([]string, len(es))
+	for i, err := range es {
+		s[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d errors occurred:\n%s", len(es), strings.Join(s, "\n"))
+}
+
+// Unwrap allows matching the errors in the list using errors.Is and
+// errors.As.
+func (es Errors) Unwrap() []error {
+	errs := make([]error, len(es))
+	for i := range es {
+		errs[i] = es[i]
+	}
+	return errs
+}
+
+// IsUnsupportedPartial returns true if err was caused by partial evaluation
+// encountering a construct it could not expand in strict mode.
+func IsUnsupportedPartial(err error) bool {
+	return errors.Is(err, &Error{Code: UnsupportedPartialErr})
+}
+
 // IsError returns true if the err is an Error.
 func IsError(err error) bool {
 	var e *Error
@@ -5,9 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/metrics"
@@ -30,17 +32,32 @@ type queryIDFactory struct {
 	curr uint64
 }
 
-// Note: The first call to Next() returns 0.
+// Note: The first call to Next() returns 0. Next is safe for concurrent use
+// because rule bodies may be evaluated in parallel.
 func (f *queryIDFactory) Next() uint64 {
-	curr := f.curr
-	f.curr++
-	return curr
+	return atomic.AddUint64(&f.curr, 1) - 1
 }
 
 type builtinErrors struct {
 	errs []error
 }
 
+// partialErrors collects the constructs that partial evaluation saved
+// instead of expanding them into flat queries. It is only set when strict
+// partial evaluation is enabled.
+type partialErrors struct {
+	errs Errors
+}
+
+func (p *partialErrors) add(err *Error) {
+	for i := range p.errs {
+		if p.errs[i].Error() == err.Error() {
+			return
+		}
+	}
+	p.errs = append(p.errs, err)
+}
+
 // earlyExitError is used to abort iteration where early exit is possible
 type earlyExitError struct {
 	prev error
@@ -89,10 +106,17 @@ type eval struct {
 	builtins               map[string]*Builtin
 	builtinCache           builtins.Cache
 	ndBuiltinCache         builtins.NDBCache
+	ndBuiltinCacheReplay   bool
 	functionMocks          *functionMocksStack
 	virtualCache           *virtualCache
 	comprehensionCache     *comprehensionCache
 	interQueryBuiltinCache cache.InterQueryCache
+	httpSendScheduler      *HTTPSendScheduler
+	httpSendTransport      http.RoundTripper
+	limiter                *evalLimiter
+	memoCache              *MemoCache
+	memoGeneration         uint64
+	parallel               *parallelPool
 	saveSet                *saveSet
 	saveStack              *saveStack
 	saveSupport            *saveSupport
@@ -103,6 +127,8 @@ type eval struct {
 	genvarid               int
 	runtime                *ast.Term
 	builtinErrors          *builtinErrors
+	partialErrors          *partialErrors
+	flatWith               *ast.Expr // expression whose with statements are applied instead of saved
 	printHook              print.Hook
 	tracingOpts            tracing.Options
 	findOne                bool
@@ -197,6 +223,18 @@ func (e *eval) unknown(x interface{}, b *bindings) bool {
 	return saveRequired(e.compiler, e.inliningControl, true, e.saveSet, b, x, false)
 }
 
+// unsupportedPartial records that partial evaluation saved a construct
+// instead of expanding it into flat queries.
+func (e *eval) unsupportedPartial(loc *ast.Location, msg string) {
+	if e.partialErrors != nil {
+		e.partialErrors.add(&Error{
+			Code:     UnsupportedPartialErr,
+			Location: loc,
+			Message:  msg,
+		})
+	}
+}
+
 func (e *eval) traceEnter(x ast.Node) {
 	e.traceEvent(EnterOp, x, "", nil)
 }
@@ -256,15 +294,16 @@ func (e *eval) traceEvent(op Op, x ast.Node, msg string, target *ast.Ref) {
 	}
 
 	evt := Event{
-		QueryID:  e.queryID,
-		ParentID: parentID,
-		Op:       op,
-		Node:     x,
-		Location: location,
-		Message:  msg,
-		Ref:      target,
-		input:    e.input,
-		bindings: e.bindings,
+		QueryID:        e.queryID,
+		ParentID:       parentID,
+		Op:             op,
+		Node:           x,
+		Location:       location,
+		Message:        msg,
+		Ref:            target,
+		input:          e.input,
+		bindings:       e.bindings,
+		sourceLocation: e.sourceLocation(x),
 	}
 
 	// Skip plugging the local variables, unless any of the tracers
@@ -282,6 +321,7 @@ func (e *eval) traceEvent(op Op, x ast.Node, msg string, target *ast.Ref) {
 			evt.LocalMetadata[original] = VarMetadata{
 				Name:     rewritten,
 				Location: k.Loc(),
+				Source:   e.sourceTerm(original),
 			}
 
 			// For backwards compatibility save a copy of the values too..
@@ -297,6 +337,12 @@ func (e *eval) traceEvent(op Op, x ast.Node, msg string, target *ast.Ref) {
 							Name:     rewritten,
 							Location: term.Loc(),
 						}
+					} else if source := e.sourceTerm(v); source != nil {
+						evt.LocalMetadata[v] = VarMetadata{
+							Name:     v,
+							Location: source.Loc(),
+							Source:   source,
+						}
 					}
 				}
 			}
@@ -329,6 +375,12 @@ func (e *eval) evalExpr(iter evalIterator) error {
 		}
 	}
 
+	if e.index < len(e.query) {
+		if err := e.limiter.step(e.query[e.index].Location); err != nil {
+			return err
+		}
+	}
+
 	if e.index >= len(e.query) {
 		err := iter(e)
 
@@ -485,17 +537,24 @@ func (e *eval) evalWith(iter evalIterator) error {
 		return false
 	}
 
+	// The with statements replacing known values of base or virtual
+	// documents may be applied during partial evaluation instead of being
+	// saved (see evalWithFlattens.)
+	var known []ast.Ref
+
 	if e.partial() {
 
 		// If the value is unknown the with statement cannot be evaluated and so
 		// the entire expression should be saved to be safe. In the future this
 		// could be relaxed in certain cases (e.g., if the with statement would
 		// have no effect.)
+		flat := true
 		for _, with := range expr.With {
 			if isFunction(e.compiler.TypeEnv, with.Target) || // non-builtin function replaced
 				isOtherRef(with.Target) { // built-in replaced
 
 				ast.WalkRefs(with.Value, disableRef)
+				flat = false
 				continue
 			}
 
@@ -507,9 +566,14 @@ func (e *eval) evalWith(iter evalIterator) error {
 			}
 			ast.WalkRefs(with.Target, disableRef)
 			ast.WalkRefs(with.Value, disableRef)
+			known = append(known, with.Target.Value.(ast.Ref))
 		}
 
 		ast.WalkRefs(expr.NoWith(), disableRef)
+
+		if !flat {
+			known = nil
+		}
 	}
 
 	pairsInput := [][2]*ast.Term{}
@@ -558,21 +622,121 @@ func (e *eval) evalWith(iter evalIterator) error {
 		}
 	}
 
-	oldInput, oldData := e.evalWithPush(input, data, functionMocks, targets, disable)
+	if len(known) > 0 {
+		if e.evalWithFlattens(input, data, targets, known) {
+			disable = nil
+		} else {
+			known = nil
+		}
+	}
+
+	oldInput, oldData := e.evalWithPush(input, data, functionMocks, targets, disable, known)
 
 	err = e.evalStep(func(e *eval) error {
-		e.evalWithPop(oldInput, oldData)
+		e.evalWithPop(oldInput, oldData, known)
 		err := e.next(iter)
-		oldInput, oldData = e.evalWithPush(input, data, functionMocks, targets, disable)
+		oldInput, oldData = e.evalWithPush(input, data, functionMocks, targets, disable, known)
 		return err
 	})
 
-	e.evalWithPop(oldInput, oldData)
+	e.evalWithPop(oldInput, oldData, known)
 
 	return err
 }
 
-func (e *eval) evalWithPush(input, data *ast.Term, functionMocks [][2]*ast.Term, targets, disable []ast.Ref) (*ast.Term, *ast.Term) {
+// evalWithFlattens returns true if the with statements of the current
+// expression can be applied during partial evaluation instead of being saved.
+// This is the case if, with the known documents replaced, the expression
+// does not save refs to the replaced documents (or their parents) and does
+// not require support rules, which would otherwise be evaluated without the
+// replacements. The expression is evaluated once to find out; the results are
+// discarded.
+func (e *eval) evalWithFlattens(input, data *ast.Term, targets, known []ast.Ref) bool {
+	saveSupport, partialErrors := e.saveSupport, e.partialErrors
+	e.saveSupport, e.partialErrors = newSaveSupport(), nil
+	e.saveStack.PushQuery(nil)
+
+	oldInput, oldData := e.evalWithPush(input, data, nil, targets, nil, known)
+
+	err := e.evalStep(func(e *eval) error {
+		for _, elem := range e.saveStack.Peek() {
+			if !e.flatWithExpr(elem.Plug(e.caller.bindings), known) {
+				return errNotFlat
+			}
+		}
+		return nil
+	})
+
+	e.evalWithPop(oldInput, oldData, known)
+
+	e.saveStack.PopQuery()
+	flat := err == nil && len(e.saveSupport.modules) == 0
+	e.saveSupport, e.partialErrors = saveSupport, partialErrors
+
+	return flat
+}
+
+// errNotFlat aborts evaluations checking whether constructs can be expanded
+// into flat queries during partial evaluation.
+var errNotFlat = errors.New("cannot be expanded into flat queries")
+
+// flatWithExpr returns true if the saved expression keeps its semantics
+// without the with statements replacing the known refs.
+func (e *eval) flatWithExpr(expr *ast.Expr, known []ast.Ref) bool {
+	if len(expr.With) > 0 {
+		return false
+	}
+
+	if expr.IsCall() {
+		if _, ok := ast.BuiltinMap[expr.Operator().String()]; !ok {
+			return false
+		}
+	}
+
+	flat := true
+	vis := ast.NewGenericVisitor(func(x interface{}) bool {
+		if !flat {
+			return true
+		}
+		switch x := x.(type) {
+		case *ast.Expr:
+			if x != expr && len(x.With) > 0 {
+				flat = false
+			}
+		case ast.Ref:
+			if !x[0].Equal(ast.InputRootDocument) && !x[0].Equal(ast.DefaultRootDocument) {
+				return false
+			}
+			for _, k := range known {
+				if x.HasPrefix(k) || k.HasPrefix(x) {
+					flat = false
+					return true
+				}
+			}
+			// Refs to data must be unknown, i.e., not refer to support rules.
+			if x[0].Equal(ast.DefaultRootDocument) && !e.saveSet.Contains(ast.NewTerm(x), nil) {
+				flat = false
+			}
+		}
+		return !flat
+	})
+
+	switch terms := expr.Terms.(type) {
+	case []*ast.Term:
+		if expr.IsCall() {
+			terms = terms[1:]
+		}
+		for _, t := range terms {
+			vis.Walk(t)
+		}
+	default:
+		vis.Walk(terms)
+	}
+
+	return flat
+}
+
+func (e *eval) evalWithPush(input, data *ast.Term, functionMocks [][2]*ast.Term, targets, disable, known []ast.Ref) (*ast.Term, *ast.Term) {
 	var oldInput *ast.Term
 
 	if input != nil {
@@ -587,6 +751,11 @@ func (e *eval) evalWithPush(input, data *ast.Term, functionMocks [][2]*ast.Term,
 		e.data = data
 	}
 
+	if len(known) > 0 {
+		e.saveSet.PushKnown(known)
+		e.flatWith = e.query[e.index]
+	}
+
 	e.comprehensionCache.Push()
 	e.virtualCache.Push()
 	e.targetStack.Push(targets)
@@ -596,7 +765,11 @@ func (e *eval) evalWithPush(input, data *ast.Term, functionMocks [][2]*ast.Term,
 	return oldInput, oldData
 }
 
-func (e *eval) evalWithPop(input, data *ast.Term) {
+func (e *eval) evalWithPop(input, data *ast.Term, known []ast.Ref) {
+	if len(known) > 0 {
+		e.saveSet.PopKnown()
+		e.flatWith = nil
+	}
 	e.inliningControl.PopDisable()
 	e.targetStack.Pop()
 	e.virtualCache.Pop()
@@ -675,7 +848,7 @@ func (e *eval) evalNotPartial(iter evalIterator) error {
 	//
 	//	(!A && !C) || (!A && !D) || (!B && !C) || (!B && !D)
 	return complementedCartesianProduct(savedQueries, 0, nil, func(q ast.Body) error {
-		return e.saveInlinedNegatedExprs(q, func() error {
+		return e.saveInlinedExprs(q, func() error {
 			return iter(e)
 		})
 	})
@@ -712,6 +885,8 @@ func (e *eval) evalNotPartialSupport(negationID uint64, expr *ast.Expr, unknowns
 		head.Args = args
 	}
 
+	e.unsupportedPartial(expr.Location, "negated expression requires support rules")
+
 	// Save support rules.
 	for _, query := range queries {
 		e.saveSupport.Insert(path, &ast.Rule{
@@ -732,7 +907,7 @@ func (e *eval) evalNotPartialSupport(negationID uint64, expr *ast.Expr, unknowns
 		cpy.Terms = term
 	}
 
-	return e.saveInlinedNegatedExprs([]*ast.Expr{cpy}, func() error {
+	return e.saveInlinedExprs([]*ast.Expr{cpy}, func() error {
 		return e.next(iter)
 	})
 }
@@ -828,7 +1003,10 @@ func (e *eval) evalCall(terms []*ast.Term, iter unifyIterator) error {
 		ParentID:               parentID,
 		PrintHook:              e.printHook,
 		DistributedTracingOpts: e.tracingOpts,
+		HTTPSendScheduler:      e.httpSendScheduler,
+		HTTPSendTransport:      e.httpSendTransport,
 		Capabilities:           capabilities,
+		limiter:                e.limiter,
 	}
 
 	eval := evalBuiltin{
@@ -1218,7 +1396,18 @@ func (e *eval) buildComprehensionCacheObject(x *ast.ObjectComprehension, keys []
 }
 
 func (e *eval) biunifyComprehensionPartial(a, b *ast.Term, b1, b2 *bindings, swap bool, iter unifyIterator) error {
-	var err error
+	value, err := e.evalComprehensionPartial(a, b1)
+	if err != nil {
+		return err
+	} else if value != nil {
+		if !swap {
+			return e.biunify(value, b, b1, b2, iter)
+		}
+		return e.biunify(b, value, b2, b1, iter)
+	}
+
+	e.unsupportedPartial(e.query[e.index].Location, "comprehension with unknowns cannot be expanded")
+
 	cpyA, err := e.amendComprehension(a, b1)
 	if err != nil {
 		return err
@@ -1241,6 +1430,78 @@ func (e *eval) biunifyComprehensionPartial(a, b *ast.Term, b1, b2 *bindings, swa
 	return e.saveUnify(b, cpyA, b2, b1, iter)
 }
 
+// evalComprehensionPartial returns the value of the comprehension a if its
+// body refers to unknowns but does not save any expressions when evaluated,
+// e.g., because the unknowns are only referred to by rules that are undefined
+// or by branches that fail. Otherwise, nil is returned.
+func (e *eval) evalComprehensionPartial(a *ast.Term, b1 *bindings) (*ast.Term, error) {
+	if b1 != e.bindings {
+		return nil, nil
+	}
+
+	var body ast.Body
+	var result *ast.Term
+
+	switch x := a.Value.(type) {
+	case *ast.ArrayComprehension:
+		body, result = x.Body, ast.ArrayTerm()
+	case *ast.SetComprehension:
+		body, result = x.Body, ast.SetTerm()
+	case *ast.ObjectComprehension:
+		body, result = x.Body, ast.ObjectTerm()
+	default:
+		return nil, fmt.Errorf("illegal comprehension %T", x)
+	}
+
+	saveSupport, partialErrors := e.saveSupport, e.partialErrors
+	e.saveSupport, e.partialErrors = newSaveSupport(), nil
+	e.saveStack.PushQuery(nil)
+
+	child := e.closure(body)
+	err := child.Run(func(child *eval) error {
+		if len(e.saveStack.Peek()) > 0 {
+			return errNotFlat
+		}
+		switch x := a.Value.(type) {
+		case *ast.ArrayComprehension:
+			head := child.bindings.Plug(x.Term)
+			if !head.IsGround() {
+				return errNotFlat
+			}
+			result.Value = result.Value.(*ast.Array).Append(head)
+		case *ast.SetComprehension:
+			head := child.bindings.Plug(x.Term)
+			if !head.IsGround() {
+				return errNotFlat
+			}
+			result.Value.(ast.Set).Add(head)
+		case *ast.ObjectComprehension:
+			key, value := child.bindings.Plug(x.Key), child.bindings.Plug(x.Value)
+			if !key.IsGround() || !value.IsGround() {
+				return errNotFlat
+			}
+			obj := result.Value.(ast.Object)
+			if exist := obj.Get(key); exist != nil && !exist.Equal(value) {
+				return objectDocKeyConflictErr(x.Key.Location)
+			}
+			obj.Insert(key, value)
+		}
+		return nil
+	})
+
+	e.saveStack.PopQuery()
+	flat := len(e.saveSupport.modules) == 0
+	e.saveSupport, e.partialErrors = saveSupport, partialErrors
+
+	switch {
+	case errors.Is(err, errNotFlat) || (err == nil && !flat):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+	return result, nil
+}
+
 // amendComprehension captures bindings available to the comprehension,
 // and used within its term or body.
 func (e *eval) amendComprehension(a *ast.Term, b1 *bindings) (*ast.Term, error) {
@@ -1410,18 +1671,22 @@ func (e *eval) saveCall(declArgsLen int, terms []*ast.Term, iter unifyIterator)
 	return err
 }
 
-func (e *eval) saveInlinedNegatedExprs(exprs []*ast.Expr, iter unifyIterator) error {
+func (e *eval) saveInlinedExprs(exprs []*ast.Expr, iter unifyIterator) error {
 
-	with := make([]*ast.With, len(e.query[e.index].With))
+	withs := e.savedWiths()
+	with := make([]*ast.With, len(withs))
 
-	for i := range e.query[e.index].With {
-		cpy := e.query[e.index].With[i].Copy()
+	for i := range withs {
+		cpy := withs[i].Copy()
 		cpy.Value = e.bindings.PlugNamespaced(cpy.Value, e.caller.bindings)
 		with[i] = cpy
 	}
 
 	for _, expr := range exprs {
 		expr.With = e.updateSavedMocks(with)
+		if len(expr.With) > 0 {
+			e.unsupportedPartial(e.query[e.index].Location, "with statement cannot be expanded")
+		}
 		e.saveStack.Push(expr, nil, nil)
 		e.traceSave(expr)
 	}
@@ -1664,6 +1929,79 @@ func (e *eval) rewrittenVar(v ast.Var) (ast.Var, bool) {
 	return v, false
 }
 
+// sourceLocation returns the location of the source that x was compiled from.
+// Expressions generated by the compiler are located at the term they were
+// generated for, as recorded in the source map.
+func (e *eval) sourceLocation(x ast.Node) *ast.Location {
+	if expr, ok := x.(*ast.Expr); ok && expr.Generated {
+		if v := definedVar(expr); v != "" {
+			if e.compiler != nil {
+				if loc := e.compiler.SourceMap.Location(v); loc != nil {
+					return loc
+				}
+			}
+			return e.querySourceMap().Location(v)
+		}
+	}
+	return nil
+}
+
+// definedVar returns the var that a generated expression binds, i.e., the
+// first operand of a generated equality or the output of a generated call.
+func definedVar(expr *ast.Expr) ast.Var {
+	var t *ast.Term
+	switch {
+	case expr.IsEquality():
+		t = expr.Operand(0)
+	case expr.IsCall():
+		operands := expr.Operands()
+		if len(operands) > 0 {
+			t = operands[len(operands)-1]
+		}
+	}
+	if t != nil {
+		if v, ok := t.Value.(ast.Var); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// querySourceMap returns the source map of the query compiler. If there is no
+// query compiler or it does not record a source map, nil is returned.
+func (e *eval) querySourceMap() ast.SourceMap {
+	if sm, ok := e.queryCompiler.(ast.SourceMapper); ok {
+		return sm.SourceMap()
+	}
+	return nil
+}
+
+// sourceTerm returns the term that the generated var v stands in for, with
+// generated and rewritten vars replaced by their originals. If v was not
+// generated by the compiler, nil is returned.
+func (e *eval) sourceTerm(v ast.Var) *ast.Term {
+	var t *ast.Term
+	if e.compiler != nil {
+		if src, ok := e.compiler.SourceMap.Term(v); ok {
+			t = e.compiler.SourceMap.Original(src)
+		}
+	}
+	if t == nil {
+		sm := e.querySourceMap()
+		if src, ok := sm.Term(v); ok {
+			t = sm.Original(src)
+		}
+	}
+	if t == nil {
+		return nil
+	}
+	_, _ = ast.TransformVars(t, func(x ast.Var) (ast.Value, error) {
+		rw, _ := e.rewrittenVar(x)
+		return rw, nil
+	})
+	return t
+}
+
 func (e *eval) getDeclArgsLen(x *ast.Expr) (int, error) {
 
 	if !x.IsCall() {
@@ -1692,8 +2030,22 @@ func (e *eval) getDeclArgsLen(x *ast.Expr) (int, error) {
 // With values are namespaced to ensure that replacement functions of
 // mocked built-ins are properly referenced in the support module.
 func (e *eval) updateFromQuery(expr *ast.Expr) {
-	expr.With = e.updateSavedMocks(e.query[e.index].With)
+	expr.With = e.updateSavedMocks(e.savedWiths())
 	expr.Location = e.query[e.index].Location
+	if len(expr.With) > 0 {
+		e.unsupportedPartial(expr.Location, "with statement cannot be expanded")
+	}
+}
+
+// savedWiths returns the with statements of the currently looked-at query
+// item that must be saved along with the expressions saved for it. There are
+// none if the with statements are applied during partial evaluation (see
+// evalWithFlattens.)
+func (e *eval) savedWiths() []*ast.With {
+	if e.flatWith == e.query[e.index] {
+		return nil
+	}
+	return e.query[e.index].With
 }
 
 type evalBuiltin struct {
@@ -1751,6 +2103,15 @@ func (e evalBuiltin) eval(iter unifyIterator) error {
 			}
 		}
 
+		// When replaying, calls that were not recorded must not be evaluated.
+		if e.e.ndBuiltinCacheReplay {
+			return &Error{
+				Code:     BuiltinErr,
+				Message:  fmt.Sprintf("%v: no recorded result for arguments %v", e.bi.Name, ast.NewArray(operands[:endIndex]...)),
+				Location: e.bctx.Location,
+			}
+		}
+
 		// Otherwise, we'll need to go through the normal unify flow.
 		e.e.instr.startTimer(evalOpBuiltinCall)
 	}
@@ -1760,9 +2121,10 @@ func (e evalBuiltin) eval(iter unifyIterator) error {
 
 		e.e.instr.stopTimer(evalOpBuiltinCall)
 
-		var err error
+		err := e.e.limiter.term(output, e.bctx.Location)
 
 		switch {
+		case err != nil: // resource limit exceeded, don't iter()
 		case e.bi.Decl.Result() == nil:
 			err = iter()
 		case len(operands) == numDeclArgs:
@@ -1858,9 +2220,14 @@ func (e evalFunc) evalValue(iter unifyIterator, argCount int, findOne bool) erro
 		}
 	}
 
+	var memoArgs ast.Value
+	if cacheKey != nil && e.e.memoEnabled(e.ir) {
+		memoArgs = e.memoArgs(cacheKey, argCount)
+	}
+
 	var prev *ast.Term
 
-	return withSuppressEarlyExit(func() error {
+	err = withSuppressEarlyExit(func() error {
 		var outerEe *deferredEarlyExitError
 		for _, rule := range e.ir.Rules {
 			next, err := e.evalOneRule(iter, rule, cacheKey, prev, findOne)
@@ -1906,6 +2273,22 @@ func (e evalFunc) evalValue(iter unifyIterator, argCount int, findOne bool) erro
 
 		return nil
 	})
+
+	if memoArgs != nil && (err == nil || isEarlyExit(err)) {
+		e.e.memoPut(cacheKey, e.ref, memoArgs, e.ir)
+	}
+
+	return err
+}
+
+// memoArgs returns the arguments of the function call as an array if they are
+// ground. Otherwise, nil is returned and the result cannot be memoized.
+func (e evalFunc) memoArgs(cacheKey ast.Ref, argCount int) ast.Value {
+	args := ast.NewArray(cacheKey[1 : argCount+1]...)
+	if !args.IsGround() {
+		return nil
+	}
+	return args
 }
 
 func (e evalFunc) evalCache(argCount int, iter unifyIterator) (ast.Ref, bool, error) {
@@ -1920,6 +2303,12 @@ func (e evalFunc) evalCache(argCount int, iter unifyIterator) (ast.Ref, bool, er
 		cacheKey[i] = e.e.bindings.Plug(e.terms[i])
 	}
 
+	if e.e.memoEnabled(e.ir) {
+		if args := e.memoArgs(cacheKey, argCount); args != nil {
+			e.e.memoGet(cacheKey, e.ref, args)
+		}
+	}
+
 	cached, _ := e.e.virtualCache.Get(cacheKey)
 	if cached != nil {
 		e.e.instr.counterIncr(evalOpVirtualCacheHit)
@@ -2025,6 +2414,8 @@ func (e evalFunc) partialEvalSupport(declArgsLen int, iter unifyIterator) error
 		return nil
 	}
 
+	e.e.unsupportedPartial(e.e.query[e.e.index].Location, fmt.Sprintf("call to %v requires support rules", e.ref))
+
 	return e.e.saveCall(declArgsLen, append([]*ast.Term{term}, e.terms[1:]...), iter)
 }
 
@@ -2516,6 +2907,10 @@ func (e evalVirtualPartial) evalAllRules(iter unifyIterator, rules []*ast.Rule)
 }
 
 func (e evalVirtualPartial) evalAllRulesNoCache(rules []*ast.Rule) (*ast.Term, error) {
+	if e.e.parallelEnabled(len(rules)) {
+		return e.evalAllRulesParallel(rules)
+	}
+
 	result := e.empty
 
 	var visitedRefs []ast.Ref
@@ -2543,6 +2938,44 @@ func (e evalVirtualPartial) evalAllRulesNoCache(rules []*ast.Rule) (*ast.Term, e
 	return result, nil
 }
 
+// evalAllRulesParallel evaluates the bodies of rules concurrently and reduces
+// their solutions in rule order so that the result (and any conflict error) is
+// the same as with sequential evaluation.
+func (e evalVirtualPartial) evalAllRulesParallel(rules []*ast.Rule) (*ast.Term, error) {
+	solutions := make([][]*bindings, len(rules))
+
+	forks, errs := e.e.evalParallel(len(rules), func(i int, fork *eval) error {
+		rule := rules[i]
+		child := fork.child(rule.Body)
+		child.traceEnter(rule)
+		return child.eval(func(child *eval) error {
+			child.traceExit(rule)
+			solutions[i] = append(solutions[i], snapshotBindings(child.bindings, rule.Head.Ref(), rule.Head.Key, rule.Head.Value))
+			child.traceRedo(rule)
+			return nil
+		})
+	})
+
+	result := e.empty
+	var visitedRefs []ast.Ref
+
+	for i, rule := range rules {
+		e.e.join(forks[i])
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		for _, b := range solutions[i] {
+			var err error
+			result, _, err = e.reduce(rule, b, result, &visitedRefs)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
 func wrapInObjects(leaf *ast.Term, ref ast.Ref) *ast.Term {
 	// We build the nested objects leaf-to-root to preserve ground:ness
 	if len(ref) == 0 {
@@ -2725,6 +3158,8 @@ func (e evalVirtualPartial) partialEvalSupport(iter unifyIterator) error {
 
 		// the entire partial set/obj was queried, e.g. data.a.q (not data.a.q[x])
 		term = e.empty
+	} else {
+		e.e.unsupportedPartial(e.e.query[e.e.index].Location, fmt.Sprintf("%v requires support rules", e.plugged[:e.pos+1]))
 	}
 
 	return e.e.saveUnify(term, e.rterm, e.bindings, e.rbindings, iter)
@@ -3002,6 +3437,11 @@ func (e evalVirtualComplete) eval(iter unifyIterator) error {
 }
 
 func (e evalVirtualComplete) evalValue(iter unifyIterator, findOne bool) error {
+	memo := e.e.memoEnabled(e.ir)
+	if memo {
+		e.e.memoGet(e.plugged[:e.pos+1], e.plugged[:e.pos+1], memoNoArgs)
+	}
+
 	cached, undefined := e.e.virtualCache.Get(e.plugged[:e.pos+1])
 	if undefined {
 		e.e.instr.counterIncr(evalOpVirtualCacheHit)
@@ -3015,7 +3455,16 @@ func (e evalVirtualComplete) evalValue(iter unifyIterator, findOne bool) error {
 		return e.evalTerm(iter, cached, e.bindings)
 	}
 
-	return withSuppressEarlyExit(func() error {
+	if e.e.parallelEnabled(len(e.ir.Rules)) {
+		e.e.instr.counterIncr(evalOpVirtualCacheMiss)
+		err := e.evalValueParallel(iter, findOne)
+		if memo && (err == nil || isEarlyExit(err)) {
+			e.e.memoPut(e.plugged[:e.pos+1], e.plugged[:e.pos+1], memoNoArgs, e.ir)
+		}
+		return err
+	}
+
+	err := withSuppressEarlyExit(func() error {
 		e.e.instr.counterIncr(evalOpVirtualCacheMiss)
 
 		var prev *ast.Term
@@ -3069,6 +3518,12 @@ func (e evalVirtualComplete) evalValue(iter unifyIterator, findOne bool) error {
 
 		return nil
 	})
+
+	if memo && (err == nil || isEarlyExit(err)) {
+		e.e.memoPut(e.plugged[:e.pos+1], e.plugged[:e.pos+1], memoNoArgs, e.ir)
+	}
+
+	return err
 }
 
 func (e evalVirtualComplete) evalValueRule(iter unifyIterator, rule *ast.Rule, prev *ast.Term, findOne bool) (*ast.Term, error) {
@@ -3107,6 +3562,72 @@ func (e evalVirtualComplete) evalValueRule(iter unifyIterator, rule *ast.Rule, p
 	return result, err
 }
 
+// evalValueParallel evaluates the bodies of the rules (and their else
+// branches) concurrently. The values are then checked for conflicts in rule
+// order before the iterator is invoked once with the value of the document.
+func (e evalVirtualComplete) evalValueParallel(iter unifyIterator, findOne bool) error {
+	type ruleValue struct {
+		value *ast.Term
+		rule  *ast.Rule
+	}
+
+	values := make([][]ruleValue, len(e.ir.Rules))
+
+	forks, errs := e.e.evalParallel(len(e.ir.Rules), func(i int, fork *eval) error {
+		rules := append([]*ast.Rule{e.ir.Rules[i]}, e.ir.Else[e.ir.Rules[i]]...)
+		for _, rule := range rules {
+			child := fork.child(rule.Body)
+			child.findOne = findOne
+			child.traceEnter(rule)
+			err := child.eval(func(child *eval) error {
+				child.traceExit(rule)
+				values[i] = append(values[i], ruleValue{value: child.bindings.Plug(rule.Head.Value), rule: rule})
+				child.traceRedo(rule)
+				return nil
+			})
+			if err := suppressEarlyExit(err); err != nil {
+				return err
+			}
+			if len(values[i]) > 0 {
+				return nil
+			}
+		}
+		return nil
+	})
+
+	var prev *ast.Term
+
+	for i := range e.ir.Rules {
+		e.e.join(forks[i])
+		if errs[i] != nil {
+			return errs[i]
+		}
+		for _, rv := range values[i] {
+			if prev != nil && ast.Compare(rv.value, prev) != 0 {
+				return completeDocConflictErr(rv.rule.Location)
+			}
+			prev = rv.value
+		}
+		if findOne && prev != nil {
+			break
+		}
+	}
+
+	if prev == nil {
+		if e.ir.Default != nil {
+			return withSuppressEarlyExit(func() error {
+				_, err := e.evalValueRule(iter, e.ir.Default, nil, findOne)
+				return err
+			})
+		}
+		e.e.virtualCache.Put(e.plugged[:e.pos+1], nil)
+		return nil
+	}
+
+	e.e.virtualCache.Put(e.plugged[:e.pos+1], prev)
+	return e.evalTerm(iter, prev, e.bindings)
+}
+
 func (e evalVirtualComplete) partialEval(iter unifyIterator) error {
 
 	for _, rule := range e.ir.Rules {
@@ -3169,6 +3690,8 @@ func (e evalVirtualComplete) partialEvalSupport(iter unifyIterator) error {
 		return nil
 	}
 
+	e.e.unsupportedPartial(e.e.query[e.e.index].Location, fmt.Sprintf("%v requires support rules", e.plugged[:e.pos+1]))
+
 	return e.e.saveUnify(term, e.rterm, e.bindings, e.rbindings, iter)
 }
 
@@ -3397,11 +3920,17 @@ type evalEvery struct {
 }
 
 func (e evalEvery) eval(iter unifyIterator) error {
-	// unknowns in domain or body: save the expression, PE its body
-	if e.e.unknown(e.generator, e.e.bindings) || e.e.unknown(e.body, e.e.bindings) {
+	// unknowns in domain: save the expression, PE its body
+	if e.e.unknown(e.generator, e.e.bindings) {
+		e.e.unsupportedPartial(e.expr.Location, "every expression with unknown domain cannot be expanded")
 		return e.save(iter)
 	}
 
+	// unknowns in body only: expand the expression for each domain element
+	if e.e.unknown(e.body, e.e.bindings) {
+		return e.expand(iter)
+	}
+
 	domain := e.e.closure(e.generator)
 	all := true // all generator evaluations yield one successful body evaluation
 
@@ -3446,6 +3975,135 @@ func (e evalEvery) eval(iter unifyIterator) error {
 	return nil
 }
 
+// maxEveryExpansion is the maximum number of queries an every expression
+// with unknowns in its body is expanded into. Larger expansions save the
+// every expression instead.
+const maxEveryExpansion = 1024
+
+// expand partially evaluates the body for each element of the known domain
+// and saves the conjunction of the results instead of the every expression.
+// If the body yields more than one query for some elements, the result is
+// the cross product of the queries. For example, with the domain [1, 2]:
+//
+//	every x in [1, 2] { x == input.a } => 1 == input.a; 2 == input.a
+//
+// Vars local to the body are quantified per element, so they are renamed
+// apart in the queries of all but the first element.
+func (e evalEvery) expand(iter unifyIterator) error {
+	var queries [][]ast.Body
+	var singles []ast.Body // queries of elements yielding exactly one query
+	all := true            // all domain elements yield at least one body query
+	size := 1
+
+	domain := e.e.closure(e.generator)
+	domain.traceEnter(e.expr)
+
+	err := domain.eval(func(child *eval) error {
+		if !all {
+			return nil
+		}
+
+		var elem []ast.Body
+		unconditional := false
+		outer := e.outerVars(child.bindings)
+
+		body := child.closure(e.body)
+		e.e.saveStack.PushQuery(nil)
+		err := body.eval(func(*eval) error {
+			query := e.e.saveStack.Peek()
+			if len(query) == 0 {
+				unconditional = true
+				return nil
+			}
+			plugged := query.Plug(e.e.caller.bindings)
+			// Skip this body query if it fails to type-check.
+			if e.e.compiler.PassesTypeCheck(plugged) {
+				elem = append(elem, plugged)
+			}
+			return nil
+		})
+		e.e.saveStack.PopQuery()
+
+		child.traceRedo(e.expr)
+
+		switch {
+		case err != nil:
+			return err
+		case unconditional:
+		case len(elem) == 0:
+			all = false
+		case len(elem) == 1 && containsQuery(singles, elem[0]):
+			// same condition as for a previous element
+		default:
+			if len(elem) == 1 {
+				singles = append(singles, elem[0])
+			}
+			if len(queries) > 0 {
+				renamed := map[ast.Var]ast.Var{}
+				for i := range elem {
+					elem[i] = e.renameLocalVars(elem[i], outer, renamed)
+				}
+			}
+			queries = append(queries, elem)
+			if size *= len(elem); size > maxEveryExpansion {
+				size = maxEveryExpansion + 1
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if !all {
+		domain.traceFail(e.expr)
+		return nil
+	}
+
+	if size > maxEveryExpansion {
+		e.e.unsupportedPartial(e.expr.Location, "every expression expands into too many queries")
+		return e.save(iter)
+	}
+
+	err = cartesianProduct(queries, 0, nil, func(q ast.Body) error {
+		return e.e.saveInlinedExprs(q, iter)
+	})
+	domain.traceExit(e.expr)
+	return err
+}
+
+// outerVars returns the vars the body shares with the enclosing query, as they
+// appear in saved expressions: body vars that are bound or unknown before the
+// body is evaluated refer to the enclosing query.
+func (e evalEvery) outerVars(b *bindings) ast.VarSet {
+	result := ast.NewVarSet()
+	for v := range e.body.Vars(ast.VarVisitorParams{}) {
+		t := ast.NewTerm(v)
+		if _, ok := b.get(t); !ok && !e.e.saveSet.Contains(t, b) {
+			continue
+		}
+		result.Update(b.PlugNamespaced(t, e.e.caller.bindings).Vars())
+	}
+	return result
+}
+
+// renameLocalVars replaces the vars in query that are neither outer vars nor
+// root documents with fresh vars. Queries of the same element share renamed.
+func (e evalEvery) renameLocalVars(query ast.Body, outer ast.VarSet, renamed map[ast.Var]ast.Var) ast.Body {
+	local := query.Vars(ast.VarVisitorParams{SkipRefCallHead: true}).Diff(outer)
+	x, _ := ast.TransformVars(query.Copy(), func(v ast.Var) (ast.Value, error) {
+		if !local.Contains(v) || ast.RootDocumentNames.Contains(ast.NewTerm(v)) {
+			return v, nil
+		}
+		if _, ok := renamed[v]; !ok {
+			renamed[v] = e.e.generateVar(fmt.Sprintf("every_%d", e.e.genvarid)).Value.(ast.Var)
+			e.e.genvarid++
+		}
+		return renamed[v], nil
+	})
+	return x.(ast.Body)
+}
+
 func (e *evalEvery) save(iter unifyIterator) error {
 	return e.e.saveExpr(e.plug(e.expr), e.e.bindings, iter)
 }
@@ -3647,6 +4305,54 @@ func containsNestedRefOrCallInTerm(vis *nestedCheckVisitor, term *ast.Term) bool
 	}
 }
 
+// cartesianProduct invokes iter with the conjunction of each combination of
+// queries, one of each element of queries. Duplicate expressions are only
+// included once.
+func cartesianProduct(queries [][]ast.Body, idx int, curr ast.Body, iter func(ast.Body) error) error {
+	if idx == len(queries) {
+		cpy := make(ast.Body, 0, len(curr))
+		for i := range curr {
+			expr := curr[i].Copy()
+			if !containsExpr(cpy, expr) {
+				cpy.Append(expr)
+			}
+		}
+		return iter(cpy)
+	}
+	for _, query := range queries[idx] {
+		n := len(curr)
+		curr = append(curr, query...)
+		if err := cartesianProduct(queries, idx+1, curr, iter); err != nil {
+			return err
+		}
+		curr = curr[:n]
+	}
+	return nil
+}
+
+// containsQuery returns true if queries contains a query equal to query.
+func containsQuery(queries []ast.Body, query ast.Body) bool {
+	for i := range queries {
+		if queries[i].Equal(query) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsExpr returns true if body contains an expression equal to expr,
+// regardless of its index.
+func containsExpr(body ast.Body, expr *ast.Expr) bool {
+	cpy := *expr
+	for _, other := range body {
+		cpy.Index = other.Index
+		if cpy.Equal(other) {
+			return true
+		}
+	}
+	return false
+}
+
 func complementedCartesianProduct(queries []ast.Body, idx int, curr ast.Body, iter func(ast.Body) error) error {
 	if idx == len(queries) {
 		return iter(curr)
@@ -20,10 +20,12 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/internal/version"
+	"github.com/open-policy-agent/opa/metrics"
 	"github.com/open-policy-agent/opa/topdown/builtins"
 	"github.com/open-policy-agent/opa/topdown/cache"
 	"github.com/open-policy-agent/opa/tracing"
@@ -40,6 +42,10 @@ const (
 
 var defaultHTTPRequestTimeout = time.Second * 5
 
+// defaultHTTPSendBackgroundRefreshTimeout bounds background refreshes of
+// requests that do not time out.
+const defaultHTTPSendBackgroundRefreshTimeout = time.Minute
+
 var allowedKeyNames = [...]string{
 	"method",
 	"url",
@@ -65,6 +71,7 @@ var allowedKeyNames = [...]string{
 	"cache",
 	"force_cache",
 	"force_cache_duration_seconds",
+	"negative_cache_duration_seconds",
 	"raise_error",
 	"caching_mode",
 	"max_retry_attempts",
@@ -91,10 +98,26 @@ var (
 	requiredKeys                = ast.NewSet(ast.StringTerm("method"), ast.StringTerm("url"))
 	httpSendLatencyMetricKey    = "rego_builtin_" + strings.ReplaceAll(ast.HTTPSend.Name, ".", "_")
 	httpSendInterQueryCacheHits = httpSendLatencyMetricKey + "_interquery_cache_hits"
+
+	httpSendInterQueryCacheStaleHits    = httpSendLatencyMetricKey + "_interquery_cache_stale_hits"
+	httpSendInterQueryCacheNegativeHits = httpSendLatencyMetricKey + "_interquery_cache_negative_hits"
+	httpSendInterQueryCacheRefreshes    = httpSendLatencyMetricKey + "_interquery_cache_background_refreshes"
+
+	// httpSendBackgroundRefreshes holds the requests whose cached responses are
+	// being refreshed in the background, for inter-query caches that do not keep
+	// track of refreshes themselves (see cache.InterQueryCacheRefresher).
+	httpSendBackgroundRefreshes sync.Map
 )
 
 type httpSendKey string
 
+// Names of the http.send values stored in distributed inter-query caches for
+// the serialized and deserialized caching modes, respectively.
+const (
+	httpSendCacheValueType = "http.send"
+	httpSendCacheDataType  = "http.send.deserialized"
+)
+
 const (
 	// httpSendBuiltinCacheKey is the key in the builtin context cache that
 	// points to the http.send() specific cache resides at.
@@ -136,6 +159,9 @@ func builtinHTTPSend(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.T
 
 	result, err := getHTTPResponse(bctx, req)
 	if err != nil {
+		if _, ok := err.(Halt); ok {
+			return err
+		}
 		if raiseError {
 			return handleHTTPSendErr(bctx, err)
 		}
@@ -166,6 +192,10 @@ func generateRaiseErrorResult(err error) *ast.Term {
 
 func getHTTPResponse(bctx BuiltinContext, req ast.Object) (*ast.Term, error) {
 
+	if err := bctx.limiter.httpSend(bctx.Location); err != nil {
+		return nil, Halt{Err: err}
+	}
+
 	bctx.Metrics.Timer(httpSendLatencyMetricKey).Start()
 
 	reqExecutor, err := newHTTPRequestExecutor(bctx, req)
@@ -203,6 +233,16 @@ func init() {
 	createCacheableHTTPStatusCodes()
 	initDefaults()
 	RegisterBuiltinFunc(ast.HTTPSend.Name, builtinHTTPSend)
+	cache.RegisterInterQueryCacheValueType(httpSendCacheValueType, func(data []byte) (cache.InterQueryCacheValue, error) {
+		return &interQueryCacheValue{Data: data}, nil
+	})
+	cache.RegisterInterQueryCacheValueType(httpSendCacheDataType, func(data []byte) (cache.InterQueryCacheValue, error) {
+		var v interQueryCacheData
+		if err := util.UnmarshalJSON(data, &v); err != nil {
+			return nil, err
+		}
+		return &v, nil
+	})
 }
 
 func handleHTTPSendErr(bctx BuiltinContext, err error) error {
@@ -480,7 +520,7 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 				return nil, nil, err
 			}
 		case "cache", "caching_mode",
-			"force_cache", "force_cache_duration_seconds",
+			"force_cache", "force_cache_duration_seconds", "negative_cache_duration_seconds",
 			"force_json_decode", "force_yaml_decode",
 			"raise_error", "max_retry_attempts": // no-op
 		default:
@@ -661,6 +701,10 @@ func createHTTPRequest(bctx BuiltinContext, obj ast.Object) (*http.Request, *htt
 		tlsConfig.ServerName = tlsServerName
 	}
 
+	if bctx.HTTPSendTransport != nil {
+		client.Transport = bctx.HTTPSendTransport
+	}
+
 	if len(bctx.DistributedTracingOpts) > 0 {
 		client.Transport = tracing.NewTransport(client.Transport, bctx.DistributedTracingOpts)
 	}
@@ -843,10 +887,35 @@ func (c *interQueryCache) checkHTTPSendInterQueryCache() (ast.Value, error) {
 		return nil, nil
 	}
 
-	if getCurrentTime(c.bctx).Before(cachedRespData.ExpiresAt) {
+	now := getCurrentTime(c.bctx)
+
+	if now.Before(cachedRespData.ExpiresAt) {
+		if cachedRespData.negative() {
+			c.bctx.Metrics.Counter(httpSendInterQueryCacheNegativeHits).Incr()
+			if cachedRespData.Error != nil {
+				return nil, cachedRespData.Error.toError()
+			}
+		}
 		return cachedRespData.formatToAST(c.forceJSONDecode, c.forceYAMLDecode)
 	}
 
+	if cachedRespData.Error != nil {
+		requestCache.Delete(c.key)
+		return nil, nil
+	}
+
+	// Serve the stale response while it is being refreshed in the background.
+	if now.Before(cachedRespData.StaleWhileRevalidateUntil) {
+		c.bctx.Metrics.Counter(httpSendInterQueryCacheStaleHits).Incr()
+		c.refreshInBackground(cachedRespData)
+		return cachedRespData.formatToAST(c.forceJSONDecode, c.forceYAMLDecode)
+	}
+
+	// Keep the stale response around in case the server cannot be reached.
+	if now.Before(cachedRespData.StaleIfErrorUntil) {
+		c.stale = cachedRespData
+	}
+
 	var err error
 	c.httpReq, c.httpClient, err = createHTTPRequest(c.bctx, c.key)
 	if err != nil {
@@ -859,64 +928,209 @@ func (c *interQueryCache) checkHTTPSendInterQueryCache() (ast.Value, error) {
 	// If server returns a new response (ie. status_code=200), update the cache with the new response
 	// If server returns an unmodified response (ie. status_code=304), update the headers for the existing response
 	result, modified, err := revalidateCachedResponse(c.httpReq, c.httpClient, c.key, headers)
-	requestCache.Delete(c.key)
-	if err != nil || result == nil {
+	if c.stale == nil {
+		requestCache.Delete(c.key)
+	}
+	if err != nil {
+		if c.serveStale() {
+			return cachedRespData.formatToAST(c.forceJSONDecode, c.forceYAMLDecode)
+		}
 		return nil, err
 	}
+	if result == nil {
+		return nil, nil
+	}
 
 	defer result.Body.Close()
 
 	if !modified {
-		// update the headers in the cached response with their corresponding values from the 304 (Not Modified) response
-		for headerName, values := range result.Header {
-			cachedRespData.Headers.Del(headerName)
-			for _, v := range values {
-				cachedRespData.Headers.Add(headerName, v)
-			}
+		if err := c.updateNotModified(cachedRespData, result.Header); err != nil {
+			return nil, err
 		}
+		return cachedRespData.formatToAST(c.forceJSONDecode, c.forceYAMLDecode)
+	}
 
-		if forceCaching(c.forceCacheParams) {
-			createdAt := getCurrentTime(c.bctx)
-			cachedRespData.ExpiresAt = createdAt.Add(time.Second * time.Duration(c.forceCacheParams.forceCacheDurationSeconds))
-		} else {
-			expiresAt, err := expiryFromHeaders(result.Header)
-			if err != nil {
-				return nil, err
-			}
-			cachedRespData.ExpiresAt = expiresAt
+	newValue, respBody, err := formatHTTPResponseToAST(result, c.forceJSONDecode, c.forceYAMLDecode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.insertIntoInterQueryCache(result, respBody); err != nil {
+		return nil, err
+	}
+
+	return newValue, nil
+}
+
+// updateNotModified updates the cached response with the headers of a 304
+// (Not Modified) response and inserts it into the inter-query cache again.
+func (c *interQueryCache) updateNotModified(cachedRespData *interQueryCacheData, header http.Header) error {
+	// update the headers in the cached response with their corresponding values from the 304 (Not Modified) response
+	for headerName, values := range header {
+		cachedRespData.Headers.Del(headerName)
+		for _, v := range values {
+			cachedRespData.Headers.Add(headerName, v)
 		}
+	}
 
-		cachingMode, err := getCachingMode(c.key)
+	if forceCaching(c.forceCacheParams) {
+		createdAt := getCurrentTime(c.bctx)
+		cachedRespData.ExpiresAt = createdAt.Add(time.Second * time.Duration(c.forceCacheParams.forceCacheDurationSeconds))
+	} else {
+		expiresAt, err := expiryFromHeaders(header)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		cachedRespData.ExpiresAt = expiresAt
+	}
 
-		var pcv cache.InterQueryCacheValue
+	if err := cachedRespData.setStaleWindows(cachedRespData.Headers); err != nil {
+		return err
+	}
 
-		if cachingMode == defaultCachingMode {
-			pcv, err = cachedRespData.toCacheValue()
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			pcv = cachedRespData
-		}
+	return insertInterQueryCacheData(c.bctx, c.key, cachedRespData)
+}
 
-		c.bctx.InterQueryBuiltinCache.InsertWithExpiry(c.key, pcv, cachedRespData.ExpiresAt)
+// serveStale returns true if the stale response kept by checkHTTPSendInterQueryCache
+// can be served in place of a failed request.
+func (c *interQueryCache) serveStale() bool {
+	if c.stale == nil || c.bctx.Context.Err() != nil {
+		return false
+	}
+	c.servedStale = true
+	c.bctx.Metrics.Counter(httpSendInterQueryCacheStaleHits).Incr()
+	return true
+}
 
-		return cachedRespData.formatToAST(c.forceJSONDecode, c.forceYAMLDecode)
+// refreshInBackground fetches a fresh response for the cached response and
+// inserts it into the inter-query cache. The refresh is detached from the
+// query: it runs with its own context, clock and timeout, is neither cancelled
+// with the query nor subject to its limits, and does not record metrics on it.
+// At most one refresh per key is in progress at any time, per inter-query cache
+// if the cache keeps track of its refreshes.
+func (c *interQueryCache) refreshInBackground(cachedRespData *interQueryCacheData) {
+	if !startBackgroundRefresh(c.bctx.InterQueryBuiltinCache, c.key) {
+		return
 	}
 
-	newValue, respBody, err := formatHTTPResponseToAST(result, c.forceJSONDecode, c.forceYAMLDecode)
+	c.bctx.Metrics.Counter(httpSendInterQueryCacheRefreshes).Incr()
+
+	ctx, cancel := context.WithTimeout(context.Background(), backgroundRefreshTimeout(c.key))
+	bctx := BuiltinContext{
+		Context:                ctx,
+		Metrics:                metrics.New(),
+		Time:                   ast.NumberTerm(int64ToJSONNumber(time.Now().UnixNano())),
+		Cache:                  builtins.Cache{},
+		InterQueryBuiltinCache: c.bctx.InterQueryBuiltinCache,
+		Location:               c.bctx.Location,
+		DistributedTracingOpts: c.bctx.DistributedTracingOpts,
+		HTTPSendScheduler:      c.bctx.HTTPSendScheduler,
+		HTTPSendTransport:      c.bctx.HTTPSendTransport,
+		Capabilities:           c.bctx.Capabilities,
+	}
+
+	refresh := &interQueryCache{
+		bctx:                  bctx,
+		key:                   c.key,
+		forceCacheParams:      c.forceCacheParams,
+		negativeCacheDuration: c.negativeCacheDuration,
+	}
+
+	go func() {
+		defer cancel()
+		defer finishBackgroundRefresh(refresh.bctx.InterQueryBuiltinCache, refresh.key)
+		_ = refresh.refresh(cachedRespData)
+	}()
+}
+
+// startBackgroundRefresh marks the cached response of req as being refreshed.
+// It returns false if a refresh is already in progress.
+func startBackgroundRefresh(c cache.InterQueryCache, req ast.Object) bool {
+	if r, ok := c.(cache.InterQueryCacheRefresher); ok {
+		return r.StartRefresh(req)
+	}
+	_, loaded := httpSendBackgroundRefreshes.LoadOrStore(req.String(), struct{}{})
+	return !loaded
+}
+
+func finishBackgroundRefresh(c cache.InterQueryCache, req ast.Object) {
+	if r, ok := c.(cache.InterQueryCacheRefresher); ok {
+		r.FinishRefresh(req)
+		return
+	}
+	httpSendBackgroundRefreshes.Delete(req.String())
+}
+
+// backgroundRefreshTimeout returns the time a background refresh of the
+// request may take: the timeout of the request (or the default timeout) for
+// each attempt. If requests do not time out, refreshes are bounded by
+// defaultHTTPSendBackgroundRefreshTimeout.
+func backgroundRefreshTimeout(req ast.Object) time.Duration {
+	timeout := defaultHTTPRequestTimeout
+	if v := req.Get(ast.StringTerm("timeout")); v != nil {
+		if t, err := parseTimeout(v.Value); err == nil {
+			timeout = t
+		}
+	}
+	if timeout <= 0 {
+		return defaultHTTPSendBackgroundRefreshTimeout
+	}
+	retries, err := getNumberValFromReqObj(req, ast.StringTerm("max_retry_attempts"))
+	if err != nil || retries < 0 {
+		retries = 0
+	}
+	return timeout * time.Duration(retries+1)
+}
+
+func (c *interQueryCache) refresh(cachedRespData *interQueryCacheData) error {
+	req, client, err := createHTTPRequest(c.bctx, c.key)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if err := insertIntoHTTPSendInterQueryCache(c.bctx, c.key, result, respBody, c.forceCacheParams); err != nil {
-		return nil, err
+	resp, modified, err := revalidateCachedResponse(req, client, c.key, parseResponseHeaders(cachedRespData.Headers))
+	if err != nil {
+		return err
 	}
+	if resp == nil {
+		resp, err = scheduleHTTPRequest(c.bctx, req, client, c.key)
+		if err != nil {
+			return err
+		}
+		modified = true
+	}
+	defer util.Close(resp)
 
-	return newValue, nil
+	if !modified {
+		return c.updateNotModified(cachedRespData, resp.Header)
+	}
+
+	// Keep serving the stale response if the server is failing.
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return c.insertIntoInterQueryCache(resp, respBody)
+}
+
+// insertIntoInterQueryCache inserts the response into the inter-query cache.
+// Error responses are cached for the negative cache duration, if set.
+func (c *interQueryCache) insertIntoInterQueryCache(resp *http.Response, respBody []byte) error {
+	if c.negativeCacheDuration > 0 && resp.StatusCode >= http.StatusBadRequest {
+		data, err := newInterQueryCacheData(c.bctx, resp, respBody, &forceCacheParams{forceCacheDurationSeconds: c.negativeCacheDuration})
+		if err != nil {
+			return err
+		}
+		data.Negative = true
+		data.StaleWhileRevalidateUntil, data.StaleIfErrorUntil = time.Time{}, time.Time{}
+		return insertInterQueryCacheData(c.bctx, c.key, data)
+	}
+	return insertIntoHTTPSendInterQueryCache(c.bctx, c.key, resp, respBody, c.forceCacheParams)
 }
 
 // insertIntoHTTPSendInterQueryCache inserts given key and value in the inter-query cache
@@ -950,7 +1164,30 @@ func insertIntoHTTPSendInterQueryCache(bctx BuiltinContext, key ast.Value, resp
 		return err
 	}
 
-	requestCache.InsertWithExpiry(key, pcv, pcvData.ExpiresAt)
+	requestCache.InsertWithExpiry(key, pcv, pcvData.evictAt())
+	return nil
+}
+
+// insertInterQueryCacheData inserts the given cached response or error in the
+// inter-query cache using the caching mode of the request.
+func insertInterQueryCacheData(bctx BuiltinContext, key ast.Object, data *interQueryCacheData) error {
+	cachingMode, err := getCachingMode(key)
+	if err != nil {
+		return err
+	}
+
+	var pcv cache.InterQueryCacheValue
+
+	if cachingMode == defaultCachingMode {
+		pcv, err = data.toCacheValue()
+		if err != nil {
+			return err
+		}
+	} else {
+		pcv = data
+	}
+
+	bctx.InterQueryBuiltinCache.InsertWithExpiry(key, pcv, data.evictAt())
 	return nil
 }
 
@@ -1068,6 +1305,14 @@ func (cb interQueryCacheValue) SizeInBytes() int64 {
 	return int64(len(cb.Data))
 }
 
+func (interQueryCacheValue) InterQueryCacheValueType() string {
+	return httpSendCacheValueType
+}
+
+func (cb interQueryCacheValue) MarshalInterQueryCacheValue() ([]byte, error) {
+	return cb.Data, nil
+}
+
 func (cb *interQueryCacheValue) copyCacheData() (*interQueryCacheData, error) {
 	var res interQueryCacheData
 	err := util.UnmarshalJSON(cb.Data, &res)
@@ -1083,6 +1328,43 @@ type interQueryCacheData struct {
 	StatusCode int
 	Headers    http.Header
 	ExpiresAt  time.Time
+
+	// StaleWhileRevalidateUntil and StaleIfErrorUntil are set from the
+	// stale-while-revalidate and stale-if-error Cache-Control directives.
+	// See https://www.rfc-editor.org/rfc/rfc5861.
+	StaleWhileRevalidateUntil time.Time `json:",omitempty"`
+	StaleIfErrorUntil         time.Time `json:",omitempty"`
+
+	// Negative is set for error responses and errors cached for the
+	// negative cache duration of the request. Error is set for the latter.
+	Negative bool                  `json:",omitempty"`
+	Error    *interQueryCacheError `json:",omitempty"`
+}
+
+// interQueryCacheError represents a network error (e.g., a timeout) cached in
+// the inter-query cache.
+type interQueryCacheError struct {
+	Op      string
+	URL     string
+	Message string
+	Timeout bool
+}
+
+func (e *interQueryCacheError) toError() error {
+	return &url.Error{Op: e.Op, URL: e.URL, Err: &cachedNetworkError{message: e.Message, timeout: e.Timeout}}
+}
+
+type cachedNetworkError struct {
+	message string
+	timeout bool
+}
+
+func (e *cachedNetworkError) Error() string {
+	return e.message
+}
+
+func (e *cachedNetworkError) Timeout() bool {
+	return e.timeout
 }
 
 func forceCaching(cacheParams *forceCacheParams) bool {
@@ -1128,9 +1410,67 @@ func newInterQueryCacheData(bctx BuiltinContext, resp *http.Response, respBody [
 		StatusCode: resp.StatusCode,
 		Headers:    resp.Header}
 
+	if err := cv.setStaleWindows(resp.Header); err != nil {
+		return nil, err
+	}
+
 	return &cv, nil
 }
 
+// setStaleWindows sets the time until which the response may be served stale
+// according to the stale-while-revalidate and stale-if-error directives.
+func (c *interQueryCacheData) setStaleWindows(headers http.Header) error {
+	c.StaleWhileRevalidateUntil, c.StaleIfErrorUntil = time.Time{}, time.Time{}
+	if c.ExpiresAt.IsZero() {
+		return nil
+	}
+
+	cc := parseCacheControlHeader(headers)
+
+	swr, err := parseDeltaSecondsCacheDirective(cc, "stale-while-revalidate")
+	if err != nil {
+		return err
+	}
+	if swr != -1 {
+		c.StaleWhileRevalidateUntil = c.ExpiresAt.Add(time.Second * time.Duration(swr))
+	}
+
+	sie, err := parseDeltaSecondsCacheDirective(cc, "stale-if-error")
+	if err != nil {
+		return err
+	}
+	if sie != -1 {
+		c.StaleIfErrorUntil = c.ExpiresAt.Add(time.Second * time.Duration(sie))
+	}
+
+	return nil
+}
+
+// evictAt returns the time at which the cached response can no longer be
+// served, not even stale.
+func (c *interQueryCacheData) evictAt() time.Time {
+	t := c.ExpiresAt
+	for _, other := range []time.Time{c.StaleWhileRevalidateUntil, c.StaleIfErrorUntil} {
+		if other.After(t) {
+			t = other
+		}
+	}
+	return t
+}
+
+func (c *interQueryCacheData) negative() bool {
+	return c.Negative || c.Error != nil
+}
+
+func (c *interQueryCacheData) toHTTPResponse() *http.Response {
+	return &http.Response{
+		Status:     c.Status,
+		StatusCode: c.StatusCode,
+		Header:     c.Headers.Clone(),
+		Body:       io.NopCloser(bytes.NewReader(c.RespBody)),
+	}
+}
+
 func (c *interQueryCacheData) formatToAST(forceJSONDecode, forceYAMLDecode bool) (ast.Value, error) {
 	return prepareASTResult(c.Headers, forceJSONDecode, forceYAMLDecode, c.RespBody, c.Status, c.StatusCode)
 }
@@ -1147,16 +1487,34 @@ func (c *interQueryCacheData) SizeInBytes() int64 {
 	return 0
 }
 
+func (*interQueryCacheData) InterQueryCacheValueType() string {
+	return httpSendCacheDataType
+}
+
+func (c *interQueryCacheData) MarshalInterQueryCacheValue() ([]byte, error) {
+	return json.Marshal(c)
+}
+
 func (c *interQueryCacheData) Clone() (cache.InterQueryCacheValue, error) {
 	dup := make([]byte, len(c.RespBody))
 	copy(dup, c.RespBody)
 
+	var cerr *interQueryCacheError
+	if c.Error != nil {
+		cpy := *c.Error
+		cerr = &cpy
+	}
+
 	return &interQueryCacheData{
-		ExpiresAt:  c.ExpiresAt,
-		RespBody:   dup,
-		Status:     c.Status,
-		StatusCode: c.StatusCode,
-		Headers:    c.Headers.Clone()}, nil
+		ExpiresAt:                 c.ExpiresAt,
+		RespBody:                  dup,
+		Status:                    c.Status,
+		StatusCode:                c.StatusCode,
+		Headers:                   c.Headers.Clone(),
+		StaleWhileRevalidateUntil: c.StaleWhileRevalidateUntil,
+		StaleIfErrorUntil:         c.StaleIfErrorUntil,
+		Negative:                  c.Negative,
+		Error:                     cerr}, nil
 }
 
 type responseHeaders struct {
@@ -1296,7 +1654,12 @@ func getResponseHeaderExpires(headers http.Header) time.Time {
 // parseMaxAgeCacheDirective parses the max-age directive expressed in delta-seconds as per
 // https://tools.ietf.org/html/rfc7234#section-1.2.1
 func parseMaxAgeCacheDirective(cc map[string]string) (deltaSeconds, error) {
-	maxAge, ok := cc["max-age"]
+	return parseDeltaSecondsCacheDirective(cc, "max-age")
+}
+
+// parseDeltaSecondsCacheDirective parses the named directive expressed in delta-seconds.
+func parseDeltaSecondsCacheDirective(cc map[string]string, name string) (deltaSeconds, error) {
+	maxAge, ok := cc[name]
 	if !ok {
 		return deltaSeconds(-1), nil
 	}
@@ -1389,23 +1752,30 @@ func newHTTPRequestExecutor(bctx BuiltinContext, key ast.Object) (httpRequestExe
 	}
 
 	if useInterQueryCache && bctx.InterQueryBuiltinCache != nil {
-		return newInterQueryCache(bctx, key, forceCacheParams)
+		negativeCacheDuration, err := getNumberValFromReqObj(key, ast.StringTerm("negative_cache_duration_seconds"))
+		if err != nil {
+			return nil, handleHTTPSendErr(bctx, err)
+		}
+		return newInterQueryCache(bctx, key, forceCacheParams, int32(negativeCacheDuration))
 	}
 	return newIntraQueryCache(bctx, key)
 }
 
 type interQueryCache struct {
-	bctx             BuiltinContext
-	key              ast.Object
-	httpReq          *http.Request
-	httpClient       *http.Client
-	forceJSONDecode  bool
-	forceYAMLDecode  bool
-	forceCacheParams *forceCacheParams
+	bctx                  BuiltinContext
+	key                   ast.Object
+	httpReq               *http.Request
+	httpClient            *http.Client
+	forceJSONDecode       bool
+	forceYAMLDecode       bool
+	forceCacheParams      *forceCacheParams
+	negativeCacheDuration int32
+	stale                 *interQueryCacheData // stale response that may be served if the request fails
+	servedStale           bool
 }
 
-func newInterQueryCache(bctx BuiltinContext, key ast.Object, forceCacheParams *forceCacheParams) (*interQueryCache, error) {
-	return &interQueryCache{bctx: bctx, key: key, forceCacheParams: forceCacheParams}, nil
+func newInterQueryCache(bctx BuiltinContext, key ast.Object, forceCacheParams *forceCacheParams, negativeCacheDuration int32) (*interQueryCache, error) {
+	return &interQueryCache{bctx: bctx, key: key, forceCacheParams: forceCacheParams, negativeCacheDuration: negativeCacheDuration}, nil
 }
 
 // CheckCache checks the cache for the value of the key set on this object
@@ -1451,14 +1821,38 @@ func (c *interQueryCache) InsertIntoCache(value *http.Response) (ast.Value, erro
 	// Always insert into the intra-query cache, to maintain consistency within the same query.
 	insertIntoHTTPSendCache(c.bctx, c.key, result)
 
+	// A stale response is already in the inter-query cache.
+	if c.servedStale {
+		return result, nil
+	}
+
 	// We ignore errors when populating the inter-query cache, because we've already populated the intra-cache,
 	// and query consistency is our primary concern.
-	_ = insertIntoHTTPSendInterQueryCache(c.bctx, c.key, value, respBody, c.forceCacheParams)
+	_ = c.insertIntoInterQueryCache(value, respBody)
 	return result, nil
 }
 
 func (c *interQueryCache) InsertErrorIntoCache(err error) {
 	insertErrorIntoHTTPSendCache(c.bctx, c.key, err)
+
+	// Only network errors (e.g., timeouts) are cached across queries. The
+	// error is not cached if the query was cancelled.
+	urlErr, ok := err.(*url.Error)
+	if !ok || c.negativeCacheDuration <= 0 || c.bctx.Context.Err() != nil {
+		return
+	}
+
+	data := &interQueryCacheData{
+		ExpiresAt: getCurrentTime(c.bctx).Add(time.Second * time.Duration(c.negativeCacheDuration)),
+		Headers:   http.Header{},
+		Error: &interQueryCacheError{
+			Op:      urlErr.Op,
+			URL:     urlErr.URL,
+			Message: urlErr.Err.Error(),
+			Timeout: urlErr.Timeout(),
+		},
+	}
+	_ = insertInterQueryCacheData(c.bctx, c.key, data)
 }
 
 // ExecuteHTTPRequest executes a HTTP request
@@ -1469,7 +1863,19 @@ func (c *interQueryCache) ExecuteHTTPRequest() (*http.Response, error) {
 		return nil, handleHTTPSendErr(c.bctx, err)
 	}
 
-	return executeHTTPRequest(c.httpReq, c.httpClient, c.key)
+	resp, err := scheduleHTTPRequest(c.bctx, c.httpReq, c.httpClient, c.key)
+
+	// Serve the stale response instead of the error, if allowed.
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		if c.serveStale() {
+			if resp != nil {
+				util.Close(resp)
+			}
+			return c.stale.toHTTPResponse(), nil
+		}
+	}
+
+	return resp, err
 }
 
 type intraQueryCache struct {
@@ -1519,7 +1925,23 @@ func (c *intraQueryCache) ExecuteHTTPRequest() (*http.Response, error) {
 	if err != nil {
 		return nil, handleHTTPSendErr(c.bctx, err)
 	}
-	return executeHTTPRequest(httpReq, httpClient, c.key)
+	return scheduleHTTPRequest(c.bctx, httpReq, httpClient, c.key)
+}
+
+// scheduleHTTPRequest executes a HTTP request through the scheduler set on the
+// built-in context, if any. Cacheable requests may be coalesced regardless of
+// their method.
+func scheduleHTTPRequest(bctx BuiltinContext, req *http.Request, client *http.Client, key ast.Object) (*http.Response, error) {
+	if bctx.HTTPSendScheduler == nil {
+		return executeHTTPRequest(req, client, key)
+	}
+	cacheable, _, err := useInterQueryCache(key)
+	if err != nil {
+		return nil, handleHTTPSendErr(bctx, err)
+	}
+	return bctx.HTTPSendScheduler.do(bctx, key, req, client.Timeout, cacheable, func(req *http.Request) (*http.Response, error) {
+		return executeHTTPRequest(req, client, key)
+	})
 }
 
 func useInterQueryCache(req ast.Object) (bool, *forceCacheParams, error) {
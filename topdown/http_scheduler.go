@@ -0,0 +1,407 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/cache"
+)
+
+var httpSendCoalescedRequests = httpSendLatencyMetricKey + "_coalesced_requests"
+
+// HTTPSendBatchFunc sends reqs as a single bulk request and returns one
+// response per request, in the same order as reqs.
+type HTTPSendBatchFunc func(ctx context.Context, reqs []*http.Request) ([]*http.Response, error)
+
+// HTTPSendBatcher batches the requests issued by http.send to endpoints that
+// accept bulk lookups. Requests for which Match returns true are collected
+// for at most Window (or until MaxSize requests have been collected) and then
+// passed to Send. If Send does not return within Timeout (by default, the
+// http.send request timeout), the requests of the batch fail.
+type HTTPSendBatcher struct {
+	Match   func(req *http.Request) bool
+	Window  time.Duration
+	MaxSize int
+	Timeout time.Duration
+	Send    HTTPSendBatchFunc
+}
+
+// HTTPSendScheduler coordinates the outbound requests issued by http.send
+// across queries. Identical requests that are in-flight at the same time are
+// coalesced into a single outbound request, the number of concurrent requests
+// per host can be limited, and requests can be batched (see WithBatcher).
+//
+// Only requests that are safe to share are coalesced, i.e., GET and HEAD
+// requests and requests that opted into caching. HTTPSendScheduler is safe for
+// concurrent use and is meant to be shared by all queries.
+type HTTPSendScheduler struct {
+	mtx           sync.Mutex
+	coalesce      bool
+	maxConcurrent int64
+	flights       map[string]*httpSendFlight
+	hosts         map[string]chan struct{}
+	batchers      []*httpSendBatchQueue
+}
+
+type httpSendFlight struct {
+	done chan struct{}
+	resp *bufferedHTTPResponse
+	err  error
+}
+
+// NewHTTPSendScheduler returns a new HTTPSendScheduler configured with the
+// http_send section of the caching configuration. If config is nil, the
+// defaults apply.
+func NewHTTPSendScheduler(config *cache.Config) *HTTPSendScheduler {
+	s := &HTTPSendScheduler{
+		flights: map[string]*httpSendFlight{},
+		hosts:   map[string]chan struct{}{},
+	}
+	s.UpdateConfig(config)
+	return s
+}
+
+// WithBatcher adds a batcher to the scheduler. Requests are passed to the
+// first batcher that matches them.
+func (s *HTTPSendScheduler) WithBatcher(b HTTPSendBatcher) *HTTPSendScheduler {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.batchers = append(s.batchers, &httpSendBatchQueue{scheduler: s, batcher: b})
+	return s
+}
+
+// UpdateConfig updates the configuration of the scheduler. Requests that are
+// in-flight or waiting to be batched are not affected. The per-host limits are
+// only reset if the maximum number of concurrent requests changes.
+func (s *HTTPSendScheduler) UpdateConfig(config *cache.Config) {
+	if config == nil {
+		var err error
+		config, err = cache.ParseCachingConfig(nil)
+		if err != nil {
+			return
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.coalesce = config.HTTPSend.CoalesceRequests != nil && *config.HTTPSend.CoalesceRequests
+	var maxConcurrent int64
+	if config.HTTPSend.MaxConcurrentRequestsPerHost != nil {
+		maxConcurrent = *config.HTTPSend.MaxConcurrentRequestsPerHost
+	}
+	if maxConcurrent != s.maxConcurrent {
+		s.maxConcurrent = maxConcurrent
+		s.hosts = map[string]chan struct{}{}
+	}
+}
+
+// do executes req with exec unless an identical request (identified by key)
+// is already in-flight, in which case the response of that request is shared.
+// Callers waiting for the response of an in-flight request give up when their
+// context is cancelled or their timeout expires, whichever comes first.
+func (s *HTTPSendScheduler) do(bctx BuiltinContext, key ast.Object, req *http.Request, timeout time.Duration, cacheable bool, exec func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	s.mtx.Lock()
+	coalesce := s.coalesce && (cacheable || req.Method == http.MethodGet || req.Method == http.MethodHead)
+	s.mtx.Unlock()
+
+	if !coalesce {
+		return s.execute(req, exec)
+	}
+
+	k := key.String()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		s.mtx.Lock()
+		flight, ok := s.flights[k]
+		if !ok {
+			flight = &httpSendFlight{done: make(chan struct{})}
+			s.flights[k] = flight
+		}
+		s.mtx.Unlock()
+
+		if !ok {
+			resp, err := s.execute(req, exec)
+			if err == nil {
+				flight.resp, flight.err = newBufferedHTTPResponse(resp)
+			} else {
+				flight.err = err
+			}
+
+			s.mtx.Lock()
+			delete(s.flights, k)
+			s.mtx.Unlock()
+			close(flight.done)
+
+			if flight.err != nil {
+				return nil, flight.err
+			}
+			return flight.resp.response(), nil
+		}
+
+		select {
+		case <-flight.done:
+		case <-bctx.Context.Done():
+			return nil, bctx.Context.Err()
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-expired:
+			return nil, &url.Error{Op: req.Method, URL: req.URL.String(), Err: errHTTPSendFlightTimeout}
+		}
+
+		// If the request that was shared got cancelled by its own caller,
+		// issue the request again on behalf of this caller.
+		if isContextErr(flight.err) && bctx.Context.Err() == nil {
+			continue
+		}
+
+		bctx.Metrics.Counter(httpSendCoalescedRequests).Incr()
+
+		if flight.err != nil {
+			return nil, flight.err
+		}
+		return flight.resp.response(), nil
+	}
+}
+
+// execute sends req using a matching batcher or exec while respecting the
+// per-host concurrency limit.
+func (s *HTTPSendScheduler) execute(req *http.Request, exec func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	s.mtx.Lock()
+	var queue *httpSendBatchQueue
+	for _, b := range s.batchers {
+		if b.batcher.Match(req) {
+			queue = b
+			break
+		}
+	}
+	s.mtx.Unlock()
+
+	if queue != nil {
+		return queue.submit(req)
+	}
+
+	release, err := s.acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return exec(req)
+}
+
+// acquire blocks until a request can be sent to host. The returned function
+// must be called when the request has completed.
+func (s *HTTPSendScheduler) acquire(ctx context.Context, host string) (func(), error) {
+	s.mtx.Lock()
+	if s.maxConcurrent <= 0 {
+		s.mtx.Unlock()
+		return func() {}, nil
+	}
+	slots, ok := s.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, s.maxConcurrent)
+		s.hosts[host] = slots
+	}
+	s.mtx.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type httpSendBatchQueue struct {
+	scheduler *HTTPSendScheduler
+	batcher   HTTPSendBatcher
+	mtx       sync.Mutex
+	pending   []*httpSendBatchItem
+	timer     *time.Timer
+}
+
+type httpSendBatchItem struct {
+	req  *http.Request
+	done chan struct{}
+	resp *http.Response
+	err  error
+}
+
+func (q *httpSendBatchQueue) submit(req *http.Request) (*http.Response, error) {
+	item := &httpSendBatchItem{req: req, done: make(chan struct{})}
+
+	q.mtx.Lock()
+	q.pending = append(q.pending, item)
+	var items []*httpSendBatchItem
+	if q.batcher.MaxSize > 0 && len(q.pending) >= q.batcher.MaxSize {
+		items = q.take()
+	} else if len(q.pending) == 1 {
+		q.timer = time.AfterFunc(q.batcher.Window, func() {
+			q.mtx.Lock()
+			items := q.take()
+			q.mtx.Unlock()
+			q.flush(items)
+		})
+	}
+	q.mtx.Unlock()
+
+	if len(items) > 0 {
+		go q.flush(items)
+	}
+
+	select {
+	case <-item.done:
+		return item.resp, item.err
+	case <-req.Context().Done():
+		// The response is not going to be read, so close it once available.
+		go func() {
+			<-item.done
+			if item.resp != nil {
+				item.resp.Body.Close()
+			}
+		}()
+		return nil, req.Context().Err()
+	}
+}
+
+// take removes the pending items from the queue. The caller must hold the
+// lock.
+func (q *httpSendBatchQueue) take() []*httpSendBatchItem {
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	items := q.pending
+	q.pending = nil
+	return items
+}
+
+func (q *httpSendBatchQueue) flush(items []*httpSendBatchItem) {
+	if len(items) == 0 {
+		return
+	}
+
+	reqs := make([]*http.Request, len(items))
+	for i := range items {
+		reqs[i] = items[i].req
+	}
+
+	timeout := q.batcher.Timeout
+	if timeout <= 0 {
+		timeout = defaultHTTPRequestTimeout
+	}
+
+	// The batch is sent on behalf of several callers, so it is not bound to
+	// the context of any one of them.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	type result struct {
+		resps []*http.Response
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		release, err := q.scheduler.acquire(ctx, reqs[0].URL.Host)
+		if err == nil {
+			r.resps, r.err = q.batcher.Send(ctx, reqs)
+			release()
+		} else {
+			r.err = err
+		}
+		done <- r
+	}()
+
+	var resps []*http.Response
+	var err error
+	select {
+	case r := <-done:
+		resps, err = r.resps, r.err
+	case <-ctx.Done():
+		// Send may not respect the deadline, so the waiters are released
+		// without waiting for it. Late responses are discarded.
+		err = fmt.Errorf("http.send batch timed out after %v", timeout)
+		go func() {
+			for _, resp := range (<-done).resps {
+				resp.Body.Close()
+			}
+		}()
+	}
+	if err == nil && len(resps) != len(reqs) {
+		for _, resp := range resps {
+			resp.Body.Close()
+		}
+		err = fmt.Errorf("http.send batch returned %d responses for %d requests", len(resps), len(reqs))
+	}
+
+	for i, item := range items {
+		if err != nil {
+			item.err = err
+		} else {
+			item.resp = resps[i]
+		}
+		close(item.done)
+	}
+}
+
+// bufferedHTTPResponse holds a response whose body has been read so that it
+// can be handed to several callers.
+type bufferedHTTPResponse struct {
+	resp *http.Response
+	body []byte
+}
+
+func newBufferedHTTPResponse(resp *http.Response) (*bufferedHTTPResponse, error) {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &bufferedHTTPResponse{resp: resp, body: body}, nil
+}
+
+func (r *bufferedHTTPResponse) response() *http.Response {
+	cpy := *r.resp
+	cpy.Header = r.resp.Header.Clone()
+	cpy.Body = io.NopCloser(bytes.NewReader(r.body))
+	return &cpy
+}
+
+// errHTTPSendFlightTimeout is returned to callers whose timeout expired while
+// waiting for the response of an in-flight request.
+var errHTTPSendFlightTimeout error = &httpSendFlightTimeoutError{}
+
+type httpSendFlightTimeoutError struct{}
+
+func (*httpSendFlightTimeoutError) Error() string {
+	return "timeout exceeded while awaiting response of in-flight request"
+}
+
+func (*httpSendFlightTimeoutError) Timeout() bool {
+	return true
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,346 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/topdown/cache"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestHTTPSendSchedulerCoalescing(t *testing.T) {
+	tests := []struct {
+		note     string
+		config   string
+		request  string
+		expCalls int32
+	}{
+		{
+			note:     "get",
+			config:   `{"http_send": {"coalesce_requests": true}}`,
+			request:  `{"method": "get", "url": "%s/a"}`,
+			expCalls: 1,
+		},
+		{
+			note:     "post with cache",
+			config:   `{"http_send": {"coalesce_requests": true}}`,
+			request:  `{"method": "post", "url": "%s/a", "cache": true}`,
+			expCalls: 1,
+		},
+		{
+			note:     "post",
+			config:   `{"http_send": {"coalesce_requests": true}}`,
+			request:  `{"method": "post", "url": "%s/a"}`,
+			expCalls: 5,
+		},
+		{
+			note:     "disabled by default",
+			request:  `{"method": "get", "url": "%s/a"}`,
+			expCalls: 5,
+		},
+		{
+			note:     "disabled",
+			config:   `{"http_send": {"coalesce_requests": false}}`,
+			request:  `{"method": "get", "url": "%s/a"}`,
+			expCalls: 5,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			var calls int32
+			release := make(chan struct{})
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"path": %q}`, r.URL.Path)
+			}))
+			defer ts.Close()
+
+			config, err := cache.ParseCachingConfig([]byte(tc.config))
+			if err != nil {
+				t.Fatal(err)
+			}
+			scheduler := NewHTTPSendScheduler(config)
+			query := fmt.Sprintf(`http.send(`+tc.request+`, x)`, ts.URL)
+
+			results := runConcurrentHTTPSend(t, scheduler, query, 5, func() {
+				waitForCalls(t, &calls, tc.expCalls)
+				close(release)
+			})
+
+			for _, result := range results {
+				if exp := ast.MustParseTerm(`{"path": "/a"}`); !result.Get(ast.StringTerm("body")).Equal(exp) {
+					t.Fatalf("expected body %v but got: %v", exp, result)
+				}
+			}
+			if n := atomic.LoadInt32(&calls); n != tc.expCalls {
+				t.Fatalf("expected %d calls but got %d", tc.expCalls, n)
+			}
+		})
+	}
+}
+
+func TestHTTPSendSchedulerCoalescingFollowerTimeout(t *testing.T) {
+	config, err := cache.ParseCachingConfig([]byte(`{"http_send": {"coalesce_requests": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewHTTPSendScheduler(config)
+
+	// The leader of the in-flight request never completes.
+	key := ast.MustParseTerm(`{"method": "get", "url": "https://example.com"}`).Value.(ast.Object)
+	scheduler.flights[key.String()] = &httpSendFlight{done: make(chan struct{})}
+
+	exec := func(*http.Request) (*http.Response, error) {
+		t.Fatal("expected request to be coalesced")
+		return nil, nil
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "https://example.com", nil)
+		bctx := BuiltinContext{Context: context.Background(), Metrics: metrics.New()}
+
+		_, err := scheduler.do(bctx, key, req, 10*time.Millisecond, false, exec)
+		if urlErr, ok := err.(*url.Error); !ok || !urlErr.Timeout() {
+			t.Fatalf("expected timeout error but got: %v", err)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com", nil)
+		bctx := BuiltinContext{Context: ctx, Metrics: metrics.New()}
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		_, err := scheduler.do(bctx, key, req, 0, false, exec)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected cancellation but got: %v", err)
+		}
+	})
+}
+
+func TestHTTPSendSchedulerMaxConcurrentRequestsPerHost(t *testing.T) {
+	var inflight, maxInflight int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			m := atomic.LoadInt32(&maxInflight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInflight, m, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+		fmt.Fprint(w, `{}`)
+	}))
+	defer ts.Close()
+
+	config, err := cache.ParseCachingConfig([]byte(`{"http_send": {"max_concurrent_requests_per_host": 2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewHTTPSendScheduler(config)
+	query := fmt.Sprintf(`http.send({"method": "post", "url": "%s"}, x)`, ts.URL)
+
+	runConcurrentHTTPSend(t, scheduler, query, 8, nil)
+
+	if n := atomic.LoadInt32(&maxInflight); n != 2 {
+		t.Fatalf("expected at most 2 concurrent requests but got %d", n)
+	}
+}
+
+func TestHTTPSendSchedulerBatcher(t *testing.T) {
+	var batches [][]string
+	var mtx sync.Mutex
+
+	scheduler := NewHTTPSendScheduler(nil).WithBatcher(HTTPSendBatcher{
+		Match: func(req *http.Request) bool {
+			return req.URL.Host == "users.example.com"
+		},
+		Window:  time.Hour,
+		MaxSize: 3,
+		Send: func(_ context.Context, reqs []*http.Request) ([]*http.Response, error) {
+			var ids []string
+			var resps []*http.Response
+			for _, req := range reqs {
+				id := req.URL.Query().Get("id")
+				ids = append(ids, id)
+				resps = append(resps, &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Content-Type": []string{"application/json"}},
+					Body:       io.NopCloser(bytes.NewBufferString(fmt.Sprintf(`{"id": %q}`, id))),
+				})
+			}
+			mtx.Lock()
+			batches = append(batches, ids)
+			mtx.Unlock()
+			return resps, nil
+		},
+	})
+
+	var wg sync.WaitGroup
+	results := make([]ast.Object, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := fmt.Sprintf(`http.send({"method": "get", "url": "https://users.example.com/?id=%d"}, x)`, i)
+			results[i] = evalHTTPSend(t, scheduler, query)
+		}(i)
+	}
+	wg.Wait()
+
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expected a single batch of 3 requests but got: %v", batches)
+	}
+	for i, result := range results {
+		if exp := ast.MustParseTerm(fmt.Sprintf(`{"id": "%d"}`, i)); !result.Get(ast.StringTerm("body")).Equal(exp) {
+			t.Fatalf("expected body %v but got: %v", exp, result)
+		}
+	}
+}
+
+func TestHTTPSendSchedulerBatcherWindow(t *testing.T) {
+	scheduler := NewHTTPSendScheduler(nil).WithBatcher(HTTPSendBatcher{
+		Match:   func(*http.Request) bool { return true },
+		Window:  10 * time.Millisecond,
+		MaxSize: 100,
+		Send: func(_ context.Context, reqs []*http.Request) ([]*http.Response, error) {
+			return nil, nil
+		},
+	})
+
+	_, err := NewQuery(ast.MustParseBody(`http.send({"method": "get", "url": "https://example.com", "raise_error": true}, x)`)).
+		WithHTTPSendScheduler(scheduler).
+		WithStrictBuiltinErrors(true).
+		Run(context.Background())
+	if err == nil || !bytes.Contains([]byte(err.Error()), []byte("http.send batch returned 0 responses for 1 requests")) {
+		t.Fatalf("expected batch error but got: %v", err)
+	}
+}
+
+func TestHTTPSendSchedulerBatcherTimeout(t *testing.T) {
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	scheduler := NewHTTPSendScheduler(nil).WithBatcher(HTTPSendBatcher{
+		Match:   func(*http.Request) bool { return true },
+		MaxSize: 1,
+		Timeout: 10 * time.Millisecond,
+		Send: func(_ context.Context, _ []*http.Request) ([]*http.Response, error) {
+			// Ignores the deadline of the context.
+			<-stuck
+			return nil, nil
+		},
+	})
+
+	_, err := NewQuery(ast.MustParseBody(`http.send({"method": "get", "url": "https://example.com", "raise_error": true}, x)`)).
+		WithHTTPSendScheduler(scheduler).
+		WithStrictBuiltinErrors(true).
+		Run(context.Background())
+	if err == nil || !bytes.Contains([]byte(err.Error()), []byte("http.send batch timed out after 10ms")) {
+		t.Fatalf("expected batch timeout but got: %v", err)
+	}
+}
+
+func TestHTTPSendSchedulerUpdateConfig(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	scheduler := NewHTTPSendScheduler(nil).WithBatcher(HTTPSendBatcher{
+		Match:   func(*http.Request) bool { return true },
+		MaxSize: 1,
+		Send: func(_ context.Context, reqs []*http.Request) ([]*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return []*http.Response{{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": []string{"application/json"}},
+				Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+			}}, nil
+		},
+	})
+
+	config, err := cache.ParseCachingConfig([]byte(`{"http_send": {"max_concurrent_requests_per_host": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler.UpdateConfig(config)
+	hosts := scheduler.hosts
+
+	results := runConcurrentHTTPSend(t, scheduler, `http.send({"method": "get", "url": "https://example.com"}, x)`, 1, func() {
+		waitForCalls(t, &calls, 1)
+		// Updating the configuration keeps the batch that is in-flight and
+		// the per-host limits as they are unchanged.
+		scheduler.UpdateConfig(config)
+		close(release)
+	})
+
+	if exp := ast.MustParseTerm(`{}`); !results[0].Get(ast.StringTerm("body")).Equal(exp) {
+		t.Fatalf("expected body %v but got: %v", exp, results[0])
+	}
+	if slots := scheduler.hosts["example.com"]; slots == nil || slots != hosts["example.com"] {
+		t.Fatalf("expected per-host limits to be kept but got: %v", scheduler.hosts)
+	}
+}
+
+func runConcurrentHTTPSend(t *testing.T, scheduler *HTTPSendScheduler, query string, n int, started func()) []ast.Object {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	results := make([]ast.Object, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = evalHTTPSend(t, scheduler, query)
+		}(i)
+	}
+	if started != nil {
+		started()
+	}
+	wg.Wait()
+	return results
+}
+
+func evalHTTPSend(t *testing.T, scheduler *HTTPSendScheduler, query string) ast.Object {
+	qrs, err := NewQuery(ast.MustParseBody(query)).
+		WithHTTPSendScheduler(scheduler).
+		WithStrictBuiltinErrors(true).
+		Run(context.Background())
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	if len(qrs) != 1 {
+		t.Errorf("expected one result but got: %v", qrs)
+		return nil
+	}
+	return qrs[0][ast.Var("x")].Value.(ast.Object)
+}
+
+func waitForCalls(t *testing.T, calls *int32, n int32) {
+	t.Helper()
+	if err := util.WaitFunc(func() bool { return atomic.LoadInt32(calls) >= n }, 5*time.Millisecond, 5*time.Second); err != nil {
+		t.Fatalf("expected %d calls but got %d", n, atomic.LoadInt32(calls))
+	}
+	// Give requests that are going to be coalesced a chance to join.
+	time.Sleep(50 * time.Millisecond)
+}
@@ -23,6 +23,8 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -3564,3 +3566,489 @@ func TestHTTPGetRequestAllowNet(t *testing.T) {
 		runTopDownTestCase(t, data, tc.note, append(tc.rules, httpSendHelperRules...), tc.expected, tc.options)
 	}
 }
+
+func TestHTTPSendInterQueryCachingStale(t *testing.T) {
+	tests := []struct {
+		note        string
+		query       string
+		cacheCtrl   string
+		failAfter   int // number of successful responses before the server fails
+		offset      time.Duration
+		expBody     string
+		expRequests int
+		expMetric   string
+	}{
+		{
+			note:        "stale-while-revalidate",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true}, x)`,
+			cacheCtrl:   "max-age=1, stale-while-revalidate=3600",
+			offset:      2 * time.Second,
+			expBody:     "response 1", // the stale response is served while refreshing
+			expRequests: 2,
+			expMetric:   httpSendInterQueryCacheStaleHits,
+		},
+		{
+			note:        "stale-while-revalidate window passed",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true}, x)`,
+			cacheCtrl:   "max-age=1, stale-while-revalidate=1",
+			offset:      3 * time.Second,
+			expBody:     "response 2",
+			expRequests: 2,
+		},
+		{
+			note:        "stale-if-error",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true}, x)`,
+			cacheCtrl:   "max-age=1, stale-if-error=3600",
+			failAfter:   1,
+			offset:      2 * time.Second,
+			expBody:     "response 1",
+			expRequests: 2,
+			expMetric:   httpSendInterQueryCacheStaleHits,
+		},
+		{
+			note:        "stale-if-error window passed",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true, "raise_error": false}, x)`,
+			cacheCtrl:   "max-age=1, stale-if-error=1",
+			failAfter:   1,
+			offset:      3 * time.Second,
+			expBody:     "failure",
+			expRequests: 2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			t0 := time.Now().UTC()
+
+			var mtx sync.Mutex
+			var count int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				mtx.Lock()
+				count++
+				n := count
+				mtx.Unlock()
+
+				w.Header().Set("Cache-Control", tc.cacheCtrl)
+				w.Header().Set("Date", t0.Format(http.TimeFormat))
+				if tc.failAfter > 0 && n > tc.failAfter {
+					w.WriteHeader(http.StatusInternalServerError)
+					_, _ = w.Write([]byte("failure"))
+					return
+				}
+				_, _ = w.Write([]byte(fmt.Sprintf("response %d", n)))
+			}))
+			defer ts.Close()
+
+			interQueryCache := iCache.NewInterQueryCache(nil)
+			query := ast.MustParseBody(strings.ReplaceAll(tc.query, "%URL%", ts.URL))
+
+			run := func(now time.Time, m metrics.Metrics) string {
+				res, err := NewQuery(query).
+					WithInterQueryBuiltinCache(interQueryCache).
+					WithMetrics(m).
+					WithStrictBuiltinErrors(true).
+					WithTime(now).
+					Run(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(res[0]["x"].Value.(ast.Object).Get(ast.StringTerm("raw_body")).Value.(ast.String))
+			}
+
+			if body := run(t0, metrics.New()); body != "response 1" {
+				t.Fatalf("expected first response but got: %v", body)
+			}
+
+			m := metrics.New()
+			if body := run(t0.Add(tc.offset), m); body != tc.expBody {
+				t.Fatalf("expected %q but got: %q", tc.expBody, body)
+			}
+
+			if err := util.WaitFunc(func() bool {
+				mtx.Lock()
+				defer mtx.Unlock()
+				return count >= tc.expRequests
+			}, 5*time.Millisecond, 5*time.Second); err != nil {
+				t.Fatalf("expected %d requests", tc.expRequests)
+			}
+
+			if tc.expMetric != "" && m.Counter(tc.expMetric).Value().(uint64) != 1 {
+				t.Fatalf("expected %v to be incremented but got: %v", tc.expMetric, m.All())
+			}
+		})
+	}
+}
+
+func TestHTTPSendInterQueryCachingStaleWhileRevalidateRefresh(t *testing.T) {
+	t0 := time.Now().UTC()
+
+	var count int32
+	refreshed := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=3600")
+		w.Header().Set("Date", t0.Add(time.Duration(n-1)*2*time.Second).Format(http.TimeFormat))
+		_, _ = w.Write([]byte(fmt.Sprintf("response %d", n)))
+		if n == 2 {
+			close(refreshed)
+		}
+	}))
+	defer ts.Close()
+
+	interQueryCache := iCache.NewInterQueryCache(nil)
+	query := ast.MustParseBody(fmt.Sprintf(`http.send({"method": "get", "url": "%s", "cache": true}, x)`, ts.URL))
+
+	run := func(now time.Time) string {
+		res, err := NewQuery(query).
+			WithInterQueryBuiltinCache(interQueryCache).
+			WithTime(now).
+			Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(res[0]["x"].Value.(ast.Object).Get(ast.StringTerm("raw_body")).Value.(ast.String))
+	}
+
+	run(t0)
+	if body := run(t0.Add(2 * time.Second)); body != "response 1" {
+		t.Fatalf("expected stale response but got: %v", body)
+	}
+
+	<-refreshed
+	if err := util.WaitFunc(func() bool {
+		return !refreshing(interQueryCache, ast.NewObject(
+			[2]*ast.Term{ast.StringTerm("method"), ast.StringTerm("get")},
+			[2]*ast.Term{ast.StringTerm("url"), ast.StringTerm(ts.URL)},
+			[2]*ast.Term{ast.StringTerm("cache"), ast.BooleanTerm(true)},
+		))
+	}, 5*time.Millisecond, 5*time.Second); err != nil {
+		t.Fatal("expected background refresh to finish")
+	}
+
+	if body := run(t0.Add(2 * time.Second)); body != "response 2" {
+		t.Fatalf("expected refreshed response but got: %v", body)
+	}
+	if n := atomic.LoadInt32(&count); n != 2 {
+		t.Fatalf("expected 2 requests but got %d", n)
+	}
+}
+
+func TestHTTPSendInterQueryCachingStaleWhileRevalidateRefreshPerCache(t *testing.T) {
+	t0 := time.Now().UTC()
+
+	var count int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		// The responses of the background refreshes are held back until
+		// both refreshes have been issued.
+		if n := atomic.AddInt32(&count, 1); n > 2 {
+			<-release
+		}
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=3600")
+		w.Header().Set("Date", t0.Format(http.TimeFormat))
+		_, _ = w.Write([]byte("response"))
+	}))
+	defer ts.Close()
+
+	query := ast.MustParseBody(fmt.Sprintf(`http.send({"method": "get", "url": "%s", "cache": true}, x)`, ts.URL))
+
+	run := func(c iCache.InterQueryCache, now time.Time) {
+		// The refresh must outlive the query's context.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if _, err := NewQuery(query).
+			WithInterQueryBuiltinCache(c).
+			WithTime(now).
+			Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Independent caches refresh the same request independently.
+	caches := []iCache.InterQueryCache{iCache.NewInterQueryCache(nil), iCache.NewInterQueryCache(nil)}
+	for _, c := range caches {
+		run(c, t0)
+	}
+	for _, c := range caches {
+		run(c, t0.Add(2*time.Second))
+	}
+
+	if err := util.WaitFunc(func() bool {
+		return atomic.LoadInt32(&count) == 4
+	}, 5*time.Millisecond, 5*time.Second); err != nil {
+		t.Fatalf("expected a background refresh per cache but got %d requests", atomic.LoadInt32(&count))
+	}
+	close(release)
+
+	req := ast.NewObject(
+		[2]*ast.Term{ast.StringTerm("method"), ast.StringTerm("get")},
+		[2]*ast.Term{ast.StringTerm("url"), ast.StringTerm(ts.URL)},
+		[2]*ast.Term{ast.StringTerm("cache"), ast.BooleanTerm(true)},
+	)
+	if err := util.WaitFunc(func() bool {
+		return !refreshing(caches[0], req) && !refreshing(caches[1], req)
+	}, 5*time.Millisecond, 5*time.Second); err != nil {
+		t.Fatal("expected background refreshes to finish")
+	}
+}
+
+// valueInterQueryCache is an inter-query cache implemented by a value type that
+// is not comparable and does not keep track of refreshes.
+type valueInterQueryCache struct {
+	items map[string]iCache.InterQueryCacheValue
+	mtx   *sync.Mutex
+}
+
+func (c valueInterQueryCache) Get(k ast.Value) (iCache.InterQueryCacheValue, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	v, ok := c.items[k.String()]
+	return v, ok
+}
+
+func (c valueInterQueryCache) Insert(k ast.Value, v iCache.InterQueryCacheValue) int {
+	return c.InsertWithExpiry(k, v, time.Time{})
+}
+
+func (c valueInterQueryCache) InsertWithExpiry(k ast.Value, v iCache.InterQueryCacheValue, _ time.Time) int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.items[k.String()] = v
+	return 0
+}
+
+func (c valueInterQueryCache) Delete(k ast.Value) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	delete(c.items, k.String())
+}
+
+func (valueInterQueryCache) UpdateConfig(*iCache.Config) {}
+
+func (valueInterQueryCache) Clone(v iCache.InterQueryCacheValue) (iCache.InterQueryCacheValue, error) {
+	return v.Clone()
+}
+
+func TestHTTPSendInterQueryCachingStaleWhileRevalidateCustomCache(t *testing.T) {
+	t0 := time.Now().UTC()
+
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		w.Header().Set("Cache-Control", "max-age=1, stale-while-revalidate=3600")
+		w.Header().Set("Date", t0.Add(time.Duration(n)*time.Second).Format(http.TimeFormat))
+		_, _ = w.Write([]byte(fmt.Sprintf("response %d", n)))
+	}))
+	defer ts.Close()
+
+	query := ast.MustParseBody(fmt.Sprintf(`http.send({"method": "get", "url": "%s", "cache": true}, x)`, ts.URL))
+	c := valueInterQueryCache{items: map[string]iCache.InterQueryCacheValue{}, mtx: &sync.Mutex{}}
+
+	for _, now := range []time.Time{t0, t0.Add(3 * time.Second)} {
+		if _, err := NewQuery(query).
+			WithInterQueryBuiltinCache(c).
+			WithTime(now).
+			Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := util.WaitFunc(func() bool {
+		return atomic.LoadInt32(&count) == 2
+	}, 5*time.Millisecond, 5*time.Second); err != nil {
+		t.Fatalf("expected background refresh but got %d requests", atomic.LoadInt32(&count))
+	}
+}
+
+// refreshing returns true if the cached response of req is being refreshed.
+func refreshing(c iCache.InterQueryCache, req ast.Object) bool {
+	if !startBackgroundRefresh(c, req) {
+		return true
+	}
+	finishBackgroundRefresh(c, req)
+	return false
+}
+
+func TestHTTPSendInterQueryNegativeCaching(t *testing.T) {
+	tests := []struct {
+		note        string
+		query       string
+		status      int
+		delay       time.Duration
+		expRequests int32
+		expCode     string
+	}{
+		{
+			note:        "server error",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true, "negative_cache_duration_seconds": 60}, x)`,
+			status:      http.StatusInternalServerError,
+			expRequests: 1,
+		},
+		{
+			note:        "client error",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true, "negative_cache_duration_seconds": 60}, x)`,
+			status:      http.StatusForbidden,
+			expRequests: 1,
+		},
+		{
+			note:        "disabled",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true}, x)`,
+			status:      http.StatusInternalServerError,
+			expRequests: 3,
+		},
+		{
+			note:        "timeout",
+			query:       `http.send({"method": "get", "url": "%URL%", "cache": true, "negative_cache_duration_seconds": 60, "timeout": "10ms", "raise_error": false}, x)`,
+			status:      http.StatusOK,
+			delay:       200 * time.Millisecond,
+			expRequests: 1,
+			expCode:     HTTPSendNetworkErr,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			var count int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				atomic.AddInt32(&count, 1)
+				time.Sleep(tc.delay)
+				w.WriteHeader(tc.status)
+			}))
+			defer ts.Close()
+
+			interQueryCache := iCache.NewInterQueryCache(nil)
+			query := ast.MustParseBody(strings.ReplaceAll(tc.query, "%URL%", ts.URL))
+			m := metrics.New()
+
+			for i := 0; i < 3; i++ {
+				res, err := NewQuery(query).
+					WithInterQueryBuiltinCache(interQueryCache).
+					WithMetrics(m).
+					WithStrictBuiltinErrors(true).
+					Run(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp := res[0]["x"].Value.(ast.Object)
+				if tc.expCode != "" {
+					code := resp.Get(ast.StringTerm("error")).Value.(ast.Object).Get(ast.StringTerm("code"))
+					if !code.Equal(ast.StringTerm(tc.expCode)) {
+						t.Fatalf("expected error code %v but got: %v", tc.expCode, resp)
+					}
+				} else if !resp.Get(ast.StringTerm("status_code")).Equal(ast.IntNumberTerm(tc.status)) {
+					t.Fatalf("expected status code %d but got: %v", tc.status, resp)
+				}
+			}
+
+			if n := atomic.LoadInt32(&count); n != tc.expRequests {
+				t.Fatalf("expected %d requests but got %d", tc.expRequests, n)
+			}
+			if exp := uint64(3 - tc.expRequests); m.Counter(httpSendInterQueryCacheNegativeHits).Value().(uint64) != exp {
+				t.Fatalf("expected %d negative cache hits but got: %v", exp, m.All())
+			}
+		})
+	}
+}
+
+type mapDistributedBackend struct {
+	mtx  sync.Mutex
+	data map[string][]byte
+}
+
+func (b *mapDistributedBackend) Get(_ context.Context, key string) ([]byte, bool, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	v, ok := b.data[key]
+	return v, ok, nil
+}
+
+func (b *mapDistributedBackend) Set(_ context.Context, key string, value []byte, _ time.Duration) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.data[key] = value
+	return nil
+}
+
+func (b *mapDistributedBackend) Delete(_ context.Context, key string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	delete(b.data, key)
+	return nil
+}
+
+func (*mapDistributedBackend) Close() error {
+	return nil
+}
+
+func TestHTTPSendDistributedInterQueryCache(t *testing.T) {
+	for _, mode := range []string{"serialized", "deserialized"} {
+		t.Run(mode, func(t *testing.T) {
+			var count int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				atomic.AddInt32(&count, 1)
+				w.Header().Set("Cache-Control", "max-age=3600")
+				w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"x": 1}`))
+			}))
+			defer ts.Close()
+
+			backend := &mapDistributedBackend{data: map[string][]byte{}}
+			query := ast.MustParseBody(fmt.Sprintf(`http.send({"method": "get", "url": %q, "cache": true, "caching_mode": %q}, x)`, ts.URL, mode))
+
+			// Each replica has its own local cache, but they share the backend.
+			for i := 0; i < 3; i++ {
+				res, err := NewQuery(query).
+					WithInterQueryBuiltinCache(iCache.NewDistributedInterQueryCache(nil, backend)).
+					WithStrictBuiltinErrors(true).
+					Run(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body := res[0]["x"].Value.(ast.Object).Get(ast.StringTerm("body")); !body.Equal(ast.MustParseTerm(`{"x": 1}`)) {
+					t.Fatalf("unexpected body: %v", body)
+				}
+			}
+
+			if n := atomic.LoadInt32(&count); n != 1 {
+				t.Fatalf("expected 1 request but got %d", n)
+			}
+		})
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHTTPSendTransport(t *testing.T) {
+	var requests []string
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		requests = append(requests, req.Method+" "+req.URL.String())
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"user": "alice"}`)),
+			Request:    req,
+		}, nil
+	})
+
+	// The host does not resolve, so the request can only be answered by the
+	// transport.
+	query := ast.MustParseBody(`http.send({"method": "get", "url": "https://users.example.invalid/alice"}, resp); x = resp.body.user`)
+	qrs, err := NewQuery(query).
+		WithHTTPSendTransport(transport).
+		Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(ast.StringTerm("alice")) {
+		t.Fatalf("unexpected results: %v", qrs)
+	}
+	if exp := []string{"GET https://users.example.invalid/alice"}; !reflect.DeepEqual(requests, exp) {
+		t.Fatalf("expected requests %v but got %v", exp, requests)
+	}
+}
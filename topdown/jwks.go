@@ -0,0 +1,162 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/internal/jwx/jwk"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+// jwksOptions holds the options of io.jwt.verify_with_jwks that are not
+// token constraints.
+type jwksOptions struct {
+	// The URL of the JWKS to verify with.
+	jwksURL string
+
+	// The issuer whose OpenID Connect discovery document points to the JWKS.
+	issuer string
+}
+
+// Implements JWT verification with keys fetched from a JWKS URL.
+func builtinJWTVerifyWithJWKS(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	// io.jwt.verify_with_jwks(string, options, [valid, header, payload])
+	//
+	// The options are the constraints accepted by io.jwt.decode_verify, except
+	// for cert and secret, plus jwks_url, issuer and leeway.
+	a := operands[0].Value
+
+	b, err := builtins.ObjectOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	opts, constraints, err := parseJWKSOptions(b, bctx.Time)
+	if err != nil {
+		return err
+	}
+
+	jwksURL := opts.jwksURL
+	if jwksURL == "" {
+		if jwksURL, err = discoverJWKSURL(bctx, opts.issuer); err != nil {
+			return err
+		}
+	}
+
+	if constraints.keys, err = fetchJWKS(bctx, jwksURL); err != nil {
+		return err
+	}
+	if err := constraints.validate(); err != nil {
+		return err
+	}
+
+	result, err := decodeVerifyJWT(a, constraints)
+	if err != nil {
+		return err
+	}
+	return iter(result)
+}
+
+// parseJWKSOptions parses the options argument of io.jwt.verify_with_jwks.
+func parseJWKSOptions(o ast.Object, wallclock *ast.Term) (*jwksOptions, *tokenConstraints, error) {
+	var opts jwksOptions
+	var leeway float64
+	rest := ast.NewObject()
+
+	if err := o.Iter(func(k *ast.Term, v *ast.Term) error {
+		name := string(k.Value.(ast.String))
+		switch name {
+		case "jwks_url":
+			return tokenConstraintString(name, v.Value, &opts.jwksURL)
+		case "issuer":
+			return tokenConstraintString(name, v.Value, &opts.issuer)
+		case "leeway":
+			n, ok := v.Value.(ast.Number)
+			if !ok {
+				return fmt.Errorf("leeway option: must be a number")
+			}
+			f, ok := n.Float64()
+			if !ok || f < 0 {
+				return fmt.Errorf("leeway option: must be a non-negative number of seconds")
+			}
+			leeway = f
+			return nil
+		case "cert", "secret":
+			return fmt.Errorf("%s constraint: not supported, keys are fetched from the JWKS", name)
+		}
+		rest.Insert(k, v)
+		return nil
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.jwksURL == "" && opts.issuer == "" {
+		return nil, nil, fmt.Errorf("one of jwks_url and issuer must be set")
+	}
+
+	constraints, err := parseTokenConstraints(rest, wallclock)
+	if err != nil {
+		return nil, nil, err
+	}
+	if constraints.iss == "" {
+		constraints.iss = opts.issuer
+	}
+	constraints.leeway = leeway
+
+	return &opts, constraints, nil
+}
+
+// discoverJWKSURL returns the jwks_uri from the OpenID Connect discovery
+// document of the issuer.
+func discoverJWKSURL(bctx BuiltinContext, issuer string) (string, error) {
+	metadata, err := getOIDCDiscovery(bctx, issuer)
+	if err != nil {
+		return "", err
+	}
+	jwksURI := objectStringField(metadata, oidcJWKSURIKey)
+	if jwksURI == "" {
+		return "", fmt.Errorf("OpenID Connect discovery document of %v has no jwks_uri", issuer)
+	}
+	return jwksURI, nil
+}
+
+// fetchJWKS returns the signature verification keys in the JWKS found at url.
+// Keys intended for encryption are ignored. If the JWKS holds no signature
+// verification keys, nil is returned.
+func fetchJWKS(bctx BuiltinContext, url string) ([]verificationKey, error) {
+	body, err := sendCachedHTTPRequest(bctx, cachedGetRequest(url))
+	if err != nil {
+		return nil, err
+	}
+
+	jwks, err := jwk.ParseString(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	var keys []verificationKey
+	for _, k := range jwks.Keys {
+		if k.GetKeyUsage() == "enc" {
+			continue
+		}
+		key, err := k.Materialize()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, verificationKey{
+			alg: k.GetAlgorithm().String(),
+			kid: k.GetKeyID(),
+			key: key,
+		})
+	}
+
+	return keys, nil
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.JWTVerifyWithJWKS.Name, builtinJWTVerifyWithJWKS)
+}
@@ -0,0 +1,235 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/cache"
+)
+
+// Key from https://tools.ietf.org/html/rfc7515#appendix-A.3
+const jwksTestPrivateKey = `{
+	"kty": "EC",
+	"crv": "P-256",
+	"x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU",
+	"y": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0",
+	"d": "jpsQnnGQmL-YBIffH1136cspYG6-0iY7X1fCE9-E9LI"
+}`
+
+const jwksTestKeys = `{"keys": [
+	{
+		"kty": "RSA",
+		"use": "enc",
+		"kid": "enc",
+		"e": "AQAB",
+		"n": "sGu-fYVE2nq2dPxJlqAMI0Z8G3FD0XcWDnD8mkfO1ddKRGuUQZmfj4gWeZGyIk3cnuoy7KJCEqa3daXc08QHuFZyfn0rH33t8_AFsvb0q0i7R2FK-Gdqs_E0-sGpYMsRJdZWfCioLkYjIHEuVnRbi3DEsWqe484rEGbKF60jNRgGC4b-8pz-E538ZkssWxcqHrYIj5bjGEU36onjS3M_yrTuNvzv_8wRioK4fbcwmGne9bDxu8LcoSReWpPn0CnUkWnfqroRcMJnC87ZuJagDW1ZWCmU3psdsVanmFFh0DP6z0fsA4h8G2n9-qp-LEKFaWwo3IWlOsIzU3MHdcEiGw"
+	},
+	{
+		"kty": "EC",
+		"use": "sig",
+		"kid": "k1",
+		"alg": "ES256",
+		"crv": "P-256",
+		"x": "f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU",
+		"y": "x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"
+	}
+]}`
+
+// jwksTestEncryptionKeys holds no signature verification keys.
+const jwksTestEncryptionKeys = `{"keys": [
+	{
+		"kty": "RSA",
+		"use": "enc",
+		"kid": "enc",
+		"e": "AQAB",
+		"n": "sGu-fYVE2nq2dPxJlqAMI0Z8G3FD0XcWDnD8mkfO1ddKRGuUQZmfj4gWeZGyIk3cnuoy7KJCEqa3daXc08QHuFZyfn0rH33t8_AFsvb0q0i7R2FK-Gdqs_E0-sGpYMsRJdZWfCioLkYjIHEuVnRbi3DEsWqe484rEGbKF60jNRgGC4b-8pz-E538ZkssWxcqHrYIj5bjGEU36onjS3M_yrTuNvzv_8wRioK4fbcwmGne9bDxu8LcoSReWpPn0CnUkWnfqroRcMJnC87ZuJagDW1ZWCmU3psdsVanmFFh0DP6z0fsA4h8G2n9-qp-LEKFaWwo3IWlOsIzU3MHdcEiGw"
+	}
+]}`
+
+func TestTopdownJWTVerifyWithJWKS(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": "%s/keys"}`, ts.URL, ts.URL)
+		case "/keys":
+			fmt.Fprint(w, jwksTestKeys)
+		case "/enc":
+			fmt.Fprint(w, jwksTestEncryptionKeys)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	// The token is valid between 1000 and 2000 seconds.
+	claims := fmt.Sprintf(`{"iss": %q, "aud": "opa", "nbf": 1000, "exp": 2000}`, ts.URL)
+
+	tests := []struct {
+		note    string
+		kid     string
+		options string
+		exp     bool
+		err     string
+	}{
+		{
+			note:    "jwks_url",
+			options: `{"jwks_url": "%[1]s/keys", "aud": "opa", "time": 1500000000000}`,
+			exp:     true,
+		},
+		{
+			note:    "issuer discovery",
+			options: `{"issuer": "%[1]s", "aud": "opa", "time": 1500000000000}`,
+			exp:     true,
+		},
+		{
+			note:    "kid",
+			kid:     "k1",
+			options: `{"jwks_url": "%[1]s/keys", "aud": "opa", "time": 1500000000000}`,
+			exp:     true,
+		},
+		{
+			note:    "wrong issuer",
+			options: `{"jwks_url": "%[1]s/keys", "iss": "other", "aud": "opa", "time": 1500000000000}`,
+			exp:     false,
+		},
+		{
+			note:    "wrong audience",
+			options: `{"jwks_url": "%[1]s/keys", "aud": "other", "time": 1500000000000}`,
+			exp:     false,
+		},
+		{
+			note:    "wrong algorithm",
+			options: `{"jwks_url": "%[1]s/keys", "alg": "RS256", "aud": "opa", "time": 1500000000000}`,
+			exp:     false,
+		},
+		{
+			note:    "expired",
+			options: `{"jwks_url": "%[1]s/keys", "aud": "opa", "time": 2010000000000}`,
+			exp:     false,
+		},
+		{
+			note:    "expired within leeway",
+			options: `{"jwks_url": "%[1]s/keys", "aud": "opa", "time": 2010000000000, "leeway": 30}`,
+			exp:     true,
+		},
+		{
+			note:    "not yet valid",
+			options: `{"jwks_url": "%[1]s/keys", "aud": "opa", "time": 990000000000}`,
+			exp:     false,
+		},
+		{
+			note:    "not yet valid within leeway",
+			options: `{"jwks_url": "%[1]s/keys", "aud": "opa", "time": 990000000000, "leeway": 30}`,
+			exp:     true,
+		},
+		{
+			note:    "missing location",
+			options: `{"aud": "%[1]s"}`,
+			err:     "one of jwks_url and issuer must be set",
+		},
+		{
+			note:    "cert",
+			options: `{"jwks_url": "%[1]s/keys", "cert": "x"}`,
+			err:     "cert constraint: not supported",
+		},
+		{
+			note:    "negative leeway",
+			options: `{"jwks_url": "%[1]s/keys", "leeway": -1}`,
+			err:     "leeway option: must be a non-negative number of seconds",
+		},
+		{
+			note:    "no signature keys",
+			options: `{"jwks_url": "%[1]s/enc", "aud": "opa", "time": 1500000000000}`,
+			err:     "no key constraint",
+		},
+		{
+			note:    "not found",
+			options: `{"jwks_url": "%[1]s/missing"}`,
+			err:     "unexpected status code 404",
+		},
+		{
+			note:    "discovery not found",
+			options: `{"issuer": "%[1]s/missing"}`,
+			err:     "unexpected status code 404",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			header := `{"alg": "ES256"}`
+			if tc.kid != "" {
+				header = fmt.Sprintf(`{"alg": "ES256", "kid": %q}`, tc.kid)
+			}
+			query := fmt.Sprintf("io.jwt.encode_sign(%s, %s, %s, token); io.jwt.verify_with_jwks(token, %s, [valid, _, _])",
+				header, claims, jwksTestPrivateKey, fmt.Sprintf(tc.options, ts.URL))
+
+			qrs, err := NewQuery(ast.MustParseBody(query)).
+				WithStrictBuiltinErrors(true).
+				Run(context.Background())
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q but got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(qrs) != 1 {
+				t.Fatalf("expected one result but got: %v", qrs)
+			}
+			if valid := qrs[0][ast.Var("valid")]; !valid.Equal(ast.BooleanTerm(tc.exp)) {
+				t.Fatalf("expected valid to be %v but got: %v", tc.exp, valid)
+			}
+		})
+	}
+}
+
+func TestTopdownJWTVerifyWithJWKSInterQueryCache(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, jwksTestKeys)
+	}))
+	defer ts.Close()
+
+	config, err := cache.ParseCachingConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interQueryCache := cache.NewInterQueryCache(config)
+
+	query := fmt.Sprintf(`io.jwt.encode_sign({"alg": "ES256"}, {}, %s, token); io.jwt.verify_with_jwks(token, {"jwks_url": %q}, [true, _, _])`,
+		jwksTestPrivateKey, ts.URL)
+
+	for i := 0; i < 3; i++ {
+		qrs, err := NewQuery(ast.MustParseBody(query)).
+			WithInterQueryBuiltinCache(interQueryCache).
+			WithStrictBuiltinErrors(true).
+			Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(qrs) != 1 {
+			t.Fatalf("expected token to be verified but got: %v", qrs)
+		}
+	}
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected JWKS to be fetched once but got %d requests", n)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// EvalLimits defines limits on the resources a single query evaluation may
+// use. Evaluation is aborted with a ResourceLimitErr error as soon as a limit
+// is exceeded. Zero values mean no limit.
+type EvalLimits struct {
+	// MaxSteps is the maximum number of expressions evaluated.
+	MaxSteps int64
+
+	// MaxTermBytes is the maximum approximate size of the terms produced by
+	// built-in function calls, e.g., walk or concat.
+	MaxTermBytes int64
+
+	// MaxResultBytes is the maximum approximate size of the query results.
+	MaxResultBytes int64
+
+	// MaxHTTPSendCalls is the maximum number of http.send calls. Built-in
+	// functions that send requests with http.send (e.g., oidc.discovery) are
+	// counted too.
+	MaxHTTPSendCalls int64
+}
+
+// Enabled returns true if any limit is set.
+func (l EvalLimits) Enabled() bool {
+	return l.MaxSteps > 0 || l.MaxTermBytes > 0 || l.MaxResultBytes > 0 || l.MaxHTTPSendCalls > 0
+}
+
+// IsResourceLimit returns true if err was caused by exceeding an evaluation
+// resource limit.
+func IsResourceLimit(err error) bool {
+	return errors.Is(err, &Error{Code: ResourceLimitErr})
+}
+
+// evalLimiter tracks the resources used by a query evaluation. It is shared
+// by all evals of the query, including those running in parallel.
+type evalLimiter struct {
+	limits        EvalLimits
+	steps         int64
+	termBytes     int64
+	resultBytes   int64
+	httpSendCalls int64
+}
+
+func newEvalLimiter(limits EvalLimits) *evalLimiter {
+	if !limits.Enabled() {
+		return nil
+	}
+	return &evalLimiter{limits: limits}
+}
+
+// step records the evaluation of an expression.
+func (l *evalLimiter) step(loc *ast.Location) error {
+	if l == nil || l.limits.MaxSteps <= 0 {
+		return nil
+	}
+	return l.add(&l.steps, 1, l.limits.MaxSteps, "evaluation steps", loc)
+}
+
+// term records a term produced by a built-in function call.
+func (l *evalLimiter) term(t *ast.Term, loc *ast.Location) error {
+	if l == nil || l.limits.MaxTermBytes <= 0 {
+		return nil
+	}
+	return l.add(&l.termBytes, termSizeBytes(t.Value), l.limits.MaxTermBytes, "term bytes", loc)
+}
+
+// result records a query result.
+func (l *evalLimiter) result(qr QueryResult) error {
+	if l == nil || l.limits.MaxResultBytes <= 0 {
+		return nil
+	}
+	var n int64
+	for k, v := range qr {
+		n += termSizeBytes(k) + termSizeBytes(v.Value)
+	}
+	return l.add(&l.resultBytes, n, l.limits.MaxResultBytes, "result bytes", nil)
+}
+
+// httpSend records an http.send call.
+func (l *evalLimiter) httpSend(loc *ast.Location) error {
+	if l == nil || l.limits.MaxHTTPSendCalls <= 0 {
+		return nil
+	}
+	return l.add(&l.httpSendCalls, 1, l.limits.MaxHTTPSendCalls, "http.send calls", loc)
+}
+
+func (l *evalLimiter) add(counter *int64, n, limit int64, name string, loc *ast.Location) error {
+	if atomic.AddInt64(counter, n) <= limit {
+		return nil
+	}
+	return &Error{
+		Code:     ResourceLimitErr,
+		Message:  fmt.Sprintf("%s limit exceeded (limit: %d)", name, limit),
+		Location: loc,
+	}
+}
+
+// termSizeBytes returns the approximate number of bytes used by v.
+func termSizeBytes(v ast.Value) int64 {
+	switch v := v.(type) {
+	case ast.Null, ast.Boolean:
+		return 8
+	case ast.Number:
+		return 16 + int64(len(v))
+	case ast.String:
+		return 16 + int64(len(v))
+	case ast.Var:
+		return 16 + int64(len(v))
+	case *ast.Array:
+		n := int64(24)
+		v.Foreach(func(t *ast.Term) {
+			n += termSizeBytes(t.Value)
+		})
+		return n
+	case ast.Object:
+		n := int64(48)
+		v.Foreach(func(k, v *ast.Term) {
+			n += termSizeBytes(k.Value) + termSizeBytes(v.Value)
+		})
+		return n
+	case ast.Set:
+		n := int64(48)
+		v.Foreach(func(t *ast.Term) {
+			n += termSizeBytes(t.Value)
+		})
+		return n
+	default:
+		return 16 + int64(len(v.String()))
+	}
+}
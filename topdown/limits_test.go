@@ -0,0 +1,90 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestTopdownEvalLimits(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		note   string
+		query  string
+		limits EvalLimits
+		err    string
+	}{
+		{
+			note:   "steps",
+			query:  `numbers.range(1, 1000, xs); xs[_] = x; x > 0`,
+			limits: EvalLimits{MaxSteps: 100},
+			err:    "evaluation steps limit exceeded (limit: 100)",
+		},
+		{
+			note:   "steps within limit",
+			query:  `numbers.range(1, 10, xs); xs[_] = x; x > 100`,
+			limits: EvalLimits{MaxSteps: 100},
+		},
+		{
+			note:   "term bytes",
+			query:  `numbers.range(1, 1000, xs)`,
+			limits: EvalLimits{MaxTermBytes: 1024},
+			err:    "term bytes limit exceeded (limit: 1024)",
+		},
+		{
+			note:   "term bytes within limit",
+			query:  `numbers.range(1, 10, xs)`,
+			limits: EvalLimits{MaxTermBytes: 1024},
+		},
+		{
+			note:   "result bytes",
+			query:  `numbers.range(1, 100, xs); xs[_] = x`,
+			limits: EvalLimits{MaxResultBytes: 1024},
+			err:    "result bytes limit exceeded (limit: 1024)",
+		},
+		{
+			note:   "http.send calls",
+			query:  `numbers.range(1, 3, xs); xs[_] = x; http.send({"method": "GET", "url": concat("", ["` + ts.URL + `/", format_int(x, 10)])}, resp)`,
+			limits: EvalLimits{MaxHTTPSendCalls: 2},
+			err:    "http.send calls limit exceeded (limit: 2)",
+		},
+		{
+			note:   "http.send calls with raise_error disabled",
+			query:  `http.send({"method": "GET", "url": "` + ts.URL + `", "raise_error": false}, resp1); http.send({"method": "GET", "url": "` + ts.URL + `/x", "raise_error": false}, resp2)`,
+			limits: EvalLimits{MaxHTTPSendCalls: 1},
+			err:    "http.send calls limit exceeded (limit: 1)",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			_, err := NewQuery(ast.MustParseBody(tc.query)).
+				WithEvalLimits(tc.limits).
+				Run(context.Background())
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("expected error %q but got: %v", tc.err, err)
+			}
+			if !IsResourceLimit(err) {
+				t.Fatalf("expected resource limit error but got: %v", err)
+			}
+		})
+	}
+}
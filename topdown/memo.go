@@ -0,0 +1,303 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+const (
+	memoCacheHit  = "eval_op_memo_cache_hit"
+	memoCacheMiss = "eval_op_memo_cache_miss"
+
+	defaultMemoCacheMaxEntries = 10000
+)
+
+// memoNoArgs is the key used to cache values of rules that are not functions.
+var memoNoArgs = ast.NewArray()
+
+// MemoCache caches the values of rules and functions annotated with
+// "memoize: true" across queries. Values are keyed on the path of the rule
+// (or function) and the arguments it was called with. Since memoized rules may
+// only depend on data, the cache must be invalidated whenever the data or
+// policies change; see Invalidate. Undefined results are not cached.
+//
+// MemoCache is safe for concurrent use. A single MemoCache must only be shared
+// by queries evaluated against the same compiler.
+type MemoCache struct {
+	mtx        sync.Mutex
+	maxEntries int
+	generation uint64
+	rules      map[string]*memoRule
+	order      *list.List // FIFO of memoKey for eviction
+	size       int
+	hits       uint64
+	misses     uint64
+}
+
+type memoRule struct {
+	deps   []ast.Ref
+	values *ast.ValueMap
+}
+
+type memoKey struct {
+	path string
+	args ast.Value
+}
+
+// MemoCacheStats contains the hit and miss counts of a MemoCache.
+type MemoCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// NewMemoCache returns a new MemoCache that holds at most 10000 values.
+func NewMemoCache() *MemoCache {
+	return &MemoCache{
+		maxEntries: defaultMemoCacheMaxEntries,
+		rules:      map[string]*memoRule{},
+		order:      list.New(),
+	}
+}
+
+// WithMaxEntries sets the maximum number of values held by the cache. When the
+// limit is exceeded the oldest values are evicted first. Zero or a negative
+// number indicates no limit.
+func (c *MemoCache) WithMaxEntries(n int) *MemoCache {
+	c.maxEntries = n
+	return c
+}
+
+// Invalidate removes values that may have been affected by event. If policies
+// changed, all values are removed. Otherwise, only values of rules depending
+// on the modified data are removed.
+func (c *MemoCache) Invalidate(event storage.TriggerEvent) {
+	if event.IsZero() {
+		return
+	}
+
+	if event.PolicyChanged() {
+		c.Clear()
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.generation++
+
+	removed := map[string]struct{}{}
+	for path, rule := range c.rules {
+		for _, de := range event.Data {
+			if rule.dependsOn(de.Path.Ref(ast.DefaultRootDocument)) {
+				removed[path] = struct{}{}
+				c.size -= rule.values.Len()
+				delete(c.rules, path)
+				break
+			}
+		}
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if _, ok := removed[elem.Value.(memoKey).path]; ok {
+			c.order.Remove(elem)
+		}
+		elem = next
+	}
+}
+
+// Clear removes all values from the cache.
+func (c *MemoCache) Clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.generation++
+	c.rules = map[string]*memoRule{}
+	c.order.Init()
+	c.size = 0
+}
+
+// Stats returns the hit and miss counts of the cache.
+func (c *MemoCache) Stats() MemoCacheStats {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return MemoCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.size}
+}
+
+// Generation returns the generation of the cache, which changes whenever the
+// cache is invalidated. Callers that open storage transactions themselves
+// should obtain the generation before the transaction is opened and pass it to
+// the query (see Query.WithMemoGeneration). Otherwise, a write that commits
+// after the transaction was opened may go unnoticed and values computed from
+// the transaction's (stale) snapshot would be cached.
+func (c *MemoCache) Generation() uint64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.generation
+}
+
+func (c *MemoCache) get(path ast.Ref, args ast.Value) (ast.Value, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if rule, ok := c.rules[path.String()]; ok {
+		if v := rule.values.Get(args); v != nil {
+			c.hits++
+			return v, true
+		}
+	}
+
+	c.misses++
+	return nil, false
+}
+
+// put inserts value into the cache unless the cache has been invalidated
+// since generation was obtained. This prevents values computed from stale
+// data from being cached.
+func (c *MemoCache) put(generation uint64, path ast.Ref, deps []ast.Ref, args, value ast.Value) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	key := path.String()
+	rule, ok := c.rules[key]
+	if !ok {
+		rule = &memoRule{deps: deps, values: ast.NewValueMap()}
+		c.rules[key] = rule
+	}
+
+	if rule.values.Get(args) != nil {
+		return
+	}
+
+	rule.values.Put(args, value)
+	c.order.PushBack(memoKey{path: key, args: args})
+	c.size++
+
+	for c.maxEntries > 0 && c.size > c.maxEntries {
+		elem := c.order.Front()
+		k := c.order.Remove(elem).(memoKey)
+		if rule, ok := c.rules[k.path]; ok {
+			rule.values.Delete(k.args)
+			c.size--
+			if rule.values.Len() == 0 {
+				delete(c.rules, k.path)
+			}
+		}
+	}
+}
+
+func (r *memoRule) dependsOn(path ast.Ref) bool {
+	for _, dep := range r.deps {
+		if dep.HasPrefix(path) || path.HasPrefix(dep) {
+			return true
+		}
+	}
+	return false
+}
+
+// memoized returns true if any of the rules in ir have been annotated with
+// "memoize: true".
+func memoized(compiler *ast.Compiler, ir *ast.IndexResult) bool {
+	for _, rule := range ir.Rules {
+		if compiler.Memoized(rule) {
+			return true
+		}
+	}
+	return ir.Default != nil && compiler.Memoized(ir.Default)
+}
+
+// memoDeps returns the (ground prefixes of) data references made by rules and
+// all of their transitive dependencies.
+func memoDeps(compiler *ast.Compiler, rules []*ast.Rule) []ast.Ref {
+	var deps []ast.Ref
+	visited := map[*ast.Rule]struct{}{}
+
+	var visit func(rule *ast.Rule)
+	visit = func(rule *ast.Rule) {
+		if _, ok := visited[rule]; ok {
+			return
+		}
+		visited[rule] = struct{}{}
+
+		ast.WalkRefs(rule, func(ref ast.Ref) bool {
+			if ref.HasPrefix(ast.DefaultRootRef) {
+				deps = append(deps, ref.GroundPrefix())
+			}
+			return false
+		})
+
+		if compiler.Graph == nil {
+			return
+		}
+		for dep := range compiler.Graph.Dependencies(rule) {
+			visit(dep.(*ast.Rule))
+		}
+	}
+
+	for _, rule := range rules {
+		visit(rule)
+	}
+
+	return deps
+}
+
+// memoEnabled returns true if the values of the rules in ir may be read from
+// and written to the memo cache. Memoization is disabled during partial
+// evaluation and when the input or data have been replaced with the with
+// keyword.
+func (e *eval) memoEnabled(ir *ast.IndexResult) bool {
+	return e.memoCache != nil && e.compiler != nil && !e.partial() && len(e.virtualCache.stack) == 1 && memoized(e.compiler, ir)
+}
+
+// memoGet looks up the value of path called with args in the memo cache and
+// if found, stores it in the virtual document cache under key.
+func (e *eval) memoGet(key, path ast.Ref, args ast.Value) {
+	if cached, undefined := e.virtualCache.Get(key); cached != nil || undefined {
+		return
+	}
+	if v, ok := e.memoCache.get(path, args); ok {
+		e.metrics.Counter(memoCacheHit).Incr()
+		e.virtualCache.Put(key, ast.NewTerm(v))
+		return
+	}
+	e.metrics.Counter(memoCacheMiss).Incr()
+}
+
+// memoPut stores the value cached in the virtual document cache under key in
+// the memo cache.
+func (e *eval) memoPut(key, path ast.Ref, args ast.Value, ir *ast.IndexResult) {
+	value, _ := e.virtualCache.Get(key)
+	if value == nil || !ast.IsConstant(value.Value) {
+		return
+	}
+	rules := ir.Rules
+	if ir.Default != nil {
+		rules = append(rules[:len(rules):len(rules)], ir.Default)
+	}
+	e.memoCache.put(e.memoGeneration, path, memoDeps(e.compiler, rules), args, value.Value)
+}
+
+// isEarlyExit returns true if err only signals that evaluation stopped early.
+// The values cached in the virtual document cache are complete in that case.
+func isEarlyExit(err error) bool {
+	switch err.(type) {
+	case *earlyExitError, *deferredEarlyExitError:
+		return true
+	}
+	return false
+}
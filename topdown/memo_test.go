@@ -0,0 +1,246 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/storage"
+	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
+	"github.com/open-policy-agent/opa/util"
+)
+
+func TestMemoCache(t *testing.T) {
+	ctx := context.Background()
+
+	module := ast.MustParseModuleWithOpts(`package test
+
+import rego.v1
+
+# METADATA
+# memoize: true
+users := {u | some u in data.users}
+
+# METADATA
+# memoize: true
+double(x) := y if {
+	y := x * 2
+}
+
+p if "alice" in users
+
+q := double(input.x)
+
+r if {
+	double(input.x) with data.users as []
+}`, ast.ParserOptions{ProcessAnnotation: true})
+
+	compiler := ast.NewCompiler()
+	if compiler.Compile(map[string]*ast.Module{"test.rego": module}); compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+
+	store := inmem.NewFromObject(map[string]interface{}{
+		"users":  []interface{}{"alice", "bob"},
+		"config": map[string]interface{}{},
+	})
+	cache := NewMemoCache()
+
+	eval := func(query string, input interface{}) (ast.Value, metrics.Metrics) {
+		t.Helper()
+		m := metrics.New()
+		var result ast.Value
+		err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+			q := NewQuery(ast.MustParseBody("x = " + query)).
+				WithCompiler(compiler).
+				WithStore(store).
+				WithTransaction(txn).
+				WithMetrics(m).
+				WithMemoCache(cache)
+			if input != nil {
+				q = q.WithInput(ast.NewTerm(ast.MustInterfaceToValue(input)))
+			}
+			qrs, err := q.Run(ctx)
+			if err != nil {
+				return err
+			}
+			if len(qrs) == 1 {
+				result = qrs[0][ast.Var("x")].Value
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result, m
+	}
+
+	assertCounters := func(m metrics.Metrics, hits, misses uint64) {
+		t.Helper()
+		act := m.All()
+		for name, exp := range map[string]uint64{memoCacheHit: hits, memoCacheMiss: misses} {
+			var n uint64
+			if v, ok := act["counter_"+name]; ok {
+				n = v.(uint64)
+			}
+			if n != exp {
+				t.Fatalf("expected %v to be %d but got: %v", name, exp, act)
+			}
+		}
+	}
+
+	_, m := eval("data.test.p", nil)
+	assertCounters(m, 0, 1)
+	v, m := eval("data.test.p", nil)
+	assertCounters(m, 1, 0)
+	if v.Compare(ast.Boolean(true)) != 0 {
+		t.Fatalf("unexpected result: %v", v)
+	}
+
+	// Functions are keyed on their arguments.
+	_, m = eval("data.test.q", map[string]interface{}{"x": 1})
+	assertCounters(m, 0, 1)
+	v, m = eval("data.test.q", map[string]interface{}{"x": 1})
+	assertCounters(m, 1, 0)
+	if v.Compare(ast.Number("2")) != 0 {
+		t.Fatalf("unexpected result: %v", v)
+	}
+	_, m = eval("data.test.q", map[string]interface{}{"x": 2})
+	assertCounters(m, 0, 1)
+
+	// Memoization is disabled when the with keyword is in effect.
+	_, m = eval("data.test.r", map[string]interface{}{"x": 3})
+	assertCounters(m, 0, 0)
+
+	// Writes to unrelated data do not invalidate values.
+	writeMemoTestData(ctx, t, store, cache, "/config", map[string]interface{}{"x": 1})
+	_, m = eval("data.test.p", nil)
+	assertCounters(m, 1, 0)
+
+	// Writes to data the rule depends on invalidate its values.
+	writeMemoTestData(ctx, t, store, cache, "/users", []interface{}{"bob"})
+	v, m = eval("data.test.p", nil)
+	assertCounters(m, 0, 1)
+	if v != nil {
+		t.Fatalf("expected undefined but got: %v", v)
+	}
+
+	// Values of functions that do not depend on the modified data are kept.
+	_, m = eval("data.test.q", map[string]interface{}{"x": 1})
+	assertCounters(m, 1, 0)
+
+	stats := cache.Stats()
+	if stats.Hits != 4 || stats.Entries != 3 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	cache.Invalidate(storage.TriggerEvent{Policy: []storage.PolicyEvent{{ID: "test.rego"}}})
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Fatalf("expected empty cache but got: %+v", stats)
+	}
+}
+
+func TestMemoCacheMaxEntries(t *testing.T) {
+	cache := NewMemoCache().WithMaxEntries(2)
+	path := ast.MustParseRef("data.test.f")
+	gen := cache.Generation()
+
+	for i := 0; i < 3; i++ {
+		cache.put(gen, path, nil, ast.NewArray(ast.IntNumberTerm(i)), ast.IntNumberTerm(i).Value)
+	}
+
+	if _, ok := cache.get(path, ast.NewArray(ast.IntNumberTerm(0))); ok {
+		t.Fatal("expected oldest value to be evicted")
+	}
+	for i := 1; i < 3; i++ {
+		if _, ok := cache.get(path, ast.NewArray(ast.IntNumberTerm(i))); !ok {
+			t.Fatalf("expected value for %d", i)
+		}
+	}
+
+	// Values computed before an invalidation are discarded.
+	cache.Clear()
+	cache.put(gen, path, nil, ast.NewArray(ast.IntNumberTerm(0)), ast.IntNumberTerm(0).Value)
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Fatalf("expected stale value to be discarded but got: %+v", stats)
+	}
+}
+
+func TestMemoCacheGenerationBeforeTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	module := ast.MustParseModuleWithOpts(`package test
+
+import rego.v1
+
+# METADATA
+# memoize: true
+users := {u | some u in data.users}`, ast.ParserOptions{ProcessAnnotation: true})
+
+	compiler := ast.NewCompiler()
+	if compiler.Compile(map[string]*ast.Module{"test.rego": module}); compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+
+	store := inmem.NewFromObject(map[string]interface{}{"users": []interface{}{"alice"}})
+	cache := NewMemoCache()
+
+	// The generation is obtained before the transaction is opened. A write
+	// that is committed before the query runs invalidates the cache, so the
+	// values computed from the transaction must not be memoized.
+	generation := cache.Generation()
+	txn := storage.NewTransactionOrDie(ctx, store)
+	cache.Invalidate(storage.TriggerEvent{Data: []storage.DataEvent{{Path: storage.MustParsePath("/users")}}})
+
+	_, err := NewQuery(ast.MustParseBody("data.test.users")).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithMemoCache(cache).
+		WithMemoGeneration(generation).
+		Run(ctx)
+	store.Abort(ctx, txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := cache.Stats(); stats.Entries != 0 {
+		t.Fatalf("expected values computed from invalidated transaction to be discarded but got: %+v", stats)
+	}
+
+	err = storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+		_, err := NewQuery(ast.MustParseBody("data.test.users")).
+			WithCompiler(compiler).
+			WithStore(store).
+			WithTransaction(txn).
+			WithMemoCache(cache).
+			WithMemoGeneration(cache.Generation()).
+			Run(ctx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats := cache.Stats(); stats.Entries != 1 {
+		t.Fatalf("expected value to be memoized but got: %+v", stats)
+	}
+}
+
+func writeMemoTestData(ctx context.Context, t *testing.T, store storage.Store, cache *MemoCache, path string, value interface{}) {
+	t.Helper()
+	txn := storage.NewTransactionOrDie(ctx, store, storage.WriteParams)
+	p := storage.MustParsePath(path)
+	if err := store.Write(ctx, txn, storage.ReplaceOp, p, util.MustUnmarshalJSON(util.MustMarshalJSON(value))); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+	cache.Invalidate(storage.TriggerEvent{Data: []storage.DataEvent{{Path: p, Data: value}}})
+}
@@ -0,0 +1,310 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+	oauth2ClientSecretBasic = "client_secret_basic"
+	oauth2ClientSecretPost  = "client_secret_post"
+)
+
+var (
+	oidcIssuerKey                 = ast.StringTerm("issuer")
+	oidcJWKSURIKey                = ast.StringTerm("jwks_uri")
+	oidcIntrospectionEndpointKey  = ast.StringTerm("introspection_endpoint")
+	oauth2IntrospectionActiveKey  = ast.StringTerm("active")
+	oauth2IntrospectionScopeKey   = ast.StringTerm("scope")
+	oauth2IntrospectionAudKey     = ast.StringTerm("aud")
+	oauth2IntrospectionInactive   = ast.NewTerm(ast.NewObject([2]*ast.Term{oauth2IntrospectionActiveKey, ast.BooleanTerm(false)}))
+	httpResponseStatusCodeKey     = ast.StringTerm("status_code")
+	httpResponseRawBodyKey        = ast.StringTerm("raw_body")
+	oauth2IntrospectionOptionKeys = map[string]struct{}{
+		"introspection_endpoint": {},
+		"issuer":                 {},
+		"client_id":              {},
+		"client_secret":          {},
+		"auth_method":            {},
+		"token_type_hint":        {},
+		"cache_duration_seconds": {},
+	}
+)
+
+// oauth2IntrospectionOptions holds the options of oauth2.introspect.
+type oauth2IntrospectionOptions struct {
+	endpoint      string
+	issuer        string
+	clientID      string
+	clientSecret  string
+	authMethod    string
+	tokenTypeHint string
+	cacheDuration *ast.Term
+}
+
+func builtinOIDCDiscovery(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	issuer, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := getOIDCDiscovery(bctx, string(issuer))
+	if err != nil {
+		return err
+	}
+	return iter(ast.NewTerm(metadata))
+}
+
+func builtinOAuth2Introspect(bctx BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	token, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+
+	o, err := builtins.ObjectOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	opts, err := parseOAuth2IntrospectionOptions(o)
+	if err != nil {
+		return err
+	}
+
+	if opts.endpoint == "" {
+		metadata, err := getOIDCDiscovery(bctx, opts.issuer)
+		if err != nil {
+			return err
+		}
+		if opts.endpoint = objectStringField(metadata, oidcIntrospectionEndpointKey); opts.endpoint == "" {
+			return fmt.Errorf("OpenID Connect discovery document of %v has no introspection_endpoint", opts.issuer)
+		}
+	}
+
+	body, err := sendCachedHTTPRequest(bctx, opts.request(string(token)))
+	if err != nil {
+		return err
+	}
+
+	claims, err := normalizeIntrospectionResponse(body)
+	if err != nil {
+		return err
+	}
+	return iter(claims)
+}
+
+// getOIDCDiscovery returns the OpenID Connect discovery document of the
+// issuer. The issuer in the document must match the requested issuer.
+func getOIDCDiscovery(bctx BuiltinContext, issuer string) (ast.Object, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	body, err := sendCachedHTTPRequest(bctx, cachedGetRequest(issuer+oidcDiscoveryPath))
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := ast.ValueFromReader(strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OpenID Connect discovery document: %w", err)
+	}
+	metadata, ok := v.(ast.Object)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse OpenID Connect discovery document: must be an object")
+	}
+
+	if iss := objectStringField(metadata, oidcIssuerKey); strings.TrimSuffix(iss, "/") != issuer {
+		return nil, fmt.Errorf("OpenID Connect discovery document issuer %q does not match %v", iss, issuer)
+	}
+	return metadata, nil
+}
+
+// objectStringField returns the value of the string field key in obj, or ""
+// if there is no such field.
+func objectStringField(obj ast.Object, key *ast.Term) string {
+	if v := obj.Get(key); v != nil {
+		if s, ok := v.Value.(ast.String); ok {
+			return string(s)
+		}
+	}
+	return ""
+}
+
+// parseOAuth2IntrospectionOptions parses the options argument of
+// oauth2.introspect.
+func parseOAuth2IntrospectionOptions(o ast.Object) (*oauth2IntrospectionOptions, error) {
+	opts := oauth2IntrospectionOptions{authMethod: oauth2ClientSecretBasic}
+
+	if err := o.Iter(func(k *ast.Term, v *ast.Term) error {
+		name := string(k.Value.(ast.String))
+		if _, ok := oauth2IntrospectionOptionKeys[name]; !ok {
+			return fmt.Errorf("unknown introspection option: %s", name)
+		}
+		if name == "cache_duration_seconds" {
+			if _, ok := v.Value.(ast.Number); !ok {
+				return fmt.Errorf("%s option: must be a number", name)
+			}
+			opts.cacheDuration = v
+			return nil
+		}
+		s, ok := v.Value.(ast.String)
+		if !ok {
+			return fmt.Errorf("%s option: must be a string", name)
+		}
+		switch name {
+		case "introspection_endpoint":
+			opts.endpoint = string(s)
+		case "issuer":
+			opts.issuer = string(s)
+		case "client_id":
+			opts.clientID = string(s)
+		case "client_secret":
+			opts.clientSecret = string(s)
+		case "auth_method":
+			opts.authMethod = string(s)
+		case "token_type_hint":
+			opts.tokenTypeHint = string(s)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if opts.endpoint == "" && opts.issuer == "" {
+		return nil, fmt.Errorf("one of introspection_endpoint and issuer must be set")
+	}
+	if opts.authMethod != oauth2ClientSecretBasic && opts.authMethod != oauth2ClientSecretPost {
+		return nil, fmt.Errorf("auth_method option: must be one of %s and %s", oauth2ClientSecretBasic, oauth2ClientSecretPost)
+	}
+	return &opts, nil
+}
+
+// request returns the http.send request introspecting token.
+func (opts *oauth2IntrospectionOptions) request(token string) ast.Object {
+	form := url.Values{"token": []string{token}}
+	if opts.tokenTypeHint != "" {
+		form.Set("token_type_hint", opts.tokenTypeHint)
+	}
+
+	headers := ast.NewObject(
+		[2]*ast.Term{ast.StringTerm("Content-Type"), ast.StringTerm("application/x-www-form-urlencoded")},
+		[2]*ast.Term{ast.StringTerm("Accept"), ast.StringTerm("application/json")},
+	)
+	if opts.clientID != "" {
+		switch opts.authMethod {
+		case oauth2ClientSecretBasic:
+			// RFC 6749 2.3.1 requires the credentials to be form-encoded.
+			credentials := url.QueryEscape(opts.clientID) + ":" + url.QueryEscape(opts.clientSecret)
+			headers.Insert(ast.StringTerm("Authorization"), ast.StringTerm("Basic "+base64.StdEncoding.EncodeToString([]byte(credentials))))
+		case oauth2ClientSecretPost:
+			form.Set("client_id", opts.clientID)
+			form.Set("client_secret", opts.clientSecret)
+		}
+	}
+
+	req := ast.NewObject(
+		[2]*ast.Term{ast.StringTerm("method"), ast.StringTerm("POST")},
+		[2]*ast.Term{ast.StringTerm("url"), ast.StringTerm(opts.endpoint)},
+		[2]*ast.Term{ast.StringTerm("headers"), ast.NewTerm(headers)},
+		[2]*ast.Term{ast.StringTerm("raw_body"), ast.StringTerm(form.Encode())},
+		[2]*ast.Term{ast.StringTerm("cache"), ast.BooleanTerm(true)},
+	)
+	if opts.cacheDuration != nil {
+		req.Insert(ast.StringTerm("force_cache"), ast.BooleanTerm(true))
+		req.Insert(ast.StringTerm("force_cache_duration_seconds"), opts.cacheDuration)
+	}
+	return req
+}
+
+// normalizeIntrospectionResponse returns the claims of an RFC 7662
+// introspection response. Inactive tokens only have the active claim. The
+// scope claim is split into an array, and a string aud claim is converted
+// to an array.
+func normalizeIntrospectionResponse(body string) (*ast.Term, error) {
+	v, err := ast.ValueFromReader(strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+	resp, ok := v.(ast.Object)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse introspection response: must be an object")
+	}
+
+	active := resp.Get(oauth2IntrospectionActiveKey)
+	if active == nil {
+		return nil, fmt.Errorf("introspection response has no active claim")
+	}
+	if b, ok := active.Value.(ast.Boolean); !ok || !bool(b) {
+		return oauth2IntrospectionInactive, nil
+	}
+
+	claims, err := resp.Map(func(k, v *ast.Term) (*ast.Term, *ast.Term, error) {
+		switch {
+		case k.Equal(oauth2IntrospectionScopeKey):
+			if s, ok := v.Value.(ast.String); ok {
+				fields := strings.Fields(string(s))
+				scopes := make([]*ast.Term, len(fields))
+				for i := range fields {
+					scopes[i] = ast.StringTerm(fields[i])
+				}
+				return k, ast.ArrayTerm(scopes...), nil
+			}
+		case k.Equal(oauth2IntrospectionAudKey):
+			if _, ok := v.Value.(ast.String); ok {
+				return k, ast.ArrayTerm(v), nil
+			}
+		}
+		return k, v, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ast.NewTerm(claims), nil
+}
+
+// cachedGetRequest returns the http.send request fetching url with caching
+// enabled.
+func cachedGetRequest(url string) ast.Object {
+	return ast.NewObject(
+		[2]*ast.Term{ast.StringTerm("method"), ast.StringTerm("GET")},
+		[2]*ast.Term{ast.StringTerm("url"), ast.StringTerm(url)},
+		[2]*ast.Term{ast.StringTerm("cache"), ast.BooleanTerm(true)},
+	)
+}
+
+// sendCachedHTTPRequest sends the http.send request and returns the body of
+// the response. Requests with caching enabled are stored in the inter-query
+// cache for as long as the Cache-Control and Expires headers of the response
+// allow. Responses other than 200 OK are returned as errors.
+func sendCachedHTTPRequest(bctx BuiltinContext, req ast.Object) (string, error) {
+	resp, err := getHTTPResponse(bctx, req)
+	if err != nil {
+		return "", err
+	}
+	obj := resp.Value.(ast.Object)
+
+	if code, _ := obj.Get(httpResponseStatusCodeKey).Value.(ast.Number).Int(); code != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %v: unexpected status code %d", objectStringField(req, ast.StringTerm("url")), code)
+	}
+
+	body, ok := obj.Get(httpResponseRawBodyKey).Value.(ast.String)
+	if !ok {
+		return "", fmt.Errorf("failed to fetch %v: missing response body", objectStringField(req, ast.StringTerm("url")))
+	}
+	return string(body), nil
+}
+
+func init() {
+	RegisterBuiltinFunc(ast.OIDCDiscovery.Name, builtinOIDCDiscovery)
+	RegisterBuiltinFunc(ast.OAuth2Introspect.Name, builtinOAuth2Introspect)
+}
@@ -0,0 +1,229 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/topdown/cache"
+)
+
+// newOAuth2TestServer returns a server with an OpenID Connect discovery
+// document and an introspection endpoint accepting the client credentials
+// client:s3cr3t and the tokens "active" and "expired".
+func newOAuth2TestServer(t *testing.T, requests *int32) *httptest.Server {
+	t.Helper()
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprintf(w, `{"issuer": %q, "introspection_endpoint": "%s/introspect"}`, ts.URL, ts.URL)
+		case "/other/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": %q}`, ts.URL)
+		case "/introspect":
+			if err := r.ParseForm(); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			id, secret, ok := r.BasicAuth()
+			if !ok {
+				id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+			}
+			if id != "client" || secret != "s3cr3t" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			switch r.PostForm.Get("token") {
+			case "active":
+				fmt.Fprintf(w, `{"active": true, "scope": "read write", "aud": "api", "sub": "alice", "token_type_hint": %q}`, r.PostForm.Get("token_type_hint"))
+			default:
+				fmt.Fprint(w, `{"active": false, "sub": "alice"}`)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestTopdownOIDCDiscovery(t *testing.T) {
+	var requests int32
+	ts := newOAuth2TestServer(t, &requests)
+
+	tests := []struct {
+		note   string
+		issuer string
+		exp    string
+		err    string
+	}{
+		{
+			note:   "issuer",
+			issuer: ts.URL,
+			exp:    fmt.Sprintf(`{"issuer": %q, "introspection_endpoint": "%s/introspect"}`, ts.URL, ts.URL),
+		},
+		{
+			note:   "trailing slash",
+			issuer: ts.URL + "/",
+			exp:    fmt.Sprintf(`{"issuer": %q, "introspection_endpoint": "%s/introspect"}`, ts.URL, ts.URL),
+		},
+		{
+			note:   "issuer mismatch",
+			issuer: ts.URL + "/other",
+			err:    "does not match",
+		},
+		{
+			note:   "not found",
+			issuer: ts.URL + "/missing",
+			err:    "unexpected status code 404",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			query := fmt.Sprintf(`oidc.discovery(%q, x)`, tc.issuer)
+			qrs, err := NewQuery(ast.MustParseBody(query)).
+				WithStrictBuiltinErrors(true).
+				Run(context.Background())
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q but got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := ast.MustParseTerm(tc.exp); len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(exp) {
+				t.Fatalf("expected %v but got: %v", exp, qrs)
+			}
+		})
+	}
+}
+
+func TestTopdownOAuth2Introspect(t *testing.T) {
+	var requests int32
+	ts := newOAuth2TestServer(t, &requests)
+	endpoint := ts.URL + "/introspect"
+
+	tests := []struct {
+		note    string
+		token   string
+		options string
+		exp     string
+		err     string
+	}{
+		{
+			note:    "client_secret_basic",
+			token:   "active",
+			options: fmt.Sprintf(`{"introspection_endpoint": %q, "client_id": "client", "client_secret": "s3cr3t"}`, endpoint),
+			exp:     `{"active": true, "scope": ["read", "write"], "aud": ["api"], "sub": "alice", "token_type_hint": ""}`,
+		},
+		{
+			note:    "client_secret_post",
+			token:   "active",
+			options: fmt.Sprintf(`{"introspection_endpoint": %q, "client_id": "client", "client_secret": "s3cr3t", "auth_method": "client_secret_post", "token_type_hint": "access_token"}`, endpoint),
+			exp:     `{"active": true, "scope": ["read", "write"], "aud": ["api"], "sub": "alice", "token_type_hint": "access_token"}`,
+		},
+		{
+			note:    "issuer discovery",
+			token:   "active",
+			options: fmt.Sprintf(`{"issuer": %q, "client_id": "client", "client_secret": "s3cr3t"}`, ts.URL),
+			exp:     `{"active": true, "scope": ["read", "write"], "aud": ["api"], "sub": "alice", "token_type_hint": ""}`,
+		},
+		{
+			note:    "inactive",
+			token:   "expired",
+			options: fmt.Sprintf(`{"introspection_endpoint": %q, "client_id": "client", "client_secret": "s3cr3t"}`, endpoint),
+			exp:     `{"active": false}`,
+		},
+		{
+			note:    "unauthorized",
+			token:   "active",
+			options: fmt.Sprintf(`{"introspection_endpoint": %q, "client_id": "client", "client_secret": "wrong"}`, endpoint),
+			err:     "unexpected status code 401",
+		},
+		{
+			note:    "missing endpoint",
+			token:   "active",
+			options: `{"client_id": "client"}`,
+			err:     "one of introspection_endpoint and issuer must be set",
+		},
+		{
+			note:    "unknown option",
+			token:   "active",
+			options: fmt.Sprintf(`{"introspection_endpoint": %q, "foo": "bar"}`, endpoint),
+			err:     "unknown introspection option: foo",
+		},
+		{
+			note:    "invalid auth method",
+			token:   "active",
+			options: fmt.Sprintf(`{"introspection_endpoint": %q, "auth_method": "private_key_jwt"}`, endpoint),
+			err:     "auth_method option: must be one of client_secret_basic and client_secret_post",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.note, func(t *testing.T) {
+			query := fmt.Sprintf(`oauth2.introspect(%q, %s, x)`, tc.token, tc.options)
+			qrs, err := NewQuery(ast.MustParseBody(query)).
+				WithStrictBuiltinErrors(true).
+				Run(context.Background())
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error %q but got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := ast.MustParseTerm(tc.exp); len(qrs) != 1 || !qrs[0][ast.Var("x")].Equal(exp) {
+				t.Fatalf("expected %v but got: %v", exp, qrs)
+			}
+		})
+	}
+}
+
+func TestTopdownOAuth2IntrospectInterQueryCache(t *testing.T) {
+	var requests int32
+	ts := newOAuth2TestServer(t, &requests)
+
+	config, err := cache.ParseCachingConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interQueryCache := cache.NewInterQueryCache(config)
+
+	query := fmt.Sprintf(`oauth2.introspect("active", {"issuer": %q, "client_id": "client", "client_secret": "s3cr3t", "cache_duration_seconds": 60}, x); x.active`, ts.URL)
+
+	for i := 0; i < 3; i++ {
+		qrs, err := NewQuery(ast.MustParseBody(query)).
+			WithInterQueryBuiltinCache(interQueryCache).
+			WithStrictBuiltinErrors(true).
+			Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(qrs) != 1 {
+			t.Fatalf("expected token to be active but got: %v", qrs)
+		}
+	}
+
+	// One request for the discovery document and one for the introspection.
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Fatalf("expected 2 requests but got %d", n)
+	}
+}
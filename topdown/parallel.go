@@ -0,0 +1,189 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/topdown/builtins"
+	"github.com/open-policy-agent/opa/topdown/print"
+)
+
+// parallelPool bounds the number of goroutines used to evaluate independent
+// rule bodies concurrently. Slots are acquired without blocking: if the pool
+// is exhausted the body is evaluated on the calling goroutine instead. This
+// keeps nested parallel evaluation free of deadlocks.
+type parallelPool struct {
+	slots chan struct{}
+}
+
+// newParallelPool returns a pool that evaluates at most n rule bodies at the
+// same time. If n is less than two or the transactions of store cannot be read
+// from multiple goroutines (see storage.ConcurrentReader), nil is returned and
+// evaluation remains sequential.
+func newParallelPool(n int, store storage.Store) *parallelPool {
+	if n < 2 {
+		return nil
+	}
+	if cr, ok := store.(storage.ConcurrentReader); !ok || !cr.ConcurrentReads() {
+		return nil
+	}
+	// The calling goroutine accounts for one of the n workers.
+	return &parallelPool{slots: make(chan struct{}, n-1)}
+}
+
+func (p *parallelPool) tryAcquire() bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *parallelPool) release() {
+	<-p.slots
+}
+
+// parallelEnabled returns true if n rule bodies may be evaluated concurrently.
+// Partial evaluation is always sequential because the save set and support
+// modules are shared between all rule bodies.
+func (e *eval) parallelEnabled(n int) bool {
+	return e.parallel != nil && n > 1 && !e.partial()
+}
+
+// evalParallel calls f for each of the n tasks with a fork of e, possibly
+// concurrently. It returns the forks and the errors returned by f. Callers
+// must join the forks (in task order) to replay their side effects.
+func (e *eval) evalParallel(n int, f func(i int, fork *eval) error) ([]*eval, []error) {
+	forks := make([]*eval, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		forks[i] = e.fork()
+		if e.parallel.tryAcquire() {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer e.parallel.release()
+				errs[i] = f(i, forks[i])
+			}(i)
+		} else {
+			errs[i] = f(i, forks[i])
+		}
+	}
+	wg.Wait()
+
+	return forks, errs
+}
+
+// fork returns a copy of e that can be evaluated concurrently with e. Caches
+// that are not safe for concurrent use are replaced with empty ones and trace
+// events, print statement outputs, and built-in errors are buffered until the
+// fork is joined. Instrumentation is disabled for the fork because timers
+// cannot be shared between goroutines.
+func (e *eval) fork() *eval {
+	cpy := *e
+
+	// The depth of the virtual cache stack indicates whether the with keyword
+	// is in effect, so preserve it.
+	cpy.virtualCache = newVirtualCache()
+	for i := 1; i < len(e.virtualCache.stack); i++ {
+		cpy.virtualCache.Push()
+	}
+
+	cpy.comprehensionCache = newComprehensionCache()
+	cpy.baseCache = newBaseCache()
+	cpy.builtinCache = builtins.Cache{}
+	cpy.targetStack = e.targetStack.copy()
+	cpy.functionMocks = e.functionMocks.copy()
+	cpy.builtinErrors = &builtinErrors{}
+	cpy.instr = nil
+
+	if e.ndBuiltinCache != nil {
+		// Cached values are read as well (e.g., to replay decisions) so
+		// the fork needs a copy of them.
+		cpy.ndBuiltinCache = make(builtins.NDBCache, len(e.ndBuiltinCache))
+		for name, obj := range e.ndBuiltinCache {
+			cpy.ndBuiltinCache[name] = obj.Copy()
+		}
+	}
+	if e.traceEnabled {
+		cpy.tracers = []QueryTracer{NewBufferTracer()}
+	}
+	if e.printHook != nil {
+		cpy.printHook = &bufferedPrintHook{}
+	}
+
+	return &cpy
+}
+
+// join replays the side effects buffered by fork onto e.
+func (e *eval) join(fork *eval) {
+	if e.traceEnabled {
+		for _, evt := range *fork.tracers[0].(*BufferTracer) {
+			for i := range e.tracers {
+				e.tracers[i].TraceEvent(*evt)
+			}
+		}
+	}
+
+	if hook, ok := fork.printHook.(*bufferedPrintHook); ok {
+		for _, out := range hook.outputs {
+			if err := e.printHook.Print(out.ctx, out.msg); err != nil {
+				e.builtinErrors.errs = append(e.builtinErrors.errs, err)
+			}
+		}
+	}
+
+	e.builtinErrors.errs = append(e.builtinErrors.errs, fork.builtinErrors.errs...)
+
+	for name, obj := range fork.ndBuiltinCache {
+		obj.Foreach(func(k, v *ast.Term) {
+			if _, ok := e.ndBuiltinCache.Get(name, k.Value); !ok {
+				e.ndBuiltinCache.Put(name, k.Value, v.Value)
+			}
+		})
+	}
+}
+
+type bufferedPrintHook struct {
+	outputs []bufferedPrint
+}
+
+type bufferedPrint struct {
+	ctx print.Context
+	msg string
+}
+
+func (h *bufferedPrintHook) Print(ctx print.Context, msg string) error {
+	h.outputs = append(h.outputs, bufferedPrint{ctx: ctx, msg: msg})
+	return nil
+}
+
+// snapshotBindings returns bindings for the vars in xs with the values bound
+// in b. The snapshot remains valid after b has been unwound.
+func snapshotBindings(b *bindings, xs ...interface{}) *bindings {
+	snap := newBindings(b.id, nil)
+	for _, x := range xs {
+		if t, ok := x.(*ast.Term); ok && t == nil {
+			continue
+		}
+		ast.WalkVars(x, func(v ast.Var) bool {
+			term := ast.NewTerm(v)
+			if _, ok := snap.get(term); ok {
+				return false
+			}
+			if value := b.Plug(term); !value.Equal(term) {
+				snap.bind(term, value, snap, &undo{})
+			}
+			return false
+		})
+	}
+	return snap
+}
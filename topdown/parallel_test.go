@@ -0,0 +1,307 @@
+// Copyright 2024 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package topdown
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/logging"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/storage/disk"
+	inmem "github.com/open-policy-agent/opa/storage/inmem/test"
+	"github.com/open-policy-agent/opa/topdown/print"
+	"github.com/open-policy-agent/opa/types"
+)
+
+func TestParallelEval(t *testing.T) {
+	module := ast.MustParseModuleWithOpts(`package test
+
+import rego.v1
+
+deny contains "a" if {
+	print("a")
+	some x in data.xs
+	x > 1
+}
+
+deny contains msg if {
+	some x in data.xs
+	msg := sprintf("x=%v", [x])
+}
+
+deny contains "c" if input.c
+
+deny contains "d" if {
+	every x in data.xs {
+		x > 0
+	}
+	print("d")
+}
+
+obj[k] := v if {
+	some k, v in {"a": 1, "b": 2}
+}
+
+obj[k] := v if {
+	some k, v in {"c": 3}
+}
+
+conflict[k] := v if {
+	some k, v in {"a": 1}
+}
+
+conflict[k] := v if {
+	some k, v in {"a": 2}
+}
+
+p := 1 if input.c
+
+p := 1 if count(data.xs) > 0
+
+q := 1 if input.c
+
+q := 2 if count(data.xs) > 0
+
+default r := "default"
+
+r := 1 if input.missing
+
+r := 1 if input.other
+
+s := count(deny)
+
+t := n if {
+	n := count(deny) with input.c as false
+}
+
+u := x if {
+	x := "u"
+	mock_count(["x"]) == 1
+} else := "else"
+
+mock_count(_) := 1 if true
+
+v := w if {
+	w := u with count as mock_count
+}`, ast.ParserOptions{RegoVersion: ast.RegoV1})
+
+	compiler := ast.NewCompiler().WithEnablePrintStatements(true)
+	if compiler.Compile(map[string]*ast.Module{"test.rego": module}); compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+
+	store := inmem.NewFromObject(map[string]interface{}{"xs": []interface{}{1, 2, 3}})
+	input := ast.MustParseTerm(`{"c": true}`)
+
+	tests := []struct {
+		query string
+		exp   string
+		err   string
+		trace bool // whether trace events are expected in the same order
+	}{
+		{query: "data.test.deny", exp: `{"a", "c", "d", "x=1", "x=2", "x=3"}`, trace: true},
+		{query: "data.test.obj", exp: `{"a": 1, "b": 2, "c": 3}`, trace: true},
+		{query: "data.test.conflict", err: "eval_conflict_error: object keys must be unique"},
+		{query: "data.test.p", exp: `1`},
+		{query: "data.test.q", err: "eval_conflict_error: complete rules must not produce multiple outputs"},
+		{query: "data.test.r", exp: `"default"`},
+		{query: "data.test.s", exp: `6`, trace: true},
+		{query: "data.test.t", exp: `5`},
+		{query: "data.test.v", exp: `"u"`},
+	}
+
+	run := func(t *testing.T, query string, parallelism int) (ast.Value, error, []string, string) {
+		t.Helper()
+		ctx := context.Background()
+		tracer := NewBufferTracer()
+		buf := bytes.NewBuffer(nil)
+
+		var result ast.Value
+		err := storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+			qrs, err := NewQuery(ast.MustParseBody("x = " + query)).
+				WithCompiler(compiler).
+				WithStore(store).
+				WithTransaction(txn).
+				WithInput(input).
+				WithQueryTracer(tracer).
+				WithPrintHook(NewPrintHook(buf)).
+				WithParallelism(parallelism).
+				Run(ctx)
+			if err != nil {
+				return err
+			}
+			if len(qrs) == 1 {
+				result = qrs[0][ast.Var("x")].Value
+			}
+			return nil
+		})
+
+		var events []string
+		for _, evt := range *tracer {
+			events = append(events, fmt.Sprintf("%v %v", evt.Op, evt.Location))
+		}
+
+		return result, err, events, buf.String()
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.query, func(t *testing.T) {
+			seqResult, seqErr, seqEvents, seqPrints := run(t, tc.query, 0)
+
+			for i := 0; i < 10; i++ {
+				result, err, events, prints := run(t, tc.query, 4)
+
+				if tc.err != "" {
+					if err == nil || err.Error() != seqErr.Error() {
+						t.Fatalf("expected error %v but got: %v", seqErr, err)
+					}
+					if !bytes.Contains([]byte(err.Error()), []byte(tc.err)) {
+						t.Fatalf("expected error containing %q but got: %v", tc.err, err)
+					}
+					continue
+				} else if err != nil {
+					t.Fatal(err)
+				}
+
+				if exp := ast.MustParseTerm(tc.exp).Value; result.Compare(exp) != 0 || seqResult.Compare(exp) != 0 {
+					t.Fatalf("expected %v but got %v (sequential: %v)", exp, result, seqResult)
+				}
+
+				// The events of a complete rule body are emitted before the
+				// events of the expression referring to the rule.
+				if len(events) != len(seqEvents) {
+					t.Fatalf("expected %d trace events but got %d", len(seqEvents), len(events))
+				} else if !tc.trace {
+					sort.Strings(events)
+					sort.Strings(seqEvents)
+				}
+				for j := range events {
+					if events[j] != seqEvents[j] {
+						t.Fatalf("expected trace event %d to be %q but got %q", j, seqEvents[j], events[j])
+					}
+				}
+
+				if prints != seqPrints {
+					t.Fatalf("expected print output %q but got %q", seqPrints, prints)
+				}
+			}
+		})
+	}
+}
+
+func TestParallelPoolExhausted(t *testing.T) {
+	pool := newParallelPool(2, inmem.New())
+	if !pool.tryAcquire() {
+		t.Fatal("expected slot to be acquired")
+	}
+	if pool.tryAcquire() {
+		t.Fatal("expected pool to be exhausted")
+	}
+	pool.release()
+	if !pool.tryAcquire() {
+		t.Fatal("expected slot to be acquired after release")
+	}
+
+	if newParallelPool(1, inmem.New()) != nil {
+		t.Fatal("expected no pool for parallelism of one")
+	}
+}
+
+func TestParallelEvalDiskStore(t *testing.T) {
+	ctx := context.Background()
+
+	// Transactions of the disk store cannot be read from multiple goroutines,
+	// so rule bodies are evaluated sequentially.
+	store, err := disk.New(ctx, logging.NewNoOpLogger(), nil, disk.Options{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close(ctx)
+
+	if err := storage.WriteOne(ctx, store, storage.AddOp, storage.MustParsePath("/xs"), []interface{}{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	if newParallelPool(4, store) != nil {
+		t.Fatal("expected no pool for the disk store")
+	}
+
+	decl := &ast.Builtin{
+		Name: "test.track",
+		Decl: types.NewFunction(types.Args(types.A), types.A),
+	}
+
+	// test.track records the maximum number of concurrent calls.
+	var active, maxActive int32
+	track := func(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			m := atomic.LoadInt32(&maxActive)
+			if n <= m || atomic.CompareAndSwapInt32(&maxActive, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		return iter(operands[0])
+	}
+
+	module := ast.MustParseModuleWithOpts(`package test
+
+deny contains x if {
+	some x in data.xs
+	test.track(x)
+}
+
+deny contains x if {
+	some y in data.xs
+	x := test.track(y * 10)
+}
+
+deny contains x if {
+	some y in data.xs
+	x := test.track(y * 100)
+}`, ast.ParserOptions{RegoVersion: ast.RegoV1})
+
+	compiler := ast.NewCompiler().WithBuiltins(map[string]*ast.Builtin{decl.Name: decl})
+	if compiler.Compile(map[string]*ast.Module{"test.rego": module}); compiler.Failed() {
+		t.Fatal(compiler.Errors)
+	}
+
+	var result ast.Value
+	err = storage.Txn(ctx, store, storage.TransactionParams{}, func(txn storage.Transaction) error {
+		qrs, err := NewQuery(ast.MustParseBody("x = data.test.deny")).
+			WithCompiler(compiler).
+			WithStore(store).
+			WithTransaction(txn).
+			WithBuiltins(map[string]*Builtin{decl.Name: {Decl: decl, Func: track}}).
+			WithParallelism(4).
+			Run(ctx)
+		if err != nil {
+			return err
+		}
+		result = qrs[0][ast.Var("x")].Value
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := ast.MustParseTerm(`{1, 2, 3, 10, 20, 30, 100, 200, 300}`).Value; result.Compare(exp) != 0 {
+		t.Fatalf("expected %v but got %v", exp, result)
+	}
+	if maxActive != 1 {
+		t.Fatalf("expected sequential evaluation but got %d concurrent calls", maxActive)
+	}
+}
+
+var _ print.Hook = &bufferedPrintHook{}
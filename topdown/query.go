@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"io"
+	"net/http"
 	"sort"
 	"time"
 
@@ -46,13 +47,21 @@ type Query struct {
 	instr                  *Instrumentation
 	disableInlining        []ast.Ref
 	shallowInlining        bool
+	strictPartialEval      bool
 	genvarprefix           string
 	runtime                *ast.Term
 	builtins               map[string]*Builtin
 	indexing               bool
 	earlyExit              bool
 	interQueryBuiltinCache cache.InterQueryCache
+	httpSendScheduler      *HTTPSendScheduler
+	httpSendTransport      http.RoundTripper
+	limits                 EvalLimits
 	ndBuiltinCache         builtins.NDBCache
+	ndBuiltinCacheReplay   bool
+	memoCache              *MemoCache
+	memoGeneration         *uint64
+	parallelism            int
 	strictBuiltinErrors    bool
 	builtinErrorList       *[]Error
 	strictObjects          bool
@@ -245,12 +254,78 @@ func (q *Query) WithInterQueryBuiltinCache(c cache.InterQueryCache) *Query {
 	return q
 }
 
+// WithHTTPSendScheduler sets the scheduler used by http.send to coalesce,
+// batch, and limit outbound requests across queries.
+func (q *Query) WithHTTPSendScheduler(s *HTTPSendScheduler) *Query {
+	q.httpSendScheduler = s
+	return q
+}
+
+// WithHTTPSendTransport sets the transport http.send sends requests with,
+// e.g., to answer them with canned responses in tests. The transport replaces
+// the one http.send would otherwise create, including its TLS and unix socket
+// settings.
+func (q *Query) WithHTTPSendTransport(t http.RoundTripper) *Query {
+	q.httpSendTransport = t
+	return q
+}
+
+// WithEvalLimits sets the limits on the resources the evaluation may use. If
+// a limit is exceeded, evaluation is aborted with a ResourceLimitErr error.
+func (q *Query) WithEvalLimits(l EvalLimits) *Query {
+	q.limits = l
+	return q
+}
+
+// WithMemoCache sets the cache used to memoize the values of rules and
+// functions annotated with "memoize: true" across queries.
+func (q *Query) WithMemoCache(c *MemoCache) *Query {
+	q.memoCache = c
+	return q
+}
+
+// WithMemoGeneration sets the generation of the memo cache that was obtained
+// before the query's transaction was opened (see MemoCache.Generation). Values
+// are only memoized if the cache has not been invalidated since. If not set,
+// the generation is obtained when evaluation starts.
+func (q *Query) WithMemoGeneration(generation uint64) *Query {
+	q.memoGeneration = &generation
+	return q
+}
+
+// WithParallelism sets the maximum number of rule bodies that may be evaluated
+// concurrently. The bodies of partial set and object rules and the multiple
+// bodies of complete rules are independent of each other, so they can be
+// evaluated in parallel. Results, conflict errors, trace events, and print
+// statement outputs are produced in the same order as with sequential
+// evaluation, except that the trace events of complete rule bodies precede
+// the events of the expression referring to the rule. Bodies evaluated in
+// parallel do not share the virtual document and intra-query built-in caches,
+// so rules they refer to may be evaluated more than once. Instrumentation is
+// not recorded for bodies evaluated in parallel. Evaluation remains sequential
+// if the store does not support concurrent reads within a transaction (see
+// storage.ConcurrentReader). Values less than two disable parallel evaluation
+// (the default).
+func (q *Query) WithParallelism(n int) *Query {
+	q.parallelism = n
+	return q
+}
+
 // WithNDBuiltinCache sets the non-deterministic builtin cache.
 func (q *Query) WithNDBuiltinCache(c builtins.NDBCache) *Query {
 	q.ndBuiltinCache = c
 	return q
 }
 
+// WithNDBuiltinCacheReplay tells the evaluator to take the results of all
+// non-deterministic built-in function calls from the non-deterministic
+// builtin cache. Calls without a cached result fail, instead of being
+// evaluated.
+func (q *Query) WithNDBuiltinCacheReplay(yes bool) *Query {
+	q.ndBuiltinCacheReplay = yes
+	return q
+}
+
 // WithStrictBuiltinErrors tells the evaluator to treat all built-in function errors as fatal errors.
 func (q *Query) WithStrictBuiltinErrors(yes bool) *Query {
 	q.strictBuiltinErrors = yes
@@ -291,6 +366,15 @@ func (q *Query) WithStrictObjects(yes bool) *Query {
 	return q
 }
 
+// WithStrictPartialEval makes partial evaluation fail instead of saving
+// constructs it cannot expand into flat queries, e.g., every expressions with
+// unknown domains, comprehensions with unknowns, with statements and
+// references to support rules. The returned Errors lists all of them.
+func (q *Query) WithStrictPartialEval(yes bool) *Query {
+	q.strictPartialEval = yes
+	return q
+}
+
 // PartialRun executes partial evaluation on the query with respect to unknown
 // values. Partial evaluation attempts to evaluate as much of the query as
 // possible without requiring values for the unknowns set on the query. The
@@ -339,7 +423,11 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		builtinCache:           builtins.Cache{},
 		functionMocks:          newFunctionMocksStack(),
 		interQueryBuiltinCache: q.interQueryBuiltinCache,
+		httpSendScheduler:      q.httpSendScheduler,
+		httpSendTransport:      q.httpSendTransport,
+		limiter:                newEvalLimiter(q.limits),
 		ndBuiltinCache:         q.ndBuiltinCache,
+		ndBuiltinCacheReplay:   q.ndBuiltinCacheReplay,
 		virtualCache:           newVirtualCache(),
 		comprehensionCache:     newComprehensionCache(),
 		saveSet:                newSaveSet(q.unknowns, b, q.instr),
@@ -363,6 +451,10 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		e.inliningControl.PushDisable(q.disableInlining, false)
 	}
 
+	if q.strictPartialEval {
+		e.partialErrors = &partialErrors{}
+	}
+
 	e.caller = e
 	q.metrics.Timer(metrics.RegoPartialEval).Start()
 	defer q.metrics.Timer(metrics.RegoPartialEval).Stop()
@@ -449,6 +541,10 @@ func (q *Query) PartialRun(ctx context.Context) (partials []ast.Body, support []
 		}
 	}
 
+	if err == nil && e.partialErrors != nil && len(e.partialErrors.errs) > 0 {
+		return nil, nil, e.partialErrors.errs
+	}
+
 	for i := range support {
 		sort.Slice(support[i].Rules, func(j, k int) bool {
 			return support[i].Rules[j].Compare(support[i].Rules[k]) < 0
@@ -515,7 +611,13 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 		builtinCache:           builtins.Cache{},
 		functionMocks:          newFunctionMocksStack(),
 		interQueryBuiltinCache: q.interQueryBuiltinCache,
+		httpSendScheduler:      q.httpSendScheduler,
+		httpSendTransport:      q.httpSendTransport,
+		limiter:                newEvalLimiter(q.limits),
 		ndBuiltinCache:         q.ndBuiltinCache,
+		ndBuiltinCacheReplay:   q.ndBuiltinCacheReplay,
+		memoCache:              q.memoCache,
+		parallel:               newParallelPool(q.parallelism, q.store),
 		virtualCache:           newVirtualCache(),
 		comprehensionCache:     newComprehensionCache(),
 		genvarprefix:           q.genvarprefix,
@@ -527,6 +629,11 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 		tracingOpts:            q.tracingOpts,
 		strictObjects:          q.strictObjects,
 	}
+	if q.memoGeneration != nil {
+		e.memoGeneration = *q.memoGeneration
+	} else if q.memoCache != nil {
+		e.memoGeneration = q.memoCache.Generation()
+	}
 	e.caller = e
 	q.metrics.Timer(metrics.RegoQueryEval).Start()
 	err := e.Run(func(e *eval) error {
@@ -535,6 +642,9 @@ func (q *Query) Iter(ctx context.Context, iter func(QueryResult) error) error {
 			qr[k.Value.(ast.Var)] = v
 			return nil
 		}) // cannot return error
+		if err := e.limiter.result(qr); err != nil {
+			return err
+		}
 		return iter(qr)
 	})
 
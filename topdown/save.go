@@ -16,6 +16,7 @@ import (
 type saveSet struct {
 	instr *Instrumentation
 	l     *list.List
+	known [][]ast.Ref
 }
 
 func newSaveSet(ts []*ast.Term, b *bindings, instr *Instrumentation) *saveSet {
@@ -35,6 +36,32 @@ func (ss *saveSet) Pop() {
 	ss.l.Remove(ss.l.Back())
 }
 
+// PushKnown marks the refs and all refs they prefix as known, e.g., because
+// a with statement replaced their values. Refs that prefix one of the known
+// refs are still contained in the save set.
+func (ss *saveSet) PushKnown(refs []ast.Ref) {
+	ss.known = append(ss.known, refs)
+}
+
+func (ss *saveSet) PopKnown() {
+	ss.known = ss.known[:len(ss.known)-1]
+}
+
+func (ss *saveSet) isKnown(t *ast.Term) bool {
+	ref, ok := t.Value.(ast.Ref)
+	if !ok {
+		return false
+	}
+	for i := range ss.known {
+		for _, k := range ss.known[i] {
+			if ref.HasPrefix(k) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Contains returns true if the term t is contained in the save set. Non-var and
 // non-ref terms are never contained. Ref terms are contained if they share a
 // prefix with a ref that was added (in either direction).
@@ -49,6 +76,9 @@ func (ss *saveSet) Contains(t *ast.Term, b *bindings) bool {
 }
 
 func (ss *saveSet) contains(t *ast.Term, b *bindings) bool {
+	if ss.isKnown(t) {
+		return false
+	}
 	for el := ss.l.Back(); el != nil; el = el.Prev() {
 		if el.Value.(*saveSetElem).Contains(t, b) {
 			return true
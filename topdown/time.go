@@ -10,6 +10,7 @@ import (
 	"math"
 	"math/big"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 	_ "time/tzdata" // this is needed to have LoadLocation when no filesystem tzdata is available
@@ -263,30 +264,9 @@ func tzTime(a ast.Value) (t time.Time, lay string, err error) {
 				return time.Time{}, layout, err
 			}
 
-			tzName := string(tzVal)
-
-			switch tzName {
-			case "", "UTC":
-				// loc is already UTC
-
-			case "Local":
-				loc = time.Local
-
-			default:
-				var ok bool
-
-				tzCacheMutex.Lock()
-				loc, ok = tzCache[tzName]
-
-				if !ok {
-					loc, err = time.LoadLocation(tzName)
-					if err != nil {
-						tzCacheMutex.Unlock()
-						return time.Time{}, layout, err
-					}
-					tzCache[tzName] = loc
-				}
-				tzCacheMutex.Unlock()
+			loc, err = loadLocation(string(tzVal))
+			if err != nil {
+				return time.Time{}, layout, err
 			}
 		}
 
@@ -321,6 +301,273 @@ func tzTime(a ast.Value) (t time.Time, lay string, err error) {
 	return t, layout, nil
 }
 
+// localTimeLayout is the layout of date-times converted between time zones.
+const localTimeLayout = "2006-01-02T15:04:05.999999999"
+
+func builtinTimeConvertZone(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	s, err := builtins.StringOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	from, err := builtins.StringOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+	to, err := builtins.StringOperand(operands[2].Value, 3)
+	if err != nil {
+		return err
+	}
+
+	fromLoc, err := loadLocation(string(from))
+	if err != nil {
+		return err
+	}
+	toLoc, err := loadLocation(string(to))
+	if err != nil {
+		return err
+	}
+
+	t, err := time.ParseInLocation(localTimeLayout, string(s), fromLoc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", ast.TimeConvertZone.Name, err)
+	}
+
+	return iter(ast.StringTerm(t.In(toLoc).Format(localTimeLayout)))
+}
+
+func intervalOperand(a ast.Value, pos int) (*ast.Term, *ast.Term, error) {
+	arr, err := builtins.ArrayOperand(a, pos)
+	if err != nil {
+		return nil, nil, err
+	}
+	if arr.Len() != 2 {
+		return nil, nil, builtins.NewOperandErr(pos, "must be an array of two numbers (start and end)")
+	}
+	start, end := arr.Elem(0), arr.Elem(1)
+	for _, x := range []*ast.Term{start, end} {
+		if _, err := builtins.NumberOperand(x.Value, pos); err != nil {
+			return nil, nil, err
+		}
+	}
+	if ast.Compare(start, end) > 0 {
+		return nil, nil, builtins.NewOperandErr(pos, "start of interval must not be after its end")
+	}
+	return start, end, nil
+}
+
+func builtinTimeIntervalOverlap(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	start1, end1, err := intervalOperand(operands[0].Value, 1)
+	if err != nil {
+		return err
+	}
+	start2, end2, err := intervalOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+
+	start, end := start1, end1
+	if ast.Compare(start2, start) > 0 {
+		start = start2
+	}
+	if ast.Compare(end2, end) < 0 {
+		end = end2
+	}
+
+	if ast.Compare(start, end) >= 0 {
+		return iter(ast.ArrayTerm())
+	}
+	return iter(ast.ArrayTerm(start, end))
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// timeWindow is a window recurring daily, or on some days of the week, in a
+// time zone.
+type timeWindow struct {
+	days       map[time.Weekday]bool
+	start, end time.Duration
+	loc        *time.Location
+	exclude    map[string]bool
+}
+
+func parseTimeWindow(obj ast.Object, loc *time.Location) (*timeWindow, error) {
+	w := &timeWindow{loc: loc}
+	var err error
+
+	for _, key := range obj.Keys() {
+		k, ok := key.Value.(ast.String)
+		if !ok {
+			return nil, builtins.NewOperandErr(2, "keys must be strings")
+		}
+		v := obj.Get(key).Value
+		switch k {
+		case "start", "end":
+			s, ok := v.(ast.String)
+			if !ok {
+				return nil, builtins.NewOperandErr(2, "%v must be a string", k)
+			}
+			d, err := parseTimeOfDay(string(s))
+			if err != nil {
+				return nil, builtins.NewOperandErr(2, "%v: %v", k, err)
+			}
+			if k == "start" {
+				w.start = d
+			} else {
+				w.end = d
+			}
+		case "tz":
+			s, ok := v.(ast.String)
+			if !ok {
+				return nil, builtins.NewOperandErr(2, "tz must be a string")
+			}
+			w.loc, err = loadLocation(string(s))
+			if err != nil {
+				return nil, err
+			}
+		case "days":
+			w.days = map[time.Weekday]bool{}
+			if err := foreachString(v, func(s string) error {
+				d, ok := weekdays[strings.ToLower(s)]
+				if !ok {
+					return builtins.NewOperandErr(2, "invalid day %q", s)
+				}
+				w.days[d] = true
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		case "exclude":
+			w.exclude = map[string]bool{}
+			if err := foreachString(v, func(s string) error {
+				if _, err := time.Parse("2006-01-02", s); err != nil {
+					return builtins.NewOperandErr(2, "invalid date %q in exclude", s)
+				}
+				w.exclude[s] = true
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, builtins.NewOperandErr(2, "invalid key %v", key)
+		}
+	}
+
+	for _, k := range []string{"start", "end"} {
+		if obj.Get(ast.StringTerm(k)) == nil {
+			return nil, builtins.NewOperandErr(2, "missing %v", k)
+		}
+	}
+
+	return w, nil
+}
+
+func foreachString(v ast.Value, f func(string) error) error {
+	var elems []*ast.Term
+	switch v := v.(type) {
+	case *ast.Array:
+		v.Foreach(func(x *ast.Term) { elems = append(elems, x) })
+	case ast.Set:
+		elems = v.Slice()
+	default:
+		return builtins.NewOperandErr(2, "must be an array or set of strings but got %v", ast.TypeName(v))
+	}
+	for _, x := range elems {
+		s, ok := x.Value.(ast.String)
+		if !ok {
+			return builtins.NewOperandErr(2, "must be an array or set of strings but got element %v", x)
+		}
+		if err := f(string(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseTimeOfDay parses a "15:04" or "15:04:05" time of day.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time of day %q (expected HH:MM or HH:MM:SS)", s)
+}
+
+// contains returns true if t falls into the window. Windows whose end is not
+// after their start end on the next day; they belong to the day they start on.
+func (w *timeWindow) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	y, m, d := t.Date()
+	sinceMidnight := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, w.loc))
+
+	day := t
+	switch {
+	case w.start < w.end:
+		if sinceMidnight < w.start || sinceMidnight >= w.end {
+			return false
+		}
+	case sinceMidnight >= w.start:
+	case sinceMidnight < w.end:
+		day = t.AddDate(0, 0, -1)
+	default:
+		return false
+	}
+
+	if w.days != nil && !w.days[day.Weekday()] {
+		return false
+	}
+	return !w.exclude[day.Format("2006-01-02")]
+}
+
+func builtinTimeInWindow(_ BuiltinContext, operands []*ast.Term, iter func(*ast.Term) error) error {
+	t, _, err := tzTime(operands[0].Value)
+	if err != nil {
+		return err
+	}
+	obj, err := builtins.ObjectOperand(operands[1].Value, 2)
+	if err != nil {
+		return err
+	}
+	w, err := parseTimeWindow(obj, t.Location())
+	if err != nil {
+		return err
+	}
+	return iter(ast.BooleanTerm(w.contains(t)))
+}
+
+// loadLocation returns the location of the IANA time zone. Locations are
+// cached as loading them requires reading the time zone database.
+func loadLocation(tzName string) (*time.Location, error) {
+	switch tzName {
+	case "", "UTC":
+		return time.UTC, nil
+	case "Local":
+		return time.Local, nil
+	}
+
+	tzCacheMutex.Lock()
+	defer tzCacheMutex.Unlock()
+
+	if loc, ok := tzCache[tzName]; ok {
+		return loc, nil
+	}
+
+	loc, err := time.LoadLocation(tzName)
+	if err != nil {
+		return nil, err
+	}
+	tzCache[tzName] = loc
+	return loc, nil
+}
+
 func int64ToJSONNumber(i int64) json.Number {
 	return json.Number(strconv.FormatInt(i, 10))
 }
@@ -336,6 +583,9 @@ func init() {
 	RegisterBuiltinFunc(ast.Weekday.Name, builtinWeekday)
 	RegisterBuiltinFunc(ast.AddDate.Name, builtinAddDate)
 	RegisterBuiltinFunc(ast.Diff.Name, builtinDiff)
+	RegisterBuiltinFunc(ast.TimeConvertZone.Name, builtinTimeConvertZone)
+	RegisterBuiltinFunc(ast.TimeIntervalOverlap.Name, builtinTimeIntervalOverlap)
+	RegisterBuiltinFunc(ast.TimeInWindow.Name, builtinTimeInWindow)
 	tzCacheMutex = &sync.Mutex{}
 	tzCache = make(map[string]*time.Location)
 }
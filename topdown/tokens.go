@@ -526,6 +526,9 @@ type tokenConstraints struct {
 	// The time to validate against, or -1 if no constraint set.
 	// (If unset, the current time will be used.)
 	time float64
+
+	// The leeway in seconds allowed when validating the exp and nbf claims.
+	leeway float64
 }
 
 // tokenConstraintHandler is the handler type for JWT verification constraints.
@@ -992,11 +995,6 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 		return err
 	}
 
-	unverified := ast.ArrayTerm(
-		ast.BooleanTerm(false),
-		ast.NewTerm(ast.NewObject()),
-		ast.NewTerm(ast.NewObject()),
-	)
 	constraints, err := parseTokenConstraints(b, bctx.Time)
 	if err != nil {
 		return err
@@ -1004,44 +1002,61 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 	if err := constraints.validate(); err != nil {
 		return err
 	}
+	result, err := decodeVerifyJWT(a, constraints)
+	if err != nil {
+		return err
+	}
+	return iter(result)
+}
+
+// decodeVerifyJWT decodes the JWT and verifies it under the constraints. If
+// the token is valid, [true, header, payload] is returned, otherwise
+// [false, {}, {}].
+func decodeVerifyJWT(a ast.Value, constraints *tokenConstraints) (*ast.Term, error) {
+	unverified := ast.ArrayTerm(
+		ast.BooleanTerm(false),
+		ast.NewTerm(ast.NewObject()),
+		ast.NewTerm(ast.NewObject()),
+	)
 	var token *JSONWebToken
 	var p *ast.Term
+	var err error
 	for {
 		// RFC7519 7.2 #1-2 split into parts
 		if token, err = decodeJWT(a); err != nil {
-			return err
+			return nil, err
 		}
 		// RFC7519 7.2 #3, #4, #6
 		if err := token.decodeHeader(); err != nil {
-			return err
+			return nil, err
 		}
 		// RFC7159 7.2 #5 (and RFC7159 5.2 #5) validate header fields
 		header, err := parseTokenHeader(token)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if !header.valid() {
-			return iter(unverified)
+			return unverified, nil
 		}
 		// Check constraints that impact signature verification.
 		if constraints.alg != "" && constraints.alg != header.alg {
-			return iter(unverified)
+			return unverified, nil
 		}
 		// RFC7159 7.2 #7 verify the signature
 		signature, err := token.decodeSignature()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if err := constraints.verify(header.kid, header.alg, token.header, token.payload, signature); err != nil {
 			if err == errSignatureNotVerified {
-				return iter(unverified)
+				return unverified, nil
 			}
-			return err
+			return nil, err
 		}
 		// RFC7159 7.2 #9-10 decode the payload
 		p, err = getResult(builtinBase64UrlDecode, ast.StringTerm(token.payload))
 		if err != nil {
-			return fmt.Errorf("JWT payload had invalid encoding: %v", err)
+			return nil, fmt.Errorf("JWT payload had invalid encoding: %v", err)
 		}
 		// RFC7159 7.2 #8 and 5.2 cty
 		if strings.ToUpper(header.cty) == headerJwt {
@@ -1055,7 +1070,7 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 	}
 	payload, err := extractJSONObject(string(p.Value.(ast.String)))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Check registered claim names against constraints or environment
 	// RFC7159 4.1.1 iss
@@ -1063,20 +1078,20 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 		if iss := payload.Get(jwtIssKey); iss != nil {
 			issVal := string(iss.Value.(ast.String))
 			if constraints.iss != issVal {
-				return iter(unverified)
+				return unverified, nil
 			}
 		} else {
-			return iter(unverified)
+			return unverified, nil
 		}
 	}
 	// RFC7159 4.1.3 aud
 	if aud := payload.Get(jwtAudKey); aud != nil {
 		if !constraints.validAudience(aud.Value) {
-			return iter(unverified)
+			return unverified, nil
 		}
 	} else {
 		if constraints.aud != "" {
-			return iter(unverified)
+			return unverified, nil
 		}
 	}
 	// RFC7159 4.1.4 exp
@@ -1084,12 +1099,12 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 		switch exp.Value.(type) {
 		case ast.Number:
 			// constraints.time is in nanoseconds but exp Value is in seconds
-			compareTime := ast.FloatNumberTerm(constraints.time / 1000000000)
+			compareTime := ast.FloatNumberTerm(constraints.time/1000000000 - constraints.leeway)
 			if ast.Compare(compareTime, exp.Value.(ast.Number)) != -1 {
-				return iter(unverified)
+				return unverified, nil
 			}
 		default:
-			return fmt.Errorf("exp value must be a number")
+			return nil, fmt.Errorf("exp value must be a number")
 		}
 	}
 	// RFC7159 4.1.5 nbf
@@ -1097,12 +1112,12 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 		switch nbf.Value.(type) {
 		case ast.Number:
 			// constraints.time is in nanoseconds but nbf Value is in seconds
-			compareTime := ast.FloatNumberTerm(constraints.time / 1000000000)
+			compareTime := ast.FloatNumberTerm(constraints.time/1000000000 + constraints.leeway)
 			if ast.Compare(compareTime, nbf.Value.(ast.Number)) == -1 {
-				return iter(unverified)
+				return unverified, nil
 			}
 		default:
-			return fmt.Errorf("nbf value must be a number")
+			return nil, fmt.Errorf("nbf value must be a number")
 		}
 	}
 
@@ -1111,7 +1126,7 @@ func builtinJWTDecodeVerify(bctx BuiltinContext, operands []*ast.Term, iter func
 		ast.NewTerm(token.decodedHeader),
 		ast.NewTerm(payload),
 	)
-	return iter(verified)
+	return verified, nil
 }
 
 // -- Utilities --
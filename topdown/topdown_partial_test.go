@@ -1072,7 +1072,7 @@ func TestTopDownPartialEval(t *testing.T) {
 					c = [1 | b = a[0]]
 				}
 			`},
-			wantQueries: []string{`x = [1 | b1 = input[0]]; y = [1 | b2 = input[0]]`},
+			wantQueries: []string{`x = [1 | b1 = input[0]]; y = [1 | b3 = input[0]]`},
 		},
 		{
 			note:        "comprehensions: closure",
@@ -1107,13 +1107,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				q { input.y = r }
 				r = 2`,
 			},
-			wantQueries: []string{
-				`input.x = 1; data.partial.test.q = x_term_1_11 with input as {"y": 2}; x_term_1_11 with input as {"y": 2}`,
-			},
-			wantSupport: []string{
-				`package partial.test
-				q = true { 2 = input.y }`,
-			},
+			wantQueries: []string{`input.x = 1`},
 		},
 		{
 			note:  "with: no unknowns",
@@ -1125,15 +1119,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				q[y] { x = 1; y = x }
 				q[2]`,
 			},
-			wantQueries: []string{
-				`data.partial.test.q[x1] = y1 with input as 1`,
-			},
-			wantSupport: []string{
-				`package partial.test
-
-				q[1]
-				q[2]`,
-			},
+			wantQueries: []string{``, ``},
 		},
 		{
 			note:  "with: iteration",
@@ -1146,15 +1132,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				r[1]
 				r[2]`,
 			},
-			wantQueries: []string{
-				`data.partial.test.q = true with input as 1`,
-			},
-			wantSupport: []string{
-				`package partial.test
-
-				q { 1 = input }
-				q { 2 = input }`,
-			},
+			wantQueries: []string{``},
 		},
 		{
 			note:  "with: unknown value",
@@ -1219,12 +1197,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				p { q[1] = 1 with input as 1 }
 				q[x] { x = 1 }`,
 			},
-			wantQueries: []string{`data.partial.test.q[1] = 1 with input as 1`},
-			wantSupport: []string{
-				`package partial.test
-
-				q[1]`,
-			},
+			wantQueries: []string{``},
 		},
 		{
 			note:  "with: ground prefix disabled with var",
@@ -1235,12 +1208,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				p { q[x] = 1 with input as 1 }
 				q[x] { x = 1 }`,
 			},
-			wantQueries: []string{`data.partial.test.q[x1] = 1 with input as 1`},
-			wantSupport: []string{
-				`package partial.test
-
-				q[1]`,
-			},
+			wantQueries: []string{``},
 		},
 		{
 			note:    "with+shallow: partial set elem",
@@ -3577,7 +3545,7 @@ func TestTopDownPartialEval(t *testing.T) {
 			note:        "negation: save inline negated with",
 			query:       `not input with data.x as 2; data.x = 1`,
 			data:        `{"x": 1}`,
-			wantQueries: []string{"not input with data.x as 2"},
+			wantQueries: []string{"not input"},
 		},
 		{
 			note:  "negation: save negated expr using plugged with value",
@@ -3725,10 +3693,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				p {
 					every x in [] { x > input }
 				}`},
-			wantQueries: []string{`every __local0__1, __local1__1 in [] {
-				__local3__1 = input
-				__local1__1 > __local3__1
-			}`},
+			wantQueries: []string{``},
 		},
 		{
 			note:  "every: known domain, unknowns in body",
@@ -3737,10 +3702,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				p {
 					every x in [1, 2, 3] { x > input }
 				}`},
-			wantQueries: []string{`every __local0__1, __local1__1 in [1, 2, 3] {
-				__local3__1 = input
-				__local1__1 > __local3__1
-			}`},
+			wantQueries: []string{`1 > input; 2 > input; 3 > input`},
 		},
 		{
 			note:  "every: known domain, unknowns in body (with call+assignment)",
@@ -3749,12 +3711,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				p {
 					every x in [1, 2, 3] { y := x+10; y > input }
 				}`},
-			wantQueries: []string{`every __local0__1, __local1__1 in [1, 2, 3] {
-				plus(__local1__1, 10, __local4__1)
-				__local2__1 = __local4__1
-				__local5__1 = input
-				__local2__1 > __local5__1
-			}`},
+			wantQueries: []string{`11 > input; 12 > input; 13 > input`},
 		},
 		{
 			note:  "every: known domain, unknowns in body, body impossible",
@@ -3763,11 +3720,7 @@ func TestTopDownPartialEval(t *testing.T) {
 				p {
 					every x in [1, 2, 3] { false; x > input }
 				}`},
-			wantQueries: []string{`every __local0__1, __local1__1 in [1, 2, 3] {
-				false
-				__local3__1 = input
-				__local1__1 > __local3__1
-			}`},
+			wantQueries: []string{},
 		},
 		{
 			note:  "every: unknown domain",
@@ -3786,7 +3739,7 @@ func TestTopDownPartialEval(t *testing.T) {
 					y := 3
 					every x in [1, 2] { x != 0; input > y }
 				}`},
-			wantQueries: []string{`every __local1__1, __local2__1 in [1, 2] { __local2__1 != 0; __local4__1 = input; __local4__1 > 3 }`},
+			wantQueries: []string{`input > 3`},
 		},
 		{
 			note:  "every: unknown domain, call in body",
@@ -3812,7 +3765,7 @@ func TestTopDownPartialEval(t *testing.T) {
 						1 == y
 					}
 				}`},
-			wantQueries: []string{`every __local1__2, __local2__2 in [1] { a2 = input; 1 = __local2__2 }`},
+			wantQueries: []string{``},
 		},
 		{
 			note:  "every: nested and closing over function args",
@@ -3829,11 +3782,94 @@ func TestTopDownPartialEval(t *testing.T) {
 						}
 					}
 				}`},
-			wantQueries: []string{`every __local1__2, __local2__2 in [1] {
-				__local6__2 = [2]
-				every __local3__2, __local4__2 in __local6__2 {
-					a2 = input; __local4__2 > __local2__2 }
+			wantQueries: []string{``},
+		},
+		{
+			note:  "every: known domain, expanded over function args",
+			query: "data.test.p",
+			modules: []string{`package test
+				p {
+					f(input)
+				}
+				f(x) {
+					every y in [1, 2] { x > y }
+				}`},
+			wantQueries: []string{`input > 1; input > 2`},
+		},
+		{
+			note:  "every: known domain, cross product of body queries",
+			query: "data.test.p",
+			modules: []string{`package test
+				p {
+					every x in [1, 2] { f(x) }
+				}
+				f(x) { x == input.a }
+				f(x) { x == input.b }`},
+			wantQueries: []string{
+				`1 = input.a; 2 = input.a`,
+				`1 = input.a; 2 = input.b`,
+				`1 = input.b; 2 = input.a`,
+				`1 = input.b; 2 = input.b`,
+			},
+		},
+		{
+			note:  "every: known domain, local vars renamed apart",
+			query: "data.test.p",
+			modules: []string{`package test
+				p {
+					every x in [1, 2] { some y in input.ys; y == x }
+				}`},
+			wantQueries: []string{`1 = input.ys[__local3__1]; 2 = input.ys[x_every_1]`},
+		},
+		{
+			note:  "every: known domain, body undefined for one element",
+			query: "data.test.p",
+			modules: []string{`package test
+				p {
+					every x in [1, 2] { x == 1; input.a == x }
 				}`},
+			wantQueries: []string{},
+		},
+		{
+			note:  "every: known domain, body true for one element",
+			query: "data.test.p",
+			modules: []string{`package test
+				p {
+					every x in [1, 2] { f(x) }
+				}
+				f(1)
+				f(x) { x == input.a }`},
+			wantQueries: []string{`2 = input.a`},
+		},
+		{
+			note:  "comprehensions: unknowns in undefined rule branches",
+			query: "data.test.p = x",
+			modules: []string{`package test
+				p = xs {
+					xs := [x | x := data.items[_]; allowed[x]]
+				}
+				allowed[x] { x := data.items[_]; x > 1 }
+				allowed[x] { x := data.items[_]; x > 100; input.admin }`},
+			data:        `{"items": [1, 2, 3]}`,
+			wantQueries: []string{`x = [2, 3]`},
+		},
+		{
+			note:  "with: known value replacing unknown document",
+			query: "data.test.p = true",
+			modules: []string{`package test
+				p { q with input.role as "admin" }
+				q { input.role == "admin"; input.user == "bob" }`},
+			wantQueries: []string{`input.user = "bob"`},
+		},
+		{
+			note:  "with: known value replacing part of saved document",
+			query: "data.test.p = true",
+			modules: []string{`package test
+				p { q with input.role as "admin" }
+				q { x := input; x.role == "admin" }`},
+			wantQueries: []string{`data.partial.test.q = x_term_1_01 with input.role as "admin"; x_term_1_01 with input.role as "admin"`},
+			wantSupport: []string{`package partial.test
+				q = true { input.role = "admin" }`},
 		},
 		{ // https://github.com/open-policy-agent/opa/issues/5367
 			note:  "copypropagation: keep equations that are only found in comprehensions, inlined function call",
@@ -4138,6 +4174,208 @@ func TestTopDownPartialEval(t *testing.T) {
 	}
 }
 
+func TestTopDownPartialEvalStrict(t *testing.T) {
+	tests := []struct {
+		note    string
+		query   string
+		modules []string
+		errs    []string
+	}{
+		{
+			note:  "flat",
+			query: "data.test.p = true",
+			modules: []string{`package test
+				p { every x in [1, 2] { x < input.a } }`},
+		},
+		{
+			note:  "every with unknown domain",
+			query: "data.test.p = true",
+			modules: []string{`package test
+				p { every x in input.xs { x > 1 } }`},
+			errs: []string{"every expression with unknown domain cannot be expanded"},
+		},
+		{
+			note:  "comprehension and with statement",
+			query: "data.test.p = true",
+			modules: []string{`package test
+				p {
+					count([x | x := input.xs[_]]) > 0
+					q with input.y as input.z
+				}
+				q { input.y == 1 }`},
+			errs: []string{
+				"comprehension with unknowns cannot be expanded",
+				"with statement cannot be expanded",
+			},
+		},
+		{
+			note:  "support rules",
+			query: "data.test.p = true",
+			modules: []string{`package test
+				p { not q }
+				q { input.xs[_] == 1 }`},
+			errs: []string{"negated expression requires support rules"},
+		},
+	}
+
+	ctx := context.Background()
+
+	for _, tc := range tests {
+		params := fixtureParams{
+			note:    tc.note,
+			query:   tc.query,
+			modules: tc.modules,
+		}
+		prepareTest(ctx, t, params, func(ctx context.Context, t *testing.T, f fixture) {
+			_, _, err := NewQuery(f.query).
+				WithCompiler(f.compiler).
+				WithStore(f.store).
+				WithTransaction(f.txn).
+				WithUnknowns([]*ast.Term{ast.MustParseTerm("input")}).
+				WithStrictPartialEval(true).
+				PartialRun(ctx)
+
+			if len(tc.errs) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("expected errors but got: %v", err)
+			}
+			if len(errs) != len(tc.errs) {
+				t.Fatalf("expected %d errors but got: %v", len(tc.errs), errs)
+			}
+			for i := range errs {
+				if !IsUnsupportedPartial(errs[i]) || errs[i].Message != tc.errs[i] {
+					t.Errorf("expected error %q but got: %v", tc.errs[i], errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTopDownPartialEvalMatchesEval(t *testing.T) {
+	tests := []struct {
+		note    string
+		modules []string
+		inputs  []string
+	}{
+		{
+			note: "every: local vars",
+			modules: []string{`package test
+				p { every x in [1, 2] { some y in input.ys; y == x } }`},
+			inputs: []string{`{"ys": [1, 2]}`, `{"ys": [2, 1, 3]}`, `{"ys": [1]}`, `{"ys": []}`},
+		},
+		{
+			note: "every: nested local vars",
+			modules: []string{`package test
+				p { every x in [1, 2] { every y in [10] { some z in input.zs; z == x + y } } }`},
+			inputs: []string{`{"zs": [11, 12]}`, `{"zs": [11]}`, `{"zs": [12, 13]}`},
+		},
+		{
+			note: "every: cross product of body queries",
+			modules: []string{`package test
+				p { every x in [1, 2] { f(x) } }
+				f(x) { x == input.a }
+				f(x) { x == input.b }`},
+			inputs: []string{`{"a": 1, "b": 2}`, `{"a": 2, "b": 1}`, `{"a": 1, "b": 1}`, `{"a": 3}`},
+		},
+		{
+			note: "every: in-scope vars",
+			modules: []string{`package test
+				p {
+					y := input.y
+					every x in [1, 2] { some z in input.zs; z > x + y }
+				}`},
+			inputs: []string{`{"y": 1, "zs": [4]}`, `{"y": 1, "zs": [3]}`, `{"y": 0, "zs": [1, 3]}`},
+		},
+		{
+			note: "comprehension",
+			modules: []string{`package test
+				p { count([x | x := input.xs[_]; x > 1]) == 2 }`},
+			inputs: []string{`{"xs": [1, 2, 3]}`, `{"xs": [2]}`, `{"xs": []}`},
+		},
+		{
+			note: "with statement",
+			modules: []string{`package test
+				p { q with input.y as 1 }
+				q { input.y == 1; input.z == 2 }`},
+			inputs: []string{`{"z": 2}`, `{"y": 2, "z": 2}`, `{"z": 3}`},
+		},
+	}
+
+	ctx := context.Background()
+
+	for _, tc := range tests {
+		params := fixtureParams{
+			note:    tc.note,
+			query:   "data.test.p = true",
+			modules: tc.modules,
+		}
+		prepareTest(ctx, t, params, func(ctx context.Context, t *testing.T, f fixture) {
+			queries, support, err := NewQuery(f.query).
+				WithCompiler(f.compiler).
+				WithStore(f.store).
+				WithTransaction(f.txn).
+				WithUnknowns([]*ast.Term{ast.MustParseTerm("input")}).
+				PartialRun(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			modules := map[string]*ast.Module{}
+			for i, m := range support {
+				modules[fmt.Sprint(i)] = m
+			}
+			compiler := ast.NewCompiler()
+			if compiler.Compile(modules); compiler.Failed() {
+				t.Fatal(compiler.Errors)
+			}
+
+			for _, s := range tc.inputs {
+				input := ast.MustParseTerm(s)
+
+				rs, err := NewQuery(f.query).
+					WithCompiler(f.compiler).
+					WithStore(f.store).
+					WithTransaction(f.txn).
+					WithInput(input).
+					Run(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				exp := len(rs) > 0
+
+				act := false
+				for _, query := range queries {
+					compiled, err := compiler.QueryCompiler().Compile(query)
+					if err != nil {
+						t.Fatalf("%v: %v", query, err)
+					}
+					rs, err := NewQuery(compiled).
+						WithCompiler(compiler).
+						WithStore(f.store).
+						WithTransaction(f.txn).
+						WithInput(input).
+						Run(ctx)
+					if err != nil {
+						t.Fatal(err)
+					}
+					act = act || len(rs) > 0
+				}
+
+				if exp != act {
+					t.Errorf("input %v: expected %v but partial evaluation results %v yield %v", input, exp, queries, act)
+				}
+			}
+		})
+	}
+}
+
 type fixtureParams struct {
 	note       string
 	data       string
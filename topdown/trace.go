@@ -70,6 +70,10 @@ const (
 type VarMetadata struct {
 	Name     ast.Var       `json:"name"`
 	Location *ast.Location `json:"location"`
+
+	// Source is the term that a var generated by the compiler stands in for.
+	// It is nil for vars that were written by the user.
+	Source *ast.Term `json:"source,omitempty"`
 }
 
 // Event contains state associated with a tracing event.
@@ -84,8 +88,9 @@ type Event struct {
 	Message       string                  // Contains message for Note events.
 	Ref           *ast.Ref                // Identifies the subject ref for the event. Only applies to Index and Wasm operations.
 
-	input    *ast.Term
-	bindings *bindings
+	input          *ast.Term
+	bindings       *bindings
+	sourceLocation *ast.Location // location of the source of a generated expression
 }
 
 // HasRule returns true if the Event contains an ast.Rule.
@@ -106,6 +111,19 @@ func (evt *Event) HasExpr() bool {
 	return ok
 }
 
+// SourceLocation returns the location of the source that the node of the event
+// was compiled from. Expressions generated by the compiler are located at the
+// term they were generated for, as recorded in the compiler's source map.
+func (evt *Event) SourceLocation() *ast.Location {
+	if evt.sourceLocation != nil {
+		return evt.sourceLocation
+	}
+	if expr, ok := evt.Node.(*ast.Expr); ok && expr != nil {
+		return expr.Location
+	}
+	return evt.Location
+}
+
 // Equal returns true if this event is equal to the other event.
 func (evt *Event) Equal(other *Event) bool {
 	if evt.Op != other.Op {
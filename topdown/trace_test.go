@@ -665,6 +665,72 @@ func TestTraceRewrittenQueryVars(t *testing.T) {
 	}
 }
 
+func TestEventSourceLocation(t *testing.T) {
+	module := `package test
+
+	p {
+		x := count(
+			[y | y := input.xs[_]])
+		x > 0
+	}`
+
+	ctx := context.Background()
+	compiler := compileModules([]string{module})
+	queryCompiler := compiler.QueryCompiler()
+	store := inmem.New()
+	txn := storage.NewTransactionOrDie(ctx, store)
+	defer store.Abort(ctx, txn)
+
+	compiledQuery, err := queryCompiler.Compile(ast.MustParseBody(`data.test.p
+		count({z | z := input.xs[_]}) > 0`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tracer := NewBufferTracer()
+	query := NewQuery(compiledQuery).
+		WithQueryCompiler(queryCompiler).
+		WithCompiler(compiler).
+		WithStore(store).
+		WithTransaction(txn).
+		WithInput(ast.MustParseTerm(`{"xs": [1]}`)).
+		WithTracer(tracer)
+
+	if _, err := query.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sourceMaps := []ast.SourceMap{compiler.SourceMap, queryCompiler.(ast.SourceMapper).SourceMap()}
+	var generated int
+	for _, event := range *tracer {
+		expr, ok := event.Node.(*ast.Expr)
+		if event.Op != EvalOp || !ok || !expr.Generated {
+			continue
+		}
+		v := definedVar(expr)
+		var exp *ast.Location
+		for _, sm := range sourceMaps {
+			if loc := sm.Location(v); loc != nil {
+				exp = loc
+			}
+		}
+		if exp == nil {
+			t.Fatalf("Expected %v in source map", v)
+		}
+		if loc := event.SourceLocation(); !loc.Equal(exp) {
+			t.Errorf("Expected %v to be located at %v but got %v", expr, exp, loc)
+		}
+		if event.Location != expr.Location {
+			t.Errorf("Expected event location of %v to be unchanged but got %v", expr, event.Location)
+		}
+		generated++
+	}
+
+	if generated < 2 {
+		t.Fatalf("Expected generated expressions in module and query to be evaluated but got %d", generated)
+	}
+}
+
 func TestTraceRewrittenVars(t *testing.T) {
 
 	mustParse := func(s string) *ast.Expr {